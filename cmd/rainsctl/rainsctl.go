@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "rainsctl",
+	Short: "rainsctl inspects and manipulates the caches of a running rainsd",
+	Long: `rainsctl is a tool for inspecting and manipulating the caches of a running rainsd over its
+admin api. The server must be started with an admin address. Cache entries are printed in zonefile
+format.`,
+}
+
+var assertionsCmd = &cobra.Command{
+	Use:     "assertions [ZONE]",
+	Aliases: []string{"a"},
+	Short:   "Prints the cached assertions",
+	Long:    `Prints the cached assertions of ZONE (default all zones) in zonefile format.`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		get(rainsd.AdminAssertionsPath, sectionFilter(args))
+	},
+}
+
+var negAssertionsCmd = &cobra.Command{
+	Use:     "negassertions [ZONE]",
+	Aliases: []string{"n"},
+	Short:   "Prints the cached shards, pshards and zones",
+	Long:    `Prints the cached shards, pshards and zones of ZONE (default all zones) in zonefile format.`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		get(rainsd.AdminNegAssertionsPath, sectionFilter(args))
+	},
+}
+
+var zoneKeysCmd = &cobra.Command{
+	Use:     "zonekeys [ZONE]",
+	Aliases: []string{"z"},
+	Short:   "Prints the delegation assertions of the cached zone keys",
+	Long: `Prints the delegation assertions containing the cached public keys of ZONE (default all
+zones) in zonefile format.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		get(rainsd.AdminZoneKeysPath, sectionFilter(args))
+	},
+}
+
+var pendingCmd = &cobra.Command{
+	Use:     "pending keys|queries",
+	Aliases: []string{"p"},
+	Short:   "Prints the sections waiting for a delegation or forwarded query",
+	Long: `Pending keys prints the sections whose signatures cannot be verified until the answer to a
+delegation query arrives. Pending queries prints the queries waiting for the answer to a forwarded
+query. Each entry starts with the token and expiration of the query it waits for and its sender.`,
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"keys", "queries"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if args[0] == "keys" {
+			get(rainsd.AdminPendingKeysPath, nil)
+		} else {
+			get(rainsd.AdminPendingQueriesPath, nil)
+		}
+	},
+}
+
+var connectionsCmd = &cobra.Command{
+	Use:     "connections",
+	Aliases: []string{"c"},
+	Short:   "Prints the open connections",
+	Long:    `Prints the network, the remote and the local address of each open connection.`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		get(rainsd.AdminConnectionsPath, nil)
+	},
+}
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Prints the standing of the peers",
+	Long: `Prints how often each peer supplied and failed to supply the public keys its sections are
+signed with, when it failed last and whether its standing is good.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		get(rainsd.AdminPeersPath, nil)
+	},
+}
+
+var flushCmd = &cobra.Command{
+	Use:     "flush ZONE",
+	Aliases: []string{"f"},
+	Short:   "Removes a zone from the caches",
+	Long:    `Removes all cached assertions, shards, pshards and zones of ZONE.`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		post(rainsd.AdminFlushPath, url.Values{"zone": args})
+	},
+}
+
+var unblockCmd = &cobra.Command{
+	Use:   "unblock ZONE",
+	Short: "Removes a misbehaving zone from the blacklist",
+	Long: `Accepts sections of ZONE again which has been blacklisted because it misbehaved. The
+context flag selects the context of ZONE, the default is the global context.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		post(rainsd.AdminUnblockPath, sectionFilter(args))
+	},
+}
+
+var checkpointCmd = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "Checkpoints the caches",
+	Long: `Immediately stores the assertion, negative assertion and zone key caches in the server's
+checkpoint files.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		post(rainsd.AdminCheckpointPath, nil)
+	},
+}
+
+var adminAddress string
+var zoneContext string
+var timeout time.Duration
+
+func init() {
+	rootCmd.AddCommand(assertionsCmd, negAssertionsCmd, zoneKeysCmd, pendingCmd, connectionsCmd,
+		peersCmd, flushCmd, unblockCmd, checkpointCmd)
+	rootCmd.PersistentFlags().StringVarP(&adminAddress, "admin", "a", "127.0.0.1:55554",
+		"the admin address of the server, either host:port or unix:PATH for a unix socket.")
+	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "t", 10*time.Second,
+		"the maximum amount of time to wait for the server's response.")
+	for _, cmd := range []*cobra.Command{assertionsCmd, negAssertionsCmd, zoneKeysCmd} {
+		cmd.Flags().StringVarP(&zoneContext, "context", "c", "",
+			"only prints entries of this context. (default all contexts)")
+	}
+	unblockCmd.Flags().StringVarP(&zoneContext, "context", "c", "",
+		"the context of the zone. (default global context)")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//sectionFilter returns the parameters restricting the printed sections to the zone in args and
+//the context flag.
+func sectionFilter(args []string) url.Values {
+	values := url.Values{}
+	if len(args) == 1 {
+		values.Set("zone", args[0])
+	}
+	if zoneContext != "" {
+		values.Set("context", zoneContext)
+	}
+	return values
+}
+
+func get(path string, values url.Values) {
+	request(http.MethodGet, path, values)
+}
+
+func post(path string, values url.Values) {
+	request(http.MethodPost, path, values)
+}
+
+//request sends a request with values to path on the admin api and prints the response. It exits
+//if the request fails.
+func request(method, path string, values url.Values) {
+	u := adminURL(path)
+	u.RawQuery = values.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		log.Fatalf("Error: was not able to create request: %v", err)
+	}
+	resp, err := client().Do(req)
+	if err != nil {
+		log.Fatalf("Error: was not able to reach the admin api at %s: %v", adminAddress, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Error: was not able to read the response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	os.Stdout.Write(body)
+}
+
+//adminURL returns the url of path on the admin api.
+func adminURL(path string) *url.URL {
+	host := adminAddress
+	if strings.HasPrefix(adminAddress, rainsd.AdminUnixPrefix) {
+		//The host is ignored as the client dials the unix socket.
+		host = "rainsd"
+	}
+	return &url.URL{Scheme: "http", Host: host, Path: path}
+}
+
+//client returns an http client which connects to the admin address.
+func client() *http.Client {
+	transport := &http.Transport{}
+	if strings.HasPrefix(adminAddress, rainsd.AdminUnixPrefix) {
+		socket := strings.TrimPrefix(adminAddress, rainsd.AdminUnixPrefix)
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/inconshreveable/log15"
@@ -13,11 +18,11 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
-	"github.com/scionproto/scion/go/lib/snet"
 	"github.com/spf13/cobra"
 )
 
 var config = rainsd.DefaultConfig()
+var configPath string
 var id string
 var rootZonePublicKeyPath string
 var assertionCheckPointInterval time.Duration
@@ -25,32 +30,55 @@ var negAssertionCheckPointInterval time.Duration
 var zoneKeyCheckPointInterval time.Duration
 var checkPointPath string
 var preLoadCaches bool
+var blacklistPath string
+var infraKeyPath string
+var infraKeyName string
+var signedMessagePeers []string
+var metricsAddress string
+var adminAddress string
+var drainTimeout time.Duration
 
 //switchboard
 var serverAddress addressFlag
+var listenAddresses addressesFlag
 var rootServerAddress addressFlag
 var maxConnections int
+var maxMsgSize int
 var keepAlivePeriod time.Duration
 var tcpTimeout time.Duration
 var tlsCertificateFile string
 var tlsPrivateKeyFile string
+var tlsVerification string
+var tlsRootCAFile string
+var messageSignatureValidity time.Duration
+var sendQueueSize int
 
 //inbox
 var prioBufferSize int
 var normalBufferSize int
+var normalSenderBufferSize int
 var notificationBufferSize int
 var prioWorkerCount int
 var normalWorkerCount int
 var notificationWorkerCount int
 var capabilitiesCacheSize int
 var capabilities string
+var rateLimit float64
+var rateLimitBurst int
+var rateLimitIPv4Prefix int
+var rateLimitIPv6Prefix int
+var rateLimitMaxSenders int
+var rateLimitNotify bool
 
 //verify
 var zoneKeyCacheSize int
 var zoneKeyCacheWarnSize int
 var maxPublicKeysPerZone int
 var pendingKeyCacheSize int
+var pendingMessageSenderLimit int
 var delegationQueryValidity time.Duration
+var delegationQueryRetries int
+var delegationQueryBackoff time.Duration
 var reapZoneKeyCacheInterval time.Duration
 var reapPendingKeyCacheInterval time.Duration
 
@@ -67,6 +95,13 @@ var maxZoneValidity time.Duration
 var reapAssertionCacheInterval time.Duration
 var reapNegAssertionCacheInterval time.Duration
 var reapPendingQCacheInterval time.Duration
+var zoneFiles []string
+var prefetchBudget int
+var prefetchInterval time.Duration
+var prefetchWindow time.Duration
+var prefetchMinHits int
+var staleGracePeriod time.Duration
+var serveStaleToAll bool
 var maxRecurseDepth int
 
 var rootCmd = &cobra.Command{
@@ -94,7 +129,8 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 1 {
 			var err error
-			if config, err = rainsd.LoadConfig(args[0]); err != nil {
+			configPath = args[0]
+			if config, err = rainsd.LoadConfig(configPath); err != nil {
 				log.Fatalf("Error: was not able to load config file: %v", err)
 			}
 		}
@@ -102,7 +138,11 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	rootCmd.Flags().Var(&serverAddress, "serverAddress", "The network address of this server.")
+	rootCmd.Flags().Var(&serverAddress, "serverAddress", "The network address of this server. "+
+		"Addresses with the prefix udp: are plain UDP addresses.")
+	rootCmd.Flags().Var(&listenAddresses, "listenAddresses", "A list of further network addresses "+
+		"on which this server listens. The format is addr(,addr)*. There can be at most one plain "+
+		"UDP and one SCION address.")
 	rootCmd.Flags().Var(&authorities, "authorities", "A list of contexts and zones for which this server "+
 		"is authoritative. The format is elem(,elem)* where elem := zoneName,contextName")
 	rootCmd.Flags().Var(&rootServerAddress, "rootServerAddress", "The root name server address")
@@ -119,9 +159,31 @@ func init() {
 		"checkpoint information is stored.")
 	rootCmd.Flags().BoolVar(&preLoadCaches, "preLoadCaches", false, "If true, the assertion, negative assertion, "+
 		"and zone key cache are pre-loaded from the checkpoint files in CheckPointPath at start up.")
+	rootCmd.Flags().StringVar(&blacklistPath, "blacklistPath", "", "Path to a json file listing IP "+
+		"address ranges and zones from which the server does not accept traffic. The file is "+
+		"reloaded when the server receives SIGHUP.")
+	rootCmd.Flags().StringVar(&infraKeyPath, "infraKeyPath", "", "Path to the pem encoded private "+
+		"infrastructure key with which the server signs outgoing messages. If empty, messages are "+
+		"not signed.")
+	rootCmd.Flags().StringVar(&infraKeyName, "infraKeyName", "", "Fully qualified name under which "+
+		"the server's infrastructure key is published in an assertion.")
+	rootCmd.Flags().StringSliceVar(&signedMessagePeers, "signedMessagePeers", nil, "IP address "+
+		"ranges in CIDR notation or single IP addresses of peers whose messages must carry a valid "+
+		"message signature. Their unsigned messages are dropped.")
+	rootCmd.Flags().StringVar(&metricsAddress, "metricsAddress", "", "Address on which the server "+
+		"serves its metrics over http at /metrics, e.g. 127.0.0.1:9120. If empty, metrics are not "+
+		"served.")
+	rootCmd.Flags().StringVar(&adminAddress, "adminAddress", "", "Address on which the server "+
+		"serves its admin api over http, e.g. 127.0.0.1:55554 or unix:/run/rainsd.sock. A tcp "+
+		"address must be a loopback address. If empty, the admin api is not served.")
+	rootCmd.Flags().DurationVar(&drainTimeout, "drainTimeout", 5*time.Second, "The maximum amount "+
+		"of time the server waits for its listeners, reapers, checkpointers and workers to stop "+
+		"when it shuts down.")
 
 	//switchboard
 	rootCmd.Flags().IntVar(&maxConnections, "maxConnections", 10000, "The maximum number of allowed active connections.")
+	rootCmd.Flags().IntVar(&maxMsgSize, "maxMsgSize", 65536, "The maximum size in bytes of a received message. "+
+		"Larger messages are answered with a message too large notification. 0 disables the limit.")
 	rootCmd.Flags().DurationVar(&keepAlivePeriod, "keepAlivePeriod", time.Minute, "How long to keep idle connections open.")
 	rootCmd.Flags().DurationVar(&tcpTimeout, "tcpTimeout", 5*time.Minute, "TCPTimeout is the maximum amount of "+
 		"time a dial will wait for a tcp connect to complete.")
@@ -129,16 +191,38 @@ func init() {
 		"certificate file proving the server's identity.")
 	rootCmd.Flags().StringVar(&tlsPrivateKeyFile, "tlsPrivateKeyFile", "data/cert/server.key", "The path to the server's tls "+
 		"private key file proving the server's identity.")
+	rootCmd.Flags().StringVar(&tlsVerification, "tlsVerification", rainsd.TLSVerifyNone, "How the tls "+
+		"certificate of a server this server connects to is verified. One of none, ca or certInfo.")
+	rootCmd.Flags().StringVar(&tlsRootCAFile, "tlsRootCAFile", "", "The path to a file with the root CAs "+
+		"against which server certificates are verified in mode ca. The server's own certificate is used if empty.")
+	rootCmd.Flags().DurationVar(&messageSignatureValidity, "messageSignatureValidity", time.Minute,
+		"The amount of seconds a signature on an outgoing message is valid.")
+	rootCmd.Flags().IntVar(&sendQueueSize, "sendQueueSize", 100, "The maximum number of messages waiting to be "+
+		"sent to one destination.")
 
 	//inbox
 	rootCmd.Flags().IntVar(&prioBufferSize, "prioBufferSize", 50, "The maximum number of messages in the priority buffer.")
 	rootCmd.Flags().IntVar(&normalBufferSize, "normalBufferSize", 100, "The maximum number of messages in the normal buffer.")
+	rootCmd.Flags().IntVar(&normalSenderBufferSize, "normalSenderBufferSize", 10, "The maximum number of "+
+		"messages of one sender in the normal buffer. 0 means no limit beyond normalBufferSize.")
 	rootCmd.Flags().IntVar(&notificationBufferSize, "notificationBufferSize", 10, "The maximum number of messages in the notification buffer.")
 	rootCmd.Flags().IntVar(&prioWorkerCount, "prioWorkerCount", 2, "Number of workers on the priority queue.")
 	rootCmd.Flags().IntVar(&normalWorkerCount, "normalWorkerCount", 10, "Number of workers on the normal queue.")
 	rootCmd.Flags().IntVar(&notificationWorkerCount, "notificationWorkerCount", 1, "Number of workers on the notification queue.")
 	rootCmd.Flags().IntVar(&capabilitiesCacheSize, "capabilitiesCacheSize", 10, "Maximum number of elements in the capabilities cache.")
 	rootCmd.Flags().StringVar(&capabilities, "capabilities", "urn:x-rains:tlssrv", "A list of capabilities this server supports.")
+	rootCmd.Flags().Float64Var(&rateLimit, "rateLimit", 0, "The maximum number of messages per second "+
+		"accepted from one sender. Messages exceeding the limit are dropped. 0 disables rate limiting.")
+	rootCmd.Flags().IntVar(&rateLimitBurst, "rateLimitBurst", 20, "The maximum number of messages "+
+		"accepted from one sender at once before the rate limit applies.")
+	rootCmd.Flags().IntVar(&rateLimitIPv4Prefix, "rateLimitIPv4Prefix", 32, "The length of the IPv4 "+
+		"prefix by which senders are grouped for rate limiting.")
+	rootCmd.Flags().IntVar(&rateLimitIPv6Prefix, "rateLimitIPv6Prefix", 64, "The length of the IPv6 "+
+		"prefix by which senders are grouped for rate limiting.")
+	rootCmd.Flags().IntVar(&rateLimitMaxSenders, "rateLimitMaxSenders", 10000, "The maximum number of "+
+		"senders tracked by the rate limiter. Additional senders share one rate limit.")
+	rootCmd.Flags().BoolVar(&rateLimitNotify, "rateLimitNotify", false, "If true, a sender is "+
+		"notified when it exceeds its rate limit.")
 
 	//verify
 	rootCmd.Flags().IntVar(&zoneKeyCacheSize, "zoneKeyCacheSize", 1000, "The maximum number of entries in the zone key cache.")
@@ -146,8 +230,14 @@ func init() {
 		"cache exceeds this value, a warning is logged.")
 	rootCmd.Flags().IntVar(&maxPublicKeysPerZone, "maxPublicKeysPerZone", 5, "The maximum number of public keys for each zone.")
 	rootCmd.Flags().IntVar(&pendingKeyCacheSize, "pendingKeyCacheSize", 100, "The maximum number of entries in the pending key cache.")
+	rootCmd.Flags().IntVar(&pendingMessageSenderLimit, "pendingMessageSenderLimit", 10, "The maximum number of "+
+		"messages of one sender waiting for the infrastructure key of their signer.")
 	rootCmd.Flags().DurationVar(&delegationQueryValidity, "delegationQueryValidity", time.Second, "The amount of seconds in the "+
 		"future when delegation queries are set to expire.")
+	rootCmd.Flags().IntVar(&delegationQueryRetries, "delegationQueryRetries", 2, "The number of times delegation "+
+		"queries are sent again to a peer before they are sent to the resolver.")
+	rootCmd.Flags().DurationVar(&delegationQueryBackoff, "delegationQueryBackoff", 250*time.Millisecond, "The time "+
+		"waited for a peer to answer delegation queries before they are sent again. It is doubled with each retry.")
 	rootCmd.Flags().DurationVar(&reapZoneKeyCacheInterval, "reapZoneKeyCacheInterval", 15*time.Minute, "The time interval to wait "+
 		"between removing expired entries from the zone key cache.")
 	rootCmd.Flags().DurationVar(&reapPendingKeyCacheInterval, "reapPendingKeyCacheInterval", 15*time.Minute, "The time interval to wait "+
@@ -180,6 +270,20 @@ func init() {
 		"wait between removing expired entries from the negative assertion cache.")
 	rootCmd.Flags().DurationVar(&reapPendingQCacheInterval, "reapPendingQCacheInterval", 15*time.Minute, "The time interval to "+
 		"wait between removing expired entries from the pending query cache.")
+	rootCmd.Flags().StringSliceVar(&zoneFiles, "zoneFiles", nil, "A list of signed zonefiles of this "+
+		"server's authorities which are loaded into the caches. Files ending in .cbor contain a cbor encoded message.")
+	rootCmd.Flags().IntVar(&prefetchBudget, "prefetchBudget", 0, "The maximum number of popular assertions "+
+		"which are refreshed through the resolver per prefetch interval before they expire. 0 disables prefetching.")
+	rootCmd.Flags().DurationVar(&prefetchInterval, "prefetchInterval", time.Minute, "The time interval to wait "+
+		"between looking for popular assertions to refresh.")
+	rootCmd.Flags().DurationVar(&prefetchWindow, "prefetchWindow", 2*time.Minute, "Popular assertions are "+
+		"refreshed when they expire within this duration.")
+	rootCmd.Flags().IntVar(&prefetchMinHits, "prefetchMinHits", 2, "The minimum number of lookups during a "+
+		"prefetch interval for an assertion to be refreshed.")
+	rootCmd.Flags().DurationVar(&staleGracePeriod, "staleGracePeriod", 0, "The time expired assertions are "+
+		"kept to answer queries when the upstream is unreachable. 0 disables serving stale assertions.")
+	rootCmd.Flags().BoolVar(&serveStaleToAll, "serveStaleToAll", false, "If true, stale assertions are served "+
+		"to all queries and not only to those with the expired assertions ok option.")
 	rootCmd.Flags().IntVar(&maxRecurseDepth, "maxrecurse", 50, "Recursive resolver maximum depth (max. depth of recursive stack)")
 }
 
@@ -209,9 +313,18 @@ func main() {
 		}
 		server.SetResolver(resolver)
 		log.Println("Server successfully initialized")
-		go server.Start(false, id)
-		handleUserInput()
-		server.Shutdown()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go reloadOnSIGHUP(server)
+		go func() {
+			<-server.Ready()
+			if handleUserInput() {
+				stop()
+			}
+		}()
+		if err := server.Run(ctx); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 }
 
@@ -235,12 +348,39 @@ func updateConfig(config *rainsd.Config) {
 	if rootCmd.Flag("preLoadCaches").Changed {
 		config.PreLoadCaches = preLoadCaches
 	}
+	if rootCmd.Flag("blacklistPath").Changed {
+		config.BlacklistPath = blacklistPath
+	}
+	if rootCmd.Flag("infraKeyPath").Changed {
+		config.InfraKeyPath = infraKeyPath
+	}
+	if rootCmd.Flag("infraKeyName").Changed {
+		config.InfraKeyName = infraKeyName
+	}
+	if rootCmd.Flag("signedMessagePeers").Changed {
+		config.SignedMessagePeers = signedMessagePeers
+	}
+	if rootCmd.Flag("metricsAddress").Changed {
+		config.MetricsAddress = metricsAddress
+	}
+	if rootCmd.Flag("adminAddress").Changed {
+		config.AdminAddress = adminAddress
+	}
+	if rootCmd.Flag("drainTimeout").Changed {
+		config.DrainTimeout = drainTimeout
+	}
 	if rootCmd.Flag("serverAddress").Changed {
 		config.ServerAddress = serverAddress.value
 	}
+	if rootCmd.Flag("listenAddresses").Changed {
+		config.ListenAddresses = listenAddresses.value
+	}
 	if rootCmd.Flag("maxConnections").Changed {
 		config.MaxConnections = maxConnections
 	}
+	if rootCmd.Flag("maxMsgSize").Changed {
+		config.MaxMsgSize = maxMsgSize
+	}
 	if rootCmd.Flag("keepAlivePeriod").Changed {
 		config.KeepAlivePeriod = keepAlivePeriod
 	}
@@ -253,12 +393,27 @@ func updateConfig(config *rainsd.Config) {
 	if rootCmd.Flag("tlsPrivateKeyFile").Changed {
 		config.TLSPrivateKeyFile = tlsPrivateKeyFile
 	}
+	if rootCmd.Flag("tlsVerification").Changed {
+		config.TLSVerification = tlsVerification
+	}
+	if rootCmd.Flag("tlsRootCAFile").Changed {
+		config.TLSRootCAFile = tlsRootCAFile
+	}
+	if rootCmd.Flag("messageSignatureValidity").Changed {
+		config.MessageSignatureValidity = messageSignatureValidity
+	}
+	if rootCmd.Flag("sendQueueSize").Changed {
+		config.SendQueueSize = sendQueueSize
+	}
 	if rootCmd.Flag("prioBufferSize").Changed {
 		config.PrioBufferSize = prioBufferSize
 	}
 	if rootCmd.Flag("normalBufferSize").Changed {
 		config.NormalBufferSize = normalBufferSize
 	}
+	if rootCmd.Flag("normalSenderBufferSize").Changed {
+		config.NormalSenderBufferSize = normalSenderBufferSize
+	}
 	if rootCmd.Flag("notificationBufferSize").Changed {
 		config.NotificationBufferSize = notificationBufferSize
 	}
@@ -277,6 +432,24 @@ func updateConfig(config *rainsd.Config) {
 	if rootCmd.Flag("capabilities").Changed {
 		config.Capabilities = []message.Capability{message.Capability(capabilities)}
 	}
+	if rootCmd.Flag("rateLimit").Changed {
+		config.RateLimit = rateLimit
+	}
+	if rootCmd.Flag("rateLimitBurst").Changed {
+		config.RateLimitBurst = rateLimitBurst
+	}
+	if rootCmd.Flag("rateLimitIPv4Prefix").Changed {
+		config.RateLimitIPv4Prefix = rateLimitIPv4Prefix
+	}
+	if rootCmd.Flag("rateLimitIPv6Prefix").Changed {
+		config.RateLimitIPv6Prefix = rateLimitIPv6Prefix
+	}
+	if rootCmd.Flag("rateLimitMaxSenders").Changed {
+		config.RateLimitMaxSenders = rateLimitMaxSenders
+	}
+	if rootCmd.Flag("rateLimitNotify").Changed {
+		config.RateLimitNotify = rateLimitNotify
+	}
 	if rootCmd.Flag("zoneKeyCacheSize").Changed {
 		config.ZoneKeyCacheSize = zoneKeyCacheSize
 	}
@@ -289,9 +462,18 @@ func updateConfig(config *rainsd.Config) {
 	if rootCmd.Flag("pendingKeyCacheSize").Changed {
 		config.PendingKeyCacheSize = pendingKeyCacheSize
 	}
+	if rootCmd.Flag("pendingMessageSenderLimit").Changed {
+		config.PendingMessageSenderLimit = pendingMessageSenderLimit
+	}
 	if rootCmd.Flag("delegationQueryValidity").Changed {
 		config.DelegationQueryValidity = delegationQueryValidity
 	}
+	if rootCmd.Flag("delegationQueryRetries").Changed {
+		config.DelegationQueryRetries = delegationQueryRetries
+	}
+	if rootCmd.Flag("delegationQueryBackoff").Changed {
+		config.DelegationQueryBackoff = delegationQueryBackoff
+	}
 	if rootCmd.Flag("reapZoneKeyCacheInterval").Changed {
 		config.ReapZoneKeyCacheInterval = reapZoneKeyCacheInterval
 	}
@@ -331,16 +513,69 @@ func updateConfig(config *rainsd.Config) {
 	if rootCmd.Flag("reapPendingQCacheInterval").Changed {
 		config.ReapPendingQCacheInterval = reapPendingQCacheInterval
 	}
+	if rootCmd.Flag("zoneFiles").Changed {
+		config.ZoneFiles = zoneFiles
+	}
+	if rootCmd.Flag("prefetchBudget").Changed {
+		config.PrefetchBudget = prefetchBudget
+	}
+	if rootCmd.Flag("prefetchInterval").Changed {
+		config.PrefetchInterval = prefetchInterval
+	}
+	if rootCmd.Flag("prefetchWindow").Changed {
+		config.PrefetchWindow = prefetchWindow
+	}
+	if rootCmd.Flag("prefetchMinHits").Changed {
+		config.PrefetchMinHits = prefetchMinHits
+	}
+	if rootCmd.Flag("staleGracePeriod").Changed {
+		config.StaleGracePeriod = staleGracePeriod
+	}
+	if rootCmd.Flag("serveStaleToAll").Changed {
+		config.ServeStaleToAll = serveStaleToAll
+	}
 }
 
-func handleUserInput() {
-	time.Sleep(500 * time.Millisecond)
+//handleUserInput returns true as soon as the user asks to shut down the server. It returns false
+//if there is no more input.
+func handleUserInput() bool {
 	fmt.Println("Enter q or quit to shutdown the server")
 	var input string
-	for true {
-		fmt.Scanln(&input)
+	for {
+		if _, err := fmt.Scanln(&input); err == io.EOF {
+			return false
+		}
 		if input == "q" || input == "quit" {
-			return
+			return true
+		}
+	}
+}
+
+//reloadOnSIGHUP reloads the server's blacklist and configuration whenever the process receives
+//SIGHUP. The configuration is read again from the config file and overridden by the cmd line flags.
+func reloadOnSIGHUP(server *rainsd.Server) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		if err := server.ReloadBlacklist(); err != nil {
+			log15.Error("Was not able to reload blacklist", "error", err)
+		}
+		newConfig := rainsd.DefaultConfig()
+		if configPath != "" {
+			var err error
+			if newConfig, err = rainsd.LoadConfig(configPath); err != nil {
+				log15.Error("Was not able to reload config file", "error", err)
+				continue
+			}
+		}
+		updateConfig(&newConfig)
+		restart, err := server.Reload(newConfig)
+		if err != nil {
+			log15.Error("Was not able to reload configuration", "error", err)
+			continue
+		}
+		if len(restart) > 0 {
+			log15.Warn("Changed settings only take effect after a restart", "fields", restart)
 		}
 	}
 }
@@ -359,16 +594,7 @@ func (i *addressFlag) String() string {
 
 func (i *addressFlag) Set(value string) (err error) {
 	i.set = true
-	i.value = connection.Info{}
-	i.value.Addr, err = net.ResolveTCPAddr("", value)
-	if err != nil { // Not an IP address
-		i.value.Addr, err = snet.ParseUDPAddr(value)
-		if err == nil {
-			i.value.Type = connection.SCION
-		}
-	} else {
-		i.value.Type = connection.TCP
-	}
+	i.value, err = connection.ParseInfo(value)
 	return err
 }
 
@@ -376,6 +602,34 @@ func (i *addressFlag) Type() string {
 	return "net.Addr"
 }
 
+type addressesFlag struct {
+	set   bool
+	value []connection.Info
+}
+
+func (i *addressesFlag) String() string {
+	if i.set {
+		return fmt.Sprintf("%v", i.value)
+	}
+	return "[]" //default
+}
+
+func (i *addressesFlag) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		info, err := connection.ParseInfo(addr)
+		if err != nil {
+			return err
+		}
+		i.value = append(i.value, info)
+	}
+	i.set = true
+	return nil
+}
+
+func (i *addressesFlag) Type() string {
+	return "[]net.Addr"
+}
+
 type authoritiesFlag struct {
 	set   bool
 	value []rainsd.ZoneContext
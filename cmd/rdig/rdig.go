@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"strings"
 	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -27,6 +30,12 @@ var expires = flag.Int64P("expires", "e", time.Now().Add(time.Second).Unix(),
 	"expires sets the valid until timestamp of the query in unix seconds since 1970. (default current timestamp + 1 second)")
 var insecureTLS = flag.BoolP("insecureTLS", "i", false,
 	"when set it does not check the validity of the server's TLS certificate. (default false)")
+var tlsRootCAFile = flag.String("tlsRootCAFile", "",
+	"is the path to a file with the root CAs against which the server's TLS certificate is verified. (default the host's root CAs)")
+var tlsServerName = flag.String("tlsServerName", "",
+	"is the name which must be contained in the server's TLS certificate. (default the server argument)")
+var udp = flag.BoolP("udp", "u", false,
+	"when set the query is sent over plain udp. Answers which do not fit into a datagram are retrieved over tcp. (default false)")
 var tok = flag.StringP("token", "t", "",
 	"specifies a token to be used in the query instead of using a randomly generated one.")
 
@@ -44,6 +53,7 @@ var maxAF = flag.BoolP("maxAF", "9", false, "Query option: Maximize answer fresh
 func init() {
 	flag.CommandLine.SortFlags = false
 	flag.Lookup("insecureTLS").NoOptDefVal = "true"
+	flag.Lookup("udp").NoOptDefVal = "true"
 	flag.Lookup("minEE").NoOptDefVal = "true"
 	flag.Lookup("minAS").NoOptDefVal = "true"
 	flag.Lookup("minIL").NoOptDefVal = "true"
@@ -79,7 +89,11 @@ func main() {
 	serverAddr, err := snet.ParseUDPAddr(fmt.Sprintf("%s:%d", server, *port))
 	if err != nil {
 		// was not a valid SCION address, try to parse it as a regular IP address
-		serverAddr, err = net.ResolveTCPAddr("", fmt.Sprintf("%s:%d", server, *port))
+		if *udp {
+			serverAddr, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", server, *port))
+		} else {
+			serverAddr, err = net.ResolveTCPAddr("", fmt.Sprintf("%s:%d", server, *port))
+		}
 		if err != nil {
 			log.Fatalf("Error: serverAddr or port malformed: %v", err)
 		}
@@ -98,13 +112,36 @@ func main() {
 
 	msg := util.NewQueryMessage(name, *context, *expires, types, parseAllQueryOptions(), t)
 
-	answerMsg, err := util.SendQuery(msg, serverAddr, time.Second)
+	verification, err := tlsVerification(server)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	answerMsg, err := util.SendVerifiedQuery(msg, serverAddr, time.Second, verification)
 	if err != nil {
 		log.Fatalf("was not able to send query: %v", err)
 	}
 	fmt.Println(zonefile.IO{}.Encode(answerMsg.Content))
 }
 
+//tlsVerification returns how the TLS certificate of server is verified according to the flags.
+func tlsVerification(server string) (connection.TLSVerification, error) {
+	v := connection.TLSVerification{Insecure: *insecureTLS, ServerName: server}
+	if flag.Lookup("tlsServerName").Changed {
+		v.ServerName = *tlsServerName
+	}
+	if *tlsRootCAFile != "" {
+		data, err := ioutil.ReadFile(*tlsRootCAFile)
+		if err != nil {
+			return v, fmt.Errorf("was not able to read root CA file: %v", err)
+		}
+		v.RootCAs = x509.NewCertPool()
+		if !v.RootCAs.AppendCertsFromPEM(data) {
+			return v, fmt.Errorf("root CA file %s does not contain a certificate", *tlsRootCAFile)
+		}
+	}
+	return v, nil
+}
+
 func parseAllQueryOptions() []query.Option {
 	qOptions := []query.Option{}
 	addOption := func(f *flag.Flag) {
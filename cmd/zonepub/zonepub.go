@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
 	"strings"
 	"time"
 
@@ -13,7 +12,6 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/publisher"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
-	"github.com/scionproto/scion/go/lib/snet"
 	"github.com/spf13/cobra"
 )
 
@@ -70,7 +68,7 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.Flags().Var(&authServers, "authServers", "Authoritative server addresses to which the sections "+
-		"in the zone file are forwarded.")
+		"in the zone file are forwarded. Addresses with the prefix udp: are plain UDP addresses.")
 	rootCmd.Flags().Var(&bfAlgo, "bfAlgo", "Bloom filter's algorithm.")
 	rootCmd.Flags().Var(&bfHash, "bfHash", "Hash algorithm used to add to or check bloomfilter.")
 	rootCmd.Flags().Var(&signatureAlgorithm, "signatureAlgorithm", "this option only has an "+
@@ -278,15 +276,11 @@ func (i *addressesFlag) Set(value string) error {
 	var addresses []string
 	addresses = strings.Split(value, ",")
 	for _, addr := range addresses {
-		if tcpAddr, err := net.ResolveTCPAddr("tcp", addr); err == nil {
-			i.value = append(i.value, connection.Info{Type: connection.TCP, Addr: tcpAddr})
-		} else {
-			if scionAddr, err := snet.ParseUDPAddr(value); err == nil {
-				i.value = append(i.value, connection.Info{Type: connection.SCION, Addr: scionAddr})
-			} else {
-				return err
-			}
+		info, err := connection.ParseInfo(addr)
+		if err != nil {
+			return err
 		}
+		i.value = append(i.value, info)
 	}
 	i.set = true
 	return nil
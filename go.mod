@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/britram/borat v0.0.0-20181011130314-f891bcfcfb9b
 	github.com/inconshreveable/log15 v0.0.0-20180818164646-67afb5ed74ec
+	github.com/prometheus/client_golang v1.11.0
 	github.com/scionproto/scion v0.6.1-0.20220202161514-5883c725f748
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/pflag v1.0.5
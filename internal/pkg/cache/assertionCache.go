@@ -2,8 +2,10 @@ package cache
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -16,11 +18,19 @@ import (
 
 //assertionCacheValue is the value stored in the AssertionImpl.cache
 type assertionCacheValue struct {
+	//hits is the number of lookups of this value since the last call to HotEntries. It is first
+	//such that it is aligned for atomic access.
+	hits       int64
 	assertions map[string]assertionExpiration //assertion.Hash -> assertionExpiration
 	cacheKey   string
 	zone       string
+	fqdn       string
+	context    string
+	oType      object.Type
 	deleted    bool
-	//mux protects deleted and assertions from simultaneous access.
+	//noPrefetch is true if the assertions must not be refreshed before they expire.
+	noPrefetch bool
+	//mux protects deleted, noPrefetch and assertions from simultaneous access.
 	mux sync.RWMutex
 }
 
@@ -41,6 +51,12 @@ type AssertionImpl struct {
 	zoneMap                *safeHashMap.Map
 	entriesPerAssertionMap map[string]int //a.Hash() -> int
 	mux                    sync.Mutex     //protects entriesPerAssertionMap from simultaneous access
+	//stale contains the expired assertions removed by RemoveExpiredValues which are kept for the
+	//stale grace period. It is only used if the grace period is positive.
+	stale map[string]map[string]assertionExpiration //cacheKey -> assertion.Hash -> assertionExpiration
+	//staleGracePeriod is the number of seconds an expired assertion is kept in stale.
+	staleGracePeriod int64
+	staleMux         sync.RWMutex //protects stale and staleGracePeriod from simultaneous access
 }
 
 func NewAssertion(maxSize int) *AssertionImpl {
@@ -49,6 +65,7 @@ func NewAssertion(maxSize int) *AssertionImpl {
 		counter:                safeCounter.New(maxSize),
 		zoneMap:                safeHashMap.New(),
 		entriesPerAssertionMap: make(map[string]int),
+		stale:                  make(map[string]map[string]assertionExpiration),
 	}
 }
 
@@ -86,6 +103,10 @@ func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal b
 			assertions: make(map[string]assertionExpiration),
 			cacheKey:   key,
 			zone:       a.SubjectZone,
+			fqdn:       mergeSubjectZone(a.SubjectName, a.SubjectZone),
+			context:    a.Context,
+			oType:      o.Type,
+			noPrefetch: isInternal,
 		}
 		v, new := c.cache.GetOrAdd(key, &cacheValue, isInternal)
 		value := v.(*assertionCacheValue)
@@ -149,7 +170,7 @@ func zoneHierarchy(fqdn string) []string {
 }
 
 //Get returns true and a set of assertions matching the given key if there exist some. Otherwise
-//nil and false is returned.
+//nil and false is returned. Assertions whose cache expiration has passed are not returned.
 // If strict is true then only a direct match for the provided FQDN is looked up.
 // Otherwise, a search up the domain name hierarchy is performed to get the topmost match.
 func (c *AssertionImpl) Get(fqdn, context string, objType object.Type, strict bool) ([]*section.Assertion, bool) {
@@ -173,22 +194,126 @@ func (c *AssertionImpl) Get(fqdn, context string, objType object.Type, strict bo
 	if !ok {
 		return nil, false
 	}
-	value := v.(*assertionCacheValue)
+	var assertions []*section.Assertion
+	now := time.Now().Unix()
+	for _, ca := range v.(*assertionCacheValue).get() {
+		if ca.Expiration >= now {
+			assertions = append(assertions, ca.Assertion)
+		}
+	}
+	return assertions, len(assertions) > 0
+}
+
+//GetWithExpiration returns true and the assertions of fqdn, context and objType together with
+//their cache expiration if there exist some. Otherwise nil and false is returned. In contrast to
+//Get, assertions whose cache expiration has passed but which have not yet been removed are
+//returned as well. Only an exact match for fqdn is returned.
+func (c *AssertionImpl) GetWithExpiration(fqdn, context string, objType object.Type) ([]CachedAssertion, bool) {
+	v, ok := c.cache.Get(assertionCacheMapKeyFQDN(fqdn, context, objType))
+	if !ok {
+		return nil, false
+	}
+	assertions := v.(*assertionCacheValue).get()
+	return assertions, len(assertions) > 0
+}
+
+//get records a lookup of value and returns its assertions together with their expiration.
+func (value *assertionCacheValue) get() []CachedAssertion {
 	value.mux.RLock()
 	defer value.mux.RUnlock()
 	if value.deleted {
-		return nil, false
+		return nil
 	}
-	var assertions []*section.Assertion
+	atomic.AddInt64(&value.hits, 1)
+	var assertions []CachedAssertion
 	for _, av := range value.assertions {
-		assertions = append(assertions, av.assertion)
+		assertions = append(assertions, CachedAssertion{Assertion: av.assertion,
+			Expiration: av.expiration})
 	}
-	return assertions, len(assertions) > 0
+	return assertions
+}
+
+//DisablePrefetch excludes the entries of a's content from HotEntries until they are removed from
+//the cache.
+func (c *AssertionImpl) DisablePrefetch(a *section.Assertion) {
+	for _, o := range a.Content {
+		v, ok := c.cache.Get(assertionCacheMapKey(a.SubjectName, a.SubjectZone, a.Context, o.Type))
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		value.mux.Lock()
+		value.noPrefetch = true
+		value.mux.Unlock()
+	}
+}
+
+//HotEntries returns the entries which have been looked up at least minHits times since the last
+//call and whose assertions all expire before until but have not yet expired. Entries excluded
+//from prefetching and entries added as internal are not returned. The entries are ordered by
+//decreasing number of hits. The hit counters of all entries are reset.
+func (c *AssertionImpl) HotEntries(until int64, minHits int) []HotEntry {
+	now := time.Now().Unix()
+	entries := []HotEntry{}
+	for _, v := range c.cache.GetAll() {
+		value := v.(*assertionCacheValue)
+		hits := int(atomic.SwapInt64(&value.hits, 0))
+		if hits < minHits {
+			continue
+		}
+		value.mux.RLock()
+		expiration := int64(0)
+		for _, av := range value.assertions {
+			if av.expiration > expiration {
+				expiration = av.expiration
+			}
+		}
+		if !value.deleted && !value.noPrefetch && expiration > now && expiration < until {
+			entries = append(entries, HotEntry{
+				Name:       value.fqdn,
+				Context:    value.context,
+				Type:       value.oType,
+				Hits:       hits,
+				Expiration: expiration,
+			})
+		}
+		value.mux.RUnlock()
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Hits > entries[j].Hits })
+	return entries
+}
+
+//GetInRange returns all cached assertions of zone and context whose subject name lies within
+//interval.
+func (c *AssertionImpl) GetInRange(zone, context string, interval section.Interval) []*section.Assertion {
+	set, ok := c.zoneMap.Get(zone)
+	if !ok {
+		return nil
+	}
+	var assertions []*section.Assertion
+	for _, key := range set.(*safeHashMap.Map).GetAllKeys() {
+		v, ok := c.cache.Get(key)
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		value.mux.RLock()
+		if !value.deleted {
+			for _, av := range value.assertions {
+				if av.assertion.Context == context && section.Intersect(av.assertion, interval) {
+					assertions = append(assertions, av.assertion)
+				}
+			}
+		}
+		value.mux.RUnlock()
+	}
+	return assertions
 }
 
 //RemoveExpiredValues goes through the cache and removes all expired assertions from the
 //assertionCache and the consistency cache.
 func (c *AssertionImpl) RemoveExpiredValues() {
+	c.removeExpiredStaleValues()
 	for _, v := range c.cache.GetAll() {
 		value := v.(*assertionCacheValue)
 		deleteCount := 0
@@ -204,6 +329,7 @@ func (c *AssertionImpl) RemoveExpiredValues() {
 				c.mux.Unlock()
 				delete(value.assertions, key)
 				deleteCount++
+				c.addStale(value.cacheKey, key, va)
 			}
 		}
 		if len(value.assertions) == 0 {
@@ -218,8 +344,115 @@ func (c *AssertionImpl) RemoveExpiredValues() {
 	}
 }
 
+//addStale adds the expired assertion va with hash to the stale assertions of cacheKey if it is
+//still within the stale grace period.
+func (c *AssertionImpl) addStale(cacheKey, hash string, va assertionExpiration) {
+	c.staleMux.Lock()
+	defer c.staleMux.Unlock()
+	if va.expiration+c.staleGracePeriod < time.Now().Unix() {
+		return
+	}
+	if _, ok := c.stale[cacheKey]; !ok {
+		c.stale[cacheKey] = make(map[string]assertionExpiration)
+	}
+	c.stale[cacheKey][hash] = va
+}
+
+//removeExpiredStaleValues removes all stale assertions whose grace period has passed.
+func (c *AssertionImpl) removeExpiredStaleValues() {
+	c.staleMux.Lock()
+	defer c.staleMux.Unlock()
+	for key, assertions := range c.stale {
+		for hash, va := range assertions {
+			if va.expiration+c.staleGracePeriod < time.Now().Unix() {
+				delete(assertions, hash)
+			}
+		}
+		if len(assertions) == 0 {
+			delete(c.stale, key)
+		}
+	}
+}
+
+//GetStale returns true and the expired assertions of fqdn, context and objType which are still
+//within the stale grace period if there exist some. Otherwise nil and false is returned. Only an
+//exact match for fqdn is returned.
+func (c *AssertionImpl) GetStale(fqdn, context string, objType object.Type) ([]*section.Assertion, bool) {
+	c.staleMux.RLock()
+	defer c.staleMux.RUnlock()
+	var assertions []*section.Assertion
+	for _, va := range c.stale[assertionCacheMapKeyFQDN(fqdn, context, objType)] {
+		if va.expiration+c.staleGracePeriod >= time.Now().Unix() {
+			assertions = append(assertions, va.assertion)
+		}
+	}
+	return assertions, len(assertions) > 0
+}
+
+//SetStaleGracePeriod changes the time expired assertions are kept for GetStale to period. Expired
+//assertions are discarded if period is not positive.
+func (c *AssertionImpl) SetStaleGracePeriod(period time.Duration) {
+	c.staleMux.Lock()
+	defer c.staleMux.Unlock()
+	c.staleGracePeriod = int64(period / time.Second)
+	if period <= 0 {
+		c.staleGracePeriod = 0
+		c.stale = make(map[string]map[string]assertionExpiration)
+	}
+}
+
+//StaleLen returns the number of stale assertions.
+func (c *AssertionImpl) StaleLen() int {
+	c.staleMux.RLock()
+	defer c.staleMux.RUnlock()
+	n := 0
+	for _, assertions := range c.stale {
+		n += len(assertions)
+	}
+	return n
+}
+
+//Remove deletes a from the cache.
+func (c *AssertionImpl) Remove(a *section.Assertion) {
+	for _, o := range a.Content {
+		v, ok := c.cache.Get(assertionCacheMapKey(a.SubjectName, a.SubjectZone, a.Context, o.Type))
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		value.mux.Lock()
+		if _, ok := value.assertions[a.Hash()]; !value.deleted && ok {
+			delete(value.assertions, a.Hash())
+			c.mux.Lock()
+			c.entriesPerAssertionMap[a.Hash()]--
+			c.mux.Unlock()
+			c.counter.Sub(1)
+			if len(value.assertions) == 0 {
+				value.deleted = true
+				c.cache.Remove(value.cacheKey)
+				if set, ok := c.zoneMap.Get(value.zone); ok {
+					set.(*safeHashMap.Map).Remove(value.cacheKey)
+				}
+			}
+		}
+		value.mux.Unlock()
+	}
+}
+
 //RemoveZone deletes all assertions in the assertionCache and consistencyCache of the given zone.
 func (c *AssertionImpl) RemoveZone(zone string) {
+	c.staleMux.Lock()
+	for key, assertions := range c.stale {
+		for hash, va := range assertions {
+			if va.assertion.SubjectZone == zone {
+				delete(assertions, hash)
+			}
+		}
+		if len(assertions) == 0 {
+			delete(c.stale, key)
+		}
+	}
+	c.staleMux.Unlock()
 	if set, ok := c.zoneMap.Remove(zone); ok {
 		for _, key := range set.(*safeHashMap.Map).GetAllKeys() {
 			v, ok := c.cache.Remove(key)
@@ -263,3 +496,8 @@ func (c *AssertionImpl) Checkpoint() (assertions []section.Section) {
 func (c *AssertionImpl) Len() int {
 	return c.counter.Value()
 }
+
+//Resize changes the maximum number of elements in the cache to maxSize.
+func (c *AssertionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
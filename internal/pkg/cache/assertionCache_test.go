@@ -3,6 +3,7 @@ package cache
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
 )
 
 func TestAssertionCache(t *testing.T) {
@@ -153,3 +155,159 @@ func TestAssertionCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestAssertionGetInRange(t *testing.T) {
+	c := NewAssertion(10)
+	delegationsCH := getExampleDelgations("ch")
+	delegationsORG := getExampleDelgations("org")
+	c.Add(delegationsCH[0], delegationsCH[0].ValidUntil(), false)
+	c.Add(delegationsORG[0], delegationsORG[0].ValidUntil(), false)
+	delegationsORG[2].Context = "test-cch"
+	c.Add(delegationsORG[2], delegationsORG[2].ValidUntil(), false)
+	var tests = []struct {
+		zone     string
+		context  string
+		interval section.Interval
+		want     []*section.Assertion
+	}{
+		{".", ".", &section.Shard{RangeFrom: "", RangeTo: ""}, []*section.Assertion{delegationsCH[0], delegationsORG[0]}},
+		{".", ".", &section.Shard{RangeFrom: "a", RangeTo: "d"}, []*section.Assertion{delegationsCH[0]}},
+		{".", ".", &section.Shard{RangeFrom: "ch", RangeTo: "org"}, nil},
+		{".", ".", delegationsORG[0], []*section.Assertion{delegationsORG[0]}},
+		{".", "test-cch", &section.Shard{RangeFrom: "", RangeTo: ""}, []*section.Assertion{delegationsORG[2]}},
+		{"com", ".", &section.Shard{RangeFrom: "", RangeTo: ""}, nil},
+	}
+	for i, test := range tests {
+		got := c.GetInRange(test.zone, test.context, test.interval)
+		sort.Slice(got, func(i, j int) bool { return got[i].SubjectName < got[j].SubjectName })
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: wrong assertions in range. expected=%v actual=%v", i, test.want, got)
+		}
+	}
+}
+
+func TestAssertionHotEntries(t *testing.T) {
+	c := NewAssertion(10)
+	soon := time.Now().Add(time.Minute).Unix()
+	later := time.Now().Add(time.Hour).Unix()
+	ch, org, com, net, internal := getExampleDelgations("ch"), getExampleDelgations("org"),
+		getExampleDelgations("com"), getExampleDelgations("net"), getExampleDelgations("int")
+	c.Add(ch[0], soon, false)
+	c.Add(org[0], soon, false)
+	c.Add(com[0], later, false)
+	c.Add(net[0], soon, false)
+	c.DisablePrefetch(net[0])
+	c.Add(internal[0], soon, true)
+	lookups := map[string]int{"ch.": 3, "org.": 5, "com.": 5, "net.": 5, "int.": 5}
+	for fqdn, n := range lookups {
+		for i := 0; i < n; i++ {
+			c.Get(fqdn, ".", object.OTDelegation, true)
+		}
+	}
+	c.Get("org.", ".", object.OTDelegation, true)
+	until := time.Now().Add(10 * time.Minute).Unix()
+	var tests = []struct {
+		minHits int
+		want    []HotEntry
+	}{
+		{2, []HotEntry{
+			{Name: "org.", Context: ".", Type: object.OTDelegation, Hits: 6, Expiration: soon},
+			{Name: "ch.", Context: ".", Type: object.OTDelegation, Hits: 3, Expiration: soon},
+		}},
+		//The hit counters have been reset.
+		{1, []HotEntry{}},
+	}
+	for i, test := range tests {
+		if got := c.HotEntries(until, test.minHits); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: wrong hot entries. expected=%v actual=%v", i, test.want, got)
+		}
+	}
+}
+
+func TestAssertionStale(t *testing.T) {
+	c := NewAssertion(10)
+	c.SetStaleGracePeriod(time.Hour)
+	ch, org, com := getExampleDelgations("ch"), getExampleDelgations("org"),
+		getExampleDelgations("com")
+	c.Add(ch[0], time.Now().Add(-time.Minute).Unix(), false)
+	c.Add(org[0], time.Now().Add(-2*time.Hour).Unix(), false)
+	c.Add(com[0], time.Now().Add(time.Hour).Unix(), false)
+	c.RemoveExpiredValues()
+	var tests = []struct {
+		fqdn string
+		want []*section.Assertion
+	}{
+		{"ch.", []*section.Assertion{ch[0]}},
+		//expired before the grace period
+		{"org.", nil},
+		//not expired
+		{"com.", nil},
+	}
+	for i, test := range tests {
+		if got, _ := c.GetStale(test.fqdn, ".", object.OTDelegation); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: wrong stale assertions. expected=%v actual=%v", i, test.want, got)
+		}
+	}
+	if c.Len() != 1 || c.StaleLen() != 1 {
+		t.Errorf("wrong number of assertions. expected=1,1 actual=%d,%d", c.Len(), c.StaleLen())
+	}
+	c.RemoveZone(".")
+	if c.StaleLen() != 0 {
+		t.Errorf("stale assertions of removed zone are kept: %d", c.StaleLen())
+	}
+	c.Add(ch[0], time.Now().Add(-time.Minute).Unix(), false)
+	c.SetStaleGracePeriod(0)
+	c.RemoveExpiredValues()
+	if c.StaleLen() != 0 {
+		t.Errorf("expired assertions are kept without grace period: %d", c.StaleLen())
+	}
+}
+
+func TestAssertionExpiration(t *testing.T) {
+	c := NewAssertion(10)
+	ch, org := getExampleDelgations("ch"), getExampleDelgations("org")
+	expired, valid := time.Now().Add(-time.Minute).Unix(), time.Now().Add(time.Hour).Unix()
+	c.Add(ch[0], expired, false)
+	c.Add(org[0], valid, false)
+	var tests = []struct {
+		fqdn       string
+		want       []*section.Assertion
+		expiration int64
+	}{
+		{"ch.", nil, expired},
+		{"org.", []*section.Assertion{org[0]}, valid},
+	}
+	for i, test := range tests {
+		if got, _ := c.Get(test.fqdn, ".", object.OTDelegation, true); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: wrong assertions. expected=%v actual=%v", i, test.want, got)
+		}
+		got, ok := c.GetWithExpiration(test.fqdn, ".", object.OTDelegation)
+		if !ok || len(got) != 1 || got[0].Expiration != test.expiration {
+			t.Errorf("%d: wrong cache expiration. expected=%d actual=%v", i, test.expiration, got)
+		}
+	}
+}
+
+func TestAssertionRemove(t *testing.T) {
+	c := NewAssertion(10)
+	ch, org := getExampleDelgations("ch"), getExampleDelgations("org")
+	valid := time.Now().Add(time.Hour).Unix()
+	c.Add(ch[0], valid, false)
+	c.Add(ch[1], valid, false)
+	c.Add(org[0], valid, true)
+	c.Remove(ch[0])
+	if got, _ := c.Get("ch.", ".", object.OTDelegation, true); len(got) != 1 || got[0] != ch[1] {
+		t.Errorf("wrong assertions after removal. expected=[%v] actual=%v", ch[1], got)
+	}
+	c.Remove(org[0])
+	if _, ok := c.Get("org.", ".", object.OTDelegation, true); ok {
+		t.Error("removed internal assertion is still cached")
+	}
+	if got := c.GetInRange(".", ".", section.TotalInterval{}); len(got) != 1 {
+		t.Errorf("wrong assertions of zone after removal. actual=%v", got)
+	}
+	c.Remove(org[0])
+	if c.Len() != 1 {
+		t.Errorf("wrong cache size. expected=1 actual=%d", c.Len())
+	}
+}
@@ -1,9 +1,7 @@
 package cache
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"sort"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
@@ -24,28 +22,32 @@ func NewCapability(maxSize int) *CapabilityImpl {
 		capabilityMap: lruCache.New(),
 		counter:       safeCounter.New(maxSize),
 	}
-	cache.capabilityMap.GetOrAdd("e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745",
-		[]message.Capability{message.TLSOverTCP}, true)
-	cache.capabilityMap.GetOrAdd("76be8b528d0075f7aae98d6fa57a6d3c83ae480a8469e668d7b0af968995ac71",
-		[]message.Capability{message.NoCapability}, false)
-	cache.counter.Add(2)
+	cache.add([]message.Capability{message.TLSOverTCP}, true)
+	cache.add([]message.Capability{message.NoCapability}, false)
 	return cache
 }
 
+//Add normalizes and serializes capabilities and then calculates a sha256 hash over it. It then
+//stores the mapping from the hex encoded hash to the sorted list.
+//If the cache is full it removes the least recently used capability list.
 func (c *CapabilityImpl) Add(capabilities []message.Capability) {
-	//FIXME CFE take a SHA-256 hash of the CBOR byte stream derived from normalizing such an array by sorting it in lexicographically increasing order,
-	//then serializing it and add it to the cache
-	sort.Slice(capabilities, func(i, j int) bool { return capabilities[i] < capabilities[j] })
-	cs := []byte{}
-	for _, c := range capabilities {
-		cs = append(cs, []byte(c)...)
+	c.add(capabilities, false)
+}
+
+func (c *CapabilityImpl) add(capabilities []message.Capability, isInternal bool) {
+	hash, err := message.CapabilityHash(capabilities)
+	if err != nil {
+		log.Warn("Was not able to compute capability hash", "capabilities", capabilities, "error", err)
+		return
 	}
-	hash := sha256.Sum256(cs)
-	_, ok := c.capabilityMap.GetOrAdd(string(hash[:]), capabilities, false)
+	_, ok := c.capabilityMap.GetOrAdd(hash, message.SortCapabilities(capabilities), isInternal)
 	//handle full cache
 	if ok && c.counter.Inc() {
 		for {
-			k, _ := c.capabilityMap.GetLeastRecentlyUsed()
+			k, v := c.capabilityMap.GetLeastRecentlyUsed()
+			if v == nil {
+				break
+			}
 			if _, ok := c.capabilityMap.Remove(k); ok {
 				c.counter.Dec()
 				break
@@ -68,3 +70,8 @@ func (c *CapabilityImpl) Get(hash []byte) ([]message.Capability, bool) {
 func (c *CapabilityImpl) Len() int {
 	return c.counter.Value()
 }
+
+//Resize changes the maximum number of elements in the cache to maxSize.
+func (c *CapabilityImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
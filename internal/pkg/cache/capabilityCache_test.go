@@ -4,31 +4,20 @@ import (
 	"reflect"
 	"testing"
 
-	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
-	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 )
 
 func TestCapabilityCache(t *testing.T) {
-	//TODO CFE remove these manually added entries once there is a working add implementation
-	cache := lruCache.New()
-	cache.GetOrAdd("e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745",
-		[]message.Capability{message.TLSOverTCP}, true)
-	cache.GetOrAdd("76be8b528d0075f7aae98d6fa57a6d3c83ae480a8469e668d7b0af968995ac71",
-		[]message.Capability{message.NoCapability}, false)
-	counter := safeCounter.New(10)
-	counter.Add(2)
 	var tests = []struct {
 		input Capability
 	}{
-		{&CapabilityImpl{capabilityMap: cache, counter: counter}},
+		{NewCapability(4)},
 	}
 	for i, test := range tests {
 		c := test.input
 		if c.Len() != 2 {
 			t.Error("init size is incorrect", "size", c.Len())
 		}
-		//TODO CFE test add when it is correctly implemented.
 		caps, ok := c.Get([]byte("e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745"))
 		if !ok {
 			t.Errorf("%d: Get did not returned contained element.", i)
@@ -36,12 +25,40 @@ func TestCapabilityCache(t *testing.T) {
 		if !reflect.DeepEqual(caps, []message.Capability{message.TLSOverTCP}) {
 			t.Errorf("%d: Returned element is wrong", i)
 		}
-		caps, ok = c.Get([]byte("76be8b528d0075f7aae98d6fa57a6d3c83ae480a8469e668d7b0af968995ac71"))
+		caps, ok = c.Get([]byte("efa1ab5d8a9573c4976b25c667d93dd9a57a554cd9f275dd20fc3139f58ee3e8"))
 		if !ok {
 			t.Errorf("%d: Get did not returned contained element.", i)
 		}
 		if !reflect.DeepEqual(caps, []message.Capability{message.NoCapability}) {
 			t.Errorf("%d: Returned element is wrong", i)
 		}
+		//Add normalizes the capability list
+		input := []message.Capability{message.TLSOverTCP, message.NoCapability}
+		c.Add(input)
+		hash, _ := message.CapabilityHash(input)
+		caps, ok = c.Get([]byte(hash))
+		if !ok || c.Len() != 3 {
+			t.Errorf("%d: Added element is not contained.", i)
+		}
+		if !reflect.DeepEqual(caps, []message.Capability{message.NoCapability, message.TLSOverTCP}) {
+			t.Errorf("%d: Returned element is not normalized. actual=%v", i, caps)
+		}
+		if input[0] != message.TLSOverTCP {
+			t.Errorf("%d: Add modified its input", i)
+		}
+		//Full cache evicts the least recently used external element
+		c.Add([]message.Capability{"urn:x-rains:test"})
+		if c.Len() != 3 {
+			t.Errorf("%d: wrong cache size after eviction. actual=%d", i, c.Len())
+		}
+		if _, ok := c.Get([]byte("e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745")); !ok {
+			t.Errorf("%d: internal element was evicted.", i)
+		}
+		//A resized cache holds more elements
+		c.Resize(5)
+		c.Add([]message.Capability{"urn:x-rains:test2"})
+		if c.Len() != 4 {
+			t.Errorf("%d: wrong cache size after resize. actual=%d", i, c.Len())
+		}
 	}
 }
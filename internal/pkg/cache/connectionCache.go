@@ -180,6 +180,25 @@ func (c *ConnectionImpl) CloseAndRemoveAllConnections() {
 	}
 }
 
+//GetAllConnections returns all cached connections.
+func (c *ConnectionImpl) GetAllConnections() []net.Conn {
+	conns := []net.Conn{}
+	for _, e := range c.cache.GetAll() {
+		v := e.(*connCacheValue)
+		v.mux.RLock()
+		if !v.deleted {
+			conns = append(conns, v.connections...)
+		}
+		v.mux.RUnlock()
+	}
+	return conns
+}
+
 func (c *ConnectionImpl) Len() int {
 	return c.counter.Value()
 }
+
+//Resize changes the maximum number of connections in the cache to maxSize.
+func (c *ConnectionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
@@ -47,6 +47,9 @@ func TestConnectionCache(t *testing.T) {
 		if c.Len() != 2 {
 			t.Errorf("%d: size is incorrect after lru removal expected=2 actual=%d", i, c.Len())
 		}
+		if conns := c.GetAllConnections(); len(conns) != 2 {
+			t.Errorf("%d: wrong number of connections. expected=2 actual=%d", i, len(conns))
+		}
 		_, ok := c.GetConnection(connInfo1)
 		if ok {
 			t.Errorf("%d: Wrong connection removed", i)
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"net"
+	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -33,21 +34,29 @@ type Connection interface {
 	CloseAndRemoveConnections(addr net.Addr)
 	//CloseAndRemoveAllConnections closes and removes all cached connections
 	CloseAndRemoveAllConnections()
+	//GetAllConnections returns all cached connections.
+	GetAllConnections() []net.Conn
 	//Len returns the number of connections currently in the cache.
 	Len() int
+	//Resize changes the maximum number of connections in the cache to maxSize. Superfluous entries
+	//are removed when new ones are added.
+	Resize(maxSize int)
 }
 
 //Capability stores a mapping from a hash of a capability list to a pointer of the list.
 type Capability interface {
 	//Add normalizes and serializes capabilities and then calculates a sha256 hash over it. It then
-	//stores the mapping from the hash to a pointer of the list.
+	//stores the mapping from the hex encoded hash to the normalized list.
 	//If the cache is full it removes a capability according to some metric
 	Add(capabilities []message.Capability)
-	//Get returns true and a pointer to the capability list from which the hash was taken if
-	//present, otherwise false and nil.
+	//Get returns true and the normalized capability list from which the hex encoded hash was
+	//taken if present, otherwise false and nil.
 	Get(hash []byte) ([]message.Capability, bool)
 	//Len returns the number of elements currently in the cache.
 	Len() int
+	//Resize changes the maximum number of elements in the cache to maxSize. Superfluous entries
+	//are removed when new ones are added.
+	Resize(maxSize int)
 }
 
 //ZonePublicKey is used to store public keys of zones and a pointer to delegation assertions
@@ -56,8 +65,9 @@ type ZonePublicKey interface {
 	//Add adds publicKey together with the assertion containing it to the cache. Returns false if
 	//the cache exceeds a configured (during initialization of the cache) amount of entries. If the
 	//cache is full it removes a public key according to some metric. The cache logs a message when
-	//a zone has more than a certain (configurable) amount of public keys. (An external service can
-	//then decide if it wants to blacklist a given zone). If the internal flag is set, the publicKey
+	//a zone has more than a certain (configurable) amount of public keys and reports it to a
+	//callback provided at initialization (which can then decide if it wants to blacklist the
+	//zone). If the internal flag is set, the publicKey
 	//will only be removed after it expired.
 	Add(assertion *section.Assertion, publicKey keys.PublicKey, internal bool) bool
 	//Get returns true, the assertion holding the returned public key, and a non expired public key
@@ -71,6 +81,41 @@ type ZonePublicKey interface {
 	Checkpoint() []section.Section
 	//Len returns the number of public keys currently in the cache.
 	Len() int
+	//Resize changes the maximum number of public keys in the cache to maxSize and the number of
+	//public keys after which Add returns false to warnSize. Superfluous public keys are removed
+	//when new ones are added.
+	Resize(maxSize, warnSize int)
+}
+
+//PendingEntry describes sections which wait for the answer to a query sent by this server.
+type PendingEntry struct {
+	//Token is the token of the query sent by this server.
+	Token token.Token
+	//Expiration is the time (number of seconds since 01.01.1970) when the query expires.
+	Expiration int64
+	//Waiting contains the sections and their sender.
+	Waiting util.MsgSectionSender
+}
+
+//CachedAssertion is an assertion together with the time until which it is cached.
+type CachedAssertion struct {
+	Assertion *section.Assertion
+	//Expiration is the time (number of seconds since 01.01.1970) when the assertion expires in
+	//the cache.
+	Expiration int64
+}
+
+//HotEntry describes a frequently looked up entry of the assertion cache.
+type HotEntry struct {
+	//Name is the fully qualified domain name of the entry's assertions.
+	Name    string
+	Context string
+	Type    object.Type
+	//Hits is the number of lookups since the entry was last considered for prefetching.
+	Hits int
+	//Expiration is the time (number of seconds since 01.01.1970) when the last of the entry's
+	//assertions expires.
+	Expiration int64
 }
 
 type PendingKey interface {
@@ -82,11 +127,49 @@ type PendingKey interface {
 	GetAndRemove(t token.Token) (util.MsgSectionSender, bool)
 	//ContainsToken returns true if t is cached
 	ContainsToken(t token.Token) bool
-	//RemoveExpiredValues deletes all expired entries. It logs the host's addr which was not able to
-	//respond in time.
-	RemoveExpiredValues()
+	//RemoveExpiredValues deletes all expired entries and returns them. It logs the host's addr
+	//which was not able to respond in time.
+	RemoveExpiredValues() []PendingEntry
+	//GetAll returns all cached entries.
+	GetAll() []PendingEntry
 	//Len returns the number of sections in the cache
 	Len() int
+	//Resize changes the maximum number of sections in the cache to maxSize. Existing entries are
+	//kept even if the cache holds more.
+	Resize(maxSize int)
+}
+
+//PendingMessageEntry is a signed message waiting for the infrastructure key of its signer.
+type PendingMessageEntry struct {
+	//Msg is the signed message.
+	Msg *message.Message
+	//Sender is the address from which Msg was received.
+	Sender net.Addr
+}
+
+//PendingMessage stores signed messages whose signatures cannot be verified until the
+//infrastructure key of the message's signer arrives. There is at most one infrastructure key query
+//per signer.
+type PendingMessage interface {
+	//Add checks if a query for the infrastructure key of msg's signer is already pending. If this is
+	//the case, msg received from sender is added to it and false is returned. If not, msg is added
+	//together with the token and expiration time of a new query and true is returned. msg is
+	//dropped and false is returned if the cache is full or sender already has the maximum number of
+	//messages in the cache.
+	Add(msg *message.Message, sender net.Addr, t token.Token, expiration int64) bool
+	//GetAndRemove returns all messages and their senders which wait for the query with token t and
+	//true, and deletes them from the cache. False is returned if no message matched t.
+	GetAndRemove(t token.Token) ([]PendingMessageEntry, bool)
+	//ContainsToken returns true if t is cached
+	ContainsToken(t token.Token) bool
+	//RemoveExpiredValues deletes all expired entries. It logs the signer whose infrastructure key
+	//did not arrive in time.
+	RemoveExpiredValues()
+	//Len returns the number of messages in the cache
+	Len() int
+	//Resize changes the maximum number of messages in the cache to maxSize. Existing entries are
+	//kept even if the cache holds more.
+	Resize(maxSize int)
 }
 
 type PendingQuery interface {
@@ -97,10 +180,16 @@ type PendingQuery interface {
 	//GetAndRemove returns all util.MsgSectionSenders which correspond to token and delete them from the
 	//cache.
 	GetAndRemove(t token.Token) []util.MsgSectionSender
-	//RemoveExpiredValues deletes all expired entries.
-	RemoveExpiredValues()
+	//RemoveExpiredValues deletes all expired entries and returns an entry for each deleted
+	//util.MsgSectionSender.
+	RemoveExpiredValues() []PendingEntry
+	//GetAll returns an entry for each cached util.MsgSectionSender.
+	GetAll() []PendingEntry
 	//Len returns the number of sections in the cache
 	Len() int
+	//Resize changes the maximum number of sections in the cache to maxSize. Existing entries are
+	//kept even if the cache holds more.
+	Resize(maxSize int)
 }
 
 //Assertion is used to store and efficiently lookup assertions
@@ -110,12 +199,41 @@ type Assertion interface {
 	//according to some strategy. It also adds assertion to the consistency cache.
 	Add(assertion *section.Assertion, expiration int64, isInternal bool) bool
 	//Get returns true and a set of assertions matching the given key if there exist some. Otherwise
-	//nil and false is returned. If strict is set only an exact match for the provided FQDN is returned
-	// otherwise a search up the domain name hiearchy is performed.
+	//nil and false is returned. Assertions whose cache expiration has passed are not returned. If
+	//strict is set only an exact match for the provided FQDN is returned otherwise a search up the
+	//domain name hiearchy is performed.
 	Get(fqdn, context string, objType object.Type, strict bool) ([]*section.Assertion, bool)
+	//GetWithExpiration returns true and the assertions of fqdn, context and objType together with
+	//their cache expiration if there exist some. Otherwise nil and false is returned. Assertions
+	//whose cache expiration has passed but which have not yet been removed are returned as well.
+	//Only an exact match for fqdn is returned.
+	GetWithExpiration(fqdn, context string, objType object.Type) ([]CachedAssertion, bool)
+	//GetInRange returns all cached assertions of zone and context whose subject name lies within
+	//interval.
+	GetInRange(zone, context string, interval section.Interval) []*section.Assertion
+	//DisablePrefetch excludes the entries of assertion's content from HotEntries until they are
+	//removed from the cache.
+	DisablePrefetch(assertion *section.Assertion)
+	//HotEntries returns the entries which have been looked up at least minHits times since the
+	//last call and whose assertions all expire before until but have not yet expired. Entries
+	//excluded from prefetching and entries added as internal are not returned. The entries are
+	//ordered by decreasing number of hits. The hit counters of all entries are reset.
+	HotEntries(until int64, minHits int) []HotEntry
+	//GetStale returns true and the expired assertions of fqdn, context and objType which are
+	//still within the stale grace period if there exist some. Otherwise nil and false is returned.
+	//Only an exact match for fqdn is returned.
+	GetStale(fqdn, context string, objType object.Type) ([]*section.Assertion, bool)
+	//SetStaleGracePeriod changes the time expired assertions are kept for GetStale to period.
+	//Expired assertions are discarded if period is not positive.
+	SetStaleGracePeriod(period time.Duration)
+	//StaleLen returns the number of stale assertions.
+	StaleLen() int
 	//RemoveExpiredValues goes through the cache and removes all expired assertions from the
-	//assertionCache and the consistency cache.
+	//assertionCache and the consistency cache. Expired assertions are kept as stale assertions for
+	//the stale grace period.
 	RemoveExpiredValues()
+	//Remove deletes assertion from the cache.
+	Remove(assertion *section.Assertion)
 	//RemoveZone deletes all assertions in the assertionCache and consistencyCache of the given
 	//zone.
 	RemoveZone(zone string)
@@ -123,6 +241,9 @@ type Assertion interface {
 	Checkpoint() []section.Section
 	//Len returns the number of elements in the cache.
 	Len() int
+	//Resize changes the maximum number of elements in the cache to maxSize. Superfluous entries
+	//are removed when new ones are added.
+	Resize(maxSize int)
 }
 
 type NegativeAssertion interface {
@@ -145,6 +266,8 @@ type NegativeAssertion interface {
 	//RemoveExpiredValues goes through the cache and removes all expired shards and zones from the
 	//assertionCache and the consistency cache.
 	RemoveExpiredValues()
+	//Remove deletes the shard, pshard or zone sec from the cache.
+	Remove(sec section.WithSigForward)
 	//RemoveZone deletes all shards and zones in the assertionCache and consistencyCache of the
 	//given subjectZone.
 	RemoveZone(subjectZone string)
@@ -152,4 +275,7 @@ type NegativeAssertion interface {
 	Checkpoint() []section.Section
 	//Len returns the number of elements in the cache.
 	Len() int
+	//Resize changes the maximum number of elements in the cache to maxSize. Superfluous entries
+	//are removed when new ones are added.
+	Resize(maxSize int)
 }
@@ -167,6 +167,29 @@ func (c *NegAssertionImpl) RemoveExpiredValues() {
 	}
 }
 
+//Remove deletes the shard, pshard or zone sec from the cache.
+func (c *NegAssertionImpl) Remove(sec section.WithSigForward) {
+	v, ok := c.cache.Get(zoneCtxKey(sec.GetSubjectZone(), sec.GetContext()))
+	if !ok {
+		return
+	}
+	value := v.(*negAssertionCacheValue)
+	value.mux.Lock()
+	defer value.mux.Unlock()
+	if _, ok := value.sections[sec.Hash()]; value.deleted || !ok {
+		return
+	}
+	delete(value.sections, sec.Hash())
+	c.counter.Sub(1)
+	if len(value.sections) == 0 {
+		value.deleted = true
+		c.cache.Remove(value.cacheKey)
+		if set, ok := c.zoneMap.Get(value.zone); ok {
+			set.(*safeHashMap.Map).Remove(value.cacheKey)
+		}
+	}
+}
+
 //RemoveZone deletes all shards and zones in the assertionCache and consistencyCache of the given
 //subjectZone.
 func (c *NegAssertionImpl) RemoveZone(zone string) {
@@ -208,3 +231,8 @@ func (c *NegAssertionImpl) Checkpoint() (sections []section.Section) {
 func (c *NegAssertionImpl) Len() int {
 	return c.counter.Value()
 }
+
+//Resize changes the maximum number of elements in the cache to maxSize.
+func (c *NegAssertionImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
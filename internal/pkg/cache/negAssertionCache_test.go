@@ -129,3 +129,23 @@ func TestNegAssertionCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestNegAssertionRemove(t *testing.T) {
+	c := NewNegAssertion(10)
+	shards, zones := getShards(), getZones()
+	c.AddShard(shards[0], shards[0].ValidUntil(), false)
+	c.AddShard(shards[1], shards[1].ValidUntil(), false)
+	c.AddZone(zones[1], zones[1].ValidUntil(), true)
+	c.Remove(shards[0])
+	if s, _ := c.Get("ch", ".", section.TotalInterval{}); len(s) != 1 || s[0] != shards[1] {
+		t.Errorf("wrong sections after removal. expected=[%v] actual=%v", shards[1], s)
+	}
+	c.Remove(zones[1])
+	if _, ok := c.Get("org", ".", section.TotalInterval{}); ok {
+		t.Error("removed internal zone is still cached")
+	}
+	c.Remove(zones[1])
+	if c.Len() != 1 {
+		t.Errorf("wrong cache size. expected=1 actual=%d", c.Len())
+	}
+}
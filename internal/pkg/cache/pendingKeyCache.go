@@ -11,6 +11,8 @@ import (
 )
 
 type pkcValue struct {
+	//token is the token of the forwarded query
+	token token.Token
 	//mss contains all the message and the sender for which some keys are missing
 	mss util.MsgSectionSender
 	//expiration contains the expiration value of the forwarded query
@@ -38,7 +40,7 @@ func (c *PendingKeyImpl) Add(ss util.MsgSectionSender, t token.Token, expiration
 		log.Error("Pending key cache is full")
 		return
 	}
-	if ok := c.tokenMap.Add(t.String(), pkcValue{mss: ss, expiration: expiration}); !ok {
+	if ok := c.tokenMap.Add(t.String(), pkcValue{token: t, mss: ss, expiration: expiration}); !ok {
 		log.Warn("Token already in key cache. Random source of Token generator no random enough?")
 		return
 	}
@@ -48,8 +50,7 @@ func (c *PendingKeyImpl) Add(ss util.MsgSectionSender, t token.Token, expiration
 //GetAndRemove returns util.MsgSectionSender which corresponds to token and true, and deletes it from
 //the cache. False is returned if no util.MsgSectionSender matched token.
 func (c *PendingKeyImpl) GetAndRemove(t token.Token) (util.MsgSectionSender, bool) {
-	if val, present := c.tokenMap.Get(t.String()); present {
-		c.tokenMap.Remove(t.String())
+	if val, present := c.tokenMap.Remove(t.String()); present {
 		c.counter.Dec()
 		return val.(pkcValue).mss, true
 	}
@@ -62,23 +63,46 @@ func (c *PendingKeyImpl) ContainsToken(t token.Token) bool {
 	return present
 }
 
-//RemoveExpiredValues deletes all expired entries. It logs the host's addr which was not able to
-//respond in time.
-func (c *PendingKeyImpl) RemoveExpiredValues() {
+//RemoveExpiredValues deletes all expired entries and returns them. It logs the host's addr which
+//was not able to respond in time.
+func (c *PendingKeyImpl) RemoveExpiredValues() []PendingEntry {
+	expired := []PendingEntry{}
 	keys := c.tokenMap.GetAllKeys()
 	for _, key := range keys {
 		if val, present := c.tokenMap.Get(key); present {
 			if val := val.(pkcValue); val.expiration < time.Now().Unix() {
-				c.tokenMap.Remove(key)
+				if _, ok := c.tokenMap.Remove(key); !ok {
+					//Removed concurrently by GetAndRemove.
+					continue
+				}
 				c.counter.Dec()
 				log.Warn("No response to delegation query received before expiration",
 					"sectionSender", val.mss)
+				expired = append(expired, PendingEntry{Token: val.token,
+					Expiration: val.expiration, Waiting: val.mss})
 			}
 		}
 	}
+	return expired
+}
+
+//GetAll returns all cached entries.
+func (c *PendingKeyImpl) GetAll() []PendingEntry {
+	entries := []PendingEntry{}
+	for _, v := range c.tokenMap.GetAll() {
+		v := v.(pkcValue)
+		entries = append(entries, PendingEntry{Token: v.token, Expiration: v.expiration,
+			Waiting: v.mss})
+	}
+	return entries
 }
 
 //Len returns the number of sections in the cache
 func (c *PendingKeyImpl) Len() int {
 	return c.tokenMap.Len()
 }
+
+//Resize changes the maximum number of sections in the cache to maxSize.
+func (c *PendingKeyImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
@@ -35,6 +35,16 @@ func TestPendingKeyCache(t *testing.T) {
 		if c.Len() != 3 {
 			t.Error("mss[2] was not added to the cache")
 		}
+		//Test c.GetAll()
+		if entries := c.GetAll(); len(entries) != 3 {
+			t.Errorf("wrong number of entries. expected=3 actual=%d", len(entries))
+		} else {
+			for _, e := range entries {
+				if e.Token != e.Waiting.Token {
+					t.Errorf("entry has wrong token. expected=%v actual=%v", e.Waiting.Token, e.Token)
+				}
+			}
+		}
 		//Test c.ContainsToken()
 		if !c.ContainsToken(mss[0].Token) || !c.ContainsToken(mss[0].Token) ||
 			!c.ContainsToken(mss[0].Token) || c.ContainsToken(token.New()) {
@@ -56,7 +66,10 @@ func TestPendingKeyCache(t *testing.T) {
 		//Test c.RemoveExpiredValues()
 		c.Add(mss[0], mss[0].Token, time.Now().Add(time.Hour).Unix())
 		c.Add(mss[2], mss[2].Token, time.Now().Add(-time.Hour).Unix())
-		c.RemoveExpiredValues()
+		if expired := c.RemoveExpiredValues(); len(expired) != 1 ||
+			expired[0].Token != mss[2].Token || !reflect.DeepEqual(expired[0].Waiting, mss[2]) {
+			t.Errorf("wrong expired entries: %v", expired)
+		}
 		if v, ok := c.GetAndRemove(mss[0].Token); !ok || c.Len() != 0 ||
 			!reflect.DeepEqual(v, mss[0]) {
 			t.Error("expired value was not removed")
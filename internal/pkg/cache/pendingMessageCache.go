@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+type pmcValue struct {
+	//signer is the name whose infrastructure key is queried
+	signer string
+	//entries contains the signed messages waiting for the infrastructure key of signer
+	entries []PendingMessageEntry
+	//expiration contains the expiration value of the infrastructure key query
+	expiration int64
+}
+
+type PendingMessageImpl struct {
+	//mux protects signerMap, tokenMap and senders from simultaneous access.
+	mux sync.Mutex
+	//signerMap is a map from signer to the token of the pending infrastructure key query
+	signerMap map[string]token.Token
+	//tokenMap is a map from token to the messages waiting for the query's answer
+	tokenMap map[token.Token]*pmcValue
+	//senders holds the number of cached messages per sender
+	senders map[string]int
+	//senderLimit is the maximum number of cached messages of one sender
+	senderLimit int
+	//senderKey returns the key under which the messages of a sender are counted
+	senderKey func(net.Addr) string
+	//counter holds the number of messages stored in the cache
+	counter *safeCounter.Counter
+}
+
+//NewPendingMessage returns a pending message cache holding at most maxSize messages of which at
+//most senderLimit are from the same sender. Senders are distinguished by senderKey.
+func NewPendingMessage(maxSize, senderLimit int, senderKey func(net.Addr) string) *PendingMessageImpl {
+	return &PendingMessageImpl{
+		signerMap:   make(map[string]token.Token),
+		tokenMap:    make(map[token.Token]*pmcValue),
+		senders:     make(map[string]int),
+		senderLimit: senderLimit,
+		senderKey:   senderKey,
+		counter:     safeCounter.New(maxSize),
+	}
+}
+
+//Add checks if a query for the infrastructure key of msg's signer is already pending. If this is
+//the case, msg received from sender is added to it and false is returned. If not, msg is added
+//together with the token and expiration time of a new query and true is returned. msg is dropped
+//and false is returned if the cache is full or sender already has the maximum number of messages
+//in the cache.
+func (c *PendingMessageImpl) Add(msg *message.Message, sender net.Addr, t token.Token,
+	expiration int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.counter.IsFull() {
+		log.Error("Pending message cache is full")
+		return false
+	}
+	key := c.senderKey(sender)
+	if c.senders[key] >= c.senderLimit {
+		log.Warn("Sender has too many messages waiting for an infrastructure key. Drop message",
+			"sender", sender, "signer", msg.Signer)
+		return false
+	}
+	c.senders[key]++
+	c.counter.Inc()
+	entry := PendingMessageEntry{Msg: msg, Sender: sender}
+	if t, ok := c.signerMap[msg.Signer]; ok && c.tokenMap[t].expiration > time.Now().Unix() {
+		c.tokenMap[t].entries = append(c.tokenMap[t].entries, entry)
+		return false
+	}
+	c.signerMap[msg.Signer] = t
+	c.tokenMap[t] = &pmcValue{
+		signer:     msg.Signer,
+		entries:    []PendingMessageEntry{entry},
+		expiration: expiration,
+	}
+	return true
+}
+
+//GetAndRemove returns all messages and their senders which wait for the query with token t and
+//true, and deletes them from the cache. False is returned if no message matched t.
+func (c *PendingMessageImpl) GetAndRemove(t token.Token) ([]PendingMessageEntry, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	val, ok := c.tokenMap[t]
+	if !ok {
+		return nil, false
+	}
+	c.remove(t, val)
+	return val.entries, true
+}
+
+//remove deletes the query with token t and its messages val. The caller must hold the lock.
+func (c *PendingMessageImpl) remove(t token.Token, val *pmcValue) {
+	delete(c.tokenMap, t)
+	if c.signerMap[val.signer] == t {
+		delete(c.signerMap, val.signer)
+	}
+	for _, entry := range val.entries {
+		key := c.senderKey(entry.Sender)
+		if c.senders[key]--; c.senders[key] <= 0 {
+			delete(c.senders, key)
+		}
+	}
+	c.counter.Sub(len(val.entries))
+}
+
+//ContainsToken returns true if t is cached
+func (c *PendingMessageImpl) ContainsToken(t token.Token) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, present := c.tokenMap[t]
+	return present
+}
+
+//RemoveExpiredValues deletes all expired entries. It logs the signer whose infrastructure key did
+//not arrive in time.
+func (c *PendingMessageImpl) RemoveExpiredValues() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for t, val := range c.tokenMap {
+		if val.expiration < time.Now().Unix() {
+			c.remove(t, val)
+			log.Warn("No response to infrastructure key query received before expiration",
+				"signer", val.signer, "messages", len(val.entries))
+		}
+	}
+}
+
+//Len returns the number of messages in the cache
+func (c *PendingMessageImpl) Len() int {
+	return c.counter.Value()
+}
+
+//Resize changes the maximum number of messages in the cache to maxSize.
+func (c *PendingMessageImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestPendingMessageCache(t *testing.T) {
+	sender := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}
+	msgs := []*message.Message{
+		&message.Message{Token: token.New(), Signer: "ns.ethz.ch."},
+		&message.Message{Token: token.New(), Signer: "ns.ch."},
+	}
+	toks := []token.Token{token.New(), token.New()}
+	var c PendingMessage = NewPendingMessage(2, 2, net.Addr.String)
+	if c.Len() != 0 {
+		t.Errorf("init size is incorrect actual=%d", c.Len())
+	}
+	if !c.Add(msgs[0], sender, toks[0], time.Now().Add(time.Hour).Unix()) ||
+		!c.Add(msgs[1], sender, toks[1], time.Now().Add(-time.Hour).Unix()) {
+		t.Error("no query must be sent for a new signer")
+	}
+	if c.Len() != 2 {
+		t.Errorf("messages were not added to the cache. len=%d", c.Len())
+	}
+	if !c.ContainsToken(toks[0]) || !c.ContainsToken(toks[1]) || c.ContainsToken(token.New()) {
+		t.Error("unexpected token was in the cache")
+	}
+	//Test max size
+	c.Add(&message.Message{}, sender, token.New(), time.Now().Add(time.Hour).Unix())
+	if c.Len() != 2 {
+		t.Error("was able to add more entries than maxSize")
+	}
+	c.RemoveExpiredValues()
+	if c.Len() != 1 || c.ContainsToken(toks[1]) {
+		t.Error("expired value was not removed")
+	}
+	if entries, ok := c.GetAndRemove(toks[0]); !ok || len(entries) != 1 ||
+		entries[0].Msg != msgs[0] || entries[0].Sender != sender || c.Len() != 0 {
+		t.Error("msgs[0] should be returned for this token")
+	}
+	if _, ok := c.GetAndRemove(toks[0]); ok {
+		t.Error("removed message was returned")
+	}
+}
+
+func TestPendingMessageCacheSigner(t *testing.T) {
+	senders := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022},
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5022},
+	}
+	c := NewPendingMessage(10, 2, net.Addr.String)
+	exp := time.Now().Add(time.Hour).Unix()
+	tok := token.New()
+	if !c.Add(&message.Message{Signer: "ns.ch."}, senders[0], tok, exp) {
+		t.Error("no query must be sent for a new signer")
+	}
+	//Later messages of the same signer wait for the same query.
+	if c.Add(&message.Message{Signer: "ns.ch."}, senders[0], token.New(), exp) ||
+		c.Add(&message.Message{Signer: "ns.ch."}, senders[1], token.New(), exp) {
+		t.Error("second query was sent for the same signer")
+	}
+	//The first sender has reached its limit.
+	if c.Add(&message.Message{Signer: "ns.ethz.ch."}, senders[0], token.New(), exp) {
+		t.Error("message of a sender which reached its limit was added")
+	}
+	if c.Len() != 3 {
+		t.Errorf("wrong number of cached messages. expected=3 actual=%d", c.Len())
+	}
+	if entries, ok := c.GetAndRemove(tok); !ok || len(entries) != 3 {
+		t.Errorf("not all messages of the signer were returned. entries=%v", entries)
+	}
+	//The messages of the first sender have been removed.
+	if !c.Add(&message.Message{Signer: "ns.ch."}, senders[0], token.New(), exp) {
+		t.Error("no query must be sent after the previous one has been answered")
+	}
+}
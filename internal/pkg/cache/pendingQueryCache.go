@@ -108,24 +108,48 @@ func (c *PendingQueryImpl) GetAndRemove(t token.Token) []util.MsgSectionSender {
 	return nil
 }
 
-//RemoveExpiredValues deletes all expired entries.
-func (c *PendingQueryImpl) RemoveExpiredValues() {
+//RemoveExpiredValues deletes all expired entries and returns an entry for each deleted
+//util.MsgSectionSender.
+func (c *PendingQueryImpl) RemoveExpiredValues() []PendingEntry {
 	c.qmux.Lock()
 	c.tmux.Lock()
 	defer c.qmux.Unlock()
 	defer c.tmux.Unlock()
 
+	expired := []PendingEntry{}
 	for k, v := range c.tokenMap {
 		if v.expiration < time.Now().Unix() {
 			delete(c.tokenMap, k)
 			key, _ := pqcKey(v.sss[0].Sections) //error case is catched in Add method.
 			delete(c.queryMap, key)             //all sss have the same pqcKey
 			c.counter.Sub(len(v.sss))
+			for _, ss := range v.sss {
+				expired = append(expired, PendingEntry{Token: k, Expiration: v.expiration, Waiting: ss})
+			}
 		}
 	}
+	return expired
+}
+
+//GetAll returns an entry for each cached util.MsgSectionSender.
+func (c *PendingQueryImpl) GetAll() []PendingEntry {
+	c.tmux.Lock()
+	defer c.tmux.Unlock()
+	entries := []PendingEntry{}
+	for t, v := range c.tokenMap {
+		for _, ss := range v.sss {
+			entries = append(entries, PendingEntry{Token: t, Expiration: v.expiration, Waiting: ss})
+		}
+	}
+	return entries
 }
 
 //Len returns the number of sections in the cache
 func (c *PendingQueryImpl) Len() int {
 	return c.counter.Value()
 }
+
+//Resize changes the maximum number of sections in the cache to maxSize.
+func (c *PendingQueryImpl) Resize(maxSize int) {
+	c.counter.SetMaxCount(maxSize)
+}
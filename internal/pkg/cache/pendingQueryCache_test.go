@@ -34,6 +34,16 @@ func TestPendingQueryCache(t *testing.T) {
 		if ok := c.Add(mss[2], mss[2].Token, time.Now().Add(time.Hour).Unix()); !ok || c.Len() != 3 {
 			t.Error("mss[2] was not added to the cache")
 		}
+		//Test c.GetAll()
+		entries := c.GetAll()
+		if len(entries) != 3 {
+			t.Errorf("wrong number of entries. expected=3 actual=%d", len(entries))
+		}
+		for _, e := range entries {
+			if e.Token == mss[1].Token {
+				t.Error("mss[1] must wait for the query of mss[0]")
+			}
+		}
 		//Test c.GetAndRemove()
 		if v := c.GetAndRemove(mss[1].Token); len(v) != 0 || c.Len() != 3 {
 			t.Error("token should not be part of the cache")
@@ -49,7 +59,10 @@ func TestPendingQueryCache(t *testing.T) {
 		//Test c.RemoveExpiredValues()
 		c.Add(mss[0], mss[0].Token, time.Now().Add(time.Hour).Unix())
 		c.Add(mss[2], mss[2].Token, time.Now().Add(-time.Hour).Unix())
-		c.RemoveExpiredValues()
+		if expired := c.RemoveExpiredValues(); len(expired) != 1 ||
+			expired[0].Token != mss[2].Token || !reflect.DeepEqual(expired[0].Waiting, mss[2]) {
+			t.Errorf("wrong expired entries: %v", expired)
+		}
 		if v := c.GetAndRemove(mss[0].Token); c.Len() != 0 || !reflect.DeepEqual(v[0], mss[0]) {
 			t.Error("expired value was not removed")
 		}
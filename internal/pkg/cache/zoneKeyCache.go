@@ -48,23 +48,30 @@ type publicKeyAssertion struct {
 type ZoneKeyImpl struct {
 	cache   *lruCache.Cache //key=zone,context,algorithmType,phaseID
 	counter *safeCounter.Counter
-	//warnSize defines the number of public keys after which the add function returns true
+	//warnSize defines the number of public keys after which the add function returns true. It is
+	//protected by mux.
 	warnSize int
 	//maxPublicKeysPerZone defines the number of keys per zone after which a message is logged that
 	//this zone uses too many public keys.
 	maxPublicKeysPerZone int
+	//tooManyKeys is called with the zone and context of a zone which has more than
+	//maxPublicKeysPerZone public keys. It might be nil.
+	tooManyKeys func(zone, context string)
 
 	mux sync.Mutex
 	//keysPerContextZone counts the number of public keys stored per zone and context
 	keysPerContextZone map[string]int //key=zone,context
 }
 
-func NewZoneKey(maxSize, warnSize, maxKeysPerZone int) *ZoneKeyImpl {
+//NewZoneKey returns a new zone key cache. tooManyKeys is called whenever a zone exceeds
+//maxKeysPerZone public keys. It might be nil.
+func NewZoneKey(maxSize, warnSize, maxKeysPerZone int, tooManyKeys func(zone, context string)) *ZoneKeyImpl {
 	return &ZoneKeyImpl{
 		cache:                lruCache.New(),
 		counter:              safeCounter.New(maxSize),
 		warnSize:             warnSize,
 		maxPublicKeysPerZone: maxKeysPerZone,
+		tooManyKeys:          tooManyKeys,
 		keysPerContextZone:   make(map[string]int),
 	}
 }
@@ -72,8 +79,8 @@ func NewZoneKey(maxSize, warnSize, maxKeysPerZone int) *ZoneKeyImpl {
 //Add adds publicKey together with the assertion containing it to the cache. Returns false if
 //the cache exceeds a configured (during initialization of the cache) amount of entries. If the
 //cache is full it removes a public key according to some metric. The cache logs a message when
-//a zone has more than a certain (configurable) amount of public keys. (An external service can
-//then decide if it wants to blacklist a given zone). If the internal flag is set, the publicKey
+//a zone has more than a certain (configurable) amount of public keys and reports the zone to the
+//tooManyKeys callback (which can then decide if it wants to blacklist the zone). If the internal flag is set, the publicKey
 //will only be removed after it expired.
 func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey, internal bool) bool {
 	log.Info("Adding key to cache", "publicKey", publicKey, "assertion", assertion)
@@ -96,13 +103,17 @@ func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey
 	if ok {
 		c.mux.Lock()
 		c.keysPerContextZone[v.getContextZone()]++
-		if c.keysPerContextZone[v.getContextZone()] > c.maxPublicKeysPerZone {
+		tooManyKeys := c.keysPerContextZone[v.getContextZone()] > c.maxPublicKeysPerZone
+		if tooManyKeys {
 			log.Warn("There are too many publicKeys for a zone and context", "zone", subjectName,
 				"context", assertion.Context, "allowed", c.maxPublicKeysPerZone, "actual",
 				c.keysPerContextZone[v.getContextZone()])
 		}
 		c.mux.Unlock()
 		v.mux.Unlock()
+		if tooManyKeys && c.tooManyKeys != nil {
+			c.tooManyKeys(subjectName, assertion.Context)
+		}
 		if c.counter.Inc() {
 			//cache is full, remove least recently used public key.
 			for {
@@ -133,6 +144,8 @@ func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey
 			}
 		}
 	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	return c.counter.Value() < c.warnSize
 }
 
@@ -200,6 +213,15 @@ func (c *ZoneKeyImpl) Len() int {
 	return c.counter.Value()
 }
 
+//Resize changes the maximum number of public keys in the cache to maxSize and the number of public
+//keys after which Add returns false to warnSize.
+func (c *ZoneKeyImpl) Resize(maxSize, warnSize int) {
+	c.mux.Lock()
+	c.warnSize = warnSize
+	c.mux.Unlock()
+	c.counter.SetMaxCount(maxSize)
+}
+
 func zoneCtxKey(zone, context string) string {
 	return fmt.Sprintf("%s %s", zone, context)
 }
@@ -127,3 +127,19 @@ func TestCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestZoneKeyTooManyKeys(t *testing.T) {
+	var reported []string
+	c := NewZoneKey(10, 8, 1, func(zone, context string) {
+		reported = append(reported, zone+" "+context)
+	})
+	delegationsCH := getExampleDelgations("ch")
+	c.Add(delegationsCH[0], delegationsCH[0].Content[0].Value.(keys.PublicKey), false)
+	if len(reported) != 0 {
+		t.Fatalf("zone reported although it has not too many keys. reported=%v", reported)
+	}
+	c.Add(delegationsCH[2], delegationsCH[2].Content[0].Value.(keys.PublicKey), false)
+	if len(reported) != 1 || reported[0] != "ch. ." {
+		t.Errorf("zone with too many keys not reported correctly. reported=%v", reported)
+	}
+}
@@ -0,0 +1,88 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+//Major types of CBOR data items as defined in RFC 7049.
+const (
+	MajorUnsigned byte = iota
+	MajorNegative
+	MajorBytes
+	MajorText
+	MajorArray
+	MajorMap
+	MajorTag
+	MajorSimple
+)
+
+//maxSkipDepth is the maximal nesting depth of data items Skip is going through.
+const maxSkipDepth = 64
+
+//ReadHead reads the initial byte and the argument of a CBOR data item from in. It returns the
+//item's major type and argument. Indefinite length items are not supported.
+func ReadHead(in io.Reader) (major byte, arg uint64, err error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(in, b[:1]); err != nil {
+		return 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		_, err = io.ReadFull(in, b[:1])
+		return major, uint64(b[0]), err
+	case info == 25:
+		_, err = io.ReadFull(in, b[:2])
+		return major, uint64(binary.BigEndian.Uint16(b)), err
+	case info == 26:
+		_, err = io.ReadFull(in, b[:4])
+		return major, uint64(binary.BigEndian.Uint32(b)), err
+	case info == 27:
+		_, err = io.ReadFull(in, b)
+		return major, binary.BigEndian.Uint64(b), err
+	default:
+		return 0, 0, fmt.Errorf("unsupported additional information in cbor head: %d", info)
+	}
+}
+
+//Skip reads and discards one CBOR data item from in. In contrast to Unmarshal, the memory it uses
+//does not depend on the size of the item.
+func Skip(in io.Reader) error {
+	return skip(in, 0)
+}
+
+func skip(in io.Reader, depth int) error {
+	if depth > maxSkipDepth {
+		return errors.New("cbor data items are nested too deeply")
+	}
+	major, arg, err := ReadHead(in)
+	if err != nil {
+		return err
+	}
+	switch major {
+	case MajorBytes, MajorText:
+		if arg > 1<<62 {
+			return fmt.Errorf("cbor string length is too large: %d", arg)
+		}
+		_, err := io.CopyN(ioutil.Discard, in, int64(arg))
+		return err
+	case MajorArray, MajorMap:
+		if major == MajorMap {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if err := skip(in, depth+1); err != nil {
+				return err
+			}
+		}
+	case MajorTag:
+		return skip(in, depth+1)
+	}
+	return nil
+}
@@ -1,17 +1,13 @@
 package connection
 
 import (
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"reflect"
+	"strings"
 
-	"github.com/netsec-ethz/rains/internal/pkg/cbor"
-	"github.com/netsec-ethz/rains/internal/pkg/connection/scion"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
@@ -20,6 +16,13 @@ import (
 
 const MaxUDPPacketBytes = 9000
 
+//UDPPrefix marks an address string as a plain UDP address.
+const UDPPrefix = "udp:"
+
+//ErrMsgTooLarge is returned by WriteMessage if a message does not fit into a datagram.
+var ErrMsgTooLarge = fmt.Errorf("message is larger than %d bytes and does not fit into a datagram",
+	MaxUDPPacketBytes)
+
 //Info contains address information about one actor of a connection of the declared type
 type Info struct {
 	Type Type
@@ -58,6 +61,19 @@ func UnmarshalNetAddr(data []byte) (Type, net.Addr, error) {
 		if err = json.Unmarshal(addrData, &value); err != nil {
 			return -1, nil, err
 		}
+	case "UDP":
+		value = reflect.New(reflect.TypeOf(net.UDPAddr{})).Interface()
+		t = UDP
+		if _, ok := m["UDPAddr"]; !ok {
+			return -1, nil, errors.New("UDPAddr key not found in JSON config")
+		}
+		addrData, err := json.Marshal(m["UDPAddr"])
+		if err != nil {
+			return -1, nil, err
+		}
+		if err = json.Unmarshal(addrData, &value); err != nil {
+			return -1, nil, err
+		}
 	case "SCION":
 		if _, ok := m["SCIONAddr"]; !ok {
 			return -1, nil, errors.New("local address is required for SCION")
@@ -81,6 +97,20 @@ func UnmarshalNetAddr(data []byte) (Type, net.Addr, error) {
 	return t, value.(net.Addr), nil
 }
 
+//ParseInfo returns the address information of value. A value starting with UDPPrefix is a plain
+//UDP address. Otherwise, value is parsed as a TCP address and if this fails as a SCION address.
+func ParseInfo(value string) (Info, error) {
+	if strings.HasPrefix(value, UDPPrefix) {
+		addr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(value, UDPPrefix))
+		return Info{Type: UDP, Addr: addr}, err
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", value); err == nil {
+		return Info{Type: TCP, Addr: addr}, nil
+	}
+	addr, err := snet.ParseUDPAddr(value)
+	return Info{Type: SCION, Addr: addr}, err
+}
+
 //Type enumerates connection types
 type Type int
 
@@ -90,18 +120,28 @@ type Type int
 const (
 	TCP Type = iota + 1
 	SCION
+	UDP
+	Memory
 )
 
-//CreateConnection returns a newly created connection with connInfo or an error
+//CreateConnection returns a newly created connection with connInfo or an error. The server's tls
+//certificate is not verified.
 func CreateConnection(addr net.Addr) (conn net.Conn, err error) {
-	switch a := addr.(type) {
-	case *net.TCPAddr:
-		return tls.Dial(a.Network(), a.String(), &tls.Config{InsecureSkipVerify: true})
-	case *snet.UDPAddr:
-		return scion.DialAddr(a)
-	default:
-		return nil, fmt.Errorf("unsupported Network address type: %s", addr)
+	return CreateVerifiedConnection(addr, TLSVerification{Insecure: true})
+}
+
+//CreateVerifiedConnection returns a newly created connection with connInfo or an error. The
+//server's tls certificate is verified according to v.
+func CreateVerifiedConnection(addr net.Addr, v TLSVerification) (conn net.Conn, err error) {
+	t, err := TransportOf(addr)
+	if err != nil {
+		return nil, err
 	}
+	if tlsTransport, ok := t.(TLSTransport); ok {
+		tlsTransport.ClientConfig = v.TLSConfig()
+		t = tlsTransport
+	}
+	return t.Dial(addr)
 }
 
 func ReceiveMessageAsync(conn net.Conn, tok token.Token, done chan<- message.Message, ec chan<- error) {
@@ -121,44 +161,21 @@ func ReceiveMessageAsync(conn net.Conn, tok token.Token, done chan<- message.Mes
 }
 
 // ReceiveMessage receives and unmarshals one message.Message from conn.
-// conn can either be a datagram (PacketConn) or a stream connection.
+// The message is read according to the transport of conn's remote address. Connections of unknown
+// transports are read as streams.
 func ReceiveMessage(conn net.Conn) (*message.Message, error) {
-	var reader io.Reader
-	switch c := conn.(type) {
-	case net.PacketConn:
-		// XXX(matzf): this is a weird check because it requires the conn to be
-		// Conn and PacketConn, but this works for snet.Conn and net.UDPConn.
-
-		// Read one datagram and then parse message from buffer
-		buf := make([]byte, MaxUDPPacketBytes)
-		n, _, err := c.ReadFrom(buf)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to Read: %v", err)
-		}
-		reader = bytes.NewReader(buf[:n])
-	default:
-		reader = conn
-	}
-
-	msg := new(message.Message)
-	if err := cbor.NewReader(reader).Unmarshal(msg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal CBOR: %v", err)
+	if t, err := TransportOf(conn.RemoteAddr()); err == nil {
+		return t.ReceiveMessage(conn)
 	}
-	return msg, nil
+	return receiveStream(conn)
 }
 
 // WriteMessage marshals one message.Message and writes it to conn.
-// conn can either be a datagram (PacketConn) or a stream connection.
+// The message is written according to the transport of conn's remote address. ErrMsgTooLarge is
+// returned if conn is a datagram connection and the message is larger than MaxUDPPacketBytes.
 func WriteMessage(conn net.Conn, msg *message.Message) error {
-
-	// Note: buffer message as direct Write to the Conn would be wrong for
-	// datagram connections and potentially slow for stream connections
-	encoding := new(bytes.Buffer)
-	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
-		return fmt.Errorf("failed to marshal message: %s", err)
-	}
-	if _, err := conn.Write(encoding.Bytes()); err != nil {
-		return fmt.Errorf("unable to write encoded message to connection: %s", err)
+	if t, err := TransportOf(conn.RemoteAddr()); err == nil {
+		return t.WriteMessage(conn, msg)
 	}
-	return nil
+	return writeMessage(conn, msg, false)
 }
@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"net"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestUnmarshalNetAddr(t *testing.T) {
+	var tests = []struct {
+		input    string
+		wantType Type
+		wantAddr string
+	}{
+		{`{"Type":"TCP","TCPAddr":{"IP":"127.0.0.1","Port":5022}}`, TCP, "127.0.0.1:5022"},
+		{`{"Type":"UDP","UDPAddr":{"IP":"127.0.0.1","Port":5022}}`, UDP, "127.0.0.1:5022"},
+	}
+	for i, test := range tests {
+		typ, addr, err := UnmarshalNetAddr([]byte(test.input))
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if typ != test.wantType || addr.String() != test.wantAddr {
+			t.Errorf("%d: wrong address. expected=%v %s actual=%v %v", i, test.wantType,
+				test.wantAddr, typ, addr)
+		}
+	}
+	if _, _, err := UnmarshalNetAddr([]byte(`{"Type":"UDP"}`)); err == nil {
+		t.Error("UDP address without UDPAddr was accepted")
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	var tests = []struct {
+		input    string
+		wantType Type
+		wantAddr net.Addr
+	}{
+		{"127.0.0.1:5022", TCP, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}},
+		{"udp:127.0.0.1:5022", UDP, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}},
+		{"udp:[::1]:53", UDP, &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53}},
+	}
+	for i, test := range tests {
+		info, err := ParseInfo(test.input)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if info.Type != test.wantType || info.Addr.Network() != test.wantAddr.Network() ||
+			info.Addr.String() != test.wantAddr.String() {
+			t.Errorf("%d: wrong info. expected=%v %v actual=%v %v", i, test.wantType,
+				test.wantAddr, info.Type, info.Addr)
+		}
+	}
+	if _, err := ParseInfo("udp:notAnAddress"); err == nil {
+		t.Error("malformed UDP address was accepted")
+	}
+}
+
+func TestUDPMessage(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer server.Close()
+	conn, err := CreateConnection(server.LocalAddr())
+	if err != nil {
+		t.Fatalf("Was not able to create UDP connection: %v", err)
+	}
+	defer conn.Close()
+	msg := &message.Message{
+		Token:   token.New(),
+		Content: []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Was not able to write message: %v", err)
+	}
+	buf := make([]byte, MaxUDPPacketBytes)
+	n, sender, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Was not able to read datagram: %v", err)
+	}
+	if _, err := server.WriteTo(buf[:n], sender); err != nil {
+		t.Fatalf("Was not able to echo datagram: %v", err)
+	}
+	answer, err := ReceiveMessage(conn)
+	if err != nil {
+		t.Fatalf("Was not able to receive message: %v", err)
+	}
+	if answer.Token != msg.Token {
+		t.Errorf("wrong token. expected=%v actual=%v", msg.Token, answer.Token)
+	}
+}
@@ -0,0 +1,135 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+)
+
+//MemoryAddr is an address of the in-memory transport. It is only reachable from within the same
+//process.
+type MemoryAddr string
+
+//Network returns the name of the in-memory network.
+func (a MemoryAddr) Network() string {
+	return "memory"
+}
+
+func (a MemoryAddr) String() string {
+	return string(a)
+}
+
+//memoryNetwork contains the listeners of the in-memory transport by their address.
+var memoryNetwork = struct {
+	sync.Mutex
+	listeners map[MemoryAddr]*memoryListener
+	//dialed is the number of connections dialed so far. It is used to assign each dialing end a
+	//unique address.
+	dialed int
+}{listeners: make(map[MemoryAddr]*memoryListener)}
+
+//MemoryTransport connects peers within the same process over synchronous in-memory pipes. It
+//allows running several servers in one process without sockets.
+type MemoryTransport struct{}
+
+//Dial returns a connection to the in-memory listener on addr.
+func (MemoryTransport) Dial(addr net.Addr) (net.Conn, error) {
+	a, ok := addr.(MemoryAddr)
+	if !ok {
+		return nil, fmt.Errorf("type assertion failed. Expected MemoryAddr, got %T", addr)
+	}
+	memoryNetwork.Lock()
+	l, ok := memoryNetwork.listeners[a]
+	memoryNetwork.dialed++
+	local := MemoryAddr(fmt.Sprintf("dialer-%d", memoryNetwork.dialed))
+	memoryNetwork.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("connection refused: no listener on %s", a)
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &memoryConn{Conn: server, local: a, remote: local}:
+		return &memoryConn{Conn: client, local: local, remote: a}, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("connection refused: listener on %s is closed", a)
+	}
+}
+
+//Listen returns a stream listener on addr. Only one listener can exist per address.
+func (MemoryTransport) Listen(addr net.Addr) (Listener, error) {
+	a, ok := addr.(MemoryAddr)
+	if !ok {
+		return Listener{}, fmt.Errorf("type assertion failed. Expected MemoryAddr, got %T", addr)
+	}
+	memoryNetwork.Lock()
+	defer memoryNetwork.Unlock()
+	if _, ok := memoryNetwork.listeners[a]; ok {
+		return Listener{}, fmt.Errorf("address already in use: %s", a)
+	}
+	l := &memoryListener{addr: a, conns: make(chan net.Conn), closed: make(chan struct{})}
+	memoryNetwork.listeners[a] = l
+	return Listener{Stream: l}, nil
+}
+
+//WriteMessage writes msg to the stream conn.
+func (MemoryTransport) WriteMessage(conn net.Conn, msg *message.Message) error {
+	return writeMessage(conn, msg, false)
+}
+
+//ReceiveMessage reads the next message from the stream conn.
+func (MemoryTransport) ReceiveMessage(conn net.Conn) (*message.Message, error) {
+	return receiveStream(conn)
+}
+
+//memoryListener accepts the connections dialed to its address.
+type memoryListener struct {
+	addr      MemoryAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+//Accept waits for and returns the next connection dialed to the listener.
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("use of closed in-memory listener")
+	}
+}
+
+//Close frees the listener's address. Blocked calls to Accept return an error.
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		memoryNetwork.Lock()
+		delete(memoryNetwork.listeners, l.addr)
+		memoryNetwork.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+//memoryConn is one end of an in-memory pipe with the addresses of both ends.
+type memoryConn struct {
+	net.Conn
+	local  MemoryAddr
+	remote MemoryAddr
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
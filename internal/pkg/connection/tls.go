@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"golang.org/x/crypto/sha3"
+)
+
+//TLSVerification determines how the certificate presented by a TLS server is verified.
+type TLSVerification struct {
+	//Insecure disables the verification of the server's certificate.
+	Insecure bool
+	//RootCAs is the set of CAs against which the server's certificate chain is verified. If it is
+	//nil, the host's root CA set is used.
+	RootCAs *x509.CertPool
+	//ServerName is checked against the names in the server's certificate. The check is skipped if
+	//it is empty.
+	ServerName string
+	//CertInfos contains the certificate information published for the server's name. If it is
+	//not empty, the server's certificate chain must match one of them instead of being verified
+	//against RootCAs.
+	CertInfos []object.Certificate
+}
+
+//TLSConfig returns a tls client configuration which verifies the server's certificate according to
+//v.
+func (v TLSVerification) TLSConfig() *tls.Config {
+	//The default verification of the tls package cannot handle pinned certificates. It is disabled
+	//and replaced by VerifyConnection which is called in all cases.
+	config := &tls.Config{InsecureSkipVerify: true}
+	if !v.Insecure {
+		config.VerifyConnection = v.verify
+	}
+	return config
+}
+
+//verify returns an error describing why the certificate chain in cs is not accepted.
+func (v TLSVerification) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls verification failed: server did not present a certificate")
+	}
+	if v.ServerName != "" {
+		if err := cs.PeerCertificates[0].VerifyHostname(v.ServerName); err != nil {
+			return fmt.Errorf("tls verification failed: %v", err)
+		}
+	}
+	if len(v.CertInfos) != 0 {
+		return VerifyCertInfos(cs.PeerCertificates, v.CertInfos)
+	}
+	if err := verifyChain(cs.PeerCertificates, v.RootCAs); err != nil {
+		return fmt.Errorf("tls verification failed: %v", err)
+	}
+	return nil
+}
+
+//VerifyCertInfos returns nil if chain matches at least one tls certificate information in infos.
+//An end entity certificate information must match the first certificate of chain. A trust anchor
+//certificate information must match a certificate of chain against which the chain verifies.
+func VerifyCertInfos(chain []*x509.Certificate, infos []object.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("tls verification failed: server did not present a certificate")
+	}
+	for _, info := range infos {
+		if info.Type != object.PTTLS {
+			continue
+		}
+		switch info.Usage {
+		case object.CUEndEntity:
+			if certInfoMatches(chain[0], info) {
+				return nil
+			}
+		case object.CUTrustAnchor:
+			for _, c := range chain {
+				if !certInfoMatches(c, info) {
+					continue
+				}
+				pool := x509.NewCertPool()
+				pool.AddCert(c)
+				if err := verifyChain(chain, pool); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("tls verification failed: certificate of %s does not match any of the %d "+
+		"published certificate information", chain[0].Subject, len(infos))
+}
+
+//verifyChain verifies chain against roots. All certificates but the first are used as
+//intermediates.
+func verifyChain(chain []*x509.Certificate, roots *x509.CertPool) error {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range chain[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
+
+//certInfoMatches returns true if the hash of cert's DER encoding (or the encoding itself if no
+//hash algorithm is specified) is equal to info's data.
+func certInfoMatches(cert *x509.Certificate, info object.Certificate) bool {
+	data, err := CertInfoData(cert, info.HashAlgo)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, info.Data)
+}
+
+//CertInfoData returns the data of a certificate information for cert with hash algorithm algo.
+func CertInfoData(cert *x509.Certificate, algo algorithmTypes.Hash) ([]byte, error) {
+	switch algo {
+	case algorithmTypes.NoHashAlgo:
+		return cert.Raw, nil
+	case algorithmTypes.Sha256:
+		hash := sha256.Sum256(cert.Raw)
+		return hash[:], nil
+	case algorithmTypes.Sha384:
+		hash := sha512.Sum384(cert.Raw)
+		return hash[:], nil
+	case algorithmTypes.Sha512:
+		hash := sha512.Sum512(cert.Raw)
+		return hash[:], nil
+	case algorithmTypes.Shake256:
+		hash := make([]byte, 64)
+		sha3.ShakeSum256(hash, cert.Raw)
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("unsupported certificate hash algorithm: %v", algo)
+	}
+}
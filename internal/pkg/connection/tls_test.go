@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+)
+
+//newCert returns a certificate for name signed by parent. It is self-signed if parent is nil.
+func newCert(t *testing.T, name string, isCA bool, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Was not able to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Was not able to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Was not able to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func certInfo(t *testing.T, cert *x509.Certificate, usage object.CertificateUsage,
+	algo algorithmTypes.Hash) object.Certificate {
+	t.Helper()
+	data, err := CertInfoData(cert, algo)
+	if err != nil {
+		t.Fatalf("Was not able to compute certificate information: %v", err)
+	}
+	return object.Certificate{Type: object.PTTLS, Usage: usage, HashAlgo: algo, Data: data}
+}
+
+func TestTLSVerification(t *testing.T) {
+	ca, caKey := newCert(t, "ca.example.", true, nil, nil)
+	leaf, _ := newCert(t, "ns.example", false, ca, caKey)
+	other, _ := newCert(t, "ns.example", false, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	chain := []*x509.Certificate{leaf, ca}
+
+	var tests = []struct {
+		name   string
+		v      TLSVerification
+		chain  []*x509.Certificate
+		errMsg string
+	}{
+		{"ca", TLSVerification{RootCAs: pool}, chain, ""},
+		{"ca with name", TLSVerification{RootCAs: pool, ServerName: "ns.example"}, chain, ""},
+		{"wrong name", TLSVerification{RootCAs: pool, ServerName: "www.example"}, chain,
+			"not www.example"},
+		{"unknown ca", TLSVerification{RootCAs: pool}, []*x509.Certificate{other},
+			"unknown authority"},
+		{"no certificate", TLSVerification{RootCAs: pool}, nil, "did not present a certificate"},
+		{"end entity", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, leaf, object.CUEndEntity, algorithmTypes.Sha256)}}, chain, ""},
+		{"end entity no hash", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, leaf, object.CUEndEntity, algorithmTypes.NoHashAlgo)}}, chain, ""},
+		{"end entity shake", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, leaf, object.CUEndEntity, algorithmTypes.Shake256)}}, chain, ""},
+		{"trust anchor", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, ca, object.CUTrustAnchor, algorithmTypes.Sha512)}}, chain, ""},
+		{"end entity is not anchor", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, ca, object.CUEndEntity, algorithmTypes.Sha256)}}, chain,
+			"does not match any"},
+		{"pinned other", TLSVerification{CertInfos: []object.Certificate{
+			certInfo(t, other, object.CUEndEntity, algorithmTypes.Sha384)}}, chain,
+			"does not match any"},
+	}
+	for _, test := range tests {
+		err := test.v.verify(tls.ConnectionState{PeerCertificates: test.chain})
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.errMsg, err)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	if config := (TLSVerification{Insecure: true}).TLSConfig(); config.VerifyConnection != nil {
+		t.Error("Insecure tls configuration must not verify connections")
+	}
+	if config := (TLSVerification{}).TLSConfig(); config.VerifyConnection == nil {
+		t.Error("tls configuration must verify connections")
+	}
+}
@@ -0,0 +1,233 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection/scion"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+//Transport establishes connections and exchanges messages over one type of network address.
+type Transport interface {
+	//Dial returns a new connection to addr.
+	Dial(addr net.Addr) (net.Conn, error)
+	//Listen returns a listener on addr.
+	Listen(addr net.Addr) (Listener, error)
+	//WriteMessage marshals msg and writes it to conn.
+	WriteMessage(conn net.Conn, msg *message.Message) error
+	//ReceiveMessage receives and unmarshals the next message on conn.
+	ReceiveMessage(conn net.Conn) (*message.Message, error)
+}
+
+//Listener listens on the address of a transport. Exactly one of its fields is set.
+type Listener struct {
+	//Stream accepts a connection for each peer of a stream transport.
+	Stream net.Listener
+	//Datagram receives the datagrams of all peers of a datagram transport. Messages are sent to a
+	//peer with WriteTo.
+	Datagram net.PacketConn
+}
+
+//Addr returns the address of the listener.
+func (l Listener) Addr() net.Addr {
+	if l.Stream != nil {
+		return l.Stream.Addr()
+	}
+	return l.Datagram.LocalAddr()
+}
+
+//Close closes the listener. Blocked calls to Accept or ReadFrom return an error.
+func (l Listener) Close() error {
+	if l.Stream != nil {
+		return l.Stream.Close()
+	}
+	return l.Datagram.Close()
+}
+
+//TransportOf returns the transport over which addr is reached. The tls transport does not verify
+//the certificate of the server it dials.
+func TransportOf(addr net.Addr) (Transport, error) {
+	switch addr.(type) {
+	case *net.TCPAddr:
+		return TLSTransport{}, nil
+	case *net.UDPAddr:
+		return UDPTransport{}, nil
+	case *snet.UDPAddr:
+		return SCIONTransport{}, nil
+	case MemoryAddr:
+		return MemoryTransport{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Network address type: %s", addr)
+	}
+}
+
+//TypeOf returns the connection type of addr or zero if addr is of an unknown type.
+func TypeOf(addr net.Addr) Type {
+	switch addr.(type) {
+	case *net.TCPAddr:
+		return TCP
+	case *net.UDPAddr:
+		return UDP
+	case *snet.UDPAddr:
+		return SCION
+	case MemoryAddr:
+		return Memory
+	default:
+		return 0
+	}
+}
+
+//TLSTransport sends messages over tls connections on tcp.
+type TLSTransport struct {
+	//ClientConfig is used to dial a server. If it is nil, the server's certificate is not
+	//verified.
+	ClientConfig *tls.Config
+	//ServerConfig is used to listen. It must provide a certificate.
+	ServerConfig *tls.Config
+	//KeepAlive is the keep alive period of dialed connections. If it is zero, a default period is
+	//used.
+	KeepAlive time.Duration
+}
+
+//Dial returns a new tls connection to addr.
+func (t TLSTransport) Dial(addr net.Addr) (net.Conn, error) {
+	config := t.ClientConfig
+	if config == nil {
+		config = TLSVerification{Insecure: true}.TLSConfig()
+	}
+	dialer := &net.Dialer{KeepAlive: t.KeepAlive}
+	return tls.DialWithDialer(dialer, addr.Network(), addr.String(), config)
+}
+
+//Listen returns a listener accepting tls connections on addr.
+func (t TLSTransport) Listen(addr net.Addr) (Listener, error) {
+	l, err := tls.Listen("tcp", addr.String(), t.ServerConfig)
+	return Listener{Stream: l}, err
+}
+
+//WriteMessage writes msg to the stream conn.
+func (TLSTransport) WriteMessage(conn net.Conn, msg *message.Message) error {
+	return writeMessage(conn, msg, false)
+}
+
+//ReceiveMessage reads the next message from the stream conn.
+func (TLSTransport) ReceiveMessage(conn net.Conn) (*message.Message, error) {
+	return receiveStream(conn)
+}
+
+//UDPTransport sends each message in a plain UDP datagram.
+type UDPTransport struct{}
+
+//Dial returns a UDP connection to addr.
+func (UDPTransport) Dial(addr net.Addr) (net.Conn, error) {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("type assertion failed. Expected *net.UDPAddr, got %T", addr)
+	}
+	return net.DialUDP(a.Network(), nil, a)
+}
+
+//Listen returns a UDP socket bound to addr.
+func (UDPTransport) Listen(addr net.Addr) (Listener, error) {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return Listener{}, fmt.Errorf("type assertion failed. Expected *net.UDPAddr, got %T", addr)
+	}
+	conn, err := net.ListenUDP(a.Network(), a)
+	if err != nil {
+		return Listener{}, err
+	}
+	return Listener{Datagram: conn}, nil
+}
+
+//WriteMessage writes msg in a datagram to conn. ErrMsgTooLarge is returned if msg does not fit.
+func (UDPTransport) WriteMessage(conn net.Conn, msg *message.Message) error {
+	return writeMessage(conn, msg, true)
+}
+
+//ReceiveMessage reads the message of the next datagram on conn.
+func (UDPTransport) ReceiveMessage(conn net.Conn) (*message.Message, error) {
+	return receiveDatagram(conn)
+}
+
+//SCIONTransport sends each message in a datagram over SCION.
+type SCIONTransport struct{}
+
+//Dial returns a SCION connection to addr.
+func (SCIONTransport) Dial(addr net.Addr) (net.Conn, error) {
+	a, ok := addr.(*snet.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("type assertion failed. Expected *snet.UDPAddr, got %T", addr)
+	}
+	return scion.DialAddr(a)
+}
+
+//Listen returns a SCION socket bound to addr.
+func (SCIONTransport) Listen(addr net.Addr) (Listener, error) {
+	a, ok := addr.(*snet.UDPAddr)
+	if !ok {
+		return Listener{}, fmt.Errorf("type assertion failed. Expected *snet.UDPAddr, got %T", addr)
+	}
+	conn, err := scion.Listen(a.Host)
+	if err != nil {
+		return Listener{}, err
+	}
+	return Listener{Datagram: conn}, nil
+}
+
+//WriteMessage writes msg in a datagram to conn. ErrMsgTooLarge is returned if msg does not fit.
+func (SCIONTransport) WriteMessage(conn net.Conn, msg *message.Message) error {
+	return writeMessage(conn, msg, true)
+}
+
+//ReceiveMessage reads the message of the next datagram on conn.
+func (SCIONTransport) ReceiveMessage(conn net.Conn) (*message.Message, error) {
+	return receiveDatagram(conn)
+}
+
+//receiveStream reads the next message from the stream conn.
+func receiveStream(conn net.Conn) (*message.Message, error) {
+	msg := new(message.Message)
+	if err := cbor.NewReader(conn).Unmarshal(msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CBOR: %v", err)
+	}
+	return msg, nil
+}
+
+//receiveDatagram reads one datagram from conn and returns the message it contains.
+func receiveDatagram(conn net.Conn) (*message.Message, error) {
+	buf := make([]byte, MaxUDPPacketBytes)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to Read: %v", err)
+	}
+	msg := new(message.Message)
+	if err := cbor.NewReader(bytes.NewReader(buf[:n])).Unmarshal(msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CBOR: %v", err)
+	}
+	return msg, nil
+}
+
+//writeMessage marshals msg and writes it to conn with a single write. ErrMsgTooLarge is returned
+//if conn is a datagram connection and msg is larger than MaxUDPPacketBytes.
+func writeMessage(conn net.Conn, msg *message.Message, datagram bool) error {
+	// Note: buffer message as direct Write to the Conn would be wrong for
+	// datagram connections and potentially slow for stream connections
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		return fmt.Errorf("failed to marshal message: %s", err)
+	}
+	if datagram && encoding.Len() > MaxUDPPacketBytes {
+		return ErrMsgTooLarge
+	}
+	if _, err := conn.Write(encoding.Bytes()); err != nil {
+		return fmt.Errorf("unable to write encoded message to connection: %s", err)
+	}
+	return nil
+}
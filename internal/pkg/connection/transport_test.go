@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"net"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+func TestTransportOf(t *testing.T) {
+	var tests = []struct {
+		addr     net.Addr
+		wantType Type
+		want     Transport
+	}{
+		{&net.TCPAddr{}, TCP, TLSTransport{}},
+		{&net.UDPAddr{}, UDP, UDPTransport{}},
+		{&snet.UDPAddr{}, SCION, SCIONTransport{}},
+		{MemoryAddr("server"), Memory, MemoryTransport{}},
+	}
+	for i, test := range tests {
+		if typ := TypeOf(test.addr); typ != test.wantType {
+			t.Errorf("%d: wrong type. expected=%v actual=%v", i, test.wantType, typ)
+		}
+		if transport, err := TransportOf(test.addr); err != nil || transport != test.want {
+			t.Errorf("%d: wrong transport. expected=%T actual=%T err=%v", i, test.want,
+				transport, err)
+		}
+	}
+	if _, err := TransportOf(&net.IPAddr{}); err == nil {
+		t.Error("transport of unsupported address type was returned")
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	addr := MemoryAddr("TestMemoryTransport")
+	if _, err := CreateConnection(addr); err == nil {
+		t.Fatal("dialed address without listener")
+	}
+	listener, err := MemoryTransport{}.Listen(addr)
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	if _, err := (MemoryTransport{}).Listen(addr); err == nil {
+		t.Error("listened twice on the same address")
+	}
+	go func() {
+		//The listener echoes the first message on each connection.
+		for {
+			conn, err := listener.Stream.Accept()
+			if err != nil {
+				return
+			}
+			msg, err := ReceiveMessage(conn)
+			if err == nil {
+				WriteMessage(conn, msg)
+			}
+		}
+	}()
+
+	conn, err := CreateConnection(addr)
+	if err != nil {
+		t.Fatalf("Was not able to dial: %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr() != addr || conn.LocalAddr() == addr {
+		t.Errorf("wrong connection addresses. local=%v remote=%v", conn.LocalAddr(),
+			conn.RemoteAddr())
+	}
+	msg := &message.Message{
+		Token:   token.New(),
+		Content: []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		t.Fatalf("Was not able to write message: %v", err)
+	}
+	answer, err := ReceiveMessage(conn)
+	if err != nil || answer.Token != msg.Token {
+		t.Fatalf("wrong echo. expected=%v actual=%v err=%v", msg, answer, err)
+	}
+
+	listener.Close()
+	if _, err := CreateConnection(addr); err == nil {
+		t.Error("dialed closed listener")
+	}
+}
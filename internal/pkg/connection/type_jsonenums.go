@@ -9,13 +9,17 @@ import (
 
 var (
 	_TypeNameToValue = map[string]Type{
-		"TCP":   TCP,
-		"SCION": SCION,
+		"TCP":    TCP,
+		"SCION":  SCION,
+		"UDP":    UDP,
+		"Memory": Memory,
 	}
 
 	_TypeValueToName = map[Type]string{
-		TCP:   "TCP",
-		SCION: "SCION",
+		TCP:    "TCP",
+		SCION:  "SCION",
+		UDP:    "UDP",
+		Memory: "Memory",
 	}
 )
 
@@ -23,8 +27,10 @@ func init() {
 	var v Type
 	if _, ok := interface{}(v).(fmt.Stringer); ok {
 		_TypeNameToValue = map[string]Type{
-			interface{}(TCP).(fmt.Stringer).String():   TCP,
-			interface{}(SCION).(fmt.Stringer).String(): SCION,
+			interface{}(TCP).(fmt.Stringer).String():    TCP,
+			interface{}(SCION).(fmt.Stringer).String():  SCION,
+			interface{}(UDP).(fmt.Stringer).String():    UDP,
+			interface{}(Memory).(fmt.Stringer).String(): Memory,
 		}
 	}
 }
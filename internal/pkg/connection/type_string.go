@@ -10,11 +10,13 @@ func _() {
 	var x [1]struct{}
 	_ = x[TCP-1]
 	_ = x[SCION-2]
+	_ = x[UDP-3]
+	_ = x[Memory-4]
 }
 
-const _Type_name = "TCPSCION"
+const _Type_name = "TCPSCIONUDPMemory"
 
-var _Type_index = [...]uint8{0, 3, 8}
+var _Type_index = [...]uint8{0, 3, 8, 11, 17}
 
 func (i Type) String() string {
 	i -= 1
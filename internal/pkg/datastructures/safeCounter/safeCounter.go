@@ -69,6 +69,17 @@ func (m *Counter) Info() (int, int) {
 
 //IsFull returns true if count is larger or equal to maxCount.
 func (m *Counter) IsFull() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.count >= m.maxCount
+}
+
+//SetMaxCount changes maxCount to maxcount. It returns true if count is larger or equal to the new
+//maxCount.
+func (m *Counter) SetMaxCount(maxcount int) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.maxCount = maxcount
 	return m.count >= m.maxCount
 }
 
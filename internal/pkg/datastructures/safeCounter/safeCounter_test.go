@@ -172,6 +172,17 @@ func TestIsFull(t *testing.T) {
 	}
 }
 
+func TestSetMaxCount(t *testing.T) {
+	counter := New(5)
+	counter.count = 3
+	if counter.SetMaxCount(4) || counter.maxCount != 4 {
+		t.Errorf("counter is not full after increasing maxCount. %v", counter)
+	}
+	if !counter.SetMaxCount(2) || !counter.IsFull() {
+		t.Errorf("counter is full after decreasing maxCount. %v", counter)
+	}
+}
+
 func TestString(t *testing.T) {
 	counter := New(5)
 	if counter.String() != "0/5" {
@@ -125,8 +125,9 @@ func (r *Resolver) ClientLookup(query *query.Name) (*message.Message, error) {
 }
 
 //ServerLookup forwards the query to the specified forwarders or performs a recursive lookup
-//starting at the specified root servers. It sends the received information to conInfo.
-func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.Token) {
+//starting at the specified root servers. It sends the received information to conInfo. An error is
+//returned if no information has been received.
+func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.Token) error {
 	var msg *message.Message
 	var err error
 	log.Info("recResolver received query", "query", query, "token", token)
@@ -137,11 +138,11 @@ func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.To
 		msg, err = r.forwardQuery(query)
 	default:
 		log.Error("Unsupported resolution mode", "mode", r.Mode)
-		return
+		return fmt.Errorf("Unsupported resolution mode: %v", r.Mode)
 	}
 	if err != nil {
 		log.Error("Query failed", "query failure", err)
-		return
+		return err
 	}
 	msg.Token = token
 	if conn, ok := r.Connections.GetConnection(addr); ok {
@@ -153,9 +154,11 @@ func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.To
 	} else {
 		r.createConnAndWrite(addr, msg)
 	}
+	return nil
 }
 
 func (r *Resolver) createConnAndWrite(addr net.Addr, msg *message.Message) {
+	//The server's certificate is not verified. Answers are authenticated by their signatures.
 	conn, err := connection.CreateConnection(addr)
 	if err != nil {
 		log.Error("Was not able to open a connection", "dst", addr)
@@ -253,6 +256,12 @@ func handleAnswer(r *Resolver, msg message.Message, q *query.Name, recurseCount
 		types[t] = true
 	}
 	for _, sec := range msg.Content {
+		if n, ok := sec.(*section.Notification); ok {
+			//An authoritative server informs the resolver that there is no answer.
+			isFinal = n.Type == section.NTNoAssertionsExist || n.Type == section.NTNoAssertionAvail
+			log.Info("Received notification", "type", n.Type, "isFinal", isFinal)
+			return
+		}
 		signed, ok := sec.(section.WithSigForward)
 		if !ok {
 			log.Error("Unexpected Section in Message not of type WithSigForward", "section", sec)
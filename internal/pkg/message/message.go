@@ -1,8 +1,13 @@
 package message
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	cbor "github.com/britram/borat"
 
@@ -27,6 +32,9 @@ type Message struct {
 	Content []section.Section
 	//Signatures authenticate the content of this message. An encoding of Message is signed by the infrastructure key of the originating server.
 	Signatures []signature.Sig
+	//Signer is the fully qualified name under which the infrastructure key of the originating
+	//server is published. It might be omitted if the message is not signed.
+	Signer string
 }
 
 func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
@@ -66,6 +74,10 @@ func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
 		}
 	} //capability might be omitted
 
+	if signer, ok := m[3].(string); ok {
+		rm.Signer = signer
+	} //signer might be omitted
+
 	tok, ok := m[2].([]byte)
 	if !ok || len(tok) != 16 {
 		return errors.New("cbor message encoding of the token should be a byte array of length 16")
@@ -153,6 +165,10 @@ func (rm *Message) MarshalCBOR(w *cbor.CBORWriter) error {
 		m[1] = caps
 	}
 	m[2] = rm.Token[:]
+	//The signer is an extension of the RAINS protocol, see docs/message-format.md.
+	if rm.Signer != "" {
+		m[3] = rm.Signer
+	}
 
 	msgsect := make([][2]interface{}, 0)
 	for _, sect := range rm.Content {
@@ -186,3 +202,32 @@ const (
 	//TLSOverTCP is used when the server listens for tls over tcp connections
 	TLSOverTCP Capability = "urn:x-rains:tlssrv"
 )
+
+//IsHash returns true if c is the hash of a capability list and not a capability urn.
+func (c Capability) IsHash() bool {
+	return !strings.HasPrefix(string(c), "urn:")
+}
+
+//SortCapabilities returns a copy of caps sorted in lexicographically increasing order.
+func SortCapabilities(caps []Capability) []Capability {
+	sorted := make([]Capability, len(caps))
+	copy(sorted, caps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+//CapabilityHash returns the hex encoded SHA-256 hash of the CBOR serialization of caps sorted in
+//lexicographically increasing order.
+func CapabilityHash(caps []Capability) (string, error) {
+	sorted := SortCapabilities(caps)
+	cs := make([]string, len(sorted))
+	for i, c := range sorted {
+		cs[i] = string(c)
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewCBORWriter(encoding).WriteStringArray(cs); err != nil {
+		return "", fmt.Errorf("was not able to serialize capabilities: %v", err)
+	}
+	hash := sha256.Sum256(encoding.Bytes())
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -55,6 +55,48 @@ func TestCBORErrorCases(t *testing.T) {
 	}
 }
 
+func TestReadToken(t *testing.T) {
+	msg := GetMessage()
+	encoding := new(bytes.Buffer)
+	for i := 0; i < 2; i++ {
+		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+			t.Fatalf("Was not able to marshal msg, err=%v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		tok, err := ReadToken(encoding)
+		if err != nil {
+			t.Fatalf("%d: Was not able to read token, err=%v", i, err)
+		}
+		if tok != msg.Token {
+			t.Errorf("%d: Token mismatch expected=%v actual=%v", i, msg.Token, tok)
+		}
+	}
+	if encoding.Len() != 0 {
+		t.Errorf("Messages were not read completely. remaining=%d", encoding.Len())
+	}
+
+	encWithTag := new(bytes.Buffer)
+	cbor2.NewCBORWriter(encWithTag).WriteTag(cbor2.CBORTag(rainsTag + 1))
+	noToken := new(bytes.Buffer)
+	cbor2.NewCBORWriter(noToken).WriteTag(cbor2.CBORTag(rainsTag))
+	cbor2.NewCBORWriter(noToken).WriteIntMap(map[int]interface{}{23: []interface{}{}})
+	var tests = []struct {
+		encoding []byte
+		errMsg   string
+	}{
+		{encWithTag.Bytes(), "expected tag for RAINS message but got: 15309737"},
+		{noToken.Bytes(), "cbor message encoding does not contain a token"},
+	}
+	for i, test := range tests {
+		if _, err := ReadToken(bytes.NewReader(test.encoding)); err == nil ||
+			err.Error() != test.errMsg {
+			t.Errorf("%d: Wrong error msg while reading token, expected=%s, actual=%v", i,
+				test.errMsg, err)
+		}
+	}
+}
+
 func CheckMessage(m1, m2 Message, t *testing.T) {
 	if m1.Token != m2.Token {
 		t.Error("Token mismatch")
@@ -67,6 +109,9 @@ func CheckMessage(m1, m2 Message, t *testing.T) {
 			t.Error("Capabilities mismatch")
 		}
 	}
+	if m1.Signer != m2.Signer {
+		t.Error("Signer mismatch")
+	}
 	if len(m1.Signatures) != len(m2.Signatures) {
 		t.Error("Signature count mismatch")
 	}
@@ -133,3 +178,32 @@ func CheckMessage(m1, m2 Message, t *testing.T) {
 		}
 	}
 }
+
+func TestCapabilityHash(t *testing.T) {
+	var tests = []struct {
+		input []Capability
+		want  string
+	}{
+		//hash taken from the draft
+		{[]Capability{TLSOverTCP}, "e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745"},
+		{[]Capability{NoCapability}, "efa1ab5d8a9573c4976b25c667d93dd9a57a554cd9f275dd20fc3139f58ee3e8"},
+	}
+	for i, test := range tests {
+		hash, err := CapabilityHash(test.input)
+		if err != nil || hash != test.want {
+			t.Errorf("%d: wrong capability hash. expected=%s actual=%s err=%v", i, test.want, hash, err)
+		}
+	}
+	caps := []Capability{TLSOverTCP, NoCapability}
+	hash1, _ := CapabilityHash(caps)
+	hash2, _ := CapabilityHash([]Capability{NoCapability, TLSOverTCP})
+	if hash1 != hash2 {
+		t.Error("capability hash depends on the order of the capabilities")
+	}
+	if caps[0] != TLSOverTCP {
+		t.Error("CapabilityHash modified its input")
+	}
+	if TLSOverTCP.IsHash() || !Capability(hash1).IsHash() {
+		t.Error("IsHash returned a wrong result")
+	}
+}
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//ReadToken reads one CBOR encoded message from in and returns its token without decoding the rest
+//of the message. The memory it uses does not depend on the message's size such that it can be used
+//to skip messages which are too large to be unmarshaled.
+func ReadToken(in io.Reader) (token.Token, error) {
+	tok := token.Token{}
+	major, tag, err := cbor.ReadHead(in)
+	if err != nil {
+		return tok, fmt.Errorf("failed to read tag: %v", err)
+	}
+	if major != cbor.MajorTag || tag != rainsTag {
+		return tok, fmt.Errorf("expected tag for RAINS message but got: %v", tag)
+	}
+	major, n, err := cbor.ReadHead(in)
+	if err != nil {
+		return tok, fmt.Errorf("failed to read map: %v", err)
+	}
+	if major != cbor.MajorMap {
+		return tok, errors.New("cbor message encoding should be a map")
+	}
+	found := false
+	for i := uint64(0); i < n; i++ {
+		major, key, err := cbor.ReadHead(in)
+		if err != nil {
+			return tok, fmt.Errorf("failed to read map key: %v", err)
+		}
+		if major != cbor.MajorUnsigned {
+			return tok, errors.New("cbor message encoding should only contain integer keys")
+		}
+		if key != 2 {
+			if err := cbor.Skip(in); err != nil {
+				return tok, fmt.Errorf("failed to skip map value: %v", err)
+			}
+			continue
+		}
+		major, length, err := cbor.ReadHead(in)
+		if err != nil || major != cbor.MajorBytes || length != uint64(len(tok)) {
+			return tok, errors.New("cbor message encoding of the token should be a byte array of length 16")
+		}
+		if _, err := io.ReadFull(in, tok[:]); err != nil {
+			return tok, fmt.Errorf("failed to read token: %v", err)
+		}
+		found = true
+	}
+	if !found {
+		return tok, errors.New("cbor message encoding does not contain a token")
+	}
+	return tok, nil
+}
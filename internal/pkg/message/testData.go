@@ -93,6 +93,7 @@ func GetMessage() Message {
 		Token:        token.New(),
 		Capabilities: []Capability{Capability("Test"), Capability("Yes!")},
 		Signatures:   []signature.Sig{sig},
+		Signer:       "ns." + testDomain + ".",
 	}
 	return message
 }
@@ -1,6 +1,7 @@
 package publisher
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -424,15 +426,8 @@ func signZoneContent(zone *section.Zone, shards []*section.Shard, pshards []*sec
 //file in zonefile format.
 func (r *Rainspub) publishZone(zoneContent []section.Section) {
 	if r.Config.DoPublish {
-		//TODO check if zone is not too large. If it is, split it up and send
-		//content separately.
 		log.Debug("publishing zone", "zone", zoneContent)
-		msg := message.Message{
-			Token:        token.New(),
-			Content:      zoneContent,
-			Capabilities: []message.Capability{message.NoCapability},
-		}
-		unsuccessfulServers := r.publishSections(msg)
+		unsuccessfulServers := r.publishSections(zoneContent)
 		if unsuccessfulServers != nil {
 			log.Warn("Was not able to connect and successfully publish to all authoritative servers", "unsuccessfulServers", unsuccessfulServers)
 		} else {
@@ -442,13 +437,21 @@ func (r *Rainspub) publishZone(zoneContent []section.Section) {
 }
 
 //publishSections establishes connections to all authoritative servers according to the r.Config. It
-//then sends sections to all of them. It returns the connection information of those servers it was
-//not able to push sections, otherwise nil is returned.
-func (r *Rainspub) publishSections(msg message.Message) []net.Addr {
+//then sends sections to all of them. If a server rejects the message because it is too large, the
+//zone is split into shards and the sections are resent in messages not exceeding the server's
+//maximum message size. It returns the connection information of those servers it was not able to
+//push sections, otherwise nil is returned.
+func (r *Rainspub) publishSections(sections []section.Section) []net.Addr {
+	msg := newPublishMessage(sections)
 	results := make(chan net.Addr, len(r.Config.AuthServers))
 	for _, info := range r.Config.AuthServers {
 		go func(server net.Addr) {
-			err := connectAndSendMsg(msg, server)
+			err := connectAndSendMsgs([]message.Message{msg}, server)
+			if tooLarge, ok := err.(msgTooLargeError); ok {
+				log.Info("Resend sections split into smaller messages", "server", server,
+					"maxSize", tooLarge.maxSize)
+				err = r.publishSplit(sections, server, tooLarge.maxSize)
+			}
 			if err != nil {
 				log.Error("Error sending message to server", "sever", server, "err", err)
 				results <- server
@@ -466,3 +469,160 @@ func (r *Rainspub) publishSections(msg message.Message) []net.Addr {
 	}
 	return errorConns
 }
+
+//newPublishMessage returns a message containing sections.
+func newPublishMessage(sections []section.Section) message.Message {
+	return message.Message{
+		Token:        token.New(),
+		Content:      sections,
+		Capabilities: []message.Capability{message.NoCapability},
+	}
+}
+
+//publishSplit sends sections to server in messages which are not larger than maxSize bytes. A zone
+//in sections is replaced by shards covering its content.
+func (r *Rainspub) publishSplit(sections []section.Section, server net.Addr, maxSize int) error {
+	if maxSize <= 0 {
+		return errors.New("server did not state its maximum message size")
+	}
+	var content []section.Section
+	for _, s := range sections {
+		if zone, ok := s.(*section.Zone); ok {
+			shards, err := r.shardZone(zone, maxSize)
+			if err != nil {
+				return err
+			}
+			for _, shard := range shards {
+				content = append(content, shard)
+			}
+		} else {
+			content = append(content, s)
+		}
+	}
+	msgs, err := splitIntoMessages(content, maxSize)
+	if err != nil {
+		return err
+	}
+	log.Info("Split sections into messages", "server", server, "sections", len(content),
+		"messages", len(msgs))
+	return connectAndSendMsgs(msgs, server)
+}
+
+//shardZone returns signed shards containing the zone's assertions such that a message containing
+//one of them is not larger than maxSize bytes. The shards have the same signature meta data as the
+//zone.
+func (r *Rainspub) shardZone(zone *section.Zone, maxSize int) ([]*section.Shard, error) {
+	if !r.Config.DoSigning {
+		return nil, errors.New("zone can only be split into shards when signing is enabled")
+	}
+	privateKeys, err := LoadPrivateKeys(r.Config.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Was not able to load private keys: %v", err)
+	}
+	newShard := func(rangeFrom string) *section.Shard {
+		shard := &section.Shard{SubjectZone: zone.SubjectZone, Context: zone.Context,
+			RangeFrom: rangeFrom}
+		for _, sig := range zone.Sigs(keys.RainsKeySpace) {
+			sig.Data = nil
+			shard.AddSig(sig)
+		}
+		return shard
+	}
+	//The signatures of a shard are only added after it is complete. Their size is determined by
+	//signing an empty shard.
+	empty := newShard("")
+	unsignedSize, err := encodedSize(newPublishMessage([]section.Section{empty}))
+	if err != nil {
+		return nil, err
+	}
+	if err := siglib.SignSectionUnsafe(empty, privateKeys); err != nil {
+		return nil, fmt.Errorf("Was not able to sign shard: %v", err)
+	}
+	signedSize, err := encodedSize(newPublishMessage([]section.Section{empty}))
+	if err != nil {
+		return nil, err
+	}
+	maxUnsignedSize := maxSize - (signedSize - unsignedSize)
+
+	assertions := make([]*section.Assertion, len(zone.Content))
+	copy(assertions, zone.Content)
+	sort.Slice(assertions, func(i, j int) bool { return assertions[i].CompareTo(assertions[j]) < 0 })
+	shards := []*section.Shard{}
+	shard := newShard("")
+	for _, sameName := range groupAssertionByName(assertions, r.Config.ShardingConf) {
+		shard.Content = append(shard.Content, sameName...)
+		size, err := encodedSize(newPublishMessage([]section.Section{shard}))
+		if err != nil {
+			return nil, err
+		}
+		if size <= maxUnsignedSize {
+			continue
+		}
+		shard.Content = shard.Content[:len(shard.Content)-len(sameName)]
+		if len(shard.Content) == 0 {
+			return nil, fmt.Errorf("assertions of %s do not fit into a message of %d bytes",
+				sameName[0].SubjectName, maxSize)
+		}
+		shard.RangeTo = sameName[0].SubjectName
+		shards = append(shards, shard)
+		shard = newShard(shard.Content[len(shard.Content)-1].SubjectName)
+		shard.Content = append(shard.Content, sameName...)
+	}
+	shards = append(shards, shard)
+	for _, shard := range shards {
+		//The assertions are signed again as part of the shard. Their old signature data must not
+		//be part of the signed encoding.
+		for i, a := range shard.Content {
+			shard.Content[i] = a.Copy(a.Context, a.SubjectZone)
+			shard.Content[i].Signatures = nil
+			for _, sig := range a.Sigs(keys.RainsKeySpace) {
+				sig.Data = nil
+				shard.Content[i].AddSig(sig)
+			}
+		}
+		if err := siglib.SignSectionUnsafe(shard, privateKeys); err != nil {
+			return nil, fmt.Errorf("Was not able to sign shard: %v", err)
+		}
+	}
+	return shards, nil
+}
+
+//splitIntoMessages returns messages containing sections such that no message is larger than
+//maxSize bytes.
+func splitIntoMessages(sections []section.Section, maxSize int) ([]message.Message, error) {
+	msgs := []message.Message{}
+	var content []section.Section
+	for _, s := range sections {
+		size, err := encodedSize(newPublishMessage(append(content, s)))
+		if err != nil {
+			return nil, err
+		}
+		if size <= maxSize {
+			content = append(content, s)
+			continue
+		}
+		if len(content) == 0 {
+			return nil, fmt.Errorf("%T does not fit into a message of %d bytes", s, maxSize)
+		}
+		msgs = append(msgs, newPublishMessage(content))
+		content = []section.Section{s}
+		if size, err := encodedSize(newPublishMessage(content)); err != nil {
+			return nil, err
+		} else if size > maxSize {
+			return nil, fmt.Errorf("%T does not fit into a message of %d bytes", s, maxSize)
+		}
+	}
+	if len(content) > 0 {
+		msgs = append(msgs, newPublishMessage(content))
+	}
+	return msgs, nil
+}
+
+//encodedSize returns the number of bytes of msg's cbor encoding.
+func encodedSize(msg message.Message) (int, error) {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		return 0, fmt.Errorf("Was not able to marshal message: %v", err)
+	}
+	return encoding.Len(), nil
+}
@@ -3,26 +3,48 @@ package publisher
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
-//connectAndSendMsg establishes a connection to server and sends msg. It returns the server info on
-//the result channel if it was not able to send the whole msg to it, else nil.
-func connectAndSendMsg(msg message.Message, server net.Addr) error {
+//msgTooLargeError is returned when a server rejects a message because it exceeds the server's
+//maximum message size.
+type msgTooLargeError struct {
+	//maxSize is the server's maximum message size in bytes. It is 0 if the server did not state it.
+	maxSize int
+}
+
+func (e msgTooLargeError) Error() string {
+	return fmt.Sprintf("message is larger than the server's maximum message size of %d bytes",
+		e.maxSize)
+}
 
+//connectAndSendMsgs establishes a connection to server and sends msgs. It returns an error if it
+//was not able to send all msgs to it or if the server rejected one of them, else nil. A
+//msgTooLargeError is returned if the server rejected a message because of its size.
+func connectAndSendMsgs(msgs []message.Message, server net.Addr) error {
+	//The server's certificate is not verified. The published sections are signed.
 	conn, err := connection.CreateConnection(server)
 	if err != nil {
 		return fmt.Errorf("unable to establish a connection: %s", err)
 	}
 	defer conn.Close()
-	err = connection.WriteMessage(conn, &msg)
-	if err != nil {
-		return fmt.Errorf("unable send message: %s", err)
+	tokens := make(map[token.Token]bool)
+	for i := range msgs {
+		tokens[msgs[i].Token] = true
+		err = connection.WriteMessage(conn, &msgs[i])
+		if err == connection.ErrMsgTooLarge {
+			return msgTooLargeError{maxSize: connection.MaxUDPPacketBytes}
+		}
+		if err != nil {
+			return fmt.Errorf("unable send message: %s", err)
+		}
 	}
 
 	// Wait 1 second for reply
@@ -31,21 +53,25 @@ func connectAndSendMsg(msg message.Message, server net.Addr) error {
 	for deadline.After(time.Now()) {
 		replyMsg, err := connection.ReceiveMessage(conn)
 		if err != nil {
+			if !deadline.After(time.Now()) {
+				//The server does not answer accepted sections.
+				return nil
+			}
 			return fmt.Errorf("error receiving message: %s", err)
 		}
 		//only accept notification messages in response to published information.
-		if n, ok := replyMsg.Content[0].(*section.Notification); ok && n.Token == msg.Token {
-			if handleResponse(msg.Content[0].(*section.Notification)) {
-				return nil
+		if n, ok := replyMsg.Content[0].(*section.Notification); ok && tokens[n.Token] {
+			if err := handleResponse(n); err != nil {
+				return err
 			}
 		}
 	}
-	return fmt.Errorf("timeout while waiting for response")
+	return nil
 }
 
-//handleResponse handles the received notification message and returns true if the connection can
-//be closed.
-func handleResponse(n *section.Notification) bool {
+//handleResponse handles the received notification message. It returns an error if the notification
+//states that the server did not accept the sent message.
+func handleResponse(n *section.Notification) error {
 	switch n.Type {
 	case section.NTHeartbeat, section.NTNoAssertionsExist, section.NTNoAssertionAvail:
 	//nop
@@ -53,12 +79,15 @@ func handleResponse(n *section.Notification) bool {
 	//TODO CFE send back the whole capability list in an empty message
 	case section.NTBadMessage:
 		log.Error("Sent msg was malformed", "data", n.Data)
+		return fmt.Errorf("sent msg was malformed: %s", n.Data)
 	case section.NTRcvInconsistentMsg:
 		log.Error("Sent msg was inconsistent", "data", n.Data)
+		return fmt.Errorf("sent msg was inconsistent: %s", n.Data)
 	case section.NTMsgTooLarge:
-		log.Error("Sent msg was too large", "data", n.Data)
-		//What should we do in this case. apparently it is not possible to send a zone because
-		//it is too large. send shards instead?
+		//publishSections resends the message's content split into smaller messages.
+		log.Warn("Sent msg was too large", "data", n.Data)
+		maxSize, _ := strconv.Atoi(n.Data)
+		return msgTooLargeError{maxSize: maxSize}
 	case section.NTUnspecServerErr:
 		log.Error("Unspecified error of other server", "data", n.Data)
 		//TODO CFE resend?
@@ -68,5 +97,5 @@ func handleResponse(n *section.Notification) bool {
 	default:
 		log.Error("Received non existing notification type")
 	}
-	return false
+	return nil
 }
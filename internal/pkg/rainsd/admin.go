@@ -0,0 +1,261 @@
+package rainsd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//AdminUnixPrefix marks an admin address as the path of a unix socket.
+const AdminUnixPrefix = "unix:"
+
+//Paths of the admin api.
+const (
+	AdminAssertionsPath     = "/assertions"
+	AdminNegAssertionsPath  = "/negassertions"
+	AdminZoneKeysPath       = "/zonekeys"
+	AdminPendingKeysPath    = "/pendingkeys"
+	AdminPendingQueriesPath = "/pendingqueries"
+	AdminConnectionsPath    = "/connections"
+	AdminPeersPath          = "/peers"
+	AdminFlushPath          = "/flush"
+	AdminUnblockPath        = "/unblock"
+	AdminCheckpointPath     = "/checkpoint"
+)
+
+//listenAdmin returns a listener on addr. An address starting with AdminUnixPrefix is the path of
+//a unix socket, all others are tcp addresses. As the admin api is not authenticated, a tcp address
+//must be a loopback address.
+func listenAdmin(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, AdminUnixPrefix) {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if !tcpAddr.IP.IsLoopback() {
+			return nil, fmt.Errorf("admin address %s is not a loopback address", addr)
+		}
+		return net.ListenTCP("tcp", tcpAddr)
+	}
+	path := strings.TrimPrefix(addr, AdminUnixPrefix)
+	//A socket file left behind by a crashed server prevents listening.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+//serveAdmin serves the admin api over http at the configured admin address. Nothing is served if
+//no admin address is configured.
+func (s *Server) serveAdmin() error {
+	if s.config().AdminAddress == "" {
+		return nil
+	}
+	l, err := listenAdmin(s.config().AdminAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminAssertionsPath, s.adminSections(s.caches.AssertionsCache.Checkpoint))
+	mux.HandleFunc(AdminNegAssertionsPath, s.adminSections(s.caches.NegAssertionCache.Checkpoint))
+	mux.HandleFunc(AdminZoneKeysPath, s.adminSections(s.caches.ZoneKeyCache.Checkpoint))
+	mux.HandleFunc(AdminPendingKeysPath, s.adminPending(s.caches.PendingKeys.GetAll))
+	mux.HandleFunc(AdminPendingQueriesPath, s.adminPending(s.caches.PendingQueries.GetAll))
+	mux.HandleFunc(AdminConnectionsPath, s.adminConnections)
+	mux.HandleFunc(AdminPeersPath, s.adminPeers)
+	mux.HandleFunc(AdminFlushPath, s.adminFlush)
+	mux.HandleFunc(AdminUnblockPath, s.adminUnblock)
+	mux.HandleFunc(AdminCheckpointPath, s.adminCheckpoint)
+	var handler http.Handler = mux
+	if !strings.HasPrefix(s.config().AdminAddress, AdminUnixPrefix) {
+		handler = checkAdminHost(mux)
+	}
+	s.adminServer = &http.Server{Handler: checkAdminOrigin(handler)}
+	log.Info("Serving admin api", "addr", l.Addr())
+	go func(server *http.Server) {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Error("Admin listener failed", "addr", l.Addr(), "error", err)
+		}
+	}(s.adminServer)
+	return nil
+}
+
+//adminSections returns a handler which writes the sections returned by values in zonefile format.
+//If the request contains a zone or context parameter, only sections of this zone or context are
+//written.
+func (s *Server) adminSections(values func() []section.Section) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		zone, context := r.FormValue("zone"), r.FormValue("context")
+		sections := []section.Section{}
+		for _, sec := range values() {
+			sec, ok := sec.(section.WithSig)
+			if !ok || (zone != "" && sec.GetSubjectZone() != zone) ||
+				(context != "" && sec.GetContext() != context) {
+				continue
+			}
+			sections = append(sections, sec)
+		}
+		if len(sections) > 0 {
+			fmt.Fprintln(w, zonefile.IO{}.Encode(sections))
+		}
+	}
+}
+
+//adminPending returns a handler which writes the entries returned by values. Each entry starts
+//with a line containing the token of the query the entry waits for, the query's expiration and the
+//sender of the waiting sections. The sections follow in zonefile format.
+func (s *Server) adminPending(values func() []cache.PendingEntry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		for _, e := range values() {
+			fmt.Fprintf(w, "token=%s expires=%s sender=%v\n", e.Token,
+				time.Unix(e.Expiration, 0).UTC().Format(time.RFC3339), e.Waiting.Sender)
+			fmt.Fprintln(w, zonefile.IO{}.Encode(e.Waiting.Sections))
+		}
+	}
+}
+
+//adminConnections writes a line with the network, the remote and the local address of each open
+//connection.
+func (s *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+	for _, conn := range s.caches.ConnCache.GetAllConnections() {
+		fmt.Fprintf(w, "%s %v %v\n", conn.RemoteAddr().Network(), conn.RemoteAddr(),
+			conn.LocalAddr())
+	}
+}
+
+//adminPeers writes a line with the standing of each peer which has been asked for the keys its
+//sections are signed with.
+func (s *Server) adminPeers(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+	for _, p := range s.peerStandings.all() {
+		lastFailure := "never"
+		if !p.LastFailure.IsZero() {
+			lastFailure = p.LastFailure.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "peer=%s supplied=%d failed=%d lastFailure=%s good=%t\n", p.Peer,
+			p.Supplied, p.Failed, lastFailure, p.Good())
+	}
+}
+
+//adminFlush removes all assertions, shards, pshards and zones of the requested zone from the
+//caches.
+func (s *Server) adminFlush(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+	zone := r.FormValue("zone")
+	if zone == "" {
+		http.Error(w, "zone is missing", http.StatusBadRequest)
+		return
+	}
+	s.caches.AssertionsCache.RemoveZone(zone)
+	s.caches.NegAssertionCache.RemoveZone(zone)
+	log.Info("Flushed zone from caches", "zone", zone)
+	fmt.Fprintf(w, "flushed zone %s\n", zone)
+}
+
+//adminUnblock removes the requested zone from the zones which have been blacklisted at runtime due
+//to misbehavior. The context defaults to the global context.
+func (s *Server) adminUnblock(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+	zone, context := r.FormValue("zone"), r.FormValue("context")
+	if zone == "" {
+		http.Error(w, "zone is missing", http.StatusBadRequest)
+		return
+	}
+	if context == "" {
+		context = "."
+	}
+	if !s.blacklist.removeZone(zone, context) {
+		http.Error(w, fmt.Sprintf("zone %s %s is not blocked", zone, context), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "unblocked zone %s %s\n", zone, context)
+}
+
+//adminCheckpoint immediately checkpoints the caches.
+func (s *Server) adminCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+	cpPath := s.config().CheckPointPath
+	if err := checkpointCaches(cpPath, s.caches); err != nil {
+		log.Error("Was not able to checkpoint caches", "path", cpPath, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "checkpointed caches to %s\n", cpPath)
+}
+
+//checkAdminOrigin returns a handler which rejects requests sent by a browser on behalf of a web page
+//and passes all others to next. Browsers add an Origin header to such requests whereas rainsctl
+//does not. This prevents cross-site request forgery.
+func checkAdminOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			log.Warn("Rejected admin request with origin", "origin", r.Header.Get("Origin"),
+				"path", r.URL.Path)
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//checkAdminHost returns a handler which rejects requests whose Host header is neither localhost nor
+//a loopback address and passes all others to next. This prevents DNS rebinding attacks in which a
+//web page's name resolves to the admin address.
+func checkAdminHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" &&
+			(ip == nil || !ip.IsLoopback()) {
+			log.Warn("Rejected admin request with invalid host", "host", r.Host, "path", r.URL.Path)
+			http.Error(w, "invalid host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//checkMethod returns true if r uses method. Otherwise, it answers r with an error.
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
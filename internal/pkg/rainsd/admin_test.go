@@ -0,0 +1,67 @@
+package rainsd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListenAdmin(t *testing.T) {
+	var tests = []struct {
+		addr  string
+		valid bool
+	}{
+		{"127.0.0.1:0", true},
+		{"[::1]:0", true},
+		{"localhost:0", true},
+		{"0.0.0.0:0", false},
+		{":0", false},
+		{"192.0.2.1:0", false},
+	}
+	for i, test := range tests {
+		l, err := listenAdmin(test.addr)
+		if err == nil {
+			l.Close()
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong result for %s. expected valid=%v err=%v", i, test.addr, test.valid,
+				err)
+		}
+	}
+}
+
+func TestAdminRequestChecks(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var tests = []struct {
+		name    string
+		handler http.Handler
+		host    string
+		origin  string
+		code    int
+	}{
+		{"loopback", checkAdminOrigin(checkAdminHost(ok)), "127.0.0.1:55554", "", http.StatusOK},
+		{"ipv6 loopback", checkAdminOrigin(checkAdminHost(ok)), "[::1]:55554", "", http.StatusOK},
+		{"localhost", checkAdminOrigin(checkAdminHost(ok)), "localhost:55554", "", http.StatusOK},
+		{"rebound name", checkAdminOrigin(checkAdminHost(ok)), "evil.example:55554", "",
+			http.StatusForbidden},
+		{"other address", checkAdminOrigin(checkAdminHost(ok)), "192.0.2.1:55554", "",
+			http.StatusForbidden},
+		{"origin", checkAdminOrigin(checkAdminHost(ok)), "127.0.0.1:55554",
+			"http://evil.example", http.StatusForbidden},
+		{"unix socket", checkAdminOrigin(ok), "rainsd", "", http.StatusOK},
+		{"unix socket origin", checkAdminOrigin(ok), "rainsd", "http://evil.example",
+			http.StatusForbidden},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://"+test.host+AdminFlushPath, nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%d: %s: wrong status code. expected=%d actual=%d", i, test.name, test.code,
+				w.Code)
+		}
+	}
+}
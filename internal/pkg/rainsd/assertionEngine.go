@@ -7,6 +7,7 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
@@ -17,22 +18,72 @@ import (
 //rains signature on the message
 func (s *Server) assert(ss util.SectionWithSigSender) {
 	log.Debug("Adding section to cache", "section", ss)
-	if sectionsAreInconsistent(ss.Sections, s.caches.AssertionsCache, s.caches.NegAssertionCache) {
-		log.Warn("section is inconsistent with cached elements.", "sections", ss.Sections)
+	inconsistent, superseded := inconsistentSections(ss.Sections, s.caches.AssertionsCache,
+		s.caches.NegAssertionCache)
+	if len(inconsistent) > 0 {
+		for _, sec := range inconsistent {
+			if isAuthoritative(sec, s.config().Authorities) {
+				log.Warn("section is inconsistent with authoritative data. Rejecting it.",
+					"section", sec, "zone", sec.GetSubjectZone())
+				continue
+			}
+			log.Warn("section is inconsistent with cached elements. Evicting zone from cache.",
+				"section", sec, "zone", sec.GetSubjectZone())
+			s.caches.AssertionsCache.RemoveZone(sec.GetSubjectZone())
+			s.caches.NegAssertionCache.RemoveZone(sec.GetSubjectZone())
+		}
 		sendNotificationMsg(ss.Token, ss.Sender, section.NTRcvInconsistentMsg, "", s)
 		return
 	}
-	addSectionsToCache(ss.Sections, s.config.Authorities, s.caches.AssertionsCache,
-		s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
-	pendingKeysCallback(ss, s.caches.PendingKeys, s.queues.Normal)
-	pendingQueriesCallback(ss, s)
+	for _, sec := range superseded {
+		log.Info("Replacing superseded section", "section", sec)
+		if a, ok := sec.(*section.Assertion); ok {
+			s.caches.AssertionsCache.Remove(a)
+		} else {
+			s.caches.NegAssertionCache.Remove(sec)
+		}
+	}
+	msss := s.caches.PendingQueries.GetAndRemove(ss.Token)
+	if len(msss) == 0 || !noProactiveCaching(msss) {
+		addSectionsToCache(ss.Sections, s.config().Authorities, s.caches.AssertionsCache,
+			s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
+		if containsNoProactiveCaching(msss) {
+			disablePrefetch(ss.Sections, s.caches.AssertionsCache)
+		}
+	} else {
+		log.Info("Sections are not cached as requested by all waiting queries", "sections",
+			ss.Sections)
+	}
+	s.keyRequestAnswered(ss.Token)
+	pendingKeysCallback(ss, s.caches.PendingKeys, s.queues)
+	pendingQueriesCallback(ss, msss, s)
+	pendingMessagesCallback(ss, s)
 	log.Info(fmt.Sprintf("Finished handling %T", ss.Sections), "section", ss.Sections)
 }
 
-//sectionsAreInconsistent returns true if at least one section is not consistent with cached element
-//which are valid at the same time.
-func sectionsAreInconsistent(sec []section.WithSigForward, assertionsCache cache.Assertion,
-	negAssertionCache cache.NegativeAssertion) bool {
+//noProactiveCaching returns true if all queries of all msss contain the no proactive caching
+//query option.
+func noProactiveCaching(msss []util.MsgSectionSender) bool {
+	for _, mss := range msss {
+		for _, sec := range mss.Sections {
+			if q, ok := sec.(*query.Name); !ok || !q.ContainsOption(query.QONoProactiveCaching) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//containsNoProactiveCaching returns true if a query of msss contains the no proactive caching
+//query option.
+func containsNoProactiveCaching(msss []util.MsgSectionSender) bool {
+	for _, mss := range msss {
+		for _, sec := range mss.Sections {
+			if q, ok := sec.(*query.Name); ok && q.ContainsOption(query.QONoProactiveCaching) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
@@ -144,14 +195,14 @@ func addZoneToCache(zone *section.Zone, isAuthoritative bool, assertionsCache ca
 }
 
 func pendingKeysCallback(mss util.SectionWithSigSender, pendingKeys cache.PendingKey,
-	normalChannel chan util.MsgSectionSender) {
+	queues *InputQueues) {
 	if ss, ok := pendingKeys.GetAndRemove(mss.Token); ok {
-		normalChannel <- ss
+		queues.push(normalQueue, ss)
 	}
 }
 
-func pendingQueriesCallback(mss util.SectionWithSigSender, s *Server) {
-	msss := s.caches.PendingQueries.GetAndRemove(mss.Token)
+//pendingQueriesCallback answers all msss waiting for mss.
+func pendingQueriesCallback(mss util.SectionWithSigSender, msss []util.MsgSectionSender, s *Server) {
 	if len(msss) == 0 {
 		return
 	}
@@ -0,0 +1,251 @@
+package rainsd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+const (
+	//misbehavingZoneBlockDuration is the time a misbehaving zone is blacklisted.
+	misbehavingZoneBlockDuration = time.Hour
+	//blockedLogInterval is the minimum time between two log messages about blocked traffic.
+	blockedLogInterval = 10 * time.Second
+)
+
+//blacklistFile is the json representation of a blacklist file.
+type blacklistFile struct {
+	//IPs contains IP address ranges in CIDR notation or single IP addresses.
+	IPs []string
+	//Zones contains zones which are blocked together with all their subzones. An empty context
+	//matches all contexts.
+	Zones []ZoneContext
+}
+
+//blacklist stores IP address ranges and zones from which this server does not accept traffic.
+type blacklist struct {
+	//blockedConnections and blockedSections count the dropped traffic. lastBlockedLog is the time
+	//in nanoseconds since 01.01.1970 when blocked traffic was last logged. They must be accessed
+	//atomically and are placed first to guarantee 64-bit alignment.
+	blockedConnections uint64
+	blockedSections    uint64
+	lastBlockedLog     int64
+
+	//path is the location of the blacklist file. It is empty if no file is configured.
+	path string
+	//ipNets and zones are loaded from the blacklist file.
+	ipNets []*net.IPNet
+	zones  []ZoneContext
+	//misbehavingZones are blacklisted at runtime until the stored time. In contrast to the zones
+	//of the file, their subzones are not blacklisted. They are kept when the file is reloaded.
+	misbehavingZones map[ZoneContext]time.Time
+	//mux protects ipNets, zones and misbehavingZones from simultaneous access.
+	mux sync.RWMutex
+}
+
+//newBlacklist returns a blacklist with the content of the file at path. If path is empty, the
+//blacklist is initially empty.
+func newBlacklist(path string) (*blacklist, error) {
+	b := &blacklist{path: path, misbehavingZones: make(map[ZoneContext]time.Time)}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//load replaces the IP address ranges and zones with the content of the blacklist file. The
+//current entries are kept in case of an error.
+func (b *blacklist) load() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return fmt.Errorf("was not able to read blacklist file: %v", err)
+	}
+	file := blacklistFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("was not able to unmarshal blacklist file: %v", err)
+	}
+	ipNets := []*net.IPNet{}
+	for _, ip := range file.IPs {
+		ipNet, err := parseIPNet(ip)
+		if err != nil {
+			return fmt.Errorf("invalid blacklist entry: %v", err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.ipNets = ipNets
+	b.zones = file.Zones
+	log.Info("Loaded blacklist", "path", b.path, "ipRanges", len(ipNets), "zones", len(file.Zones))
+	return nil
+}
+
+//parseIPNet returns the IP address range of s. s is either in CIDR notation or a single IP
+//address.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("malformed IP range: %v", err)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("malformed IP address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+//addZone blacklists zone in context for duration. Its subzones are not blacklisted.
+func (b *blacklist) addZone(zone, context string, duration time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	for zc, until := range b.misbehavingZones {
+		if until.Before(now) {
+			delete(b.misbehavingZones, zc)
+		}
+	}
+	zc := ZoneContext{Zone: zone, Context: context}
+	if _, ok := b.misbehavingZones[zc]; !ok {
+		log.Warn("Zone has been blacklisted", "zone", zone, "context", context,
+			"duration", duration)
+	}
+	b.misbehavingZones[zc] = now.Add(duration)
+}
+
+//removeZone removes zone in context from the zones blacklisted at runtime. It returns false if
+//zone is not blacklisted at runtime.
+func (b *blacklist) removeZone(zone, context string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	zc := ZoneContext{Zone: zone, Context: context}
+	if until, ok := b.misbehavingZones[zc]; !ok || until.Before(time.Now()) {
+		delete(b.misbehavingZones, zc)
+		return false
+	}
+	delete(b.misbehavingZones, zc)
+	log.Info("Zone has been removed from the blacklist", "zone", zone, "context", context)
+	return true
+}
+
+//blockMisbehavingZone blacklists zone in context for misbehavingZoneBlockDuration. The root zone
+//and the zones this server is authoritative for are never blacklisted.
+func (s *Server) blockMisbehavingZone(zone, context string) {
+	if zone == "." {
+		log.Warn("Root zone misbehaves but is not blacklisted", "context", context)
+		return
+	}
+	for _, auth := range s.config().Authorities {
+		if auth.Zone == zone && auth.Context == context {
+			log.Warn("Zone of this authority misbehaves but is not blacklisted", "zone", zone,
+				"context", context)
+			return
+		}
+	}
+	s.blacklist.addZone(zone, context, misbehavingZoneBlockDuration)
+}
+
+//addrIP returns the IP address of addr. It returns nil if addr has no IP address.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *snet.UDPAddr:
+		if addr.Host == nil {
+			return nil
+		}
+		return addr.Host.IP
+	default:
+		return nil
+	}
+}
+
+//isIPBlacklisted returns true if addr is blacklisted
+func (b *blacklist) isIPBlacklisted(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for _, ipNet := range b.ipNets {
+		if ipNet.Contains(ip) {
+			n := atomic.AddUint64(&b.blockedConnections, 1)
+			if b.logBlocked() {
+				log.Info("Blocked traffic from blacklisted IP", "addr", addr, "range", ipNet,
+					"blockedConnections", n)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+//isZoneBlacklisted returns true if zone is blacklisted in context
+func (b *blacklist) isZoneBlacklisted(zone, context string) bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for _, zc := range b.zones {
+		if zoneContextMatches(zc, zone, context) {
+			b.logBlockedSection(zone, context, zc)
+			return true
+		}
+	}
+	zc := ZoneContext{Zone: zone, Context: context}
+	if until, ok := b.misbehavingZones[zc]; ok && until.After(time.Now()) {
+		b.logBlockedSection(zone, context, zc)
+		return true
+	}
+	return false
+}
+
+func (b *blacklist) logBlockedSection(zone, context string, entry ZoneContext) {
+	n := atomic.AddUint64(&b.blockedSections, 1)
+	if b.logBlocked() {
+		log.Info("Blocked section of blacklisted zone", "zone", zone, "context", context,
+			"entry", entry, "blockedSections", n)
+	}
+}
+
+//logBlocked returns true if blocked traffic should be logged. It returns true at most once per
+//blockedLogInterval such that an attacker cannot flood the log.
+func (b *blacklist) logBlocked() bool {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&b.lastBlockedLog)
+	return now-last >= int64(blockedLogInterval) &&
+		atomic.CompareAndSwapInt64(&b.lastBlockedLog, last, now)
+}
+
+//zoneContextMatches returns true if zone is equal to or a subzone of zc.Zone and zc.Context is
+//either empty or equal to context.
+func zoneContextMatches(zc ZoneContext, zone, context string) bool {
+	if zc.Context != "" && zc.Context != context {
+		return false
+	}
+	suffix := strings.TrimSuffix(zc.Zone, ".")
+	zone = strings.TrimSuffix(zone, ".")
+	return suffix == "" || zone == suffix || strings.HasSuffix(zone, "."+suffix)
+}
+
+//blocked returns the number of blocked connections and sections.
+func (b *blacklist) blocked() (connections, sections uint64) {
+	return atomic.LoadUint64(&b.blockedConnections), atomic.LoadUint64(&b.blockedSections)
+}
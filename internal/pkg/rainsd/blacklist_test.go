@@ -0,0 +1,146 @@
+package rainsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlacklist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	if err != nil {
+		t.Fatalf("Was not able to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blacklist.json")
+	writeBlacklist(t, path, `{"IPs":["192.0.2.0/24","2001:db8::1"],
+		"Zones":[{"Zone":"evil.ch.","Context":"."},{"Zone":"bad.org.","Context":""}]}`)
+	b, err := newBlacklist(path)
+	if err != nil {
+		t.Fatalf("Was not able to load blacklist: %v", err)
+	}
+	var ipTests = []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.17"), Port: 1}, true},
+		{&net.UDPAddr{IP: net.ParseIP("192.0.2.255"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.3.1"), Port: 1}, false},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1}, false},
+	}
+	for i, test := range ipTests {
+		if b.isIPBlacklisted(test.addr) != test.want {
+			t.Errorf("%d: wrong blacklist result for %v. expected=%v", i, test.addr, test.want)
+		}
+	}
+	var zoneTests = []struct {
+		zone    string
+		context string
+		want    bool
+	}{
+		{"evil.ch.", ".", true},
+		{"www.evil.ch.", ".", true},
+		{"notevil.ch.", ".", false},
+		{"evil.ch.", "cx-test", false},
+		{"bad.org.", "cx-test", true},
+		{"ch.", ".", false},
+	}
+	for i, test := range zoneTests {
+		if b.isZoneBlacklisted(test.zone, test.context) != test.want {
+			t.Errorf("%d: wrong blacklist result for %s %s. expected=%v", i, test.zone,
+				test.context, test.want)
+		}
+	}
+	if conns, secs := b.blocked(); conns != 3 || secs != 3 {
+		t.Errorf("wrong number of blocked traffic. connections=%d sections=%d", conns, secs)
+	}
+
+	//Zones blacklisted at runtime are kept on reload
+	b.addZone("spam.net.", ".", time.Hour)
+	writeBlacklist(t, path, `{"IPs":["198.51.100.0/24"],"Zones":[]}`)
+	if err := b.load(); err != nil {
+		t.Fatalf("Was not able to reload blacklist: %v", err)
+	}
+	if b.isZoneBlacklisted("evil.ch.", ".") || !b.isZoneBlacklisted("spam.net.", ".") {
+		t.Error("Blacklisted zones are wrong after reload")
+	}
+	if b.isIPBlacklisted(&net.TCPAddr{IP: net.ParseIP("192.0.2.17")}) ||
+		!b.isIPBlacklisted(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}) {
+		t.Error("Blacklisted IPs are wrong after reload")
+	}
+
+	//A malformed file does not change the blacklist
+	writeBlacklist(t, path, `{"IPs":["198.51.100.0/33"]}`)
+	if err := b.load(); err == nil {
+		t.Error("Malformed blacklist file was loaded")
+	}
+	if !b.isIPBlacklisted(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}) {
+		t.Error("Blacklist changed although the file is malformed")
+	}
+}
+
+func TestMisbehavingZone(t *testing.T) {
+	config := memoryConfig(t, "TestMisbehavingZone", DefaultConfig().Capabilities)
+	config.Authorities = []ZoneContext{{"example.", "."}}
+	s, err := New(config, "TestMisbehavingZone")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	for _, zone := range []string{".", "example.", "spam.net."} {
+		s.blockMisbehavingZone(zone, ".")
+	}
+	s.blacklist.addZone("expired.net.", ".", -time.Second)
+	var tests = []struct {
+		zone    string
+		context string
+		want    bool
+	}{
+		{"spam.net.", ".", true},
+		{"a.spam.net.", ".", false},
+		{"spam.net.", "cx-test", false},
+		{"net.", ".", false},
+		{"ch.", ".", false},
+		{"example.", ".", false},
+		{"expired.net.", ".", false},
+	}
+	for i, test := range tests {
+		if s.blacklist.isZoneBlacklisted(test.zone, test.context) != test.want {
+			t.Errorf("%d: wrong blacklist result for %s %s. expected=%v", i, test.zone,
+				test.context, test.want)
+		}
+	}
+	if s.blacklist.removeZone("expired.net.", ".") || !s.blacklist.removeZone("spam.net.", ".") {
+		t.Error("wrong zones removed from blacklist")
+	}
+	if s.blacklist.isZoneBlacklisted("spam.net.", ".") {
+		t.Error("removed zone is still blacklisted")
+	}
+	if len(s.blacklist.misbehavingZones) != 0 {
+		t.Errorf("expired zones are kept: %v", s.blacklist.misbehavingZones)
+	}
+}
+
+func TestLogBlocked(t *testing.T) {
+	b, err := newBlacklist("")
+	if err != nil {
+		t.Fatalf("Was not able to create blacklist: %v", err)
+	}
+	if !b.logBlocked() || b.logBlocked() {
+		t.Error("blocked traffic is logged more than once per interval")
+	}
+	b.lastBlockedLog -= int64(blockedLogInterval)
+	if !b.logBlocked() {
+		t.Error("blocked traffic is not logged after the interval")
+	}
+}
+
+func writeBlacklist(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Was not able to write blacklist file: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package rainsd
 
 import (
+	"sync"
+	"time"
+
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 )
 
@@ -17,6 +20,10 @@ type Caches struct {
 	//pendingSignatures contains all sections that are waiting for a delegation query to arrive such that their signatures can be verified.
 	PendingKeys cache.PendingKey
 
+	//PendingMessages contains signed messages that are waiting for the infrastructure key of their
+	//signer. It has the same size limit and reap interval as PendingKeys.
+	PendingMessages cache.PendingMessage
+
 	//pendingQueries contains a mapping from all self issued pending queries to the set of message bodies waiting for it.
 	PendingQueries cache.PendingQuery
 
@@ -31,23 +38,63 @@ type Caches struct {
 	NegAssertionCache cache.NegativeAssertion
 }
 
-func initCaches(config Config) *Caches {
+//initCaches creates all caches according to config. tooManyKeys is called when a zone has more
+//public keys than allowed.
+func initCaches(config Config, tooManyKeys func(zone, context string)) *Caches {
 	caches := new(Caches)
 	caches.ConnCache = cache.NewConnection(config.MaxConnections)
 	caches.Capabilities = cache.NewCapability(config.CapabilitiesCacheSize)
 	caches.ZoneKeyCache = cache.NewZoneKey(config.ZoneKeyCacheSize, config.ZoneKeyCacheWarnSize,
-		config.MaxPublicKeysPerZone)
+		config.MaxPublicKeysPerZone, tooManyKeys)
 	caches.PendingKeys = cache.NewPendingKey(config.PendingKeyCacheSize)
+	senderLimit := config.PendingMessageSenderLimit
+	if senderLimit <= 0 {
+		senderLimit = defaultPendingMessageSenderLimit
+	}
+	caches.PendingMessages = cache.NewPendingMessage(config.PendingKeyCacheSize, senderLimit,
+		senderKey)
 	caches.PendingQueries = cache.NewPendingQuery(config.PendingQueryCacheSize)
 	caches.AssertionsCache = cache.NewAssertion(config.AssertionCacheSize)
+	caches.AssertionsCache.SetStaleGracePeriod(config.StaleGracePeriod)
 	caches.NegAssertionCache = cache.NewNegAssertion(config.NegativeAssertionCacheSize)
 	return caches
 }
 
-func initReapers(config Config, caches *Caches, stop chan bool) {
-	go repeatFuncCaller(caches.ZoneKeyCache.RemoveExpiredKeys, config.ReapZoneKeyCacheInterval, stop)
-	go repeatFuncCaller(caches.PendingKeys.RemoveExpiredValues, config.ReapPendingKeyCacheInterval, stop)
-	go repeatFuncCaller(caches.AssertionsCache.RemoveExpiredValues, config.ReapAssertionCacheInterval, stop)
-	go repeatFuncCaller(caches.NegAssertionCache.RemoveExpiredValues, config.ReapNegAssertionCacheInterval, stop)
-	go repeatFuncCaller(caches.PendingQueries.RemoveExpiredValues, config.ReapPendingQCacheInterval, stop)
+//initReapers starts the go routines which periodically remove expired entries from the server's
+//caches until stop is closed. They are added to routines. The reap intervals are taken from the
+//current config before each wait. The senders waiting in the pending key and pending query caches
+//are notified about expired entries which have not yet been removed by the pending timers.
+func (s *Server) initReapers(stop <-chan struct{}, routines *sync.WaitGroup) {
+	config, caches := s.config, s.caches
+	reap := func(function func(), interval func() time.Duration) {
+		routines.Add(1)
+		go func() {
+			defer routines.Done()
+			repeatFuncCaller(function, interval, stop)
+		}()
+	}
+	reap(caches.ZoneKeyCache.RemoveExpiredKeys,
+		func() time.Duration { return config().ReapZoneKeyCacheInterval })
+	reap(s.expirePendingKeys,
+		func() time.Duration { return config().ReapPendingKeyCacheInterval })
+	reap(caches.PendingMessages.RemoveExpiredValues,
+		func() time.Duration { return config().ReapPendingKeyCacheInterval })
+	reap(caches.AssertionsCache.RemoveExpiredValues,
+		func() time.Duration { return config().ReapAssertionCacheInterval })
+	reap(caches.NegAssertionCache.RemoveExpiredValues,
+		func() time.Duration { return config().ReapNegAssertionCacheInterval })
+	reap(s.expirePendingQueries,
+		func() time.Duration { return config().ReapPendingQCacheInterval })
+}
+
+//resizeCaches changes the maximum sizes of all caches to the ones in config.
+func resizeCaches(config *Config, caches *Caches) {
+	caches.ConnCache.Resize(config.MaxConnections)
+	caches.Capabilities.Resize(config.CapabilitiesCacheSize)
+	caches.ZoneKeyCache.Resize(config.ZoneKeyCacheSize, config.ZoneKeyCacheWarnSize)
+	caches.PendingKeys.Resize(config.PendingKeyCacheSize)
+	caches.PendingMessages.Resize(config.PendingKeyCacheSize)
+	caches.PendingQueries.Resize(config.PendingQueryCacheSize)
+	caches.AssertionsCache.Resize(config.AssertionCacheSize)
+	caches.NegAssertionCache.Resize(config.NegativeAssertionCacheSize)
 }
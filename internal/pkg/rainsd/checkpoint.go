@@ -0,0 +1,372 @@
+package rainsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+)
+
+const (
+	aCheckPointFileName = "assertionCheckPoint.cbor"
+	nCheckPointFileName = "negAssertionCheckPoint.cbor"
+	zCheckPointFileName = "zoneKeyCheckPoint.cbor"
+
+	//checkpointMagic identifies a checkpoint file.
+	checkpointMagic = "rains checkpoint"
+	//checkpointVersion is the version of the checkpoint format written by this server.
+	checkpointVersion = 1
+	//corruptSuffix is appended to the name of a damaged checkpoint file after it has been loaded.
+	corruptSuffix = ".corrupt"
+)
+
+//legacyCheckPointFileNames are the names of the gob encoded checkpoint files of earlier versions.
+//They are not loaded as their sections cannot be verified.
+var legacyCheckPointFileNames = []string{
+	"assertionCheckPoint.gob",
+	"negAssertionCheckPoint.gob",
+	"zoneKeyCheckPoint.gob",
+}
+
+//A checkpoint file consists of a cbor map header followed by one cbor map entry per section. The
+//header contains the magic string, the format version and the number of entries. An entry contains
+//the validity of the cached section, the section's type and the section itself.
+const (
+	headerMagic   = 0
+	headerVersion = 1
+	headerEntries = 2
+
+	entryValidSince = 0
+	entryValidUntil = 1
+	entryType       = 2
+	entrySection    = 3
+)
+
+//The section types of an entry are the same as in a message.
+const (
+	assertionType = 1
+	shardType     = 2
+	pshardType    = 3
+	zoneType      = 4
+)
+
+//checkpointEntry is a section loaded from a checkpoint file together with its stored validity.
+type checkpointEntry struct {
+	section    section.WithSigForward
+	validSince int64
+	validUntil int64
+}
+
+//checkpoint stores the sections returned by values in the file at path. The file is first written
+//to a temporary file in the same folder which then replaces the file at path. Thus, the file at
+//path is never partially written.
+func checkpoint(path string, values func() []section.Section) (err error) {
+	sections := values()
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	buffer := bufio.NewWriter(file)
+	w := cbor.NewWriter(buffer)
+	if err := w.WriteIntMap(map[int]interface{}{
+		headerMagic:   checkpointMagic,
+		headerVersion: checkpointVersion,
+		headerEntries: len(sections),
+	}); err != nil {
+		return err
+	}
+	for _, sec := range sections {
+		sec, ok := sec.(section.WithSigForward)
+		if !ok {
+			return fmt.Errorf("cannot checkpoint section of type %T", sec)
+		}
+		t, err := sectionType(sec)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteIntMap(map[int]interface{}{
+			entryValidSince: sec.ValidSince(),
+			entryValidUntil: sec.ValidUntil(),
+			entryType:       t,
+			entrySection:    sec,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+//checkpointCaches immediately stores the content of the assertion, negative assertion and zone
+//key caches in the checkpoint files at cpPath.
+func checkpointCaches(cpPath string, caches *Caches) error {
+	if err := os.MkdirAll(cpPath, os.ModePerm); err != nil {
+		return err
+	}
+	if err := checkpoint(path.Join(cpPath, aCheckPointFileName),
+		caches.AssertionsCache.Checkpoint); err != nil {
+		return err
+	}
+	if err := checkpoint(path.Join(cpPath, nCheckPointFileName),
+		caches.NegAssertionCache.Checkpoint); err != nil {
+		return err
+	}
+	return checkpoint(path.Join(cpPath, zCheckPointFileName), caches.ZoneKeyCache.Checkpoint)
+}
+
+//loadCaches adds the sections of the checkpoint files to the caches. The signatures of all sections
+//are verified again. Zone keys are verified with the root key and the zone keys of the checkpoint
+//which have already been verified. A section is dropped if a signature is invalid, a public key is
+//missing or it has expired. A section expires at the latest when it expired in the checkpointed
+//cache.
+func (s *Server) loadCaches() {
+	config := s.config()
+	for _, path := range legacyCheckpoints(config.CheckPointPath) {
+		log.Warn("Skipped checkpoint file of an old format. Its sections are not loaded", "path",
+			path)
+	}
+	zoneKeys := s.readCheckpointFile(path.Join(config.CheckPointPath, zCheckPointFileName))
+	for verified := true; verified; {
+		verified = false
+		remaining := []checkpointEntry{}
+		for _, e := range zoneKeys {
+			valid, keysMissing := s.verifyCheckpointEntry(e)
+			if keysMissing {
+				remaining = append(remaining, e)
+				continue
+			}
+			if a, ok := e.section.(*section.Assertion); valid && ok {
+				addZoneKeysToCache(a, isAuthoritative(a, config.Authorities), s.caches)
+				verified = true
+			}
+		}
+		zoneKeys = remaining
+	}
+	if len(zoneKeys) > 0 {
+		log.Warn("Dropped checkpointed zone keys with missing public keys", "count", len(zoneKeys))
+	}
+
+	for _, e := range s.readCheckpointFile(path.Join(config.CheckPointPath, aCheckPointFileName)) {
+		a, ok := e.section.(*section.Assertion)
+		if !ok {
+			log.Warn("Invalid type for assertion cache", "type", fmt.Sprintf("%T", e.section))
+			continue
+		}
+		if valid, _ := s.verifyCheckpointEntry(e); valid {
+			s.caches.AssertionsCache.Add(a, a.ValidUntil(), isAuthoritative(a, config.Authorities))
+		}
+	}
+
+	for _, e := range s.readCheckpointFile(path.Join(config.CheckPointPath, nCheckPointFileName)) {
+		if valid, _ := s.verifyCheckpointEntry(e); !valid {
+			continue
+		}
+		isAuth := isAuthoritative(e.section, config.Authorities)
+		switch sec := e.section.(type) {
+		case *section.Shard:
+			s.caches.NegAssertionCache.AddShard(sec, sec.ValidUntil(), isAuth)
+		case *section.Pshard:
+			s.caches.NegAssertionCache.AddPshard(sec, sec.ValidUntil(), isAuth)
+		case *section.Zone:
+			s.caches.NegAssertionCache.AddZone(sec, sec.ValidUntil(), isAuth)
+		default:
+			log.Warn("Invalid type for negative Assertion cache", "type", fmt.Sprintf("%T", sec))
+		}
+	}
+}
+
+//legacyCheckpoints returns the paths of the gob encoded checkpoint files of earlier versions in
+//cpPath.
+func legacyCheckpoints(cpPath string) []string {
+	var paths []string
+	for _, name := range legacyCheckPointFileNames {
+		if _, err := os.Stat(path.Join(cpPath, name)); err == nil {
+			paths = append(paths, path.Join(cpPath, name))
+		}
+	}
+	return paths
+}
+
+//addZoneKeysToCache adds the public keys of all delegations in a to the zone key cache.
+func addZoneKeysToCache(a *section.Assertion, isAuthoritative bool, caches *Caches) {
+	for _, o := range a.Content {
+		if o.Type != object.OTDelegation {
+			continue
+		}
+		if publicKey, ok := o.Value.(keys.PublicKey); ok {
+			publicKey.ValidSince = a.ValidSince()
+			publicKey.ValidUntil = a.ValidUntil()
+			caches.ZoneKeyCache.Add(a, publicKey, isAuthoritative)
+		}
+	}
+}
+
+//verifyCheckpointEntry verifies the signatures of e's section against the zone key cache and
+//restricts its validity to the stored one. It returns true if all signatures are valid and the
+//section has not yet expired. keysMissing is true if the section could not be verified because a
+//public key is not cached.
+func (s *Server) verifyCheckpointEntry(e checkpointEntry) (valid, keysMissing bool) {
+	keys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	missingKeys := make(map[missingKeyMetaData]bool)
+	publicKeysPresent(e.section, s.caches.ZoneKeyCache, keys, missingKeys, s.metrics)
+	if len(missingKeys) > 0 {
+		return false, true
+	}
+	valid = siglib.CheckSectionSignatures(e.section, keys, s.config().MaxCacheValidity)
+	s.metrics.sigVerification("section", valid)
+	if !valid {
+		log.Warn("Dropped checkpointed section with invalid signature", "section", e.section)
+		return false, false
+	}
+	if e.validSince > e.section.ValidSince() {
+		e.section.SetValidSince(e.validSince)
+	}
+	if e.validUntil < e.section.ValidUntil() {
+		e.section.SetValidUntil(e.validUntil)
+	}
+	return e.section.ValidUntil() > time.Now().Unix(), false
+}
+
+//readCheckpointFile returns the entries of the checkpoint file at path. If the file is damaged,
+//the entries before the damaged part are returned and the file is renamed such that it is kept for
+//inspection but not loaded again.
+func (s *Server) readCheckpointFile(path string) []checkpointEntry {
+	entries, err := readCheckpoint(path)
+	if os.IsNotExist(err) {
+		log.Info("There is no checkpoint file", "path", path)
+		return nil
+	}
+	if err == nil {
+		return entries
+	}
+	if len(entries) == 0 {
+		log.Warn("Skipped unreadable checkpoint file", "path", path, "error", err)
+	} else {
+		log.Warn("Checkpoint file is damaged. Loading intact entries", "path", path,
+			"entries", len(entries), "error", err)
+	}
+	if err := os.Rename(path, path+corruptSuffix); err != nil {
+		log.Error("Was not able to move damaged checkpoint file", "path", path, "error", err)
+	}
+	return entries
+}
+
+//readCheckpoint returns the entries of the checkpoint file at path. In case of an error, the entries
+//read before it occurred are returned. Entries which cannot be decoded are skipped.
+func readCheckpoint(path string) (entries []checkpointEntry, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	//The cbor reader panics on some malformed input.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed cbor: %v", r)
+		}
+	}()
+	r := cbor.NewReader(bufio.NewReader(file))
+	header, err := r.ReadIntMapUntagged()
+	if err != nil {
+		return nil, fmt.Errorf("was not able to read header: %v", err)
+	}
+	if magic, _ := header[headerMagic].(string); magic != checkpointMagic {
+		return nil, errors.New("not a checkpoint file")
+	}
+	if version, _ := header[headerVersion].(int); version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version: %v", header[headerVersion])
+	}
+	n, ok := header[headerEntries].(int)
+	if !ok {
+		return nil, errors.New("header does not contain the number of entries")
+	}
+	var skipped error
+	for i := 0; i < n; i++ {
+		m, err := r.ReadIntMapUntagged()
+		if err != nil {
+			return entries, fmt.Errorf("file is truncated after %d of %d entries: %v", i, n, err)
+		}
+		e, err := decodeCheckpointEntry(m)
+		if err != nil {
+			skipped = fmt.Errorf("skipped malformed entry %d: %v", i, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, skipped
+}
+
+//decodeCheckpointEntry returns the entry encoded in m.
+func decodeCheckpointEntry(m map[int]interface{}) (checkpointEntry, error) {
+	var sec section.WithSigForward
+	switch m[entryType] {
+	case assertionType:
+		sec = &section.Assertion{}
+	case shardType:
+		sec = &section.Shard{}
+	case pshardType:
+		sec = &section.Pshard{}
+	case zoneType:
+		sec = &section.Zone{}
+	default:
+		return checkpointEntry{}, fmt.Errorf("unknown section type: %v", m[entryType])
+	}
+	encoding, ok := m[entrySection].(map[int]interface{})
+	if !ok {
+		return checkpointEntry{}, errors.New("section is not a map")
+	}
+	if err := sec.UnmarshalMap(encoding); err != nil {
+		return checkpointEntry{}, err
+	}
+	validSince, ok := m[entryValidSince].(int)
+	if !ok {
+		return checkpointEntry{}, errors.New("validSince is not an integer")
+	}
+	validUntil, ok := m[entryValidUntil].(int)
+	if !ok {
+		return checkpointEntry{}, errors.New("validUntil is not an integer")
+	}
+	return checkpointEntry{section: sec, validSince: int64(validSince),
+		validUntil: int64(validUntil)}, nil
+}
+
+//sectionType returns the type with which sec is stored in a checkpoint.
+func sectionType(sec section.WithSigForward) (int, error) {
+	switch sec.(type) {
+	case *section.Assertion:
+		return assertionType, nil
+	case *section.Shard:
+		return shardType, nil
+	case *section.Pshard:
+		return pshardType, nil
+	case *section.Zone:
+		return zoneType, nil
+	default:
+		return 0, fmt.Errorf("cannot checkpoint section of type %T", sec)
+	}
+}
@@ -0,0 +1,198 @@
+package rainsd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"golang.org/x/crypto/ed25519"
+)
+
+//delegation returns an assertion delegating zone name.zone to a newly generated key pair signed by
+//the key signer. The key pair is stored at keyPath under the delegated zone's name.
+func delegation(t *testing.T, keyPath, signer, zone, name string) *section.Assertion {
+	t.Helper()
+	if err := keyManager.GenerateKey(keyPath, name, "", algorithmTypes.Ed25519.String(), "",
+		1); err != nil {
+		t.Fatalf("Was not able to generate key pair: %v", err)
+	}
+	block, err := keyManager.DecryptKey(keyPath, name+keyManager.SecSuffix, "")
+	if err != nil {
+		t.Fatalf("Was not able to decrypt key: %v", err)
+	}
+	keyID, privateKey, err := keyManager.PemToKeyID(block)
+	if err != nil {
+		t.Fatalf("Was not able to decode key: %v", err)
+	}
+	a := &section.Assertion{
+		SubjectName: name,
+		SubjectZone: zone,
+		Context:     ".",
+		Content: []object.Object{{Type: object.OTDelegation, Value: keys.PublicKey{
+			PublicKeyID: keyID,
+			Key:         privateKey.(ed25519.PrivateKey).Public().(ed25519.PublicKey),
+		}}},
+	}
+	signAssertion(t, keyPath, signer, a)
+	return a
+}
+
+//newCheckpointServer returns a server which is not running with the root key of config.
+func newCheckpointServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	s, err := New(config, "checkpoint")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	return s
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	config := memoryConfig(t, "TestCheckpointRoundTrip", DefaultConfig().Capabilities)
+	s := newCheckpointServer(t, config)
+	a := signedAssertion(t, config, ".", "www", "192.0.2.1")
+	validUntil := time.Now().Add(10 * time.Minute).Unix()
+	a.SetValidSince(time.Now().Unix())
+	a.SetValidUntil(validUntil)
+	s.caches.AssertionsCache.Add(a, a.ValidUntil(), false)
+	if err := checkpointCaches(config.CheckPointPath, s.caches); err != nil {
+		t.Fatalf("Was not able to checkpoint caches: %v", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(config.CheckPointPath, "*.tmp*")); len(tmp) != 0 {
+		t.Errorf("temporary files were not removed: %v", tmp)
+	}
+
+	loaded := newCheckpointServer(t, config)
+	loaded.loadCaches()
+	assertions, ok := loaded.caches.AssertionsCache.Get("www.", ".", object.OTIP4Addr, false)
+	if !ok || len(assertions) != 1 {
+		t.Fatalf("checkpointed assertion was not loaded: %v", assertions)
+	}
+	if assertions[0].ValidUntil() != validUntil {
+		t.Errorf("wrong expiration. expected=%d actual=%d", validUntil, assertions[0].ValidUntil())
+	}
+}
+
+func TestLoadCachesVerifiesSignatures(t *testing.T) {
+	config := memoryConfig(t, "TestLoadCachesVerifiesSignatures", DefaultConfig().Capabilities)
+	dir := config.CheckPointPath
+	//The delegation of ethz.ch. can only be verified after the one of ch.
+	ch := delegation(t, dir, "root", ".", "ch")
+	ethz := delegation(t, dir, "ch", "ch.", "ethz")
+	//The key of net. is not checkpointed.
+	if err := keyManager.GenerateKey(dir, "net", "", algorithmTypes.Ed25519.String(), "",
+		1); err != nil {
+		t.Fatalf("Was not able to generate key pair: %v", err)
+	}
+	example := delegation(t, dir, "net", "net.", "example")
+	storeCheckpoint(t, filepath.Join(dir, zCheckPointFileName), ethz, example, ch)
+
+	valid := &section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: ".",
+		Content: []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+	signAssertion(t, dir, "ethz", valid)
+	tampered := signedAssertion(t, config, ".", "tampered", "192.0.2.2")
+	tampered.Content[0].Value = net.ParseIP("192.0.2.3")
+	expired := signedAssertion(t, config, ".", "expired", "192.0.2.4")
+	expired.SetValidUntil(time.Now().Add(-time.Minute).Unix())
+	missingKey := &section.Assertion{SubjectName: "www", SubjectZone: "example.net.", Context: ".",
+		Content: []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.5")}}}
+	signAssertion(t, dir, "example", missingKey)
+	storeCheckpoint(t, filepath.Join(dir, aCheckPointFileName), valid, tampered, expired,
+		missingKey)
+
+	s := newCheckpointServer(t, config)
+	s.loadCaches()
+	//root, ch. and ethz.ch.
+	if n := s.caches.ZoneKeyCache.Len(); n != 3 {
+		t.Errorf("wrong number of loaded zone keys. expected=3 actual=%d", n)
+	}
+	if ip := cachedIP4(s, "www.ethz.ch."); ip != "192.0.2.1" {
+		t.Errorf("valid assertion was not loaded: %s", ip)
+	}
+	for _, fqdn := range []string{"tampered.", "expired.", "www.example.net."} {
+		if ip := cachedIP4(s, fqdn); ip != "" {
+			t.Errorf("invalid assertion %s was loaded", fqdn)
+		}
+	}
+}
+
+func TestLoadDamagedCheckpoint(t *testing.T) {
+	config := memoryConfig(t, "TestLoadDamagedCheckpoint", DefaultConfig().Capabilities)
+	path := filepath.Join(config.CheckPointPath, aCheckPointFileName)
+	var tests = []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   []string
+	}{
+		{"truncated", func(data []byte) []byte { return data[:len(data)-5] }, []string{"first."}},
+		{"garbage", func(data []byte) []byte { return []byte("not a checkpoint") }, nil},
+		{"empty", func(data []byte) []byte { return nil }, nil},
+	}
+	for _, test := range tests {
+		first := signedAssertion(t, config, ".", "first", "192.0.2.1")
+		second := signedAssertion(t, config, ".", "second", "192.0.2.2")
+		storeCheckpoint(t, path, first, second)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: Was not able to read checkpoint: %v", test.name, err)
+		}
+		if err := os.WriteFile(path, test.damage(data), 0600); err != nil {
+			t.Fatalf("%s: Was not able to damage checkpoint: %v", test.name, err)
+		}
+		s := newCheckpointServer(t, config)
+		s.loadCaches()
+		if n := s.caches.AssertionsCache.Len(); n != len(test.want) {
+			t.Errorf("%s: wrong number of loaded assertions. expected=%d actual=%d", test.name,
+				len(test.want), n)
+		}
+		for _, fqdn := range test.want {
+			if cachedIP4(s, fqdn) == "" {
+				t.Errorf("%s: intact assertion %s was not loaded", test.name, fqdn)
+			}
+		}
+		if _, err := os.Stat(path + corruptSuffix); err != nil {
+			t.Errorf("%s: damaged checkpoint was not moved: %v", test.name, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: damaged checkpoint is still loaded on the next start", test.name)
+		}
+	}
+}
+
+//storeCheckpoint stores sections in a checkpoint file at path with the validity of their
+//signatures.
+func storeCheckpoint(t *testing.T, path string, sections ...*section.Assertion) {
+	t.Helper()
+	values := []section.Section{}
+	for _, a := range sections {
+		if a.ValidUntil() == 0 {
+			a.SetValidSince(time.Now().Unix())
+			a.SetValidUntil(time.Now().Add(time.Hour).Unix())
+		}
+		values = append(values, a)
+	}
+	if err := checkpoint(path, func() []section.Section { return values }); err != nil {
+		t.Fatalf("Was not able to store checkpoint: %v", err)
+	}
+}
+
+func TestLegacyCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	if paths := legacyCheckpoints(dir); len(paths) != 0 {
+		t.Errorf("legacy checkpoints found in empty folder: %v", paths)
+	}
+	path := filepath.Join(dir, "assertionCheckPoint.gob")
+	if err := os.WriteFile(path, []byte("gob"), 0600); err != nil {
+		t.Fatalf("Was not able to write legacy checkpoint: %v", err)
+	}
+	if paths := legacyCheckpoints(dir); len(paths) != 1 || paths[0] != path {
+		t.Errorf("wrong legacy checkpoints. expected=[%s] actual=%v", path, paths)
+	}
+}
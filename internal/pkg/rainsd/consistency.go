@@ -0,0 +1,168 @@
+package rainsd
+
+import (
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//inconsistentSections returns all sections which contradict a cached element that is valid at the
+//same time and is not superseded by them. An assertion contradicts a shard, pshard or zone if the
+//latter states that at least one of the assertion's object types does not exist for the assertion's
+//subject name. Contradicting cached elements superseded by a consistent section are returned as
+//superseded. They are outdated versions of the section's zone and must be replaced by it.
+func inconsistentSections(sections []section.WithSigForward, assertionsCache cache.Assertion,
+	negAssertionCache cache.NegativeAssertion) (inconsistent, superseded []section.WithSigForward) {
+	for _, sec := range sections {
+		var outdated []section.WithSigForward
+		isInconsistent := false
+		for _, cached := range contradictingSections(sec, assertionsCache, negAssertionCache) {
+			if !supersedes(sec, cached) {
+				log.Warn("Section contradicts a cached section", "section", sec, "cached", cached)
+				isInconsistent = true
+				break
+			}
+			outdated = append(outdated, cached)
+		}
+		if isInconsistent {
+			inconsistent = append(inconsistent, sec)
+		} else {
+			superseded = append(superseded, outdated...)
+		}
+	}
+	return inconsistent, superseded
+}
+
+//supersedes returns true if sec replaces cached. This is the case if cached belongs to the same
+//zone and context and its signatures are not newer than sec's signatures. A section received later
+//replaces a cached section signed at the same time.
+func supersedes(sec, cached section.WithSigForward) bool {
+	return sec.GetSubjectZone() == cached.GetSubjectZone() &&
+		sec.GetContext() == cached.GetContext() && signedSince(cached) <= signedSince(sec)
+}
+
+//signedSince returns the earliest start of the validity of sec's signatures. The validity of the
+//section is returned if it has no signatures. It is not used in the first place as it is also
+//restricted by the validity of the public keys.
+func signedSince(sec section.WithSigForward) int64 {
+	sigs := sec.AllSigs()
+	if len(sigs) == 0 {
+		return sec.ValidSince()
+	}
+	since := sigs[0].ValidSince
+	for _, sig := range sigs[1:] {
+		if sig.ValidSince < since {
+			since = sig.ValidSince
+		}
+	}
+	return since
+}
+
+//contradictingSections returns the cached elements which are valid at the same time as sec and
+//contradict it.
+func contradictingSections(sec section.WithSigForward, assertionsCache cache.Assertion,
+	negAssertionCache cache.NegativeAssertion) []section.WithSigForward {
+	switch sec := sec.(type) {
+	case *section.Assertion:
+		return denyingCachedSections(sec, sec, negAssertionCache)
+	case *section.Shard:
+		var contradicting []section.WithSigForward
+		for _, a := range sec.Content {
+			contradicting = append(contradicting, denyingCachedSections(
+				a.Copy(sec.Context, sec.SubjectZone), sec, negAssertionCache)...)
+		}
+		return append(contradicting, deniedCachedAssertions(sec, assertionsCache)...)
+	case *section.Pshard:
+		return deniedCachedAssertions(sec, assertionsCache)
+	case *section.Zone:
+		var contradicting []section.WithSigForward
+		for _, a := range sec.Content {
+			contradicting = append(contradicting, denyingCachedSections(
+				a.Copy(sec.Context, sec.SubjectZone), sec, negAssertionCache)...)
+		}
+		return append(contradicting, deniedCachedAssertions(sec, assertionsCache)...)
+	default:
+		log.Warn("Not supported section type for consistency check", "type", sec)
+		return nil
+	}
+}
+
+//denyingCachedSections returns the cached shards, pshards and zones which are valid at the same
+//time as the incoming section sec and state that a does not exist. a is either sec itself or an
+//assertion contained in sec.
+func denyingCachedSections(a *section.Assertion, sec section.WithSigForward,
+	negAssertionCache cache.NegativeAssertion) []section.WithSigForward {
+	negs, ok := negAssertionCache.Get(a.SubjectZone, a.Context, a)
+	if !ok {
+		return nil
+	}
+	var denying []section.WithSigForward
+	for _, neg := range negs {
+		if validityOverlaps(sec, neg) && denies(neg, a) {
+			denying = append(denying, neg)
+		}
+	}
+	return denying
+}
+
+//deniedCachedAssertions returns the cached assertions which are valid at the same time as neg and
+//whose existence neg denies.
+func deniedCachedAssertions(neg section.WithSigForward,
+	assertionsCache cache.Assertion) []section.WithSigForward {
+	var denied []section.WithSigForward
+	for _, a := range assertionsCache.GetInRange(neg.GetSubjectZone(), neg.GetContext(), neg) {
+		if validityOverlaps(neg, a) && denies(neg, a) {
+			denied = append(denied, a)
+		}
+	}
+	return denied
+}
+
+//denies returns true if neg states that at least one of a's object types does not exist for a's
+//subject name. a must have the same subject zone and context as neg.
+func denies(neg section.WithSigForward, a *section.Assertion) bool {
+	switch neg := neg.(type) {
+	case *section.Shard:
+		return neg.InRange(a.SubjectName) && !containsAllTypes(neg.Content, a)
+	case *section.Pshard:
+		if !neg.InRange(a.SubjectName) {
+			return false
+		}
+		for _, o := range a.Content {
+			ok, err := neg.BloomFilter.Contains(a.SubjectName, neg.SubjectZone, neg.Context, o.Type)
+			if err == nil && !ok {
+				return true
+			}
+		}
+		return false
+	case *section.Zone:
+		return !containsAllTypes(neg.Content, a)
+	default:
+		return false
+	}
+}
+
+//containsAllTypes returns true if assertions together hold an object of each of a's object types
+//for a's subject name.
+func containsAllTypes(assertions []*section.Assertion, a *section.Assertion) bool {
+	types := make(map[object.Type]bool)
+	for _, assertion := range assertions {
+		if assertion.SubjectName == a.SubjectName {
+			for _, o := range assertion.Content {
+				types[o.Type] = true
+			}
+		}
+	}
+	for _, o := range a.Content {
+		if !types[o.Type] {
+			return false
+		}
+	}
+	return true
+}
+
+//validityOverlaps returns true if the validity periods of x and y overlap.
+func validityOverlaps(x, y section.WithSig) bool {
+	return x.ValidSince() <= y.ValidUntil() && y.ValidSince() <= x.ValidUntil()
+}
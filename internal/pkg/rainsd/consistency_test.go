@@ -0,0 +1,69 @@
+package rainsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestInconsistentSection(t *testing.T) {
+	var tests = []struct {
+		name          string
+		authoritative bool
+		shardOffset   int64
+		inconsistent  bool
+		assertionKept bool
+	}{
+		{"older authoritative", true, -60, true, true},
+		{"older", false, -60, true, false},
+		{"newer authoritative", true, 60, false, false},
+		{"newer", false, 60, false, false},
+		{"same validity", false, 0, false, false},
+	}
+	for i, test := range tests {
+		addr := connection.MemoryAddr("TestInconsistentSection" + test.name)
+		config := memoryConfig(t, addr, DefaultConfig().Capabilities)
+		if test.authoritative {
+			config.Authorities = []ZoneContext{{"example.", "."}}
+		}
+		s, err := runMemoryServer(t, config)
+		if err != nil {
+			t.Fatalf("%d: Was not able to run server: %v", i, err)
+		}
+		client := addr + "Client"
+		msgs := memoryPeer(t, client)
+		a := signedAssertion(t, config, "example.", "www", "192.0.2.1")
+		now := a.AllSigs()[0].ValidSince
+		a.SetValidSince(now)
+		a.SetValidUntil(now + 3600)
+		s.caches.AssertionsCache.Add(a, now+3600, test.authoritative)
+
+		//A shard without content states that www.example. does not exist. It contradicts the
+		//cached assertion unless it is a newer version of the zone.
+		shard := &section.Shard{SubjectZone: "example.", Context: "."}
+		shard.AddSig(signature.Sig{ValidSince: now + test.shardOffset, ValidUntil: now + 3600})
+		shard.SetValidSince(now + test.shardOffset)
+		shard.SetValidUntil(now + 3600)
+		tok := token.New()
+		s.assert(util.SectionWithSigSender{Sender: client, Token: tok,
+			Sections: []section.WithSigForward{shard}})
+		if test.inconsistent {
+			waitForNotification(t, msgs, tok, section.NTRcvInconsistentMsg, time.Second)
+		}
+
+		//An authoritative server keeps its data. Otherwise the zone is evicted or the assertion
+		//is replaced by the newer shard.
+		if ip := cachedIP4(s, "www.example."); (ip != "") != test.assertionKept {
+			t.Errorf("%d: %s: wrong cached assertion. expected kept=%v actual=%q", i, test.name,
+				test.assertionKept, ip)
+		}
+		if _, ok := s.caches.NegAssertionCache.Get("example.", ".", shard); ok == test.inconsistent {
+			t.Errorf("%d: %s: shard cached=%v expected=%v", i, test.name, ok, !test.inconsistent)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package rainsd
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+const (
+	//maxPeerStandings is the maximum number of peers whose standing is recorded.
+	maxPeerStandings = 10000
+	//defaultDelegationQueryBackoff is the time waited for a peer's answer before delegation
+	//queries are sent again if no backoff is configured.
+	defaultDelegationQueryBackoff = 250 * time.Millisecond
+)
+
+//keyRequest contains the delegation queries sent to a peer to obtain the public keys with which
+//the sections it sent are signed.
+type keyRequest struct {
+	msg  message.Message
+	peer net.Addr
+	//retries is the number of times the queries are sent again to peer before they are sent to
+	//the recursive resolver.
+	retries int
+	//backoff is the time waited for peer's answer before the queries are sent again.
+	backoff time.Duration
+	timer   *time.Timer
+}
+
+//keyRequests contains the unanswered key requests by the token of their delegation queries.
+type keyRequests struct {
+	requests map[token.Token]*keyRequest
+	stopped  bool
+	//mux protects requests and stopped from simultaneous access.
+	mux sync.Mutex
+}
+
+func newKeyRequests() *keyRequests {
+	return &keyRequests{requests: make(map[token.Token]*keyRequest)}
+}
+
+//add stores req and calls retry with the token of req once req.backoff has passed.
+func (r *keyRequests) add(req *keyRequest, retry func(token.Token)) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.stopped {
+		return false
+	}
+	r.requests[req.msg.Token] = req
+	req.timer = time.AfterFunc(req.backoff, func() { retry(req.msg.Token) })
+	return true
+}
+
+//remove deletes and returns the request with token t. It returns false if there is no such
+//request.
+func (r *keyRequests) remove(t token.Token) (*keyRequest, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	req, ok := r.requests[t]
+	if !ok {
+		return nil, false
+	}
+	req.timer.Stop()
+	delete(r.requests, t)
+	return req, true
+}
+
+//stop stops the timers of all requests. No requests are sent anymore.
+func (r *keyRequests) stop() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.stopped = true
+	for t, req := range r.requests {
+		req.timer.Stop()
+		delete(r.requests, t)
+	}
+}
+
+//PeerStanding records how often a peer supplied the public keys of the sections it sent when it
+//was asked for them.
+type PeerStanding struct {
+	Peer        string
+	Supplied    int
+	Failed      int
+	LastFailure time.Time
+}
+
+//Good returns true if the peer supplied its keys at least as often as it failed to do so.
+func (p PeerStanding) Good() bool {
+	return p.Failed <= p.Supplied
+}
+
+//peerStandings contains the standing of peers by their host.
+type peerStandings struct {
+	peers map[string]*PeerStanding
+	//mux protects peers from simultaneous access.
+	mux sync.Mutex
+}
+
+func newPeerStandings() *peerStandings {
+	return &peerStandings{peers: make(map[string]*PeerStanding)}
+}
+
+//peerHost returns the address of peer without the port such that the connections of a peer share
+//a standing.
+func peerHost(peer net.Addr) string {
+	if host, _, err := net.SplitHostPort(peer.String()); err == nil {
+		return host
+	}
+	return peer.String()
+}
+
+//get returns the standing of peer. A new standing is created if there is room for it, otherwise
+//nil is returned. It must be called with p.mux held.
+func (p *peerStandings) get(peer net.Addr) *PeerStanding {
+	host := peerHost(peer)
+	if standing, ok := p.peers[host]; ok {
+		return standing
+	}
+	if len(p.peers) >= maxPeerStandings {
+		return nil
+	}
+	standing := &PeerStanding{Peer: host}
+	p.peers[host] = standing
+	return standing
+}
+
+//supplied records that peer supplied the requested keys.
+func (p *peerStandings) supplied(peer net.Addr) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if standing := p.get(peer); standing != nil {
+		standing.Supplied++
+	}
+}
+
+//failed records that peer failed to supply the requested keys.
+func (p *peerStandings) failed(peer net.Addr) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if standing := p.get(peer); standing != nil {
+		standing.Failed++
+		standing.LastFailure = time.Now()
+	}
+}
+
+//good returns true if peer has a good standing. Peers without a standing are good.
+func (p *peerStandings) good(peer net.Addr) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	standing, ok := p.peers[peerHost(peer)]
+	return !ok || standing.Good()
+}
+
+//all returns the standings of all peers sorted by peer.
+func (p *peerStandings) all() []PeerStanding {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	standings := []PeerStanding{}
+	for _, standing := range p.peers {
+		standings = append(standings, *standing)
+	}
+	sort.Slice(standings, func(i, j int) bool { return standings[i].Peer < standings[j].Peer })
+	return standings
+}
+
+//delegationQueryBackoff returns the configured delegation query backoff or the default if none is
+//configured.
+func delegationQueryBackoff(config *Config) time.Duration {
+	if config.DelegationQueryBackoff <= 0 {
+		return defaultDelegationQueryBackoff
+	}
+	return config.DelegationQueryBackoff
+}
+
+//keyRequestDuration returns the longest time which passes between sending a delegation query to a
+//peer and sending it to the recursive resolver.
+func keyRequestDuration(config *Config) time.Duration {
+	total := time.Duration(0)
+	backoff := delegationQueryBackoff(config)
+	for i := 0; i <= config.DelegationQueryRetries; i++ {
+		total += backoff
+		backoff *= 2
+	}
+	return total
+}
+
+//requestKeys sends the delegation queries in msg to peer. If peer does not answer within
+//DelegationQueryBackoff, the queries are sent again with doubled backoff up to
+//DelegationQueryRetries times. Afterwards, the queries are sent to the recursive resolver. A peer
+//which failed to supply its keys more often than it supplied them is not asked again.
+func (s *Server) requestKeys(msg message.Message, peer net.Addr) {
+	config := s.config()
+	req := &keyRequest{
+		msg:     msg,
+		peer:    peer,
+		retries: config.DelegationQueryRetries,
+		backoff: delegationQueryBackoff(config),
+	}
+	if !s.peerStandings.good(peer) {
+		log.Info("Peer has a bad standing, do not retry delegation queries", "peer", peer)
+		req.retries = 0
+	}
+	if !s.keyRequests.add(req, s.retryKeyRequest) {
+		return
+	}
+	s.sendKeyRequest(req)
+}
+
+//sendKeyRequest sends the delegation queries of req to its peer.
+func (s *Server) sendKeyRequest(req *keyRequest) {
+	if err := s.sendTo(req.msg, req.peer, 0, 0); err != nil {
+		log.Warn("Was not able to send delegation queries", "peer", req.peer, "error", err)
+	}
+}
+
+//retryKeyRequest is called when the peer has not answered the key request with token t in time.
+//The delegation queries are sent again to the peer or, if no retries are left, to the recursive
+//resolver.
+func (s *Server) retryKeyRequest(t token.Token) {
+	s.keyRequests.mux.Lock()
+	req, ok := s.keyRequests.requests[t]
+	if !ok || s.keyRequests.stopped {
+		s.keyRequests.mux.Unlock()
+		return
+	}
+	if !s.caches.PendingKeys.ContainsToken(t) {
+		//The waiting sections have expired.
+		delete(s.keyRequests.requests, t)
+		s.keyRequests.mux.Unlock()
+		return
+	}
+	if req.retries > 0 {
+		req.retries--
+		req.backoff *= 2
+		req.timer = time.AfterFunc(req.backoff, func() { s.retryKeyRequest(t) })
+		s.keyRequests.mux.Unlock()
+		log.Info("Peer did not answer delegation queries in time, send them again",
+			"peer", req.peer, "token", t, "retriesLeft", req.retries)
+		s.sendKeyRequest(req)
+		return
+	}
+	delete(s.keyRequests.requests, t)
+	s.keyRequests.mux.Unlock()
+	s.keyRequestFailed(req)
+}
+
+//keyRequestFailed lowers the standing of req's peer and sends the delegation queries to the
+//recursive resolver. It returns false if there is no recursive resolver.
+func (s *Server) keyRequestFailed(req *keyRequest) bool {
+	log.Warn("Peer failed to supply the keys its sections are signed with", "peer", req.peer,
+		"token", req.msg.Token)
+	s.peerStandings.failed(req.peer)
+	if s.resolver == nil {
+		return false
+	}
+	log.Info("Send delegation queries to recursive resolver", "msg", req.msg)
+	s.sendToRecursiveResolver(req.msg)
+	return true
+}
+
+//keyRequestAnswered is called when an answer with token t arrives. If it answers a key request,
+//the standing of the request's peer is raised.
+func (s *Server) keyRequestAnswered(t token.Token) {
+	if req, ok := s.keyRequests.remove(t); ok {
+		s.peerStandings.supplied(req.peer)
+	}
+}
+
+//keyRequestRefused is called when a notification with token t arrives. If the peer of a key
+//request notifies that it cannot answer the delegation queries, they are immediately sent to the
+//recursive resolver. It returns true if the sections waiting for the keys must be kept.
+func (s *Server) keyRequestRefused(t token.Token) bool {
+	req, ok := s.keyRequests.remove(t)
+	if !ok {
+		return false
+	}
+	return s.keyRequestFailed(req)
+}
@@ -0,0 +1,152 @@
+package rainsd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//missingExampleKeys lets s wait for the delegation of example. to verify an assertion of
+//www.example. signed with the key example in keyPath which peer sent with token tok.
+func missingExampleKeys(t *testing.T, s *Server, keyPath string, peer net.Addr, tok token.Token) {
+	t.Helper()
+	www := &section.Assertion{SubjectName: "www", SubjectZone: "example.", Context: ".",
+		Content: []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+	signAssertion(t, keyPath, "example", www)
+	ss := util.MsgSectionSender{Sender: peer, Token: tok, Sections: []section.Section{www}}
+	handleMissingKeys(ss, map[missingKeyMetaData]bool{{Zone: "example.", Context: "."}: true}, s,
+		false)
+}
+
+//delegationQueries returns the tokens of the delegation queries for example. received on msgs
+//within timeout.
+func delegationQueries(msgs <-chan *message.Message, timeout time.Duration) []token.Token {
+	tokens := []token.Token{}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-msgs:
+			for _, sec := range msg.Content {
+				if q, ok := sec.(*query.Name); ok && q.Name == "example." &&
+					q.Types[0] == object.OTDelegation {
+					tokens = append(tokens, msg.Token)
+				}
+			}
+		case <-deadline:
+			return tokens
+		}
+	}
+}
+
+func TestKeyRequestRetry(t *testing.T) {
+	config := memoryConfig(t, "TestKeyRequestRetry", DefaultConfig().Capabilities)
+	config.DelegationQueryRetries = 2
+	config.DelegationQueryBackoff = 50 * time.Millisecond
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	peer := connection.MemoryAddr("TestKeyRequestRetryPeer")
+	msgs := memoryPeer(t, peer)
+	delegation(t, config.CheckPointPath, "root", ".", "example")
+
+	missingExampleKeys(t, s, config.CheckPointPath, peer, token.New())
+	tokens := delegationQueries(msgs, time.Second)
+	if len(tokens) != 3 || tokens[0] != tokens[1] || tokens[1] != tokens[2] {
+		t.Fatalf("delegation query was not sent 3 times with the same token: %v", tokens)
+	}
+	standings := s.peerStandings.all()
+	if len(standings) != 1 || standings[0].Peer != peer.String() || standings[0].Failed != 1 ||
+		standings[0].Good() {
+		t.Fatalf("failure of peer is not recorded: %v", standings)
+	}
+	if !s.caches.PendingKeys.ContainsToken(tokens[0]) {
+		t.Errorf("sections were dropped before their delegation query expired")
+	}
+
+	//A peer with a bad standing is asked only once.
+	missingExampleKeys(t, s, config.CheckPointPath, peer, token.New())
+	if tokens := delegationQueries(msgs, time.Second); len(tokens) != 1 {
+		t.Errorf("peer with bad standing was asked %d times", len(tokens))
+	}
+}
+
+func TestKeyRequestFallback(t *testing.T) {
+	var tests = []struct {
+		name   string
+		refuse bool
+	}{
+		{"no answer", false},
+		{"refused", true},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//The authoritative server of the root zone has the delegation of example.
+			config := memoryConfig(t, connection.MemoryAddr(t.Name()+"Auth"),
+				DefaultConfig().Capabilities)
+			config.Authorities = []ZoneContext{{".", "."}}
+			config.ZoneFiles = []string{filepath.Join(t.TempDir(), "root.txt")}
+			storeZonefile(t, config.ZoneFiles[0],
+				delegation(t, config.CheckPointPath, "root", ".", "example"))
+			auth, err := runMemoryServer(t, config)
+			if err != nil {
+				t.Fatalf("%d: Was not able to run authoritative server: %v", i, err)
+			}
+
+			config.ServerAddress = connection.Info{Type: connection.Memory,
+				Addr: connection.MemoryAddr(t.Name())}
+			config.Authorities = nil
+			config.ZoneFiles = nil
+			config.DelegationQueryRetries = 1
+			config.DelegationQueryBackoff = 50 * time.Millisecond
+			s, err := New(config, t.Name())
+			if err != nil {
+				t.Fatalf("%d: Was not able to create server: %v", i, err)
+			}
+			r, err := libresolve.New(nil, []net.Addr{auth.Addr()}, config.RootZonePublicKeyPath,
+				libresolve.Forward, s.Addr(), 10, config.MaxCacheValidity, 10)
+			if err != nil {
+				t.Fatalf("%d: Was not able to create libresolve resolver: %v", i, err)
+			}
+			s.SetResolver(r)
+			go s.Run(context.Background())
+			<-s.Ready()
+			t.Cleanup(s.Shutdown)
+
+			peer := connection.MemoryAddr(t.Name() + "Peer")
+			msgs := memoryPeer(t, peer)
+			missingExampleKeys(t, s, config.CheckPointPath, peer, token.New())
+			if test.refuse {
+				msg := <-msgs
+				s.notify(util.MsgSectionSender{Sender: peer, Token: msg.Token,
+					Sections: []section.Section{&section.Notification{Token: msg.Token,
+						Type: section.NTNoAssertionAvail}}})
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				if _, ok := s.caches.AssertionsCache.Get("www.example.", ".", object.OTIP4Addr,
+					true); ok {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d: sections were not verified with the keys of the resolver", i)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if standings := s.peerStandings.all(); len(standings) != 1 || standings[0].Failed != 1 {
+				t.Errorf("%d: failure of peer is not recorded: %v", i, standings)
+			}
+		})
+	}
+}
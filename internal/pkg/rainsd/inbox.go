@@ -3,41 +3,44 @@ package rainsd
 import (
 	"fmt"
 	"net"
-	"time"
+	"strings"
+	"sync"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/netsec-ethz/rains/internal/pkg/cache"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/scionproto/scion/go/lib/snet"
 )
 
-type InputQueues struct {
-	//incoming messages are buffered in one of these channels until they get processed by a worker
-	//go routine the prioChannel only contains incoming sections in response to a delegation query
-	//issued by this server.
-	Prio   chan util.MsgSectionSender
-	Normal chan util.MsgSectionSender
-	Notify chan util.MsgSectionSender
-
-	//These channels limit the number of go routines working on the different queues to avoid memory
-	//exhaustion.
-	PrioW   chan struct{}
-	NormalW chan struct{}
-	NotifyW chan struct{}
-}
-
 //deliver pushes all incoming messages to the prio or normal channel.
 //A message is added to the priority channel if it is the response to a non-expired delegation query
-func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSectionSender,
-	normalChannel chan util.MsgSectionSender, notificationChannel chan util.MsgSectionSender,
-	pendingKeys cache.PendingKey) {
-
-	//TODO Check message signatures here once they are implemented
+//Sections of blacklisted zones are dropped. Signed messages are only delivered if their signatures
+//are valid. Unsigned messages of peers whose messages must be signed and messages of senders which
+//exceeded their rate limit are dropped.
+func (s *Server) deliver(msg *message.Message, sender net.Addr) {
+	if s.isRateLimited(msg, sender) {
+		return
+	}
+	if len(msg.Signatures) > 0 {
+		if !s.verifyMessageSignatures(msg, sender) {
+			return
+		}
+	} else if s.requiresMessageSignature(msg, sender) {
+		log.Warn("Drop unsigned message of peer whose messages must be signed", "sender", sender,
+			"token", msg.Token)
+		sendNotificationMsg(msg.Token, sender, section.NTBadMessage, "missing message signature", s)
+		return
+	}
+	s.deliverContent(msg, sender)
+}
 
-	processCapability(msg.Capabilities, sender, msg.Token)
+//deliverContent pushes the sections of msg, whose signatures have already been checked, to the
+//corresponding queue.
+func (s *Server) deliverContent(msg *message.Message, sender net.Addr) {
+	s.processCapability(msg.Capabilities, sender, msg.Token)
 
 	//handle notification separately. Assertions and Queries are processed together respectively.
 	queries := []section.Section{}
@@ -45,174 +48,396 @@ func deliver(msg *message.Message, sender net.Addr, prioChannel chan util.MsgSec
 	for _, m := range msg.Content {
 		switch m := m.(type) {
 		case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
-			if !isZoneBlacklisted(m.(section.WithSig).GetSubjectZone()) {
+			sec := m.(section.WithSig)
+			if !s.blacklist.isZoneBlacklisted(sec.GetSubjectZone(), sec.GetContext()) {
 				sections = append(sections, m)
 			}
 		case *query.Name:
+			if !strings.HasSuffix(m.Name, ".") {
+				log.Warn("Query name is not fully qualified", "name", m.Name, "sender", sender)
+				sendNotificationMsg(msg.Token, sender, section.NTBadMessage,
+					"query name is not fully qualified", s)
+				continue
+			}
 			log.Debug(fmt.Sprintf("add %T to normal queue", m))
 			queries = append(queries, m)
 		case *section.Notification:
 			log.Debug("Add notification to notification queue", "token", msg.Token)
-			notificationChannel <- util.MsgSectionSender{
+			s.queues.push(notifyQueue, util.MsgSectionSender{
 				Sender:   sender,
 				Sections: []section.Section{m},
 				Token:    msg.Token,
-			}
+			})
 		default:
 			log.Warn(fmt.Sprintf("unsupported message section type %T", m))
 			return
 		}
 	}
 	if len(queries) > 0 {
-		normalChannel <- util.MsgSectionSender{Sender: sender, Sections: queries, Token: msg.Token}
+		s.queues.push(normalQueue, util.MsgSectionSender{Sender: sender, Sections: queries,
+			Token: msg.Token})
 	}
 	if len(sections) > 0 {
 		mss := util.MsgSectionSender{Sender: sender, Sections: sections, Token: msg.Token}
-		if pendingKeys.ContainsToken(msg.Token) {
+		if s.caches.PendingKeys.ContainsToken(msg.Token) ||
+			s.caches.PendingMessages.ContainsToken(msg.Token) {
 			log.Debug("add section with signature to priority queue", "token", msg.Token)
-			prioChannel <- mss
+			s.queues.push(prioQueue, mss)
 		} else {
 			log.Debug("add section with signature to normal queue", "token", msg.Token)
-			normalChannel <- mss
+			s.queues.push(normalQueue, mss)
 		}
 	}
 }
 
 //processCapability processes capabilities and sends a notification back to the sender if the hash
-//is not understood.
-func processCapability(caps []message.Capability, sender net.Addr, token token.Token) {
-	log.Debug("Processing Capabilities not yet supported")
-	/*log.Debug("Process capabilities", "capabilities", caps)
-	if len(caps) > 0 {
-		isHash := !strings.HasPrefix(string(caps[0]), "urn:")
-		if isHash {
-			if caps, ok := capabilities.Get([]byte(caps[0])); ok {
-				addCapabilityAndRespond(sender, caps)
-			} else { //capability hash not understood
-				sendNotificationMsg(token, sender, section.NTCapHashNotKnown, capabilityHash)
-			}
+//is not understood. Known capabilities are attached to the sender's connection cache entry.
+func (s *Server) processCapability(caps []message.Capability, sender net.Addr, tok token.Token) {
+	if len(caps) == 0 {
+		return
+	}
+	log.Debug("Process capabilities", "capabilities", caps, "sender", sender)
+	if caps[0].IsHash() {
+		if list, ok := s.caches.Capabilities.Get([]byte(caps[0])); ok {
+			s.caches.ConnCache.AddCapabilityList(sender, list)
 		} else {
-			addCapabilityAndRespond(sender, caps)
+			log.Info("Capability hash not known", "hash", caps[0], "sender", sender)
+			sendNotificationMsg(tok, sender, section.NTCapHashNotKnown, s.capabilityList, s)
+		}
+		return
+	}
+	s.caches.Capabilities.Add(caps)
+	s.caches.ConnCache.AddCapabilityList(sender, message.SortCapabilities(caps))
+}
+
+//queueKind identifies one of the input queues and the pool of workers taking messages from it.
+type queueKind int
+
+const (
+	//prioQueue only contains incoming sections in response to a delegation or infrastructure key
+	//query issued by this server.
+	prioQueue queueKind = iota
+	normalQueue
+	notifyQueue
+	nofQueues
+)
+
+//String returns the name of the queue
+func (k queueKind) String() string {
+	switch k {
+	case prioQueue:
+		return "prio"
+	case normalQueue:
+		return "normal"
+	case notifyQueue:
+		return "notification"
+	}
+	return fmt.Sprintf("queueKind(%d)", int(k))
+}
+
+//InputQueues buffers incoming messages until a worker processes them. Each queue has a pool of
+//workers. The workers of the normal queue take messages from the priority queue before they take
+//messages from the normal queue. The prio queue is necessary to avoid a blocking of the server,
+//e.g. when the normal queue is filled up with sections which are all missing a public key. Without
+//the prio queue, new sections would win against all waiting delegations and although the server is
+//working all the time, no section would be added to the caches.
+//
+//The normal queue keeps a separate queue for each sender. They are served in round robin order and
+//each of them holds at most senderLimit messages such that a single sender cannot delay the
+//messages of other senders. Adding a message to a full queue blocks until there is space.
+type InputQueues struct {
+	mutex sync.Mutex
+	//spaceAvailable is signaled when a message is removed from a queue.
+	spaceAvailable *sync.Cond
+	//msgAvailable contains for each worker pool a condition which is signaled when there is a
+	//message it can take.
+	msgAvailable [nofQueues]*sync.Cond
+	prio         msgQueue
+	notify       msgQueue
+	normal       senderQueues
+	//capacity is the maximum number of messages in each queue.
+	capacity [nofQueues]int
+	//senderLimit is the maximum number of messages of one sender in the normal queue.
+	senderLimit int
+	//busy is the number of workers of each pool processing a message.
+	busy [nofQueues]int
+	//workers is the size of each worker pool.
+	workers [nofQueues]int
+	//started is the number of started workers of each pool which have not stopped yet.
+	started [nofQueues]int
+	//handlers contains for each pool the function which its workers call on the messages.
+	handlers [nofQueues]func(util.MsgSectionSender)
+	closed   bool
+	//running contains the started workers which have not stopped yet.
+	running sync.WaitGroup
+}
+
+//newInputQueues returns input queues with buffer sizes and worker counts according to config.
+func newInputQueues(config Config) *InputQueues {
+	q := &InputQueues{
+		normal: senderQueues{queues: make(map[string][]util.MsgSectionSender)},
+		capacity: [nofQueues]int{config.PrioBufferSize, config.NormalBufferSize,
+			config.NotificationBufferSize},
+		senderLimit: config.NormalSenderBufferSize,
+		workers: [nofQueues]int{config.PrioWorkerCount, config.NormalWorkerCount,
+			config.NotificationWorkerCount},
+	}
+	for i := range q.capacity {
+		if q.capacity[i] < 1 {
+			q.capacity[i] = 1
 		}
-	}*/
+	}
+	if q.senderLimit <= 0 || q.senderLimit > q.capacity[normalQueue] {
+		q.senderLimit = q.capacity[normalQueue]
+	}
+	q.spaceAvailable = sync.NewCond(&q.mutex)
+	for i := range q.msgAvailable {
+		q.msgAvailable[i] = sync.NewCond(&q.mutex)
+	}
+	return q
 }
 
-//addCapabilityAndRespond adds caps to the connection cache entry of sender and sends its own
-//capabilities back if it has not already received capability information on this connection.
-func addCapabilityAndRespond(sender net.Addr, caps []message.Capability) {
-	/*if !connCache.AddCapabilityList(sender, caps) {
-		sendCapability(sender, []message.Capability{message.Capability(capabilityHash)})
-	}*/
+//push adds msg to the queue of kind. It blocks until there is space in the queue. msg is dropped
+//if the queues are closed.
+func (q *InputQueues) push(kind queueKind, msg util.MsgSectionSender) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	sender := senderKey(msg.Sender)
+	for !q.closed && !q.hasSpace(kind, sender) {
+		q.spaceAvailable.Wait()
+	}
+	if q.closed {
+		log.Debug("Drop message as input queues are closed", "queue", kind, "token", msg.Token)
+		return
+	}
+	switch kind {
+	case prioQueue:
+		q.prio.push(msg)
+		//Workers of the normal queue also work on the prio queue.
+		q.msgAvailable[prioQueue].Signal()
+		q.msgAvailable[normalQueue].Signal()
+	case normalQueue:
+		q.normal.push(sender, msg)
+		q.msgAvailable[normalQueue].Signal()
+	case notifyQueue:
+		q.notify.push(msg)
+		q.msgAvailable[notifyQueue].Signal()
+	}
 }
 
-//isZoneBlacklisted returns true if zone is blacklisted
-func isZoneBlacklisted(zone string) bool {
+//hasSpace returns true if a message of sender fits into the queue of kind.
+func (q *InputQueues) hasSpace(kind queueKind, sender string) bool {
+	switch kind {
+	case prioQueue:
+		return q.prio.len() < q.capacity[prioQueue]
+	case normalQueue:
+		return q.normal.len < q.capacity[normalQueue] &&
+			len(q.normal.queues[sender]) < q.senderLimit
+	case notifyQueue:
+		return q.notify.len() < q.capacity[notifyQueue]
+	}
 	return false
 }
 
-//workBoth works on the prioChannel and on the normalChannel. A worker only fetches a message from
-//the normalChannel if the prioChannel is empty. the channel normalWorkers enforces a maximum number
-//of go routines working on the prioChannel and normalChannel.
-func (s *Server) workBoth() {
+//next blocks until there is a message for a worker of pool and returns it. The worker is counted
+//as busy until it calls done. False is returned if the queues are closed and empty or the worker
+//must stop because the pool is too large.
+func (q *InputQueues) next(pool queueKind) (util.MsgSectionSender, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	for {
-		select {
-		case <-s.shutdown:
-			//Avoid closing the s.queues.Normal channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Normal channel
-			time.Sleep(time.Second)
-			close(s.queues.Normal)
-			close(s.queues.NormalW)
-			log.Debug("Closed normal server channels")
-			return
-		default:
+		if q.started[pool] > q.workers[pool] {
+			//The pool has been shrunk.
+			q.started[pool]--
+			return util.MsgSectionSender{}, false
 		}
-		s.queues.NormalW <- struct{}{}
-		select {
-		case msg := <-s.queues.Prio:
-			go prioWorkerHandler(s, msg, false)
-			continue
-		default:
-			//do nothing
+		if msg, ok := q.pop(pool); ok {
+			q.busy[pool]++
+			q.spaceAvailable.Broadcast()
+			return msg, true
 		}
-		select {
-		case msg := <-s.queues.Normal:
-			go normalWorkerHandler(s, msg)
-		default:
-			<-s.queues.NormalW
-			time.Sleep(time.Millisecond)
+		if q.closed {
+			return util.MsgSectionSender{}, false
 		}
+		q.msgAvailable[pool].Wait()
 	}
 }
 
-//normalWorkerHandler handles sections on the normalChannel
-func normalWorkerHandler(s *Server, msg util.MsgSectionSender) {
-	if msg.Sections != nil {
-		s.verify(msg)
+//pop removes the next message for a worker of pool. Messages on the prio queue are always taken
+//first by workers of the normal queue.
+func (q *InputQueues) pop(pool queueKind) (util.MsgSectionSender, bool) {
+	switch pool {
+	case prioQueue:
+		return q.prio.pop()
+	case normalQueue:
+		if msg, ok := q.prio.pop(); ok {
+			return msg, true
+		}
+		return q.normal.pop()
+	case notifyQueue:
+		return q.notify.pop()
 	}
-	<-s.queues.NormalW
+	return util.MsgSectionSender{}, false
 }
 
-//workPrio works on the prioChannel. It waits on the prioChannel and creates a new go routine which handles the section.
-//the channel prioWorkers enforces a maximum number of go routines working on the prioChannel.
-//The prio channel is necessary to avoid a blocking of the server. e.g. in the following unrealistic scenario
-//1) normal queue fills up with non delegation queries which all are missing a public key
-//2) The non-delegation queries get processed by the normalWorkers and added to the pendingSignature cache
-//3) For each non-delegation query that gets taken off the queue a new non-delegation query or expired
-//   delegation query wins against all waiting valid delegation-queries.
-//4) Then although the server is working all the time, no section is added to the caches.
-func (s *Server) workPrio() {
-	for {
-		select {
-		case <-s.shutdown:
-			//Avoid closing the s.queues.Prio channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Prio channel
-			time.Sleep(time.Second)
-			close(s.queues.Prio)
-			close(s.queues.PrioW)
-			return
-		default:
-		}
-		s.queues.PrioW <- struct{}{}
-		msg := <-s.queues.Prio
-		go prioWorkerHandler(s, msg, true)
+//done marks a worker of pool as idle again.
+func (q *InputQueues) done(pool queueKind) {
+	q.mutex.Lock()
+	q.busy[pool]--
+	q.mutex.Unlock()
+}
+
+//work starts the workers of pool. Each of them calls handle on the messages it takes from the
+//queues until the queues are closed and empty.
+func (q *InputQueues) work(pool queueKind, handle func(util.MsgSectionSender)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.handlers[pool] = handle
+	q.startWorkers(pool)
+}
+
+//resize changes the number of workers of pool to size. Superfluous workers stop as soon as they
+//are idle.
+func (q *InputQueues) resize(pool queueKind, size int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.workers[pool] = size
+	if q.handlers[pool] != nil {
+		q.startWorkers(pool)
 	}
+	q.msgAvailable[pool].Broadcast()
 }
 
-//prioWorkerHandler handles sections on the prioChannel
-func prioWorkerHandler(s *Server, msg util.MsgSectionSender, prioWorker bool) {
-	if msg.Sections != nil {
-		s.verify(msg)
+//startWorkers starts workers until pool has its configured size. The caller must hold the lock.
+func (q *InputQueues) startWorkers(pool queueKind) {
+	handle := q.handlers[pool]
+	for ; q.started[pool] < q.workers[pool]; q.started[pool]++ {
+		q.running.Add(1)
+		go func() {
+			defer q.running.Done()
+			for {
+				msg, ok := q.next(pool)
+				if !ok {
+					return
+				}
+				handle(msg)
+				q.done(pool)
+			}
+		}()
 	}
-	if prioWorker {
-		<-s.queues.PrioW
+}
+
+//close stops accepting messages. The workers handle the already queued messages and stop.
+//Blocked calls to push return.
+func (q *InputQueues) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.spaceAvailable.Broadcast()
+	for _, c := range q.msgAvailable {
+		c.Broadcast()
 	}
 }
 
-//workNotification works on the notificationChannel. It waits on the notificationChannel and creates
-//a new go routine which handles the notification. the channel notificationWorkers enforces a
-//maximum number of go routines working on the notificationChannel
-func (s *Server) workNotification() {
-	for {
-		select {
-		case <-s.shutdown:
-			//Avoid closing the s.queues.Notify channel before server.Shutdown() has sent a dummy
-			//message in case this worker is not waiting on the s.queues.Notify channel
-			time.Sleep(time.Second)
-			close(s.queues.Notify)
-			close(s.queues.NotifyW)
-			return
-		default:
-		}
-		s.queues.NotifyW <- struct{}{}
-		msg := <-s.queues.Notify
-		go handleNotification(s, msg)
+//wait blocks until all workers have stopped.
+func (q *InputQueues) wait() {
+	q.running.Wait()
+}
+
+//length returns the number of messages in the queue of kind and its capacity.
+func (q *InputQueues) length(kind queueKind) (int, int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	switch kind {
+	case prioQueue:
+		return q.prio.len(), q.capacity[kind]
+	case normalQueue:
+		return q.normal.len, q.capacity[kind]
+	case notifyQueue:
+		return q.notify.len(), q.capacity[kind]
 	}
+	return 0, 0
 }
 
-//handleNotification works on notificationChannel.
-func handleNotification(s *Server, msg util.MsgSectionSender) {
-	if msg.Sections != nil {
-		s.notify(msg)
+//busyWorkers returns the number of busy workers of pool and the pool's size.
+func (q *InputQueues) busyWorkers(pool queueKind) (int, int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.busy[pool], q.workers[pool]
+}
+
+//senderKey returns the key of sender's queue. Senders are identified by their IP address such that
+//a peer cannot obtain additional queues by opening connections from different ports.
+func senderKey(sender net.Addr) string {
+	if sender == nil {
+		return ""
+	}
+	ip := addrIP(sender)
+	if ip == nil {
+		return sender.Network() + "://" + sender.String()
+	}
+	if addr, ok := sender.(*snet.UDPAddr); ok {
+		return addr.IA.String() + "," + ip.String()
+	}
+	return ip.String()
+}
+
+//msgQueue is a FIFO queue of messages.
+type msgQueue struct {
+	msgs []util.MsgSectionSender
+}
+
+func (q *msgQueue) push(msg util.MsgSectionSender) {
+	q.msgs = append(q.msgs, msg)
+}
+
+func (q *msgQueue) pop() (util.MsgSectionSender, bool) {
+	if len(q.msgs) == 0 {
+		return util.MsgSectionSender{}, false
+	}
+	msg := q.msgs[0]
+	q.msgs[0] = util.MsgSectionSender{}
+	q.msgs = q.msgs[1:]
+	return msg, true
+}
+
+func (q *msgQueue) len() int {
+	return len(q.msgs)
+}
+
+//senderQueues contains a FIFO queue of messages per sender. The queues are served in round robin
+//order.
+type senderQueues struct {
+	queues map[string][]util.MsgSectionSender
+	//order contains the senders with queued messages in the order in which they are served.
+	order []string
+	//len is the total number of queued messages.
+	len int
+}
+
+func (q *senderQueues) push(sender string, msg util.MsgSectionSender) {
+	if len(q.queues[sender]) == 0 {
+		q.order = append(q.order, sender)
+	}
+	q.queues[sender] = append(q.queues[sender], msg)
+	q.len++
+}
+
+func (q *senderQueues) pop() (util.MsgSectionSender, bool) {
+	if len(q.order) == 0 {
+		return util.MsgSectionSender{}, false
+	}
+	sender := q.order[0]
+	q.order = q.order[1:]
+	msgs := q.queues[sender]
+	msg := msgs[0]
+	if len(msgs) > 1 {
+		msgs[0] = util.MsgSectionSender{}
+		q.queues[sender] = msgs[1:]
+		q.order = append(q.order, sender)
+	} else {
+		delete(q.queues, sender)
 	}
-	<-s.queues.NormalW
+	q.len--
+	return msg, true
 }
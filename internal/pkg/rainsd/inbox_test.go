@@ -0,0 +1,397 @@
+package rainsd
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//testMsg returns a message with id sent from the IP address sender. Each message is sent from a
+//different port.
+func testMsg(sender string, id int) util.MsgSectionSender {
+	msg := util.MsgSectionSender{Sender: &net.TCPAddr{IP: net.ParseIP(sender), Port: 1024 + id}}
+	binary.BigEndian.PutUint64(msg.Token[:], uint64(id))
+	return msg
+}
+
+func msgID(msg util.MsgSectionSender) int {
+	return int(binary.BigEndian.Uint64(msg.Token[:]))
+}
+
+//handledOrder starts a single worker of pool after all msgs have been pushed and returns the ids
+//of the messages in the order they were handled.
+func handledOrder(t *testing.T, q *InputQueues, pool queueKind, n int) []int {
+	handled := make(chan int, n)
+	q.work(pool, func(msg util.MsgSectionSender) { handled <- msgID(msg) })
+	ids := []int{}
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-handled:
+			ids = append(ids, id)
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d of %d messages were handled", i, n)
+		}
+	}
+	return ids
+}
+
+func checkOrder(t *testing.T, actual, expected []int) {
+	if len(actual) != len(expected) {
+		t.Fatalf("wrong number of handled messages. expected=%v actual=%v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("wrong order of handled messages. expected=%v actual=%v", expected, actual)
+		}
+	}
+}
+
+func TestInputQueuesPriority(t *testing.T) {
+	q := newInputQueues(Config{PrioBufferSize: 10, NormalBufferSize: 10, NormalWorkerCount: 1})
+	defer q.close()
+	q.push(normalQueue, testMsg("192.0.2.1", 1))
+	q.push(normalQueue, testMsg("192.0.2.1", 2))
+	q.push(prioQueue, testMsg("192.0.2.2", 3))
+	q.push(prioQueue, testMsg("192.0.2.2", 4))
+	checkOrder(t, handledOrder(t, q, normalQueue, 4), []int{3, 4, 1, 2})
+}
+
+func TestInputQueuesFairness(t *testing.T) {
+	q := newInputQueues(Config{NormalBufferSize: 10, NormalSenderBufferSize: 3,
+		NormalWorkerCount: 1})
+	defer q.close()
+	for i := 1; i <= 3; i++ {
+		q.push(normalQueue, testMsg("192.0.2.1", i))
+	}
+	q.push(normalQueue, testMsg("192.0.2.2", 4))
+	q.push(normalQueue, testMsg("192.0.2.3", 5))
+	q.push(normalQueue, testMsg("192.0.2.2", 6))
+
+	//The queue of the first sender is full.
+	pushed := make(chan bool)
+	go func() {
+		q.push(normalQueue, testMsg("192.0.2.1", 7))
+		pushed <- true
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("Message was added to a full sender queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if l, _ := q.length(normalQueue); l != 6 {
+		t.Errorf("wrong queue length. expected=6 actual=%d", l)
+	}
+	checkOrder(t, handledOrder(t, q, normalQueue, 7), []int{1, 4, 5, 2, 6, 3, 7})
+	<-pushed
+}
+
+func TestInputQueuesWorkers(t *testing.T) {
+	q := newInputQueues(Config{PrioBufferSize: 10, NormalBufferSize: 10,
+		NotificationBufferSize: 10, PrioWorkerCount: 1, NormalWorkerCount: 2,
+		NotificationWorkerCount: 1})
+	release := make(chan bool)
+	var started sync.WaitGroup
+	handle := func(util.MsgSectionSender) {
+		started.Done()
+		<-release
+	}
+	for _, pool := range []queueKind{prioQueue, normalQueue, notifyQueue} {
+		q.work(pool, handle)
+	}
+	started.Add(3)
+	q.push(normalQueue, testMsg("192.0.2.1", 1))
+	q.push(normalQueue, testMsg("192.0.2.1", 2))
+	q.push(notifyQueue, testMsg("192.0.2.1", 3))
+	started.Wait()
+	for pool, want := range map[queueKind]int{prioQueue: 0, normalQueue: 2, notifyQueue: 1} {
+		if busy, _ := q.busyWorkers(pool); busy != want {
+			t.Errorf("wrong number of busy %v workers. expected=%d actual=%d", pool, want, busy)
+		}
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	for pool := prioQueue; pool < nofQueues; pool++ {
+		if busy, _ := q.busyWorkers(pool); busy != 0 {
+			t.Errorf("%v workers are still busy. actual=%d", pool, busy)
+		}
+	}
+
+	//Closing the queues stops the workers and unblocks waiting pushes.
+	q.close()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			q.push(prioQueue, testMsg("192.0.2.1", i))
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on closed input queues")
+	}
+	if _, ok := q.next(normalQueue); ok {
+		t.Error("closed input queues returned a message")
+	}
+}
+
+func TestInputQueuesClose(t *testing.T) {
+	q := newInputQueues(Config{NormalBufferSize: 10, NormalWorkerCount: 1})
+	for i := 1; i <= 3; i++ {
+		q.push(normalQueue, testMsg("192.0.2.1", i))
+	}
+	//Messages which are queued before the queues are closed are still handled.
+	q.close()
+	q.push(normalQueue, testMsg("192.0.2.1", 4))
+	checkOrder(t, handledOrder(t, q, normalQueue, 3), []int{1, 2, 3})
+	stopped := make(chan bool)
+	go func() {
+		q.wait()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("workers did not stop on closed and empty input queues")
+	}
+}
+
+func TestInputQueuesResize(t *testing.T) {
+	q := newInputQueues(Config{NormalBufferSize: 10, NormalWorkerCount: 1})
+	defer q.close()
+	release := make(chan bool)
+	var started sync.WaitGroup
+	q.work(normalQueue, func(util.MsgSectionSender) {
+		started.Done()
+		<-release
+	})
+	started.Add(3)
+	for i := 1; i <= 3; i++ {
+		q.push(normalQueue, testMsg("192.0.2.1", i))
+	}
+	q.resize(normalQueue, 3)
+	started.Wait()
+	if busy, workers := q.busyWorkers(normalQueue); busy != 3 || workers != 3 {
+		t.Errorf("wrong number of workers. expected=(3,3) actual=(%d,%d)", busy, workers)
+	}
+	q.resize(normalQueue, 1)
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.started[normalQueue] != 1 {
+		t.Errorf("superfluous workers did not stop. expected=1 actual=%d", q.started[normalQueue])
+	}
+}
+
+//legacyInputQueues is the channel based implementation of the input queues which the benchmarks
+//compare against. A worker polls the prio and the normal channel and sleeps if both are empty.
+type legacyInputQueues struct {
+	Prio    chan util.MsgSectionSender
+	Normal  chan util.MsgSectionSender
+	PrioW   chan struct{}
+	NormalW chan struct{}
+	stop    chan bool
+}
+
+func newLegacyInputQueues(config Config) *legacyInputQueues {
+	return &legacyInputQueues{
+		Prio:    make(chan util.MsgSectionSender, config.PrioBufferSize),
+		Normal:  make(chan util.MsgSectionSender, config.NormalBufferSize),
+		PrioW:   make(chan struct{}, config.PrioWorkerCount),
+		NormalW: make(chan struct{}, config.NormalWorkerCount),
+		stop:    make(chan bool),
+	}
+}
+
+func (q *legacyInputQueues) push(kind queueKind, msg util.MsgSectionSender) {
+	if kind == prioQueue {
+		q.Prio <- msg
+	} else {
+		q.Normal <- msg
+	}
+}
+
+func (q *legacyInputQueues) work(handle func(util.MsgSectionSender)) {
+	go func() {
+		for {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+			q.NormalW <- struct{}{}
+			select {
+			case msg := <-q.Prio:
+				go func() { handle(msg); <-q.NormalW }()
+				continue
+			default:
+			}
+			select {
+			case msg := <-q.Normal:
+				go func() { handle(msg); <-q.NormalW }()
+			default:
+				<-q.NormalW
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	go func() {
+		for {
+			q.PrioW <- struct{}{}
+			select {
+			case msg := <-q.Prio:
+				go func() { handle(msg); <-q.PrioW }()
+			case <-q.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (q *legacyInputQueues) close() {
+	close(q.stop)
+}
+
+//benchmarkInputQueues sends b.N messages from 8 concurrent senders of which every tenth is put on
+//the prio queue. The handler simulates work of 10 microseconds. It reports the average time between
+//adding a message to a queue and the start of its handling.
+func benchmarkInputQueues(b *testing.B, push func(queueKind, util.MsgSectionSender),
+	work func(func(util.MsgSectionSender)), close func()) {
+	const senders = 8
+	enqueued := make([]time.Time, b.N)
+	var latency time.Duration
+	var mutex sync.Mutex
+	var handled sync.WaitGroup
+	handled.Add(b.N)
+	work(func(msg util.MsgSectionSender) {
+		id := msgID(msg)
+		mutex.Lock()
+		latency += time.Since(enqueued[id])
+		mutex.Unlock()
+		time.Sleep(10 * time.Microsecond)
+		handled.Done()
+	})
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			sender := net.IPv4(192, 0, 2, byte(s)).String()
+			for id := s; id < b.N; id += senders {
+				msg := testMsg(sender, id)
+				kind := normalQueue
+				if id%10 == 0 {
+					kind = prioQueue
+				}
+				mutex.Lock()
+				enqueued[id] = time.Now()
+				mutex.Unlock()
+				push(kind, msg)
+			}
+		}(s)
+	}
+	wg.Wait()
+	handled.Wait()
+	b.StopTimer()
+	close()
+	b.ReportMetric(float64(latency.Nanoseconds())/float64(b.N), "latency-ns/msg")
+}
+
+var benchConfig = Config{PrioBufferSize: 50, NormalBufferSize: 1000, NormalSenderBufferSize: 100,
+	PrioWorkerCount: 2, NormalWorkerCount: 10}
+
+func BenchmarkInputQueues(b *testing.B) {
+	q := newInputQueues(benchConfig)
+	benchmarkInputQueues(b, q.push, func(handle func(util.MsgSectionSender)) {
+		q.work(prioQueue, handle)
+		q.work(normalQueue, handle)
+	}, q.close)
+}
+
+func BenchmarkLegacyInputQueues(b *testing.B) {
+	q := newLegacyInputQueues(benchConfig)
+	benchmarkInputQueues(b, q.push, q.work, q.close)
+}
+
+func TestSignedMessagePeers(t *testing.T) {
+	config := memoryConfig(t, "TestSignedMessagePeers", DefaultConfig().Capabilities)
+	config.SignedMessagePeers = []string{"127.0.0.1"}
+	s, err := New(config, "TestSignedMessagePeers")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	var tests = []struct {
+		name   string
+		sender net.Addr
+		queued bool
+	}{
+		{"configured peer", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}, false},
+		{"other peer", &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 1}, true},
+		{"no IP address", connection.MemoryAddr("TestSignedMessagePeersClient"), true},
+	}
+	for i, test := range tests {
+		//The server does not run such that queued messages are not handled.
+		before, _ := s.queues.length(normalQueue)
+		s.deliver(&message.Message{Token: token.New(),
+			Content: []section.Section{ip4Query("www.")}}, test.sender)
+		after, _ := s.queues.length(normalQueue)
+		if (after > before) != test.queued {
+			t.Errorf("%d: %s: unsigned message queued=%v expected=%v", i, test.name,
+				after > before, test.queued)
+		}
+	}
+
+	config.SignedMessagePeers = []string{"127.0.0.1/33"}
+	if _, err := New(config, "TestSignedMessagePeersMalformed"); err == nil {
+		t.Error("malformed signed message peer was accepted")
+	}
+}
+
+func TestMissingInfraKey(t *testing.T) {
+	config := memoryConfig(t, "TestMissingInfraKey", DefaultConfig().Capabilities)
+	config.PendingMessageSenderLimit = 3
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	client := connection.MemoryAddr("TestMissingInfraKeyClient")
+	msgs := memoryPeer(t, client)
+	for i := 0; i < 5; i++ {
+		s.deliver(&message.Message{Token: token.New(), Signer: "ns.example.",
+			Signatures: []signature.Sig{{}}, Content: []section.Section{ip4Query("www.")}}, client)
+	}
+	//All messages wait for the same infrastructure key query.
+	queries := 0
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case msg := <-msgs:
+			for _, sec := range msg.Content {
+				if q, ok := sec.(*query.Name); ok && q.Name == "ns.example." {
+					queries++
+				}
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	if queries != 1 {
+		t.Errorf("wrong number of infrastructure key queries. expected=1 actual=%d", queries)
+	}
+	if l := s.caches.PendingMessages.Len(); l != 3 {
+		t.Errorf("wrong number of pending messages. expected=3 actual=%d", l)
+	}
+}
@@ -0,0 +1,205 @@
+package rainsd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//defaultPendingMessageSenderLimit is the maximum number of messages of one sender waiting for an
+//infrastructure key if none is configured.
+const defaultPendingMessageSenderLimit = 10
+
+//loadInfraKey returns the private infrastructure key stored in pem format at keyPath. It returns
+//nil if keyPath is empty.
+func loadInfraKey(keyPath, keyName string) (map[keys.PublicKeyID]interface{}, error) {
+	if keyPath == "" {
+		return nil, nil
+	}
+	if keyName == "" {
+		return nil, errors.New("infrastructure key is configured without the name it is published under")
+	}
+	keyPem, err := keyManager.DecryptKey(filepath.Dir(keyPath), filepath.Base(keyPath), "")
+	if err != nil {
+		return nil, fmt.Errorf("was not able to decrypt infrastructure key: %v", err)
+	}
+	keyID, pkey, err := keyManager.PemToKeyID(keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("was not able to decode infrastructure key: %v", err)
+	}
+	log.Info("Loaded infrastructure key", "path", keyPath, "name", keyName, "keyID", keyID)
+	return map[keys.PublicKeyID]interface{}{keyID: pkey}, nil
+}
+
+//parseSignedMessagePeers returns the IP address ranges of peers. Each peer is either an IP range in
+//CIDR notation or a single IP address.
+func parseSignedMessagePeers(peers []string) ([]*net.IPNet, error) {
+	ipNets := []*net.IPNet{}
+	for _, peer := range peers {
+		ipNet, err := parseIPNet(peer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signed message peer: %v", err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+//requiresMessageSignature returns true if sender is one of the configured peers whose messages
+//must be signed. The answer to an infrastructure key query is exempt as its sections are
+//authenticated by their own signatures.
+func (s *Server) requiresMessageSignature(msg *message.Message, sender net.Addr) bool {
+	ip := addrIP(sender)
+	if ip == nil || s.caches.PendingMessages.ContainsToken(msg.Token) {
+		return false
+	}
+	for _, ipNet := range s.signedMessagePeers {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//signMessage signs msg with this server's infrastructure key. msg is not changed if no
+//infrastructure key is configured.
+func (s *Server) signMessage(msg *message.Message) error {
+	if s.infraKey == nil {
+		return nil
+	}
+	msg.Signer = s.config().InfraKeyName
+	msg.Signatures = nil
+	for keyID := range s.infraKey {
+		msg.Signatures = append(msg.Signatures, signature.Sig{
+			PublicKeyID: keyID,
+			ValidSince:  time.Now().Unix(),
+			ValidUntil:  time.Now().Add(s.config().MessageSignatureValidity).Unix(),
+		})
+	}
+	return siglib.SignMessageUnsafe(msg, s.infraKey)
+}
+
+//verifyMessageSignatures returns true if all signatures on msg are valid. If the infrastructure
+//key of msg's signer is not cached, msg is added to the pending message cache, a query for the key
+//is sent and false is returned.
+func (s *Server) verifyMessageSignatures(msg *message.Message, sender net.Addr) bool {
+	if s.caches.PendingMessages.ContainsToken(msg.Token) {
+		//The answer to an infrastructure key query cannot be verified with the key it contains.
+		//Its sections are authenticated by their own signatures.
+		log.Debug("Skip message signature check of infrastructure key answer", "token", msg.Token)
+		return true
+	}
+	if msg.Signer == "" {
+		log.Warn("Signed message does not state its signer", "sender", sender, "token", msg.Token)
+		sendNotificationMsg(msg.Token, sender, section.NTBadMessage, "missing signer", s)
+		return false
+	}
+	pkeys, ok := infraKeys(msg.Signer, s.caches.AssertionsCache)
+	if !ok {
+		handleMissingInfraKey(msg, sender, s)
+		return false
+	}
+	return checkMessageSignatures(msg, sender, pkeys, s)
+}
+
+//checkMessageSignatures returns true if all signatures on msg are valid. Otherwise a notification
+//is sent back to sender.
+func checkMessageSignatures(msg *message.Message, sender net.Addr,
+	pkeys map[keys.PublicKeyID][]keys.PublicKey, s *Server) bool {
+	valid := siglib.CheckMessageSignatures(msg, pkeys)
+	s.metrics.sigVerification("message", valid)
+	if !valid {
+		log.Warn("Invalid message signature", "signer", msg.Signer, "sender", sender,
+			"token", msg.Token)
+		sendNotificationMsg(msg.Token, sender, section.NTBadMessage, "invalid message signature", s)
+		return false
+	}
+	log.Debug("Message signatures are valid", "signer", msg.Signer, "token", msg.Token)
+	return true
+}
+
+//infraKeys returns all cached infrastructure keys of name together with the validity of the
+//assertion containing them. It returns false if there is no such key.
+func infraKeys(name string, assertionsCache cache.Assertion) (
+	map[keys.PublicKeyID][]keys.PublicKey, bool) {
+	assertions, ok := assertionsCache.Get(name, ".", object.OTInfraKey, true)
+	if !ok {
+		return nil, false
+	}
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for _, a := range assertions {
+		for _, o := range a.Content {
+			if o.Type != object.OTInfraKey {
+				continue
+			}
+			if pkey, ok := o.Value.(keys.PublicKey); ok {
+				pkey.ValidSince = a.ValidSince()
+				pkey.ValidUntil = a.ValidUntil()
+				pkeys[pkey.PublicKeyID] = append(pkeys[pkey.PublicKeyID], pkey)
+			}
+		}
+	}
+	return pkeys, len(pkeys) > 0
+}
+
+//handleMissingInfraKey adds msg to the pending message cache and sends a query for the
+//infrastructure key of msg's signer unless such a query is already pending.
+func handleMissingInfraKey(msg *message.Message, sender net.Addr, s *Server) {
+	log.Info("Infrastructure key is missing. Add message to pending message cache",
+		"signer", msg.Signer, "sender", sender)
+	exp := time.Now().Add(s.config().DelegationQueryValidity).Unix()
+	t := token.New()
+	if !s.caches.PendingMessages.Add(msg, sender, t, exp) {
+		log.Debug("Infrastructure key query is already pending or message was dropped",
+			"signer", msg.Signer)
+		return
+	}
+	q := message.Message{Token: t, Content: []section.Section{&query.Name{
+		Name:       msg.Signer,
+		Context:    ".",
+		Expiration: exp,
+		Types:      []object.Type{object.OTInfraKey},
+	}}}
+	if s.resolver != nil {
+		log.Info("Send infrastructure key query to recursive resolver", "msg", q)
+		s.sendToRecursiveResolver(q)
+	} else {
+		s.sendTo(q, sender, 0, 0)
+	}
+}
+
+//pendingMessagesCallback delivers the messages waiting for the infrastructure key contained in
+//ss whose signatures are valid.
+func pendingMessagesCallback(ss util.SectionWithSigSender, s *Server) {
+	entries, ok := s.caches.PendingMessages.GetAndRemove(ss.Token)
+	if !ok {
+		return
+	}
+	signer := entries[0].Msg.Signer
+	pkeys, ok := infraKeys(signer, s.caches.AssertionsCache)
+	if !ok {
+		log.Warn("Answer does not contain the requested infrastructure key", "signer", signer,
+			"sender", ss.Sender)
+		return
+	}
+	for _, e := range entries {
+		if checkMessageSignatures(e.Msg, e.Sender, pkeys, s) {
+			s.deliverContent(e.Msg, e.Sender)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package rainsd
+
+import (
+	"net"
+	"net/http"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "rainsd"
+	metricsPath      = "/metrics"
+)
+
+//metrics contains the counters of a server. Gauges such as queue lengths and cache sizes are
+//determined when the metrics are scraped.
+type metrics struct {
+	registry *prometheus.Registry
+	//cacheLookups counts lookups per cache and result (hit or miss).
+	cacheLookups *prometheus.CounterVec
+	//sigVerifications counts signature verifications per kind (section or message) and result
+	//(success or failure).
+	sigVerifications *prometheus.CounterVec
+	//notificationsRcvd counts received notifications per type.
+	notificationsRcvd *prometheus.CounterVec
+	//notificationsSent counts sent notifications per type.
+	notificationsSent *prometheus.CounterVec
+	//staleAnswers counts answers containing stale assertions.
+	staleAnswers prometheus.Counter
+}
+
+//newMetrics creates the metrics of s and registers them with a new registry.
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Number of cache lookups by cache and result.",
+		}, []string{"cache", "result"}),
+		sigVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "signature_verifications_total",
+			Help:      "Number of signature verifications by kind and result.",
+		}, []string{"kind", "result"}),
+		notificationsRcvd: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "notifications_received_total",
+			Help:      "Number of received notifications by type.",
+		}, []string{"type"}),
+		notificationsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "notifications_sent_total",
+			Help:      "Number of sent notifications by type.",
+		}, []string{"type"}),
+		staleAnswers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "stale_answers_total",
+			Help:      "Number of answers with stale assertions sent because the upstream failed.",
+		}),
+	}
+	m.registry.MustRegister(m.cacheLookups, m.sigVerifications, m.notificationsRcvd,
+		m.notificationsSent, m.staleAnswers)
+
+	for kind := queueKind(0); kind < nofQueues; kind++ {
+		kind := kind
+		labels := prometheus.Labels{"queue": kind.String()}
+		m.registry.MustRegister(
+			gaugeFunc("queue_length", "Number of messages waiting in the queue.", labels,
+				func() int { l, _ := s.queues.length(kind); return l }),
+			gaugeFunc("queue_capacity", "Maximum number of messages in the queue.", labels,
+				func() int { _, c := s.queues.length(kind); return c }),
+			gaugeFunc("workers_busy", "Number of workers processing messages of the queue.",
+				labels, func() int { b, _ := s.queues.busyWorkers(kind); return b }),
+			gaugeFunc("workers", "Maximum number of workers of the queue.", labels,
+				func() int { _, w := s.queues.busyWorkers(kind); return w }),
+		)
+	}
+
+	m.registry.MustRegister(
+		gaugeFunc("rate_limiter_senders", "Number of senders tracked by the rate limiter.", nil,
+			func() int { n, _, _ := s.rateLimiter.state(); return n }),
+		gaugeFunc("rate_limited_senders", "Number of senders which currently exceed their rate limit.",
+			nil, func() int { _, n, _ := s.rateLimiter.state(); return n }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_messages_total",
+			Help:      "Number of messages dropped because their sender exceeded its rate limit.",
+		}, func() float64 { _, _, n := s.rateLimiter.state(); return float64(n) }),
+		gaugeFunc("send_queue_length", "Number of messages waiting to be sent.", nil,
+			func() int { n, _ := s.sendQueues.state(); return n }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "send_queue_dropped_total",
+			Help:      "Number of messages dropped because the send queue of their receiver was full " +
+				"or there were too many send queues.",
+		}, func() float64 { _, n := s.sendQueues.state(); return float64(n) }),
+	)
+
+	caches := map[string]func() int{
+		"connection":        func() int { return s.caches.ConnCache.Len() },
+		"capability":        func() int { return s.caches.Capabilities.Len() },
+		"zoneKey":           func() int { return s.caches.ZoneKeyCache.Len() },
+		"pendingKey":        func() int { return s.caches.PendingKeys.Len() },
+		"pendingMessage":    func() int { return s.caches.PendingMessages.Len() },
+		"pendingQuery":      func() int { return s.caches.PendingQueries.Len() },
+		"assertion":         func() int { return s.caches.AssertionsCache.Len() },
+		"staleAssertion":    func() int { return s.caches.AssertionsCache.StaleLen() },
+		"negativeAssertion": func() int { return s.caches.NegAssertionCache.Len() },
+	}
+	for name, length := range caches {
+		m.registry.MustRegister(gaugeFunc("cache_entries", "Number of entries in the cache.",
+			prometheus.Labels{"cache": name}, length))
+	}
+	return m
+}
+
+//gaugeFunc returns a gauge in the rainsd namespace whose value is determined by value.
+func gaugeFunc(name, help string, labels prometheus.Labels, value func() int) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, func() float64 { return float64(value()) })
+}
+
+//cacheLookup counts a lookup in cache.
+func (m *metrics) cacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+//sigVerification counts a signature verification of kind.
+func (m *metrics) sigVerification(kind string, valid bool) {
+	result := "failure"
+	if valid {
+		result = "success"
+	}
+	m.sigVerifications.WithLabelValues(kind, result).Inc()
+}
+
+//notificationReceived counts a received notification of type t.
+func (m *metrics) notificationReceived(t section.NotificationType) {
+	m.notificationsRcvd.WithLabelValues(t.String()).Inc()
+}
+
+//notificationSent counts a sent notification of type t.
+func (m *metrics) notificationSent(t section.NotificationType) {
+	m.notificationsSent.WithLabelValues(t.String()).Inc()
+}
+
+//serveMetrics serves the server's metrics over http at the configured metrics address until the
+//server is shut down.
+func (s *Server) serveMetrics() error {
+	if s.config().MetricsAddress == "" {
+		log.Warn("Metrics are not served as no metrics address is configured")
+		return nil
+	}
+	l, err := net.Listen("tcp", s.config().MetricsAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	s.metricsServer = &http.Server{Handler: mux}
+	log.Info("Serving metrics", "addr", l.Addr(), "path", metricsPath)
+	go func(server *http.Server) {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Error("Metrics listener failed", "addr", l.Addr(), "error", err)
+		}
+	}(s.metricsServer)
+	return nil
+}
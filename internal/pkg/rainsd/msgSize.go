@@ -0,0 +1,139 @@
+package rainsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//errMsgTooLarge is returned by msgSizeLimiter when a message exceeds the maximum message size.
+var errMsgTooLarge = errors.New("message exceeds maximum message size")
+
+//maxMsgDepth is the maximal nesting depth of the data items of a message.
+const maxMsgDepth = 64
+
+//msgSizeLimiter reads one message at a time into memory. It fails with errMsgTooLarge as soon as
+//the current message is known to be larger than limit bytes. The declared length of each data item
+//is checked before the item is read such that a forged length cannot make the server allocate more
+//than limit bytes. It keeps the bytes of the current message such that the message can be skipped
+//afterwards.
+type msgSizeLimiter struct {
+	in       io.Reader
+	limit    int
+	read     bytes.Buffer
+	tooLarge bool
+}
+
+func newMsgSizeLimiter(in io.Reader, limit int) *msgSizeLimiter {
+	return &msgSizeLimiter{in: in, limit: limit}
+}
+
+//Read implements io.Reader
+func (l *msgSizeLimiter) Read(p []byte) (int, error) {
+	if l.limit > 0 {
+		remaining := l.limit - l.read.Len()
+		if remaining <= 0 {
+			l.tooLarge = true
+			return 0, errMsgTooLarge
+		}
+		if len(p) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := l.in.Read(p)
+	l.read.Write(p[:n])
+	return n, err
+}
+
+//readMsg reads the next message and returns its encoding. The returned slice is only valid until
+//the next message is read.
+func (l *msgSizeLimiter) readMsg() ([]byte, error) {
+	l.reset()
+	if err := l.readItem(0); err != nil {
+		return nil, err
+	}
+	return l.read.Bytes(), nil
+}
+
+//readItem reads one CBOR data item after checking that its declared length fits into the message.
+func (l *msgSizeLimiter) readItem(depth int) error {
+	if depth > maxMsgDepth {
+		return errors.New("cbor data items are nested too deeply")
+	}
+	major, arg, err := cbor.ReadHead(l)
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cbor.MajorBytes, cbor.MajorText:
+		if err := l.reserve(arg); err != nil {
+			return err
+		}
+		_, err := io.CopyN(ioutil.Discard, l, int64(arg))
+		return err
+	case cbor.MajorArray, cbor.MajorMap:
+		//Each element is encoded in at least one byte.
+		if err := l.reserve(arg); err != nil {
+			return err
+		}
+		if major == cbor.MajorMap {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if err := l.readItem(depth + 1); err != nil {
+				return err
+			}
+		}
+	case cbor.MajorTag:
+		return l.readItem(depth + 1)
+	}
+	return nil
+}
+
+//reserve returns errMsgTooLarge if n more bytes do not fit into the current message.
+func (l *msgSizeLimiter) reserve(n uint64) error {
+	if n > 1<<62 {
+		return fmt.Errorf("cbor length is too large: %d", n)
+	}
+	if l.limit > 0 && n > uint64(l.limit-l.read.Len()) {
+		l.tooLarge = true
+		return errMsgTooLarge
+	}
+	return nil
+}
+
+//exceeded returns true if the current message is larger than the limit.
+func (l *msgSizeLimiter) exceeded() bool {
+	return l.tooLarge
+}
+
+//reset starts a new message.
+func (l *msgSizeLimiter) reset() {
+	l.read.Reset()
+	l.tooLarge = false
+}
+
+//skipMsg reads the rest of the current message and returns its token.
+func (l *msgSizeLimiter) skipMsg() (token.Token, error) {
+	tok, err := message.ReadToken(io.MultiReader(bytes.NewReader(l.read.Bytes()), l.in))
+	l.reset()
+	return tok, err
+}
+
+//handleMsgTooLarge informs sender that the message with token tok exceeded the maximum message size
+//of this server. The notification's data contains the maximum message size in bytes.
+func handleMsgTooLarge(tok token.Token, sender net.Addr, s *Server) {
+	log.Warn("Received message is too large", "sender", sender, "token", tok,
+		"maxMsgSize", s.config().MaxMsgSize)
+	sendNotificationMsg(tok, sender, section.NTMsgTooLarge, strconv.Itoa(s.config().MaxMsgSize), s)
+}
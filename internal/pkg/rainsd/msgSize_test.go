@@ -0,0 +1,94 @@
+package rainsd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//cborHead returns the head of a CBOR data item of type major with an 8 byte argument.
+func cborHead(major byte, arg uint64) []byte {
+	head := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(head[1:], arg)
+	return head
+}
+
+func TestMsgSizeLimiter(t *testing.T) {
+	msg := message.Message{Token: token.New(), Content: []section.Section{
+		&section.Notification{Token: token.New(), Type: section.NTHeartbeat}}}
+	encoded := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoded).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to encode message: %v", err)
+	}
+	//The messages declare a length of 1TB but only contain a few bytes.
+	forgedString := append(cborHead(cbor.MajorMap, 1), 0)
+	forgedString = append(forgedString, cborHead(cbor.MajorBytes, 1<<40)...)
+	forgedString = append(forgedString, "abc"...)
+	forgedArray := append(cborHead(cbor.MajorTag, 0xE99BA8), cborHead(cbor.MajorArray, 1<<40)...)
+	var tests = []struct {
+		name     string
+		input    []byte
+		limit    int
+		tooLarge bool
+		valid    bool
+	}{
+		{"valid", encoded.Bytes(), 1000, false, true},
+		{"valid without limit", encoded.Bytes(), 0, false, true},
+		{"too large", encoded.Bytes(), encoded.Len() - 1, true, false},
+		{"forged string length", forgedString, 1000, true, false},
+		{"forged array length", forgedArray, 1000, true, false},
+		{"forged string length without limit", forgedString, 0, false, false},
+		{"forged string length in datagram", forgedString, len(forgedString), true, false},
+	}
+	for i, test := range tests {
+		l := newMsgSizeLimiter(bytes.NewReader(test.input), test.limit)
+		read, err := l.readMsg()
+		if l.exceeded() != test.tooLarge {
+			t.Errorf("%d: %s: wrong too large result. expected=%v actual=%v err=%v", i,
+				test.name, test.tooLarge, l.exceeded(), err)
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("%d: %s: unexpected error: %v", i, test.name, err)
+			continue
+		}
+		if test.valid {
+			var decoded message.Message
+			if err := cbor.NewReader(bytes.NewReader(read)).Unmarshal(&decoded); err != nil ||
+				decoded.Token != msg.Token {
+				t.Errorf("%d: %s: wrong message read: %v", i, test.name, err)
+			}
+		}
+		if len(read) > len(test.input) {
+			t.Errorf("%d: %s: read more than the input", i, test.name)
+		}
+	}
+}
+
+func TestForgedLength(t *testing.T) {
+	s := newMemoryServer(t, "TestForgedLength", DefaultConfig().Capabilities)
+	conn, err := connection.MemoryTransport{}.Dial(s.Addr())
+	if err != nil {
+		t.Fatalf("Was not able to connect to server: %v", err)
+	}
+	defer conn.Close()
+	//A message of a few bytes declares an array of 2^40 elements.
+	forged := append(cborHead(cbor.MajorTag, 0xE99BA8), cborHead(cbor.MajorArray, 1<<40)...)
+	if _, err := conn.Write(forged); err != nil {
+		t.Fatalf("Was not able to send forged message: %v", err)
+	}
+	//The server is still running.
+	msg := message.Message{Token: token.New(),
+		Content: []section.Section{ip4Query("www.", query.QOCachedAnswersOnly)}}
+	if _, err := util.SendQuery(msg, s.Addr(), time.Second); err != nil {
+		t.Fatalf("No answer after forged message: %v", err)
+	}
+}
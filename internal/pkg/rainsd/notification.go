@@ -15,31 +15,27 @@ import (
 func (s *Server) notify(msgSender util.MsgSectionSender) {
 	notifLog := log.New("notificationMsgSection", msgSender.Sections[0])
 	sec := msgSender.Sections[0].(*section.Notification)
+	s.metrics.notificationReceived(sec.Type)
 	switch sec.Type {
 	case section.NTHeartbeat:
 	case section.NTCapHashNotKnown:
-		if len(sec.Data) == 0 {
-			caps, _ := s.caches.ConnCache.GetCapabilityList(s.config.ServerAddress.Addr)
-			sendCapability(msgSender.Sender, caps, s)
-		} else {
-			if capabilityIsHash(sec.Data) {
+		//The other server does not know our capability hash. The notification's data might
+		//contain its capabilities.
+		if len(sec.Data) > 0 {
+			if message.Capability(sec.Data).IsHash() {
 				if caps, ok := s.caches.Capabilities.Get([]byte(sec.Data)); ok {
 					s.caches.ConnCache.AddCapabilityList(msgSender.Sender, caps)
-					ownCaps, _ := s.caches.ConnCache.GetCapabilityList(s.config.ServerAddress.Addr)
-					sendCapability(msgSender.Sender, ownCaps, s)
-				} else {
-					sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTCapHashNotKnown, "", s)
 				}
 			} else {
 				cList := []message.Capability{}
 				for _, c := range strings.Split(sec.Data, " ") {
 					cList = append(cList, message.Capability(c))
 				}
-				s.caches.ConnCache.AddCapabilityList(msgSender.Sender, cList)
-				ownCaps, _ := s.caches.ConnCache.GetCapabilityList(s.config.ServerAddress.Addr)
-				sendCapability(msgSender.Sender, ownCaps, s)
+				s.caches.Capabilities.Add(cList)
+				s.caches.ConnCache.AddCapabilityList(msgSender.Sender, message.SortCapabilities(cList))
 			}
 		}
+		sendCapability(msgSender.Sender, s.config().Capabilities, s)
 	case section.NTBadMessage:
 		notifLog.Error("Sent msg was malformed")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, true, s)
@@ -47,11 +43,13 @@ func (s *Server) notify(msgSender util.MsgSectionSender) {
 		notifLog.Error("Sent msg was inconsistent")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, true, s)
 	case section.NTMsgTooLarge:
-		notifLog.Error("Sent msg was too large")
-		//TODO CFE resend message in smaller chunks
+		//The section waiting for the answer cannot be handled by the other server.
+		notifLog.Error("Sent msg was too large", "maxMsgSize", sec.Data)
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTNoAssertionsExist:
-		notifLog.Info("Bad request, only clients receive this notification type")
-		sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTBadMessage, "", s)
+		//An authoritative server proved that the queried names do not exist.
+		notifLog.Info("Queried assertions do not exist")
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTUnspecServerErr:
 		notifLog.Error("Unspecified error of other server")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
@@ -67,20 +65,28 @@ func (s *Server) notify(msgSender util.MsgSectionSender) {
 	}
 }
 
-//capabilityIsHash returns true if capabilities are represented as a hash.
-func capabilityIsHash(capabilities string) bool {
-	return !strings.HasPrefix(capabilities, "urn:")
-}
-
 //dropPendingSectionsAndQueries removes all entries from the pending caches matching token and
 //forwards the received notification or unspecServerErr depending on serverError flag
 func dropPendingSectionsAndQueries(token token.Token, notification *section.Notification,
 	serverError bool, s *Server) {
-	if ss, ok := s.caches.PendingKeys.GetAndRemove(token); ok {
+	//Sections whose delegation queries are handed to the recursive resolver keep waiting.
+	if !s.keyRequestRefused(token) {
+		if ss, ok := s.caches.PendingKeys.GetAndRemove(token); ok {
+			if serverError {
+				sendNotificationMsg(ss.Token, ss.Sender, section.NTUnspecServerErr, "", s)
+			} else {
+				sendNotificationMsg(ss.Token, ss.Sender, notification.Type, notification.Data, s)
+			}
+		}
+	}
+	entries, _ := s.caches.PendingMessages.GetAndRemove(token)
+	for _, e := range entries {
+		log.Warn("Drop message whose signer's infrastructure key is not available",
+			"signer", e.Msg.Signer, "sender", e.Sender)
 		if serverError {
-			sendNotificationMsg(ss.Token, ss.Sender, section.NTUnspecServerErr, "", s)
+			sendNotificationMsg(e.Msg.Token, e.Sender, section.NTUnspecServerErr, "", s)
 		} else {
-			sendNotificationMsg(ss.Token, ss.Sender, notification.Type, notification.Data, s)
+			sendNotificationMsg(e.Msg.Token, e.Sender, notification.Type, notification.Data, s)
 		}
 	}
 	sectionSenders := s.caches.PendingQueries.GetAndRemove(token)
@@ -0,0 +1,90 @@
+package rainsd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//pendingTimer calls expire as soon as the earliest entry of a pending cache has expired such that
+//the waiting senders are notified when their query expires and not only when the cache is reaped.
+type pendingTimer struct {
+	//expire removes the expired entries of the cache and notifies their senders.
+	expire func()
+	//entries returns the entries of the cache.
+	entries func() []cache.PendingEntry
+	timer   *time.Timer
+	//next is the expiration (number of seconds since 01.01.1970) for which timer is set. It is 0
+	//if timer is not set.
+	next    int64
+	stopped bool
+	//mux protects timer, next and stopped from simultaneous access.
+	mux sync.Mutex
+}
+
+func newPendingTimer(expire func(), entries func() []cache.PendingEntry) *pendingTimer {
+	return &pendingTimer{expire: expire, entries: entries}
+}
+
+//schedule makes sure that expire is called as soon as an entry with expiration has expired.
+func (t *pendingTimer) schedule(expiration int64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.stopped || (t.next != 0 && t.next <= expiration) {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.next = expiration
+	//An entry has expired once its expiration lies in the past.
+	t.timer = time.AfterFunc(time.Until(time.Unix(expiration+1, 0)), t.fire)
+}
+
+//fire removes the expired entries and sets the timer for the earliest remaining entry.
+func (t *pendingTimer) fire() {
+	t.mux.Lock()
+	t.next = 0
+	t.mux.Unlock()
+	t.expire()
+	for _, e := range t.entries() {
+		t.schedule(e.Expiration)
+	}
+}
+
+//stop stops the timer. Expired entries are no longer removed.
+func (t *pendingTimer) stop() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+//expirePendingQueries removes the expired entries of the pending query cache and notifies each
+//waiting sender that no assertion is available for its query.
+func (s *Server) expirePendingQueries() {
+	for _, e := range s.caches.PendingQueries.RemoveExpiredValues() {
+		log.Info("Query expired before an answer arrived", "token", e.Token,
+			"sectionSender", e.Waiting)
+		if e.Waiting.Sender != nil {
+			sendNotificationMsg(e.Waiting.Token, e.Waiting.Sender, section.NTNoAssertionAvail,
+				"query expired before an answer arrived", s)
+		}
+	}
+}
+
+//expirePendingKeys removes the expired entries of the pending key cache and notifies each waiting
+//sender that its sections could not be verified.
+func (s *Server) expirePendingKeys() {
+	for _, e := range s.caches.PendingKeys.RemoveExpiredValues() {
+		if e.Waiting.Sender != nil {
+			sendNotificationMsg(e.Waiting.Token, e.Waiting.Sender, section.NTUnspecServerErr,
+				"public keys to verify the sections did not arrive in time", s)
+		}
+	}
+}
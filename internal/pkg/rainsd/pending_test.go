@@ -0,0 +1,157 @@
+package rainsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//memoryPeer returns the messages received on the in-memory address addr until the test has
+//finished.
+func memoryPeer(t *testing.T, addr connection.MemoryAddr) <-chan *message.Message {
+	t.Helper()
+	l, err := connection.MemoryTransport{}.Listen(addr)
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	msgs := make(chan *message.Message, 10)
+	go func() {
+		for {
+			conn, err := l.Stream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					msg, err := connection.MemoryTransport{}.ReceiveMessage(conn)
+					if err != nil {
+						return
+					}
+					msgs <- msg
+				}
+			}()
+		}
+	}()
+	return msgs
+}
+
+//waitForNotification returns as soon as a notification of type nt about tok arrives on msgs. The
+//test fails if it does not arrive within timeout.
+func waitForNotification(t *testing.T, msgs <-chan *message.Message, tok token.Token,
+	nt section.NotificationType, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-msgs:
+			for _, sec := range msg.Content {
+				if n, ok := sec.(*section.Notification); ok && n.Token == tok && n.Type == nt {
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatalf("No %v notification for token %v", nt, tok)
+		}
+	}
+}
+
+func TestPendingTimer(t *testing.T) {
+	c := cache.NewPendingQuery(10)
+	expired := make(chan []cache.PendingEntry, 10)
+	timer := newPendingTimer(func() { expired <- c.RemoveExpiredValues() }, c.GetAll)
+	defer timer.stop()
+	later := time.Now().Add(time.Hour).Unix()
+	earlier := time.Now().Add(-time.Second).Unix()
+	for _, e := range []struct {
+		name       string
+		expiration int64
+	}{{"later.", later}, {"earlier.", earlier}} {
+		tok := token.New()
+		c.Add(util.MsgSectionSender{Token: tok, Sections: []section.Section{ip4Query(e.name)}}, tok,
+			e.expiration)
+		timer.schedule(e.expiration)
+	}
+	select {
+	case entries := <-expired:
+		if len(entries) != 1 || entries[0].Expiration != earlier {
+			t.Errorf("wrong expired entries: %v", entries)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timer did not fire for the earlier entry")
+	}
+	time.Sleep(10 * time.Millisecond)
+	timer.mux.Lock()
+	defer timer.mux.Unlock()
+	if timer.next != later {
+		t.Errorf("timer is not set for the remaining entry. expected=%d actual=%d", later, timer.next)
+	}
+}
+
+func TestPendingQueryExpiry(t *testing.T) {
+	config := memoryConfig(t, "TestPendingQueryExpiry", DefaultConfig().Capabilities)
+	s, err := New(config, "TestPendingQueryExpiry")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	//The forwarder does not exist such that the query is never answered.
+	r, err := libresolve.New(nil, []net.Addr{connection.MemoryAddr("TestPendingQueryExpiryUpstream")},
+		config.RootZonePublicKeyPath, libresolve.Forward, s.Addr(), 10, config.MaxCacheValidity, 10)
+	if err != nil {
+		t.Fatalf("Was not able to create libresolve resolver: %v", err)
+	}
+	s.SetResolver(r)
+	go s.Run(context.Background())
+	<-s.Ready()
+	t.Cleanup(s.Shutdown)
+
+	//The query expires after QueryValidity, long before the pending query cache is reaped.
+	msg := message.Message{Token: token.New(), Content: []section.Section{ip4Query("www.")}}
+	answer, err := util.SendQuery(msg, s.Addr(), 3*time.Second)
+	if err != nil {
+		t.Fatalf("No notification about expired query: %v", err)
+	}
+	if n, ok := answer.Content[0].(*section.Notification); !ok ||
+		n.Type != section.NTNoAssertionAvail || n.Token != msg.Token {
+		t.Errorf("wrong answer to expired query: %v", answer.Content)
+	}
+	if s.caches.PendingQueries.Len() != 0 {
+		t.Errorf("expired query is still pending")
+	}
+}
+
+func TestPendingKeyExpiry(t *testing.T) {
+	config := memoryConfig(t, "TestPendingKeyExpiry", DefaultConfig().Capabilities)
+	//The delegation query is not sent again such that the sections expire after its validity.
+	config.DelegationQueryRetries = 0
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	peer := connection.MemoryAddr("TestPendingKeyExpiryPeer")
+	msgs := memoryPeer(t, peer)
+
+	//The delegation of example. is neither cached nor sent by the peer.
+	delegation(t, config.CheckPointPath, "root", ".", "example")
+	www := &section.Assertion{SubjectName: "www", SubjectZone: "example.", Context: ".",
+		Content: []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+	signAssertion(t, config.CheckPointPath, "example", www)
+	tok := token.New()
+	ss := util.MsgSectionSender{Sender: peer, Token: tok, Sections: []section.Section{www}}
+	handleMissingKeys(ss, map[missingKeyMetaData]bool{{Zone: "example.", Context: "."}: true}, s,
+		false)
+	waitForNotification(t, msgs, tok, section.NTUnspecServerErr, 3*time.Second)
+	if s.caches.PendingKeys.Len() != 0 {
+		t.Errorf("expired sections are still waiting for keys")
+	}
+}
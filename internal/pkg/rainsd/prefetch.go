@@ -0,0 +1,87 @@
+package rainsd
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//defaultPrefetchInterval is the time between two prefetch rounds if no prefetch interval is
+//configured.
+const defaultPrefetchInterval = time.Minute
+
+//initPrefetcher starts the go routine which periodically refreshes popular assertions until stop
+//is closed. It is added to routines. The interval is taken from the current config before each
+//wait.
+func (s *Server) initPrefetcher(stop <-chan struct{}, routines *sync.WaitGroup) {
+	routines.Add(1)
+	go func() {
+		defer routines.Done()
+		repeatFuncCaller(s.prefetch, func() time.Duration {
+			if interval := s.config().PrefetchInterval; interval > 0 {
+				return interval
+			}
+			return defaultPrefetchInterval
+		}, stop)
+	}()
+}
+
+//prefetch queries the resolver for the assertions which have been looked up at least
+//PrefetchMinHits times since the last round and which expire within the prefetch window. The
+//answers replace the cached assertions before they expire such that clients are answered from the
+//cache. At most PrefetchBudget of the most popular assertions are queried. Assertions populated by
+//queries with the no proactive caching option are never prefetched.
+func (s *Server) prefetch() {
+	config := s.config()
+	if config.PrefetchBudget <= 0 || s.resolver == nil {
+		return
+	}
+	until := time.Now().Add(config.PrefetchWindow).Unix()
+	entries := s.caches.AssertionsCache.HotEntries(until, config.PrefetchMinHits)
+	if len(entries) == 0 {
+		return
+	}
+	if len(entries) > config.PrefetchBudget {
+		log.Info("Prefetch budget exceeded", "candidates", len(entries),
+			"budget", config.PrefetchBudget)
+		entries = entries[:config.PrefetchBudget]
+	}
+	expiration := time.Now().Add(config.QueryValidity).Unix()
+	queries := []section.Section{}
+	for _, e := range entries {
+		queries = append(queries, &query.Name{
+			Name:       e.Name,
+			Context:    e.Context,
+			Types:      []object.Type{e.Type},
+			Expiration: expiration,
+		})
+	}
+	log.Info("Prefetching popular assertions", "queries", queries)
+	s.sendToRecursiveResolver(message.Message{Token: token.New(), Content: queries})
+}
+
+//disablePrefetch excludes the cached assertions of sections and the ones contained in shards and
+//zones of sections from prefetching.
+func disablePrefetch(sections []section.WithSigForward, assertionsCache cache.Assertion) {
+	for _, sec := range sections {
+		switch sec := sec.(type) {
+		case *section.Assertion:
+			assertionsCache.DisablePrefetch(sec)
+		case *section.Shard:
+			for _, a := range sec.Content {
+				assertionsCache.DisablePrefetch(a.Copy(sec.Context, sec.SubjectZone))
+			}
+		case *section.Zone:
+			for _, a := range sec.Content {
+				assertionsCache.DisablePrefetch(a.Copy(sec.Context, sec.SubjectZone))
+			}
+		}
+	}
+}
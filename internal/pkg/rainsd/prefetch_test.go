@@ -0,0 +1,153 @@
+package rainsd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//newPrefetchServers returns a running authoritative server for the root zone serving the zonefile
+//at the returned path and a running server forwarding queries to it which prefetches every
+//assertion looked up at least once.
+func newPrefetchServers(t *testing.T, name string) (auth, resolver *Server, zonefile string) {
+	t.Helper()
+	config := memoryConfig(t, connection.MemoryAddr(name+"Auth"), DefaultConfig().Capabilities)
+	config.Authorities = []ZoneContext{{".", "."}}
+	config.ZoneFiles = []string{filepath.Join(t.TempDir(), "root.txt")}
+	storeZonefile(t, config.ZoneFiles[0], signedAssertion(t, config, ".", "www", "192.0.2.1"))
+	auth, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run authoritative server: %v", err)
+	}
+
+	resolverConfig := config
+	resolverConfig.ServerAddress = connection.Info{Type: connection.Memory,
+		Addr: connection.MemoryAddr(name + "Resolver")}
+	resolverConfig.CheckPointPath = t.TempDir()
+	resolverConfig.Authorities = nil
+	resolverConfig.ZoneFiles = nil
+	resolverConfig.PrefetchBudget = 10
+	resolverConfig.PrefetchInterval = time.Hour
+	resolverConfig.PrefetchWindow = 2 * time.Hour
+	resolverConfig.PrefetchMinHits = 1
+	resolver, err = New(resolverConfig, name+"Resolver")
+	if err != nil {
+		t.Fatalf("Was not able to create resolver: %v", err)
+	}
+	r, err := libresolve.New(nil, []net.Addr{auth.Addr()}, config.RootZonePublicKeyPath,
+		libresolve.Forward, resolver.Addr(), 10, config.MaxCacheValidity, 10)
+	if err != nil {
+		t.Fatalf("Was not able to create libresolve resolver: %v", err)
+	}
+	resolver.SetResolver(r)
+	go resolver.Run(context.Background())
+	<-resolver.Ready()
+	t.Cleanup(resolver.Shutdown)
+	return auth, resolver, config.ZoneFiles[0]
+}
+
+//ip4Query returns a query for the ip4 address of name with options.
+func ip4Query(name string, options ...query.Option) *query.Name {
+	return &query.Name{
+		Name:       name,
+		Context:    ".",
+		Types:      []object.Type{object.OTIP4Addr},
+		Expiration: time.Now().Add(time.Minute).Unix(),
+		Options:    options,
+	}
+}
+
+//lookup sends queries in one message to s and waits for the answer.
+func lookup(t *testing.T, s *Server, queries ...*query.Name) {
+	t.Helper()
+	msg := message.Message{Token: token.New()}
+	for _, q := range queries {
+		msg.Content = append(msg.Content, q)
+	}
+	if _, err := util.SendQuery(msg, s.Addr(), time.Second); err != nil {
+		t.Fatalf("No answer to queries %v: %v", queries, err)
+	}
+}
+
+//hasCachedIP4 returns true if s caches an assertion of name in the root zone with the ip4 address
+//ip. It does not count as a lookup.
+func hasCachedIP4(s *Server, name, ip string) bool {
+	all := &section.Shard{RangeFrom: "", RangeTo: ""}
+	for _, a := range s.caches.AssertionsCache.GetInRange(".", ".", all) {
+		if a.SubjectName == name && a.Content[0].Value.(net.IP).String() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrefetch(t *testing.T) {
+	auth, resolver, zonefile := newPrefetchServers(t, "TestPrefetch")
+	//The first lookup is answered by the authoritative server, the second one from the cache.
+	lookup(t, resolver, ip4Query("www."))
+	lookup(t, resolver, ip4Query("www."))
+	if !hasCachedIP4(resolver, "www", "192.0.2.1") {
+		t.Fatal("answer of the authoritative server is not cached")
+	}
+
+	storeZonefile(t, zonefile, signedAssertion(t, *auth.config(), ".", "www", "192.0.2.2"))
+	if _, err := auth.Reload(*auth.config()); err != nil {
+		t.Fatalf("Was not able to reload authoritative server: %v", err)
+	}
+	resolver.prefetch()
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasCachedIP4(resolver, "www", "192.0.2.2") {
+		if time.Now().After(deadline) {
+			t.Fatal("popular assertion was not prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrefetchNoProactiveCaching(t *testing.T) {
+	config := memoryConfig(t, "TestPrefetchNoProactiveCaching", DefaultConfig().Capabilities)
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	answer := func(names ...string) []section.WithSigForward {
+		sections := []section.WithSigForward{}
+		for _, name := range names {
+			a := signedAssertion(t, config, ".", name, "192.0.2.1")
+			a.SetValidSince(time.Now().Unix())
+			a.SetValidUntil(time.Now().Add(time.Hour).Unix())
+			sections = append(sections, a)
+		}
+		return sections
+	}
+	//The answer is cached because one of the waiting queries allows it but it must not be
+	//refreshed.
+	tok := token.New()
+	s.caches.PendingQueries.Add(util.MsgSectionSender{
+		Sender:   connection.MemoryAddr("TestPrefetchNoProactiveCachingClient"),
+		Token:    token.New(),
+		Sections: []section.Section{ip4Query("www.", query.QONoProactiveCaching), ip4Query("ftp.")},
+	}, tok, time.Now().Add(time.Minute).Unix())
+	s.assert(util.SectionWithSigSender{Token: tok, Sections: answer("www", "ftp")})
+	s.assert(util.SectionWithSigSender{Token: token.New(), Sections: answer("mail")})
+	for _, fqdn := range []string{"www.", "ftp.", "mail."} {
+		if _, ok := s.caches.AssertionsCache.Get(fqdn, ".", object.OTIP4Addr, true); !ok {
+			t.Fatalf("answer for %s is not cached", fqdn)
+		}
+	}
+	hot := s.caches.AssertionsCache.HotEntries(time.Now().Add(2*time.Hour).Unix(), 1)
+	if len(hot) != 1 || hot[0].Name != "mail." {
+		t.Errorf("wrong prefetch candidates. expected=[mail.] actual=%v", hot)
+	}
+}
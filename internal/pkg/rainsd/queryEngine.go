@@ -1,12 +1,14 @@
 package rainsd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
+	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
@@ -33,7 +35,7 @@ func (s *Server) processQuery(msgSender util.MsgSectionSender) {
 			return
 		}
 	}
-	if len(s.config.Authorities) == 0 {
+	if len(s.config().Authorities) == 0 {
 		//caching resolver
 		answerQueriesCachingResolver(msgSender, s)
 	} else {
@@ -47,16 +49,32 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 	log.Info("Start processing query as cr", "queries", ss.Sections)
 	queries := []*query.Name{}
 	sections := []section.Section{}
+	notAvailable := []*query.Name{}
 	for _, q := range ss.Sections {
 		q := q.(*query.Name)
-		if secs := cacheLookup(q, ss.Sender, ss.Token, s); secs != nil {
-			sections = append(sections, secs...)
+		//A query with the max freshness option is always forwarded such that the answer is as
+		//up to date as possible.
+		if !q.ContainsOption(query.QOMaxFreshness) {
+			if secs := cacheLookup(q, ss.Sender, ss.Token, s); secs != nil {
+				sections = append(sections, secs...)
+				continue
+			}
+		}
+		if q.ContainsOption(query.QOCachedAnswersOnly) {
+			notAvailable = append(notAvailable, q)
 		} else {
 			queries = append(queries, q)
 		}
 	}
+	if len(notAvailable) > 0 {
+		log.Info("No cached answer for queries with cached answers only option", "queries",
+			notAvailable)
+		sendNotificationMsg(ss.Token, ss.Sender, section.NTNoAssertionAvail, "", s)
+	}
 	if len(queries) == 0 {
-		sendSections(sections, ss.Token, ss.Sender, s)
+		if len(sections) > 0 || len(notAvailable) == 0 {
+			sendSections(sections, ss.Token, ss.Sender, s)
+		}
 		return
 	}
 
@@ -65,14 +83,16 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 	if !ss.Sections[0].(*query.Name).ContainsOption(query.QOTokenTracing) {
 		tok = token.New()
 	}
-	validUntil := time.Now().Add(s.config.QueryValidity).Unix() //Upper bound for forwarded query expiration time
+	validUntil := time.Now().Add(s.config().QueryValidity).Unix() //Upper bound for forwarded query expiration time
 	for _, q := range queries {
 		if q.Expiration < validUntil {
 			validUntil = q.Expiration
 		}
 	}
 	log.Info("Adding sectionSender to pending query cache", "sectionSender", ss)
-	if isNew := s.caches.PendingQueries.Add(ss, tok, validUntil); isNew {
+	isNew := s.caches.PendingQueries.Add(ss, tok, validUntil)
+	s.pendingQueryTimer.schedule(validUntil)
+	if isNew {
 		log.Info("Forwarding queries to recursive resolver", "queries", queries)
 		qs := []section.Section{}
 		for _, q := range queries {
@@ -85,50 +105,111 @@ func answerQueriesCachingResolver(ss util.MsgSectionSender, s *Server) {
 	}
 }
 
-//answerQueryAuthoritative is how an authoritative server answers queries
+//answerQueryAuthoritative is how an authoritative server answers queries. It always answers. Queries
+//about names outside its authority are answered with a referral to the closest zone for which it
+//has glue records. If there is no answer for a query, the sender is notified that no assertions
+//exist if their absence is proven by a cached section, and that no assertions are available
+//otherwise.
 func answerQueriesAuthoritative(qs []*query.Name, sender net.Addr, token token.Token, s *Server) {
 	log.Info("Start processing query as authority", "queries", qs)
+	sections := []section.Section{}
+	nonexistent := []*query.Name{}
+	notAvailable := []*query.Name{}
+	glueNames := make(map[ZoneContext]bool)
 	for _, q := range qs {
-		for i, auth := range s.config.Authorities {
-			// check this server is authoritative for the name and the query has the right context
-			if strings.HasSuffix(q.Name, auth.Zone) && q.Name != auth.Zone && q.Context == auth.Context {
-				break
-			}
-			if i == len(s.config.Authorities)-1 {
-				log.Info("Query is not about a name this zone has authority over", "name", q.Name,
-					"authorities", s.config.Authorities)
-				return
+		if !isAuthoritativeForQuery(q, s.config().Authorities) {
+			log.Info("Query is not about a name this zone has authority over", "name", q.Name,
+				"authorities", s.config().Authorities)
+			if referral := s.referral(q.Name, q.Context); len(referral) > 0 {
+				sections = append(sections, referral...)
+			} else {
+				notAvailable = append(notAvailable, q)
 			}
+			continue
 		}
-	}
-
-	queries := []*query.Name{}
-	sections := []section.Section{}
-	for _, q := range qs {
 		if secs := cacheLookup(q, sender, token, s); secs != nil {
 			sections = append(sections, secs...)
-		} else {
-			queries = append(queries, q)
+			continue
 		}
-	}
-
-	if len(queries) != 0 {
 		//glueRecordNames assumes that the names of delegates do not contain a dot '.'.
-		names := glueRecordNames(queries, s.config.Authorities)
-		for name := range names {
+		for name := range glueRecordNames([]*query.Name{q}, s.config().Authorities) {
+			if glueNames[name] {
+				continue
+			}
+			glueNames[name] = true
 			glueRecords, err := s.glueRecordLookup(name.Zone, name.Context, s.caches.AssertionsCache)
-			if err != nil {
-				log.Warn("Was not able to find all glue records.", "name", name, "error", err.Error())
-				return
+			if err == nil {
+				sections = append(sections, glueRecords...)
+				continue
+			}
+			log.Info("Was not able to find all glue records.", "name", name, "error", err.Error())
+			if isNonexistent(name, s) {
+				nonexistent = append(nonexistent, q)
+			} else {
+				notAvailable = append(notAvailable, q)
 			}
-			sections = append(sections, glueRecords...)
 		}
 	}
-	sendSections(sections, token, sender, s)
+	if len(nonexistent) > 0 && len(notAvailable) == 0 {
+		log.Info("Queried names do not exist", "queries", nonexistent)
+		sendNotificationMsg(token, sender, section.NTNoAssertionsExist, "", s)
+	} else if len(nonexistent) > 0 || len(notAvailable) > 0 {
+		log.Info("No answer available for queries", "queries", append(nonexistent, notAvailable...))
+		sendNotificationMsg(token, sender, section.NTNoAssertionAvail, "", s)
+	}
+	if len(sections) > 0 || (len(nonexistent) == 0 && len(notAvailable) == 0) {
+		sendSections(sections, token, sender, s)
+	}
 	log.Info("Finished handling query by sending records from cache", "queries", qs,
 		"sections", sections)
 }
 
+//isAuthoritativeForQuery returns true if one of the authorities is responsible for the queried
+//name in the queried context.
+func isAuthoritativeForQuery(q *query.Name, authorities []ZoneContext) bool {
+	for _, auth := range authorities {
+		if strings.HasSuffix(q.Name, auth.Zone) && q.Name != auth.Zone && q.Context == auth.Context {
+			return true
+		}
+	}
+	return false
+}
+
+//referral returns the glue records of name or of the closest zone above it for which glue records
+//are cached. nil is returned if there are none.
+func (s *Server) referral(name, context string) []section.Section {
+	for name != "" {
+		if glueRecords, err := s.glueRecordLookup(name, context, s.caches.AssertionsCache); err == nil {
+			return glueRecords
+		}
+		i := strings.Index(name, ".")
+		if name == "." || i < 0 {
+			//A name which is not fully qualified has no zone above it.
+			break
+		}
+		if i < len(name)-1 {
+			name = name[i+1:]
+		} else {
+			name = "."
+		}
+	}
+	return nil
+}
+
+//isNonexistent returns true if a cached section proves that there is no delegation or redirection
+//assertion for the zone name.
+func isNonexistent(name ZoneContext, s *Server) bool {
+	subject, zone, err := toSubjectZone(name.Zone)
+	if err != nil {
+		return false
+	}
+	secs, _ := s.caches.NegAssertionCache.Get(zone, name.Context, section.StringInterval{Name: subject})
+	q := &query.Name{Name: name.Zone, Context: name.Context,
+		Types: []object.Type{object.OTDelegation, object.OTRedirection}}
+	answer, proof := selectAnswer(q, subject, removeExpiredSections(secs))
+	return answer == nil && proof != nil
+}
+
 //cacheLookup answers q with a cached entry if there is one. True is returned in case of a cache hit
 func cacheLookup(q *query.Name, sender net.Addr, token token.Token, s *Server) []section.Section {
 	assertions := assertionCacheLookup(q, s)
@@ -152,20 +233,25 @@ func assertionCacheLookup(q *query.Name, s *Server) (assertions []section.Sectio
 		return fmt.Sprintf("%s_%s_%s", a.SubjectName, a.SubjectZone, a.Context)
 	}
 
+	//An assertion is expired when its cache expiration has passed. The cache expiration is at most
+	//the assertion's validity but may be shorter due to the configured maximum cache validity.
+	now := time.Now().Unix()
 	for _, t := range q.Types {
-		if asserts, ok := s.caches.AssertionsCache.Get(q.Name, q.Context, t, true); ok {
-			for _, a := range asserts {
+		if cached, ok := s.caches.AssertionsCache.GetWithExpiration(q.Name, q.Context, t); ok {
+			for _, ca := range cached {
+				a := ca.Assertion
 				if _, ok := assertionSet[asKey(a)]; ok {
 					continue
 				}
-				if a.ValidUntil() > time.Now().Unix() {
-					log.Debug(fmt.Sprintf("appending valid assertion: %v", a))
+				if ca.Expiration >= now || q.ContainsOption(query.QOExpiredAssertionsOk) {
+					log.Debug(fmt.Sprintf("appending assertion: %v", a))
 					assertions = append(assertions, a)
 					assertionSet[asKey(a)] = true
 				}
 			}
 		}
 	}
+	s.metrics.cacheLookup("assertion", len(assertions) > 0)
 	return
 }
 
@@ -178,20 +264,102 @@ func negativeCacheLookup(q *query.Name, sender net.Addr, token token.Token, s *S
 		return nil
 	}
 	answer, _ := s.caches.NegAssertionCache.Get(zone, q.Context, section.StringInterval{Name: subject})
-	return filterAnswer(answer)
+	if !q.ContainsOption(query.QOExpiredAssertionsOk) {
+		answer = removeExpiredSections(answer)
+	}
+	sections := filterAnswer(q, subject, answer)
+	s.metrics.cacheLookup("negativeAssertion", len(sections) > 0)
+	return sections
 }
 
-func filterAnswer(sections []section.WithSigForward) (answer []section.Section) {
-	//TODO CFE For each type check if one of the zone or shards contain the queried
-	//assertion. If there is at least one assertion answer with it. If no assertion is
-	//contained in a zone or shard for any of the queried connection, answer with the shortest
-	//element. shortest according to what? size in bytes? how to efficiently determine that.
-	//e.g. using gob encoding. alternatively we could also count the number of contained
-	//elements.
-	for _, s := range sections {
-		answer = append(answer, s)
+//removeExpiredSections returns all sections which are not yet expired.
+func removeExpiredSections(sections []section.WithSigForward) []section.WithSigForward {
+	valid := []section.WithSigForward{}
+	for _, sec := range sections {
+		if sec.ValidUntil() > time.Now().Unix() {
+			valid = append(valid, sec)
+		}
 	}
-	return
+	return valid
+}
+
+//filterAnswer returns the smallest shard or zone of sections which contains an assertion answering
+//q. If there is none, it returns the smallest section proving that subject does not exist. nil is
+//returned if no section answers q.
+func filterAnswer(q *query.Name, subject string, sections []section.WithSigForward) []section.Section {
+	answer, proof := selectAnswer(q, subject, sections)
+	if answer != nil {
+		return []section.Section{answer}
+	}
+	if proof != nil {
+		return []section.Section{proof}
+	}
+	return nil
+}
+
+//selectAnswer returns the smallest shard or zone of sections containing an assertion answering q
+//and the smallest section proving that subject does not exist. Pshards only prove nonexistence if
+//their bloom filter does not contain any of the queried types. The size of a section is the length
+//of its cbor encoding.
+func selectAnswer(q *query.Name, subject string, sections []section.WithSigForward) (
+	answer, proof section.Section) {
+	answerSize, proofSize := 0, 0
+	for _, sec := range sections {
+		var content []*section.Assertion
+		switch sec := sec.(type) {
+		case *section.Shard:
+			content = sec.Content
+		case *section.Zone:
+			content = sec.Content
+		case *section.Pshard:
+			if nonexistent, err := sec.IsNonexistent(q); err != nil || !nonexistent {
+				continue
+			}
+		default:
+			log.Warn("Not supported section type in negative answer", "type", fmt.Sprintf("%T", sec))
+			continue
+		}
+		size, err := encodedSize(sec)
+		if err != nil {
+			log.Warn("Was not able to determine encoding size of section", "error", err)
+			continue
+		}
+		if containsAnswer(content, subject, q.Types) {
+			if answer == nil || size < answerSize {
+				answer, answerSize = sec, size
+			}
+		} else if proof == nil || size < proofSize {
+			proof, proofSize = sec, size
+		}
+	}
+	return answer, proof
+}
+
+//containsAnswer returns true if one of the assertions is about subject and contains an object of
+//one of the types.
+func containsAnswer(assertions []*section.Assertion, subject string, types []object.Type) bool {
+	for _, a := range assertions {
+		if a.SubjectName != subject {
+			continue
+		}
+		for _, o := range a.Content {
+			for _, t := range types {
+				if o.Type == t {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+//encodedSize returns the length of sec's cbor encoding.
+func encodedSize(sec section.Section) (int, error) {
+	encoding := new(bytes.Buffer)
+	if err := sec.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		return 0, err
+	}
+	return encoding.Len(), nil
 }
 
 //glueRecordNames returns the unique names for which glue records should be looked up based on qs.
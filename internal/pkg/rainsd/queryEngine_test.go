@@ -0,0 +1,178 @@
+package rainsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/datastructures/bitarray"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestFilterAnswer(t *testing.T) {
+	ip4 := func(name string) *section.Assertion {
+		return &section.Assertion{SubjectName: name,
+			Content: []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+	}
+	zone := &section.Zone{SubjectZone: "ethz.ch.", Context: ".",
+		Content: []*section.Assertion{ip4("ftp"), ip4("mail"), ip4("www")}}
+	largeShard := &section.Shard{SubjectZone: "ethz.ch.", Context: ".", RangeFrom: "",
+		RangeTo: "", Content: []*section.Assertion{ip4("ftp"), ip4("mail"), ip4("www")}}
+	wwwShard := &section.Shard{SubjectZone: "ethz.ch.", Context: ".", RangeFrom: "mail",
+		RangeTo: "", Content: []*section.Assertion{ip4("www")}}
+	emptyShard := &section.Shard{SubjectZone: "ethz.ch.", Context: ".", RangeFrom: "ftp",
+		RangeTo: "mail"}
+	pshard := &section.Pshard{SubjectZone: "ethz.ch.", Context: ".", RangeFrom: "", RangeTo: "",
+		BloomFilter: section.BloomFilter{
+			Algorithm: section.BloomKM12,
+			Hash:      algorithmTypes.Shake256,
+			Filter:    make(bitarray.BitArray, 16),
+		}}
+	zone.AddCtxAndZoneToContent()
+	for _, a := range zone.Content {
+		if err := pshard.AddAssertion(a); err != nil {
+			t.Fatalf("Was not able to add assertion to pshard: %v", err)
+		}
+	}
+	zone.RemoveCtxAndZoneFromContent()
+
+	newQuery := func(name string, t object.Type) *query.Name {
+		return &query.Name{Name: name, Context: ".", Types: []object.Type{t}}
+	}
+	var tests = []struct {
+		q        *query.Name
+		subject  string
+		sections []section.WithSigForward
+		want     section.Section
+	}{
+		//smallest section containing an answer
+		{newQuery("www.ethz.ch.", object.OTIP4Addr), "www",
+			[]section.WithSigForward{zone, largeShard, wwwShard, pshard}, wwwShard},
+		{newQuery("mail.ethz.ch.", object.OTIP4Addr), "mail",
+			[]section.WithSigForward{largeShard, zone, wwwShard}, zone},
+		//smallest section proving nonexistence
+		{newQuery("www.ethz.ch.", object.OTIP6Addr), "www",
+			[]section.WithSigForward{zone, wwwShard}, wwwShard},
+		{newQuery("www.ethz.ch.", object.OTIP6Addr), "www",
+			[]section.WithSigForward{zone, largeShard, pshard}, pshard},
+		{newQuery("gopher.ethz.ch.", object.OTIP4Addr), "gopher",
+			[]section.WithSigForward{zone, emptyShard, pshard}, emptyShard},
+		//a pshard containing the queried name does not prove anything
+		{newQuery("www.ethz.ch.", object.OTIP4Addr), "www", []section.WithSigForward{pshard}, nil},
+		{newQuery("www.ethz.ch.", object.OTIP4Addr), "www", nil, nil},
+	}
+	for i, test := range tests {
+		answer := filterAnswer(test.q, test.subject, test.sections)
+		if test.want == nil {
+			if answer != nil {
+				t.Errorf("%d: expected no answer. actual=%v", i, answer)
+			}
+			continue
+		}
+		if len(answer) != 1 || answer[0] != test.want {
+			t.Errorf("%d: wrong answer. expected=%v actual=%v", i, test.want, answer)
+		}
+	}
+}
+
+func TestReferralNotFullyQualified(t *testing.T) {
+	s := newMemoryServer(t, "TestReferralNotFullyQualified", DefaultConfig().Capabilities)
+	done := make(chan []section.Section)
+	go func() { done <- s.referral("www", ".") }()
+	select {
+	case glue := <-done:
+		if glue != nil {
+			t.Errorf("unexpected glue records: %v", glue)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("referral does not return for a name which is not fully qualified")
+	}
+}
+
+func TestQueryNotFullyQualified(t *testing.T) {
+	config := memoryConfig(t, "TestQueryNotFullyQualified", DefaultConfig().Capabilities)
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	a := signedAssertion(t, config, ".", "www", "192.0.2.1")
+	a.SetValidSince(time.Now().Unix())
+	a.SetValidUntil(time.Now().Add(time.Hour).Unix())
+	s.caches.AssertionsCache.Add(a, a.ValidUntil(), false)
+
+	//The query which is not fully qualified is rejected, the other one is still answered.
+	client := connection.MemoryAddr("TestQueryNotFullyQualifiedClient")
+	msgs := memoryPeer(t, client)
+	msg := &message.Message{Token: token.New(),
+		Content: []section.Section{ip4Query("www"), ip4Query("www.")}}
+	s.deliver(msg, client)
+	rejected, answered := false, false
+	deadline := time.After(time.Second)
+	for !rejected || !answered {
+		select {
+		case answer := <-msgs:
+			for _, sec := range answer.Content {
+				switch sec := sec.(type) {
+				case *section.Notification:
+					rejected = rejected || sec.Token == msg.Token && sec.Type == section.NTBadMessage
+				case *section.Assertion:
+					answered = answered || sec.Hash() == a.Hash()
+				}
+			}
+		case <-deadline:
+			t.Fatalf("wrong reaction to message. rejected=%v answered=%v", rejected, answered)
+		}
+	}
+}
+
+func TestExpiredAssertionsOk(t *testing.T) {
+	var tests = []struct {
+		name          string
+		authoritative bool
+		options       []query.Option
+		wantAnswer    bool
+	}{
+		{"caching", false, []query.Option{query.QOCachedAnswersOnly}, false},
+		{"caching expired ok", false, []query.Option{query.QOCachedAnswersOnly,
+			query.QOExpiredAssertionsOk}, true},
+		{"authoritative", true, nil, false},
+		{"authoritative expired ok", true, []query.Option{query.QOExpiredAssertionsOk}, true},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := memoryConfig(t, connection.MemoryAddr(t.Name()), DefaultConfig().Capabilities)
+			if test.authoritative {
+				config.Authorities = []ZoneContext{{"example.", "."}}
+			}
+			s, err := runMemoryServer(t, config)
+			if err != nil {
+				t.Fatalf("%d: Was not able to run server: %v", i, err)
+			}
+			//The signature is still valid but the assertion has expired in the cache.
+			a := signedAssertion(t, config, "example.", "www", "192.0.2.1")
+			s.caches.AssertionsCache.Add(a, time.Now().Add(-time.Minute).Unix(), false)
+
+			msg := message.Message{Token: token.New(),
+				Content: []section.Section{ip4Query("www.example.", test.options...)}}
+			answer, err := util.SendQuery(msg, s.Addr(), time.Second)
+			if err != nil {
+				t.Fatalf("%d: no answer: %v", i, err)
+			}
+			if len(answer.Content) != 1 {
+				t.Fatalf("%d: not exactly one section in answer: %v", i, answer.Content)
+			}
+			if got, ok := answer.Content[0].(*section.Assertion); ok != test.wantAnswer ||
+				(ok && got.Hash() != a.Hash()) {
+				t.Errorf("%d: wrong answer. expected assertion=%v actual=%v", i, test.wantAnswer,
+					answer.Content[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package rainsd
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+const (
+	//defaultRateLimitSenders is the number of senders for which a rate limiter keeps a token
+	//bucket if no maximum is configured.
+	defaultRateLimitSenders = 10000
+	//overflowSender is the key of the token bucket shared by all senders which do not fit into
+	//the rate limiter.
+	overflowSender = "overflow"
+)
+
+//tokenBucket contains the tokens of a sender. A message consumes one token.
+type tokenBucket struct {
+	tokens float64
+	//last is the point in time at which tokens has been refilled the last time.
+	last time.Time
+	//limited is true if the last message of the sender has been dropped.
+	limited bool
+}
+
+//rateLimiter limits the number of messages per second the server accepts from each sender. Senders
+//are grouped by IP address prefix. Each group has a token bucket which holds at most burst tokens
+//and is refilled with rate tokens per second. All senders which do not fit into the rate limiter
+//share one token bucket such that spoofed source addresses cannot exhaust the server's memory.
+type rateLimiter struct {
+	//dropped counts the dropped messages. It must be accessed atomically and is placed first to
+	//guarantee 64-bit alignment.
+	dropped uint64
+
+	rate       float64
+	burst      float64
+	ipv4Mask   net.IPMask
+	ipv6Mask   net.IPMask
+	maxSenders int
+	//now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	//mutex protects buckets from simultaneous access.
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+//newRateLimiter returns a rate limiter according to config. It returns nil if rate limiting is
+//disabled.
+func newRateLimiter(config Config) *rateLimiter {
+	if config.RateLimit <= 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		rate:       config.RateLimit,
+		burst:      float64(config.RateLimitBurst),
+		ipv4Mask:   net.CIDRMask(32, 32),
+		ipv6Mask:   net.CIDRMask(128, 128),
+		maxSenders: config.RateLimitMaxSenders,
+		now:        time.Now,
+		buckets:    make(map[string]*tokenBucket),
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	if config.RateLimitIPv4Prefix > 0 && config.RateLimitIPv4Prefix < 32 {
+		l.ipv4Mask = net.CIDRMask(config.RateLimitIPv4Prefix, 32)
+	}
+	if config.RateLimitIPv6Prefix > 0 && config.RateLimitIPv6Prefix < 128 {
+		l.ipv6Mask = net.CIDRMask(config.RateLimitIPv6Prefix, 128)
+	}
+	if l.maxSenders <= 0 {
+		l.maxSenders = defaultRateLimitSenders
+	}
+	return l
+}
+
+//allow consumes a token of sender's bucket and returns true if there was one. first is true if
+//the message is the first one of sender which is dropped since its last accepted message. A nil
+//rate limiter allows all messages.
+func (l *rateLimiter) allow(sender net.Addr) (ok, first bool) {
+	if l == nil {
+		return true, false
+	}
+	key := l.senderKey(sender)
+	now := l.now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	b, found := l.buckets[key]
+	if !found {
+		if len(l.buckets) >= l.maxSenders {
+			l.prune(now)
+		}
+		if len(l.buckets) >= l.maxSenders {
+			key = overflowSender
+			b = l.buckets[key]
+		}
+		if b == nil {
+			b = &tokenBucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		first = !b.limited
+		b.limited = true
+		atomic.AddUint64(&l.dropped, 1)
+		return false, first
+	}
+	b.tokens--
+	b.limited = false
+	return true, false
+}
+
+//refill adds the tokens to b which have accumulated since the last refill.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+//prune removes all token buckets which are full as they do not restrict their senders anymore.
+//The caller must hold the lock.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+//senderKey returns the key of the token bucket of sender. The IP address is truncated to the
+//configured prefix length. SCION addresses are additionally distinguished by their ISD-AS.
+func (l *rateLimiter) senderKey(sender net.Addr) string {
+	var ip net.IP
+	prefix := ""
+	switch addr := sender.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *snet.UDPAddr:
+		if addr.Host == nil {
+			return addr.IA.String()
+		}
+		ip = addr.Host.IP
+		prefix = addr.IA.String() + ","
+	default:
+		return sender.String()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return prefix + ip4.Mask(l.ipv4Mask).String()
+	}
+	return prefix + ip.Mask(l.ipv6Mask).String()
+}
+
+//state returns the number of senders with a token bucket, the number of those which are currently
+//limited and the number of dropped messages.
+func (l *rateLimiter) state() (senders, limited int, dropped uint64) {
+	if l == nil {
+		return 0, 0, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, b := range l.buckets {
+		if b.limited {
+			limited++
+		}
+	}
+	return len(l.buckets), limited, atomic.LoadUint64(&l.dropped)
+}
+
+//isRateLimited returns true if msg from sender must be dropped because sender exceeded its rate
+//limit. Responses to pending requests of this server are never limited. If configured, sender is
+//notified about the first dropped message since its last accepted message.
+func (s *Server) isRateLimited(msg *message.Message, sender net.Addr) bool {
+	if s.rateLimiter == nil || s.caches.PendingKeys.ContainsToken(msg.Token) ||
+		s.caches.PendingMessages.ContainsToken(msg.Token) {
+		return false
+	}
+	ok, first := s.rateLimiter.allow(sender)
+	if ok {
+		return false
+	}
+	if first {
+		log.Info("Sender exceeded its rate limit", "sender", sender, "rate", s.config().RateLimit,
+			"burst", s.config().RateLimitBurst)
+		if s.config().RateLimitNotify {
+			sendNotificationMsg(msg.Token, sender, section.NTUnspecServerErr,
+				"rate limit exceeded", s)
+		}
+	}
+	return true
+}
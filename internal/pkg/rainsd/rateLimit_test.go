@@ -0,0 +1,56 @@
+package rainsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if l := newRateLimiter(Config{}); l != nil {
+		t.Fatal("Rate limiter must be disabled without a rate")
+	}
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(Config{RateLimit: 2, RateLimitBurst: 3, RateLimitIPv4Prefix: 24,
+		RateLimitMaxSenders: 2})
+	l.now = func() time.Time { return now }
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1} }
+
+	var tests = []struct {
+		advance time.Duration
+		sender  string
+		ok      bool
+		first   bool
+	}{
+		{0, "192.0.2.1", true, false},
+		{0, "192.0.2.1", true, false},
+		//same prefix shares the bucket
+		{0, "192.0.2.200", true, false},
+		{0, "192.0.2.1", false, true},
+		{0, "192.0.2.200", false, false},
+		{0, "198.51.100.1", true, false},
+		//refilled with 2 tokens per second
+		{500 * time.Millisecond, "192.0.2.1", true, false},
+		{0, "192.0.2.1", false, true},
+		//the full bucket of 198.51.100.1 is pruned to make space for a new sender
+		{0, "2001:db8::1", true, false},
+		//additional senders share the overflow bucket
+		{0, "2001:db8::2", true, false},
+		{0, "2001:db8::3", true, false},
+		{0, "2001:db8::4", true, false},
+		{0, "2001:db8::5", false, true},
+		{10 * time.Second, "2001:db8::6", true, false},
+	}
+	for i, test := range tests {
+		now = now.Add(test.advance)
+		ok, first := l.allow(addr(test.sender))
+		if ok != test.ok || first != test.first {
+			t.Errorf("%d: wrong result for %s. expected=(%v,%v) actual=(%v,%v)", i, test.sender,
+				test.ok, test.first, ok, first)
+		}
+	}
+	senders, limited, dropped := l.state()
+	if senders != 1 || limited != 0 || dropped != 4 {
+		t.Errorf("wrong state. expected=(1,0,4) actual=(%d,%d,%d)", senders, limited, dropped)
+	}
+}
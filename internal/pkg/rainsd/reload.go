@@ -0,0 +1,97 @@
+package rainsd
+
+import (
+	"reflect"
+
+	log "github.com/inconshreveable/log15"
+)
+
+//reloadableFields contains the names of the config fields which can be changed while the server
+//is running.
+var reloadableFields = map[string]bool{
+	"Authorities":      true,
+	"MaxCacheValidity": true,
+	"ZoneFiles":        true,
+	//cache sizes
+	"MaxConnections":             true,
+	"CapabilitiesCacheSize":      true,
+	"ZoneKeyCacheSize":           true,
+	"ZoneKeyCacheWarnSize":       true,
+	"PendingKeyCacheSize":        true,
+	"PendingQueryCacheSize":      true,
+	"AssertionCacheSize":         true,
+	"NegativeAssertionCacheSize": true,
+	//reap intervals
+	"ReapZoneKeyCacheInterval":      true,
+	"ReapPendingKeyCacheInterval":   true,
+	"ReapAssertionCacheInterval":    true,
+	"ReapNegAssertionCacheInterval": true,
+	"ReapPendingQCacheInterval":     true,
+	//prefetching
+	"PrefetchBudget":   true,
+	"PrefetchInterval": true,
+	"PrefetchWindow":   true,
+	"PrefetchMinHits":  true,
+	//serve-stale
+	"StaleGracePeriod": true,
+	"ServeStaleToAll":  true,
+	//delegation queries
+	"DelegationQueryRetries": true,
+	"DelegationQueryBackoff": true,
+	//worker counts
+	"PrioWorkerCount":         true,
+	"NormalWorkerCount":       true,
+	"NotificationWorkerCount": true,
+	//tls certificate
+	"TLSCertificateFile": true,
+	"TLSPrivateKeyFile":  true,
+}
+
+//Reload applies those fields of config which can be changed while the server is running: the
+//authorities, the cache sizes, the reap intervals, the prefetch, serve-stale and delegation query
+//retry settings, the worker counts, the maximum cache validities and the tls certificate, which is
+//read again from its files. The zonefiles are loaded again even if their paths did not change. It
+//returns the names of all other fields which differ from the current configuration. They are
+//ignored and only take effect after a restart. Nothing is applied if an error is returned.
+func (s *Server) Reload(config Config) ([]string, error) {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+	updated, restart := mergeReloadable(*s.config(), config)
+	creds, err := loadTLSCredentials(updated)
+	if err != nil {
+		return nil, err
+	}
+	zonefiles, err := s.readZonefiles(&updated)
+	if err != nil {
+		return nil, err
+	}
+	s.currentTLS.Store(creds)
+	s.currentConfig.Store(&updated)
+	resizeCaches(&updated, s.caches)
+	s.caches.AssertionsCache.SetStaleGracePeriod(updated.StaleGracePeriod)
+	s.queues.resize(prioQueue, updated.PrioWorkerCount)
+	s.queues.resize(normalQueue, updated.NormalWorkerCount)
+	s.queues.resize(notifyQueue, updated.NotificationWorkerCount)
+	s.addZonefileContent(zonefiles)
+	log.Info("Reloaded configuration", "id", s.id, "restartRequired", restart)
+	return restart, nil
+}
+
+//mergeReloadable returns current with all reloadable fields set to the values in config and the
+//names of the other fields whose values differ in config.
+func mergeReloadable(current, config Config) (Config, []string) {
+	restart := []string{}
+	updated := reflect.ValueOf(&current).Elem()
+	values := reflect.ValueOf(config)
+	for i := 0; i < updated.NumField(); i++ {
+		if reflect.DeepEqual(updated.Field(i).Interface(), values.Field(i).Interface()) {
+			continue
+		}
+		if name := updated.Type().Field(i).Name; reloadableFields[name] {
+			updated.Field(i).Set(values.Field(i))
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	return current, restart
+}
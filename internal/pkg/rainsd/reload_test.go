@@ -0,0 +1,41 @@
+package rainsd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+)
+
+func TestMergeReloadable(t *testing.T) {
+	current := DefaultConfig()
+	config := DefaultConfig()
+	if _, restart := mergeReloadable(current, config); len(restart) != 0 {
+		t.Errorf("equal configs must not require a restart. actual=%v", restart)
+	}
+	addr, _ := net.ResolveTCPAddr("", "127.0.0.1:5000")
+	config.ServerAddress = connection.Info{Type: connection.TCP, Addr: addr}
+	config.PrioBufferSize = 5
+	config.NormalWorkerCount = 3
+	config.AssertionCacheSize = 10
+	config.ReapAssertionCacheInterval = time.Minute
+	config.Authorities = []ZoneContext{{Zone: "ch.", Context: "."}}
+	config.TLSCertificateFile = "new.crt"
+	updated, restart := mergeReloadable(current, config)
+	if expected := []string{"ServerAddress", "PrioBufferSize"}; !reflect.DeepEqual(restart, expected) {
+		t.Errorf("wrong restart fields. expected=%v actual=%v", expected, restart)
+	}
+	if updated.ServerAddress != current.ServerAddress || updated.PrioBufferSize != 50 {
+		t.Error("fields requiring a restart must keep their current value")
+	}
+	if updated.NormalWorkerCount != 3 || updated.AssertionCacheSize != 10 ||
+		updated.ReapAssertionCacheInterval != time.Minute || len(updated.Authorities) != 1 ||
+		updated.TLSCertificateFile != "new.crt" {
+		t.Errorf("reloadable fields were not updated. actual=%+v", updated)
+	}
+	if current.NormalWorkerCount != 10 {
+		t.Error("current config must not be modified")
+	}
+}
@@ -0,0 +1,257 @@
+package rainsd
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+const (
+	//maxCoalescedSections is the maximum number of sections of a message into which queued
+	//messages are coalesced.
+	maxCoalescedSections = 32
+	//sendQueueIdleTimeout is the time after which the writer of an empty send queue stops.
+	sendQueueIdleTimeout = 10 * time.Second
+	//defaultSendQueueSize is the maximum number of messages in a send queue if no size is
+	//configured.
+	defaultSendQueueSize = 100
+	//maxSendQueues is the maximum number of destinations with a send queue. Together with the
+	//size of a send queue it bounds the number of queued messages.
+	maxSendQueues = 10000
+)
+
+//errSendQueueFull is returned when a message is sent to a destination whose send queue is full.
+var errSendQueueFull = errors.New("send queue of receiver is full")
+
+//errTooManySendQueues is returned when a message is sent to a destination without a send queue
+//while there are already maxSendQueues send queues.
+var errTooManySendQueues = errors.New("too many destinations with a send queue")
+
+//errSendQueuesClosed is returned when a message is sent after the send queues have been closed.
+var errSendQueuesClosed = errors.New("server does not send messages anymore")
+
+//outgoing is a message waiting in a send queue.
+type outgoing struct {
+	msg message.Message
+	//retries is the number of times sending msg is tried.
+	retries int
+	//backoff is the time waited before the first retry. It is doubled with each retry.
+	backoff time.Duration
+	//parts contains the messages which have been coalesced into msg. It is nil if msg has not
+	//been coalesced.
+	parts []outgoing
+}
+
+//sendQueue contains the messages waiting to be sent to receiver by the queue's writer.
+type sendQueue struct {
+	receiver net.Addr
+	msgs     chan outgoing
+}
+
+//sendQueues contains a send queue for each destination to which messages are being sent.
+type sendQueues struct {
+	queues map[string]*sendQueue
+	//size is the maximum number of messages in a send queue.
+	size int
+	//maxQueues is the maximum number of send queues.
+	maxQueues int
+	closed    bool
+	//dropped counts the messages which have been dropped because their send queue was full or
+	//there were too many send queues.
+	dropped int
+	//mux protects queues, closed and dropped from simultaneous access.
+	mux     sync.Mutex
+	writers sync.WaitGroup
+}
+
+func newSendQueues(size int) *sendQueues {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+	return &sendQueues{queues: make(map[string]*sendQueue), size: size, maxQueues: maxSendQueues}
+}
+
+//destination returns the key of receiver's send queue.
+func destination(receiver net.Addr) string {
+	return receiver.Network() + " " + receiver.String()
+}
+
+//push adds o to the send queue of receiver. If receiver has no send queue, a new one is created
+//and write is started in a new go routine to send its messages. o is dropped if the send queue is
+//full or if there are already maxQueues send queues.
+func (q *sendQueues) push(o outgoing, receiver net.Addr, write func(*sendQueue)) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.closed {
+		return errSendQueuesClosed
+	}
+	queue, ok := q.queues[destination(receiver)]
+	if !ok && len(q.queues) >= q.maxQueues {
+		q.dropped++
+		return errTooManySendQueues
+	}
+	if !ok {
+		queue = &sendQueue{receiver: receiver, msgs: make(chan outgoing, q.size)}
+		q.queues[destination(receiver)] = queue
+		q.writers.Add(1)
+		go func() {
+			defer q.writers.Done()
+			write(queue)
+		}()
+	}
+	select {
+	case queue.msgs <- o:
+		return nil
+	default:
+		q.dropped++
+		return errSendQueueFull
+	}
+}
+
+//removeIfEmpty removes queue if it is empty and returns true. Messages are only pushed to a
+//queue while holding q.mux such that no message is lost.
+func (q *sendQueues) removeIfEmpty(queue *sendQueue) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if len(queue.msgs) > 0 || q.closed {
+		return false
+	}
+	delete(q.queues, destination(queue.receiver))
+	return true
+}
+
+//state returns the number of queued messages and the number of dropped messages.
+func (q *sendQueues) state() (queued, dropped int) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for _, queue := range q.queues {
+		queued += len(queue.msgs)
+	}
+	return queued, q.dropped
+}
+
+//close stops accepting messages. The writers send the already queued messages and stop.
+func (q *sendQueues) close() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, queue := range q.queues {
+		close(queue.msgs)
+	}
+}
+
+//wait blocks until all writers have stopped.
+func (q *sendQueues) wait() {
+	q.writers.Wait()
+}
+
+//writeQueue sends the messages of queue until it is closed or has been idle for
+//sendQueueIdleTimeout. All messages waiting in the queue are coalesced where possible and sent
+//together.
+func (s *Server) writeQueue(queue *sendQueue) {
+	idle := time.NewTimer(sendQueueIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case o, ok := <-queue.msgs:
+			if !ok {
+				return
+			}
+			batch, open := []outgoing{o}, true
+			for open && len(batch) < cap(queue.msgs) {
+				select {
+				case o, ok := <-queue.msgs:
+					if ok {
+						batch = append(batch, o)
+					}
+					open = ok
+				default:
+					open = false
+				}
+			}
+			for _, o := range coalesce(batch) {
+				if err := s.write(o, queue.receiver); err != nil {
+					log.Warn("Was not able to send message", "receiver", queue.receiver,
+						"error", err)
+					s.sendFailed(o)
+				}
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(sendQueueIdleTimeout)
+		case <-idle.C:
+			if s.sendQueues.removeIfEmpty(queue) {
+				return
+			}
+			idle.Reset(sendQueueIdleTimeout)
+		}
+	}
+}
+
+//sendFailed reports to the pending caches that o could not be sent. Sections and queries waiting
+//for an answer to o are dropped and their senders are notified.
+func (s *Server) sendFailed(o outgoing) {
+	if o.parts != nil {
+		for _, part := range o.parts {
+			s.sendFailed(part)
+		}
+		return
+	}
+	dropPendingSectionsAndQueries(o.msg.Token, nil, true, s)
+}
+
+//coalesce combines consecutive messages of batch which can be sent together into one message.
+func coalesce(batch []outgoing) []outgoing {
+	merged := []outgoing{}
+	for _, o := range batch {
+		if n := len(merged); n > 0 && canCoalesce(merged[n-1].msg, o.msg) {
+			last := &merged[n-1]
+			if last.parts == nil {
+				last.parts = []outgoing{*last}
+				last.msg.Content = append([]section.Section{}, last.msg.Content...)
+			}
+			last.parts = append(last.parts, o)
+			last.msg.Content = append(last.msg.Content, o.msg.Content...)
+			if o.retries > last.retries {
+				last.retries = o.retries
+			}
+			if o.backoff > last.backoff {
+				last.backoff = o.backoff
+			}
+			continue
+		}
+		merged = append(merged, o)
+	}
+	return merged
+}
+
+//canCoalesce returns true if the sections of b can be appended to the ones of a. The receiver
+//must not be able to tell the difference. This is the case if both messages have the same token
+//or if they only contain notifications, which carry their own token. Messages with a capability
+//list are sent on their own.
+func canCoalesce(a, b message.Message) bool {
+	if len(a.Capabilities) > 0 || len(b.Capabilities) > 0 || len(a.Content) == 0 ||
+		len(b.Content) == 0 || len(a.Content)+len(b.Content) > maxCoalescedSections {
+		return false
+	}
+	return a.Token == b.Token || (onlyNotifications(a) && onlyNotifications(b))
+}
+
+//onlyNotifications returns true if all sections of msg are notifications.
+func onlyNotifications(msg message.Message) bool {
+	for _, sec := range msg.Content {
+		if _, ok := sec.(*section.Notification); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package rainsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestCoalesce(t *testing.T) {
+	tok := token.New()
+	notification := func() outgoing {
+		return outgoing{msg: message.Message{Token: token.New(), Content: []section.Section{
+			&section.Notification{Token: token.New(), Type: section.NTNoAssertionAvail}}}}
+	}
+	answer := func(tok token.Token, n int) outgoing {
+		o := outgoing{msg: message.Message{Token: tok}}
+		for i := 0; i < n; i++ {
+			o.msg.Content = append(o.msg.Content, ip4Query("www."))
+		}
+		return o
+	}
+	capabilities := outgoing{msg: message.Message{Token: token.New(),
+		Capabilities: []message.Capability{message.TLSOverTCP}}}
+	var tests = []struct {
+		name     string
+		batch    []outgoing
+		sections []int
+	}{
+		{"notifications", []outgoing{notification(), notification(), notification()}, []int{3}},
+		{"same token", []outgoing{answer(tok, 1), answer(tok, 2)}, []int{3}},
+		{"different tokens", []outgoing{answer(tok, 1), answer(token.New(), 1)}, []int{1, 1}},
+		{"mixed", []outgoing{notification(), answer(tok, 1), notification()}, []int{1, 1, 1}},
+		{"capabilities", []outgoing{capabilities, capabilities}, []int{0, 0}},
+		{"too large", []outgoing{answer(tok, maxCoalescedSections), answer(tok, 1)}, []int{32, 1}},
+	}
+	for i, test := range tests {
+		merged := coalesce(test.batch)
+		if len(merged) != len(test.sections) {
+			t.Fatalf("%d: %s: wrong number of messages. expected=%d actual=%d", i, test.name,
+				len(test.sections), len(merged))
+		}
+		for j, o := range merged {
+			if len(o.msg.Content) != test.sections[j] {
+				t.Errorf("%d: %s: message %d has wrong number of sections. expected=%d actual=%d",
+					i, test.name, j, test.sections[j], len(o.msg.Content))
+			}
+			if len(o.parts) > 1 && o.parts[0].msg.Token != o.msg.Token {
+				t.Errorf("%d: %s: coalesced message has wrong token", i, test.name)
+			}
+		}
+	}
+	//The first message of a coalesced batch must not be changed.
+	batch := []outgoing{answer(tok, 1), answer(tok, 1)}
+	coalesce(batch)
+	if len(batch[0].msg.Content) != 1 {
+		t.Errorf("coalescing changed the queued message: %v", batch[0].msg.Content)
+	}
+}
+
+func TestSendQueueFull(t *testing.T) {
+	q := newSendQueues(2)
+	block := make(chan struct{})
+	defer close(block)
+	write := func(*sendQueue) { <-block }
+	receiver := connection.MemoryAddr("TestSendQueueFull")
+	for i := 0; i < 2; i++ {
+		if err := q.push(outgoing{}, receiver, write); err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+	}
+	if err := q.push(outgoing{}, receiver, write); err != errSendQueueFull {
+		t.Errorf("full queue accepted message: %v", err)
+	}
+	if err := q.push(outgoing{}, connection.MemoryAddr("TestSendQueueFullOther"), write); err != nil {
+		t.Errorf("queue of other destination is affected: %v", err)
+	}
+	if queued, dropped := q.state(); queued != 3 || dropped != 1 {
+		t.Errorf("wrong state. expected=(3,1) actual=(%d,%d)", queued, dropped)
+	}
+	q.close()
+	if err := q.push(outgoing{}, receiver, write); err != errSendQueuesClosed {
+		t.Errorf("closed queues accepted message: %v", err)
+	}
+}
+
+func TestTooManySendQueues(t *testing.T) {
+	q := newSendQueues(2)
+	q.maxQueues = 2
+	block := make(chan struct{})
+	defer close(block)
+	write := func(*sendQueue) { <-block }
+	for i, name := range []string{"TestTooManySendQueues1", "TestTooManySendQueues2"} {
+		if err := q.push(outgoing{}, connection.MemoryAddr(name), write); err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+	}
+	if err := q.push(outgoing{}, connection.MemoryAddr("TestTooManySendQueues3"),
+		write); err != errTooManySendQueues {
+		t.Errorf("send queue was created above the limit: %v", err)
+	}
+	if err := q.push(outgoing{}, connection.MemoryAddr("TestTooManySendQueues1"), write); err != nil {
+		t.Errorf("existing send queue is affected by the limit: %v", err)
+	}
+	if queued, dropped := q.state(); queued != 3 || dropped != 1 {
+		t.Errorf("wrong state. expected=(3,1) actual=(%d,%d)", queued, dropped)
+	}
+}
+
+func TestSendFailedTooManySendQueues(t *testing.T) {
+	config := memoryConfig(t, "TestSendFailedTooManySendQueues", DefaultConfig().Capabilities)
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	client := connection.MemoryAddr("TestSendFailedTooManySendQueuesClient")
+	msgs := memoryPeer(t, client)
+	tok, queryTok := token.New(), token.New()
+	s.caches.PendingKeys.Add(util.MsgSectionSender{Sender: client, Token: tok,
+		Sections: []section.Section{ip4Query("www.")}}, queryTok, time.Now().Add(time.Minute).Unix())
+
+	//The send queue of client exists such that it can still be notified.
+	sendNotificationMsg(token.New(), client, section.NTHeartbeat, "", s)
+	<-msgs
+	s.sendQueues.mux.Lock()
+	s.sendQueues.maxQueues = len(s.sendQueues.queues)
+	s.sendQueues.mux.Unlock()
+	msg := message.Message{Token: queryTok, Content: []section.Section{ip4Query("example.")}}
+	if err := s.sendTo(msg, connection.MemoryAddr("TestSendFailedTooManySendQueuesPeer"), 0,
+		0); err != errTooManySendQueues {
+		t.Fatalf("message was queued above the limit: %v", err)
+	}
+	waitForNotification(t, msgs, tok, section.NTUnspecServerErr, time.Second)
+	if s.caches.PendingKeys.ContainsToken(queryTok) {
+		t.Errorf("sections waiting for the answer to the dropped message are still pending")
+	}
+}
+
+func TestSendFailed(t *testing.T) {
+	config := memoryConfig(t, "TestSendFailed", DefaultConfig().Capabilities)
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	client := connection.MemoryAddr("TestSendFailedClient")
+	msgs := memoryPeer(t, client)
+	tok, queryTok := token.New(), token.New()
+	s.caches.PendingKeys.Add(util.MsgSectionSender{Sender: client, Token: tok,
+		Sections: []section.Section{ip4Query("www.")}}, queryTok, time.Now().Add(time.Minute).Unix())
+
+	//Nobody listens on the address such that the message cannot be sent.
+	msg := message.Message{Token: queryTok, Content: []section.Section{ip4Query("example.")}}
+	if err := s.sendTo(msg, connection.MemoryAddr("TestSendFailedPeer"), 1, 0); err != nil {
+		t.Fatalf("Was not able to queue message: %v", err)
+	}
+	waitForNotification(t, msgs, tok, section.NTUnspecServerErr, time.Second)
+	if s.caches.PendingKeys.ContainsToken(queryTok) {
+		t.Errorf("sections waiting for the answer to the failed message are still pending")
+	}
+}
@@ -1,77 +1,132 @@
 package rainsd
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
-	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
-const (
-	nofReapers       = 3
-	nofCheckPointers = 3
-	noListeners      = 1
-	shutdownChannels = nofReapers + nofCheckPointers + noListeners
-)
+//defaultDrainTimeout is the time a server waits for its routines to stop during shut down if no
+//drain timeout is configured.
+const defaultDrainTimeout = 5 * time.Second
 
 //Server represents a rainsd server instance.
 type Server struct {
 	//resolver can be configured as a forwarder or perform recursive lookup by itself.
 	resolver *libresolve.Resolver
-	//config contains configurations of this server
-	config Config
-	//authority states the names over which this server has authority
-	authority map[ZoneContext]bool
-	//certPool stores received certificates
-	certPool *x509.CertPool
-	//tlsCert holds the tls certificate of this server
-	tlsCert tls.Certificate
+	//currentConfig holds a *Config with the configuration of this server. It is replaced when the
+	//configuration is reloaded.
+	currentConfig atomic.Value
+	//currentTLS holds the *tlsCredentials of this server. They are replaced when the configuration
+	//is reloaded.
+	currentTLS atomic.Value
+	//reloadMutex serializes reloads of the configuration.
+	reloadMutex sync.Mutex
 	//capabilityHash contains the sha256 hash of this server's capability list
 	capabilityHash string
 	//capabilityList contains the string representation of this server's capability list.
 	capabilityList string
-	//shutdown can be used to stop the go routines handling the input channels and closes them.
-	shutdown chan bool
+	//id identifies this server in log messages.
+	id string
 	//queues store the incoming sections and keeps track of how many go routines are working on it.
-	queues InputQueues
+	queues *InputQueues
 	//caches contains all caches of this server
 	caches *Caches
-	//packetConn is the server UDP socket if we are in that mode, or nil otherwise.
-	packetConn net.PacketConn
+	//pendingQueryTimer and pendingKeyTimer expire the entries of the pending query and pending key
+	//cache as soon as they expire.
+	pendingQueryTimer *pendingTimer
+	pendingKeyTimer   *pendingTimer
+	//keyRequests contains the delegation queries sent to peers which have not been answered yet.
+	keyRequests *keyRequests
+	//peerStandings records which peers failed to supply the keys their sections are signed with.
+	peerStandings *peerStandings
+	//listeners accept tls over tcp connections on the server's TCP and UDP addresses.
+	listeners []net.Listener
+	//packetConns contains the server's datagram sockets by the type of their address. Messages to
+	//an address of this type are sent over the corresponding socket.
+	packetConns map[connection.Type]net.PacketConn
+	//sendQueues contains the messages waiting to be sent by destination.
+	sendQueues *sendQueues
+	//blacklist contains IP address ranges and zones from which no traffic is accepted.
+	blacklist *blacklist
+	//rateLimiter limits the number of messages accepted from each sender. It is nil if rate
+	//limiting is disabled.
+	rateLimiter *rateLimiter
+	//infraKey holds the private infrastructure key with which outgoing messages are signed. It is
+	//nil if messages are not signed.
+	infraKey map[keys.PublicKeyID]interface{}
+	//signedMessagePeers contains the IP address ranges of peers whose messages must be signed.
+	signedMessagePeers []*net.IPNet
+	//metrics contains counters about the server's operation.
+	metrics *metrics
+	//metricsServer serves the metrics over http. It is nil if metrics are not served.
+	metricsServer *http.Server
+	//adminServer serves the admin api over http. It is nil if the admin api is not served.
+	adminServer *http.Server
+	//ready is closed as soon as the server accepts messages.
+	ready chan struct{}
+	//stopped is closed when a started server has stopped.
+	stopped chan struct{}
+	//stop ends a started server. It is nil if the server has not been started.
+	stop context.CancelFunc
+	//isShutDown is true if Shutdown has been called.
+	isShutDown bool
+	//mutex protects stop and isShutDown from simultaneous access.
+	mutex sync.Mutex
 }
 
 //New returns a pointer to a newly created rainsd server instance with the given config. The server
 //logs with the provided level of logging.
 func New(config Config, id string) (server *Server, err error) {
 	log.Info("server New", "id", id)
-	server = &Server{config: config}
-	server.authority = make(map[ZoneContext]bool)
-	for _, auth := range server.config.Authorities {
-		server.authority[auth] = true
+	server = &Server{id: id, ready: make(chan struct{}), stopped: make(chan struct{})}
+	server.currentConfig.Store(&config)
+	creds, err := loadTLSCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+	server.currentTLS.Store(creds)
+	if server.infraKey, err = loadInfraKey(config.InfraKeyPath,
+		config.InfraKeyName); err != nil {
+		return nil, err
+	}
+	if server.signedMessagePeers, err = parseSignedMessagePeers(
+		config.SignedMessagePeers); err != nil {
+		return nil, err
 	}
-	if server.certPool, server.tlsCert, err = loadTLSCertificate(server.config.TLSCertificateFile,
-		server.config.TLSPrivateKeyFile); err != nil {
+	if server.capabilityHash, server.capabilityList, err = initOwnCapabilities(
+		config.Capabilities); err != nil {
 		return nil, err
 	}
-	server.capabilityHash, server.capabilityList = initOwnCapabilities(server.config.Capabilities)
-
-	server.shutdown = make(chan bool, shutdownChannels)
-	server.queues = InputQueues{
-		Prio:    make(chan util.MsgSectionSender, server.config.PrioBufferSize),
-		Normal:  make(chan util.MsgSectionSender, server.config.NormalBufferSize),
-		Notify:  make(chan util.MsgSectionSender, server.config.NotificationBufferSize),
-		PrioW:   make(chan struct{}, server.config.PrioWorkerCount),
-		NormalW: make(chan struct{}, server.config.NormalWorkerCount),
-		NotifyW: make(chan struct{}, server.config.NotificationWorkerCount),
-	}
-	log.Debug("Created server channels")
-	server.caches = initCaches(server.config)
-	if err = loadRootZonePublicKey(server.config.RootZonePublicKeyPath, server.caches.ZoneKeyCache,
-		server.config.MaxCacheValidity); err != nil {
+
+	server.queues = newInputQueues(config)
+	log.Debug("Created server input queues")
+	if server.blacklist, err = newBlacklist(config.BlacklistPath); err != nil {
+		return nil, err
+	}
+	server.rateLimiter = newRateLimiter(config)
+	server.caches = initCaches(config, server.blockMisbehavingZone)
+	server.caches.Capabilities.Add(config.Capabilities)
+	server.pendingQueryTimer = newPendingTimer(server.expirePendingQueries,
+		server.caches.PendingQueries.GetAll)
+	server.pendingKeyTimer = newPendingTimer(server.expirePendingKeys,
+		server.caches.PendingKeys.GetAll)
+	server.keyRequests = newKeyRequests()
+	server.sendQueues = newSendQueues(config.SendQueueSize)
+	server.peerStandings = newPeerStandings()
+	server.metrics = newMetrics(server)
+	if err = loadRootZonePublicKey(config.RootZonePublicKeyPath, server.caches.ZoneKeyCache,
+		config.MaxCacheValidity); err != nil {
 		log.Warn("Failed to load root zone public key")
 		return nil, err
 	}
@@ -82,11 +137,22 @@ func New(config Config, id string) (server *Server, err error) {
 
 //Addr returns the server's address
 func (s *Server) Addr() net.Addr {
-	return s.config.ServerAddress.Addr
+	return s.config().ServerAddress.Addr
 }
 
+//Config returns the current configuration of the server.
 func (s *Server) Config() Config {
-	return s.config
+	return *s.config()
+}
+
+//config returns the current configuration of the server. It must not be modified.
+func (s *Server) config() *Config {
+	return s.currentConfig.Load().(*Config)
+}
+
+//credentials returns the current tls credentials of the server.
+func (s *Server) credentials() *tlsCredentials {
+	return s.currentTLS.Load().(*tlsCredentials)
 }
 
 //SetResolver adds a resolver which can forward or recursively resolve queries for this server
@@ -94,52 +160,159 @@ func (s *Server) SetResolver(resolver *libresolve.Resolver) {
 	s.resolver = resolver
 }
 
+//ReloadBlacklist reloads the blacklist file. Zones which have been blacklisted at runtime due to
+//misbehavior are kept until their block expires or they are unblocked over the admin api.
+func (s *Server) ReloadBlacklist() error {
+	return s.blacklist.load()
+}
+
+//Ready returns a channel which is closed as soon as the server accepts messages.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+//Run starts up the server and serves messages according to its config until ctx is done or
+//Shutdown is called. The server's metrics are served if a metrics address is configured. Run
+//returns after all listeners, reapers, checkpointers and workers have stopped. An error is
+//returned if the server could not be started or did not stop within the drain timeout.
+func (s *Server) Run(ctx context.Context) error {
+	return s.run(ctx, s.config().MetricsAddress != "", s.id)
+}
+
 //Start starts up the server and it begins to listen for incoming connections according to its
-//config.
+//config. If monitorResources is true, the server's metrics are served at the configured metrics
+//address. Start blocks until the server is shut down.
 func (s *Server) Start(monitorResources bool, id string) error {
-	go s.workPrio()
-	go s.workBoth()
-	go s.workNotification()
+	return s.run(context.Background(), monitorResources, id)
+}
+
+func (s *Server) run(ctx context.Context, monitorResources bool, id string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mutex.Lock()
+	if s.isShutDown || s.stop != nil {
+		s.mutex.Unlock()
+		return errors.New("server has already been started or shut down")
+	}
+	s.stop = cancel
+	s.mutex.Unlock()
+	defer close(s.stopped)
+
+	srvLogger := log.New("id", id)
+	if err := s.openListeners(srvLogger); err != nil {
+		s.queues.close()
+		return err
+	}
+	if monitorResources {
+		if err := s.serveMetrics(); err != nil {
+			log.Error("Was not able to serve metrics", "addr", s.config().MetricsAddress, "error", err)
+			s.closeListeners()
+			s.queues.close()
+			return err
+		}
+	}
+	if err := s.serveAdmin(); err != nil {
+		log.Error("Was not able to serve admin api", "addr", s.config().AdminAddress, "error", err)
+		if s.metricsServer != nil {
+			s.metricsServer.Close()
+		}
+		s.closeListeners()
+		s.queues.close()
+		return err
+	}
+	s.queues.work(prioQueue, s.verify)
+	s.queues.work(normalQueue, s.verify)
+	s.queues.work(notifyQueue, s.notify)
 	log.Debug("Goroutines working on input queue started")
-	initReapers(s.config, s.caches, s.shutdown)
-	if s.config.PreLoadCaches {
-		loadCaches(s.config.CheckPointPath, s.caches, s.config.Authorities)
+	routines := &sync.WaitGroup{}
+	s.initReapers(ctx.Done(), routines)
+	if s.config().PreLoadCaches {
+		s.loadCaches()
 		log.Info("Caches loaded from checkpoint",
 			"assertions", s.caches.AssertionsCache.Len(),
 			"negAssertions", s.caches.NegAssertionCache.Len(),
 			"zoneKey", s.caches.ZoneKeyCache.Len())
 	}
-	initStoreCachesContent(s.config, s.caches, s.shutdown)
-	log.Info("Reapers and Checkpointing started")
-	if monitorResources {
-		go measureSystemRessources()
+	if err := s.loadZonefiles(); err != nil {
+		log.Error("Was not able to load zonefiles", "error", err)
+		cancel()
+		s.drain(routines)
+		return err
 	}
-	s.listen(id)
-	return nil
+	initStoreCachesContent(*s.config(), s.caches, ctx.Done(), routines)
+	s.initPrefetcher(ctx.Done(), routines)
+	log.Info("Reapers, checkpointing and prefetching started")
+	routines.Add(1)
+	go func() {
+		defer routines.Done()
+		s.serve(ctx, srvLogger, routines)
+		//The server stops if its listeners fail.
+		cancel()
+	}()
+	close(s.ready)
+
+	<-ctx.Done()
+	return s.drain(routines)
 }
 
-//Shutdown closes the input channels and stops the function creating new go routines to handle the
-//input. Already running worker go routines will finish eventually.
-func (s *Server) Shutdown() {
-	for i := 0; i < shutdownChannels; i++ {
-		s.shutdown <- true
+//drain stops the listeners and stops accepting new input. The workers then handle the already
+//queued messages and their answers are sent before all connections are closed. drain waits at
+//most for the drain timeout until all routines and the workers have stopped.
+func (s *Server) drain(routines *sync.WaitGroup) error {
+	s.closeListeners()
+	s.pendingQueryTimer.stop()
+	s.pendingKeyTimer.stop()
+	s.keyRequests.stop()
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
 	}
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
+	s.queues.close()
 
-	// Unblock the switchboard listener to get the shutdown message delivered
-	switch s.config.ServerAddress.Type {
-	case connection.TCP:
-		if conn, err := net.Dial(s.Addr().Network(), s.Addr().String()); err == nil {
-			conn.Close()
-		}
-	case connection.SCION:
-		s.packetConn.Close()
-	default:
-		log.Warn("Unsupported Network address type.")
+	drained := make(chan struct{})
+	go func() {
+		s.queues.wait()
+		s.sendQueues.close()
+		s.sendQueues.wait()
+		//The connections are closed only after the answers of the workers have been sent.
+		s.caches.ConnCache.CloseAndRemoveAllConnections()
+		routines.Wait()
+		close(drained)
+	}()
+	timeout := s.config().DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
 	}
+	select {
+	case <-drained:
+		log.Info("Server shut down", "id", s.id)
+		return nil
+	case <-time.After(timeout):
+		log.Warn("Server did not drain in time", "id", s.id, "timeout", timeout)
+		s.sendQueues.close()
+		s.caches.ConnCache.CloseAndRemoveAllConnections()
+		return fmt.Errorf("server did not drain within %v", timeout)
+	}
+}
 
-	s.caches.ConnCache.CloseAndRemoveAllConnections()
-	s.queues.Normal <- util.MsgSectionSender{}
-	s.queues.Prio <- util.MsgSectionSender{}
-	s.queues.Notify <- util.MsgSectionSender{}
-	log.Info("Server shut down")
+//Shutdown stops the server and waits until it has drained. A server which has been shut down
+//cannot be started again.
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	s.isShutDown = true
+	stop := s.stop
+	s.mutex.Unlock()
+	if stop == nil {
+		s.pendingQueryTimer.stop()
+		s.pendingKeyTimer.stop()
+		s.keyRequests.stop()
+		s.sendQueues.close()
+		s.caches.ConnCache.CloseAndRemoveAllConnections()
+		s.queues.close()
+		return
+	}
+	stop()
+	<-s.stopped
 }
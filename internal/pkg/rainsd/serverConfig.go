@@ -18,31 +18,54 @@ type Config struct {
 	ZoneKeyCheckPointInterval      time.Duration //in seconds
 	CheckPointPath                 string
 	PreLoadCaches                  bool
+	BlacklistPath                  string
+	InfraKeyPath                   string
+	InfraKeyName                   string
+	SignedMessagePeers             []string
+	MetricsAddress                 string
+	AdminAddress                   string
+	DrainTimeout                   time.Duration //in seconds
 
 	//switchboard
-	ServerAddress      connection.Info
-	MaxConnections     int
-	KeepAlivePeriod    time.Duration //in seconds
-	TCPTimeout         time.Duration //in seconds
-	TLSCertificateFile string
-	TLSPrivateKeyFile  string
+	ServerAddress            connection.Info
+	ListenAddresses          []connection.Info
+	MaxConnections           int
+	MaxMsgSize               int           //in bytes
+	KeepAlivePeriod          time.Duration //in seconds
+	TCPTimeout               time.Duration //in seconds
+	TLSCertificateFile       string
+	TLSPrivateKeyFile        string
+	TLSVerification          string
+	TLSRootCAFile            string
+	MessageSignatureValidity time.Duration //in seconds
+	SendQueueSize            int
 
 	//inbox
 	PrioBufferSize          int
 	NormalBufferSize        int
+	NormalSenderBufferSize  int
 	NotificationBufferSize  int
 	PrioWorkerCount         int
 	NormalWorkerCount       int
 	NotificationWorkerCount int
 	CapabilitiesCacheSize   int
 	Capabilities            []message.Capability
+	RateLimit               float64 //in messages per second and sender, 0 disables rate limiting
+	RateLimitBurst          int
+	RateLimitIPv4Prefix     int //in bits, 0 means the whole address
+	RateLimitIPv6Prefix     int //in bits, 0 means the whole address
+	RateLimitMaxSenders     int
+	RateLimitNotify         bool
 
 	//verify
 	ZoneKeyCacheSize            int
 	ZoneKeyCacheWarnSize        int
 	MaxPublicKeysPerZone        int
 	PendingKeyCacheSize         int
+	PendingMessageSenderLimit   int
 	DelegationQueryValidity     time.Duration //in seconds
+	DelegationQueryRetries      int
+	DelegationQueryBackoff      time.Duration //in milliseconds
 	ReapZoneKeyCacheInterval    time.Duration //in seconds
 	ReapPendingKeyCacheInterval time.Duration //in seconds
 
@@ -56,6 +79,13 @@ type Config struct {
 	ReapAssertionCacheInterval    time.Duration         //in seconds
 	ReapNegAssertionCacheInterval time.Duration         //in seconds
 	ReapPendingQCacheInterval     time.Duration         //in seconds
+	ZoneFiles                     []string
+	PrefetchBudget                int           //assertions refreshed per interval, 0 disables prefetching
+	PrefetchInterval              time.Duration //in seconds
+	PrefetchWindow                time.Duration //in seconds
+	PrefetchMinHits               int
+	StaleGracePeriod              time.Duration //in seconds, 0 disables serving stale assertions
+	ServeStaleToAll               bool
 }
 
 //DefaultConfig return the default configuration for the zone publisher.
@@ -68,34 +98,57 @@ func DefaultConfig() Config {
 		ZoneKeyCheckPointInterval:      30 * time.Minute,
 		CheckPointPath:                 "data/checkpoint/resolver/",
 		PreLoadCaches:                  false,
+		BlacklistPath:                  "",
+		InfraKeyPath:                   "",
+		InfraKeyName:                   "",
+		SignedMessagePeers:             nil,
+		MetricsAddress:                 "",
+		AdminAddress:                   "",
+		DrainTimeout:                   5 * time.Second,
 
 		//switchboard
 		ServerAddress: connection.Info{
 			Type: connection.TCP,
 			Addr: serverAddr,
 		},
-		MaxConnections:     10000,
-		KeepAlivePeriod:    time.Minute,
-		TCPTimeout:         5 * time.Minute,
-		TLSCertificateFile: "data/cert/server.crt",
-		TLSPrivateKeyFile:  "data/cert/server.key",
+		ListenAddresses:          nil,
+		MaxConnections:           10000,
+		MaxMsgSize:               65536,
+		KeepAlivePeriod:          time.Minute,
+		TCPTimeout:               5 * time.Minute,
+		TLSCertificateFile:       "data/cert/server.crt",
+		TLSPrivateKeyFile:        "data/cert/server.key",
+		TLSVerification:          TLSVerifyNone,
+		TLSRootCAFile:            "",
+		MessageSignatureValidity: time.Minute,
+		SendQueueSize:            100,
 
 		//inbox
 		PrioBufferSize:          50,
 		NormalBufferSize:        1000,
+		NormalSenderBufferSize:  100,
 		NotificationBufferSize:  10,
 		PrioWorkerCount:         2,
 		NormalWorkerCount:       10,
 		NotificationWorkerCount: 1,
 		CapabilitiesCacheSize:   10,
 		Capabilities:            []message.Capability{message.Capability("urn:x-rains:tlssrv")},
+		RateLimit:               0,
+		RateLimitBurst:          20,
+		RateLimitIPv4Prefix:     32,
+		RateLimitIPv6Prefix:     64,
+		RateLimitMaxSenders:     10000,
+		RateLimitNotify:         false,
 
 		//verify
 		ZoneKeyCacheSize:            1000,
 		ZoneKeyCacheWarnSize:        750,
 		MaxPublicKeysPerZone:        5,
 		PendingKeyCacheSize:         100,
+		PendingMessageSenderLimit:   10,
 		DelegationQueryValidity:     time.Second,
+		DelegationQueryRetries:      2,
+		DelegationQueryBackoff:      250 * time.Millisecond,
 		ReapZoneKeyCacheInterval:    15 * time.Minute,
 		ReapPendingKeyCacheInterval: 15 * time.Minute,
 
@@ -114,5 +167,12 @@ func DefaultConfig() Config {
 		ReapAssertionCacheInterval:    15 * time.Minute,
 		ReapNegAssertionCacheInterval: 15 * time.Minute,
 		ReapPendingQCacheInterval:     15 * time.Minute,
+		ZoneFiles:                     nil,
+		PrefetchBudget:                0,
+		PrefetchInterval:              time.Minute,
+		PrefetchWindow:                2 * time.Minute,
+		PrefetchMinHits:               2,
+		StaleGracePeriod:              0,
+		ServeStaleToAll:               false,
 	}
 }
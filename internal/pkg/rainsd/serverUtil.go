@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -24,12 +25,6 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
-const (
-	aCheckPointFileName = "assertionCheckPoint.gob"
-	nCheckPointFileName = "negAssertionCheckPoint.gob"
-	zCheckPointFileName = "zoneKeyCheckPoint.gob"
-)
-
 type missingKeyMetaData struct {
 	Zone     string
 	Context  string
@@ -42,12 +37,6 @@ type ZoneContext struct {
 	Context string
 }
 
-type checkPointValue struct {
-	Sections   []section.Section
-	ValidSince []int64
-	ValidUntil []int64
-}
-
 //sendNotificationMsg sends a message containing freshly generated token and a notification section with
 //notificationType, token, and data to destination.
 func sendNotificationMsg(tok token.Token, destination net.Addr,
@@ -57,6 +46,7 @@ func sendNotificationMsg(tok token.Token, destination net.Addr,
 		Token: tok,
 		Data:  data,
 	}
+	s.metrics.notificationSent(notificationType)
 	sendSection(notification, token.Token{}, destination, s)
 }
 
@@ -76,7 +66,7 @@ func sendSection(sec section.Section, token token.Token, destination net.Addr, s
 	return sendSections([]section.Section{sec}, token, destination, s)
 }
 
-//sendCapability sends a message with capabilities to sender
+//sendCapability sends a message with the full capability list to destination
 func sendCapability(destination net.Addr, capabilities []message.Capability, s *Server) {
 	msg := message.Message{Token: token.New(), Capabilities: capabilities}
 	s.sendTo(msg, destination, 1, 1)
@@ -99,7 +89,9 @@ func LoadConfig(configPath string) (Config, error) {
 	config.ZoneKeyCheckPointInterval *= time.Second
 	config.KeepAlivePeriod *= time.Second
 	config.TCPTimeout *= time.Second
+	config.MessageSignatureValidity *= time.Second
 	config.DelegationQueryValidity *= time.Second
+	config.DelegationQueryBackoff *= time.Millisecond
 	config.ReapZoneKeyCacheInterval *= time.Second
 	config.ReapPendingKeyCacheInterval *= time.Second
 	config.QueryValidity *= time.Second
@@ -110,6 +102,10 @@ func LoadConfig(configPath string) (Config, error) {
 	config.ReapAssertionCacheInterval *= time.Second
 	config.ReapNegAssertionCacheInterval *= time.Second
 	config.ReapPendingQCacheInterval *= time.Second
+	config.DrainTimeout *= time.Second
+	config.PrefetchInterval *= time.Second
+	config.PrefetchWindow *= time.Second
+	config.StaleGracePeriod *= time.Second
 	return config, nil
 }
 
@@ -146,17 +142,19 @@ func loadTLSCertificate(certPath string, TLSPrivateKeyPath string) (*x509.CertPo
 }
 
 //initOwnCapabilities sorts capabilities in lexicographically increasing order.
-//It stores the hex encoded sha256 hash of the sorted capabilities to capabilityHash
-//and a string representation of the capability list to capabilityList
-func initOwnCapabilities(capabilities []message.Capability) (string, string) {
-	//TODO CFE when we have CBOR use it to normalize&serialize the array before hashing it.
-	//Currently we use the hard coded version from the draft.
-	capabilityHash := "e5365a09be554ae55b855f15264dbc837b04f5831daeb321359e18cdabab5745"
-	cs := make([]string, len(capabilities))
-	for i, c := range capabilities {
+//It returns the hex encoded sha256 hash of the CBOR serialization of the sorted capabilities and a
+//string representation of the sorted capability list.
+func initOwnCapabilities(capabilities []message.Capability) (string, string, error) {
+	capabilityHash, err := message.CapabilityHash(capabilities)
+	if err != nil {
+		return "", "", err
+	}
+	sorted := message.SortCapabilities(capabilities)
+	cs := make([]string, len(sorted))
+	for i, c := range sorted {
 		cs[i] = string(c)
 	}
-	return capabilityHash, strings.Join(cs, " ")
+	return capabilityHash, strings.Join(cs, " "), nil
 }
 
 //loadRootZonePublicKey stores the root zone public key from disk into the zoneKeyCache.
@@ -199,107 +197,28 @@ func loadRootZonePublicKey(keyPath string, zoneKeyCache cache.ZonePublicKey,
 	return err
 }
 
-//measureSystemRessources measures current cpu usage
-func measureSystemRessources() {
-	//Not yet implemented
-}
-
-func initStoreCachesContent(config Config, caches *Caches, stop chan bool) {
+//initStoreCachesContent starts the go routines which periodically checkpoint the caches until stop
+//is closed. They are added to routines.
+func initStoreCachesContent(config Config, caches *Caches, stop <-chan struct{},
+	routines *sync.WaitGroup) {
 	if err := os.MkdirAll(config.CheckPointPath, os.ModePerm); err != nil {
 		log.Error("Was not able to create folders", "error", err)
 	}
-	time.Sleep(100 * time.Millisecond)
-	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, aCheckPointFileName),
-			caches.AssertionsCache.Checkpoint)
-	}, config.AssertionCheckPointInterval, stop)
-	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, nCheckPointFileName),
-			caches.NegAssertionCache.Checkpoint)
-	}, config.NegAssertionCheckPointInterval, stop)
-	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, zCheckPointFileName),
-			caches.ZoneKeyCache.Checkpoint)
-	}, config.ZoneKeyCheckPointInterval, stop)
-}
-
-func checkpoint(path string, values func() []section.Section) {
-	value := checkPointValue{Sections: values()}
-	for _, s := range value.Sections {
-		value.ValidSince = append(value.ValidSince, s.(section.WithSigForward).ValidSince())
-		value.ValidUntil = append(value.ValidUntil, s.(section.WithSigForward).ValidUntil())
-	}
-	if err := util.Save(path, value); err != nil {
-		log.Error("Was not able to checkpoint cache", "path", path, "error", err)
-	}
-}
-
-func loadCaches(cpPath string, caches *Caches, authorities []ZoneContext) {
-
-	//load assertion check point
-	sections, err := readMsgFromFile(path.Join(cpPath, aCheckPointFileName))
-	if err != nil {
-		log.Warn("Was not able to load assertion check point from file", "error", err)
-	}
-	for _, s := range sections {
-		if s, ok := s.(*section.Assertion); ok {
-			caches.AssertionsCache.Add(s, time.Now().Add(24*time.Hour).Unix(),
-				isAuthoritative(s, authorities))
-		} else {
-			log.Warn("Invalid type for assertion cache", "type", fmt.Sprintf("%T", s))
-		}
-	}
-
-	//load negAssertion check point
-	sections, err = readMsgFromFile(path.Join(cpPath, nCheckPointFileName))
-	if err != nil {
-		log.Warn("Was not able to load negAssertion check point from file", "error", err)
-	}
-	for _, s := range sections {
-		switch s := s.(type) {
-		case *section.Shard:
-			caches.NegAssertionCache.AddShard(s, time.Now().Add(24*time.Hour).Unix(),
-				isAuthoritative(s, authorities))
-		case *section.Pshard:
-			caches.NegAssertionCache.AddPshard(s, time.Now().Add(24*time.Hour).Unix(),
-				isAuthoritative(s, authorities))
-		case *section.Zone:
-			caches.NegAssertionCache.AddZone(s, time.Now().Add(24*time.Hour).Unix(),
-				isAuthoritative(s, authorities))
-		default:
-			log.Warn("Invalid type for negative Assertion cache", "type", fmt.Sprintf("%T", s))
-		}
-	}
-
-	//load zone key check point
-	sections, err = readMsgFromFile(path.Join(cpPath, zCheckPointFileName))
-	if err != nil {
-		log.Warn("Was not able to load zone key check point from file", "error", err)
-	}
-	for _, s := range sections {
-		if s, ok := s.(*section.Assertion); ok {
-			for _, o := range s.Content {
-				if o.Type == object.OTDelegation {
-					caches.ZoneKeyCache.Add(s, o.Value.(keys.PublicKey),
-						isAuthoritative(s, authorities))
+	store := func(fileName string, values func() []section.Section, interval time.Duration) {
+		routines.Add(1)
+		go func() {
+			defer routines.Done()
+			repeatFuncCaller(func() {
+				if err := checkpoint(path.Join(config.CheckPointPath, fileName), values); err != nil {
+					log.Error("Was not able to checkpoint cache", "file", fileName, "error", err)
 				}
-			}
-		} else {
-			log.Warn("Invalid type for zone key cache", "type", fmt.Sprintf("%T", s))
-		}
-	}
-}
-
-func readMsgFromFile(path string) ([]section.Section, error) {
-	values := &checkPointValue{}
-	if err := util.Load(path, values); err != nil {
-		return nil, err
-	}
-	for i, s := range values.Sections {
-		s.(section.WithSigForward).SetValidSince(values.ValidSince[i])
-		s.(section.WithSigForward).SetValidUntil(values.ValidUntil[i])
+			}, func() time.Duration { return interval }, stop)
+		}()
 	}
-	return values.Sections, nil
+	store(aCheckPointFileName, caches.AssertionsCache.Checkpoint, config.AssertionCheckPointInterval)
+	store(nCheckPointFileName, caches.NegAssertionCache.Checkpoint,
+		config.NegAssertionCheckPointInterval)
+	store(zCheckPointFileName, caches.ZoneKeyCache.Checkpoint, config.ZoneKeyCheckPointInterval)
 }
 
 func isAuthoritative(s section.WithSigForward, authorities []ZoneContext) bool {
@@ -313,8 +232,8 @@ func isAuthoritative(s section.WithSigForward, authorities []ZoneContext) bool {
 	return isAuthoritative
 }
 
-//repeatFuncCaller executes function in intervals of waitTime
-func repeatFuncCaller(function func(), waitTime time.Duration, stop chan bool) {
+//repeatFuncCaller executes function in intervals returned by waitTime until stop is closed.
+func repeatFuncCaller(function func(), waitTime func() time.Duration, stop <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
@@ -322,6 +241,12 @@ func repeatFuncCaller(function func(), waitTime time.Duration, stop chan bool) {
 		default:
 		}
 		function()
-		time.Sleep(waitTime)
+		timer := time.NewTimer(waitTime())
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 	}
 }
@@ -0,0 +1,103 @@
+package rainsd
+
+import (
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//staleNotificationData is the data of the notification which follows the stale assertions of an
+//answer.
+const staleNotificationData = "stale assertions served because upstream is unreachable"
+
+//serveStale is called when the recursive lookup of q sent with tok has failed. If serving stale
+//assertions is enabled, the queries waiting for tok are answered with the assertions of q which
+//expired at most StaleGracePeriod ago. A query is only answered if it contains the expired
+//assertions ok query option or if ServeStaleToAll is set. The assertions are followed by a no
+//assertion available notification such that the answer can be distinguished from a regular one.
+//Waiting queries are left untouched if there are no stale assertions.
+func (s *Server) serveStale(q *query.Name, tok token.Token) {
+	config := s.config()
+	if config.StaleGracePeriod <= 0 {
+		return
+	}
+	allowed := false
+	for _, e := range s.caches.PendingQueries.GetAll() {
+		if e.Token == tok && staleAllowed(q, e.Waiting, config) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return
+	}
+	assertions := s.staleAssertions(q, config.StaleGracePeriod)
+	if len(assertions) == 0 {
+		log.Info("Upstream failed and there are no stale assertions", "query", q)
+		return
+	}
+	for _, mss := range s.caches.PendingQueries.GetAndRemove(tok) {
+		if !staleAllowed(q, mss, config) {
+			sendNotificationMsg(mss.Token, mss.Sender, section.NTNoAssertionAvail, "", s)
+			continue
+		}
+		answer := append([]section.Section{}, assertions...)
+		answer = append(answer, &section.Notification{
+			Type:  section.NTNoAssertionAvail,
+			Token: mss.Token,
+			Data:  staleNotificationData,
+		})
+		log.Info("Serving stale assertions", "query", q, "assertions", assertions,
+			"destination", mss.Sender)
+		s.metrics.staleAnswers.Inc()
+		s.metrics.notificationSent(section.NTNoAssertionAvail)
+		sendSections(answer, mss.Token, mss.Sender, s)
+	}
+}
+
+//staleAssertions returns the assertions of q which expired at most gracePeriod ago. They are
+//taken from the stale assertions and from the expired assertions which are still cached.
+func (s *Server) staleAssertions(q *query.Name, gracePeriod time.Duration) []section.Section {
+	now := time.Now().Unix()
+	oldest := time.Now().Add(-gracePeriod).Unix()
+	assertions := []section.Section{}
+	seen := make(map[string]bool)
+	for _, t := range q.Types {
+		cached, _ := s.caches.AssertionsCache.GetWithExpiration(q.Name, q.Context, t)
+		for _, ca := range cached {
+			if ca.Expiration >= now || ca.Expiration < oldest || seen[ca.Assertion.Hash()] {
+				continue
+			}
+			seen[ca.Assertion.Hash()] = true
+			assertions = append(assertions, ca.Assertion)
+		}
+		//GetStale only returns assertions within the stale grace period.
+		stale, _ := s.caches.AssertionsCache.GetStale(q.Name, q.Context, t)
+		for _, a := range stale {
+			if !seen[a.Hash()] {
+				seen[a.Hash()] = true
+				assertions = append(assertions, a)
+			}
+		}
+	}
+	return assertions
+}
+
+//staleAllowed returns true if the queries of mss for q's name may be answered with stale
+//assertions according to config.
+func staleAllowed(q *query.Name, mss util.MsgSectionSender, config *Config) bool {
+	if config.ServeStaleToAll {
+		return true
+	}
+	for _, sec := range mss.Sections {
+		if wq, ok := sec.(*query.Name); ok && wq.Name == q.Name && wq.Context == q.Context &&
+			wq.ContainsOption(query.QOExpiredAssertionsOk) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package rainsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestServeStale(t *testing.T) {
+	var tests = []struct {
+		name        string
+		gracePeriod time.Duration
+		toAll       bool
+		options     []query.Option
+		wantStale   bool
+	}{
+		{"option", time.Hour, false, []query.Option{query.QOExpiredAssertionsOk}, true},
+		{"policy", time.Hour, true, nil, true},
+		{"not allowed", time.Hour, false, nil, false},
+		{"disabled", 0, true, []query.Option{query.QOExpiredAssertionsOk}, false},
+		{"grace period passed", 30 * time.Second, true, nil, false},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := memoryConfig(t, connection.MemoryAddr(t.Name()), DefaultConfig().Capabilities)
+			config.StaleGracePeriod = test.gracePeriod
+			config.ServeStaleToAll = test.toAll
+			s, err := New(config, t.Name())
+			if err != nil {
+				t.Fatalf("Was not able to create server: %v", err)
+			}
+			//The forwarder does not exist such that all lookups fail.
+			r, err := libresolve.New(nil, []net.Addr{connection.MemoryAddr(t.Name() + "Upstream")},
+				config.RootZonePublicKeyPath, libresolve.Forward, s.Addr(), 10,
+				config.MaxCacheValidity, 10)
+			if err != nil {
+				t.Fatalf("Was not able to create libresolve resolver: %v", err)
+			}
+			s.SetResolver(r)
+			go s.Run(context.Background())
+			<-s.Ready()
+			t.Cleanup(s.Shutdown)
+
+			a := signedAssertion(t, config, ".", "www", "192.0.2.1")
+			a.SetValidSince(time.Now().Add(-time.Hour).Unix())
+			a.SetValidUntil(time.Now().Add(-time.Minute).Unix())
+			s.caches.AssertionsCache.Add(a, a.ValidUntil(), false)
+			s.caches.AssertionsCache.RemoveExpiredValues()
+
+			msg := message.Message{Token: token.New(),
+				Content: []section.Section{ip4Query("www.", test.options...)}}
+			answer, err := util.SendQuery(msg, s.Addr(), 500*time.Millisecond)
+			if !test.wantStale {
+				if err == nil {
+					t.Errorf("%d: unexpected answer: %v", i, answer.Content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%d: no stale answer: %v", i, err)
+			}
+			if len(answer.Content) != 2 || answer.Content[0].(*section.Assertion).Hash() != a.Hash() {
+				t.Fatalf("%d: wrong stale answer: %v", i, answer.Content)
+			}
+			n, ok := answer.Content[1].(*section.Notification)
+			if !ok || n.Type != section.NTNoAssertionAvail || n.Data != staleNotificationData ||
+				n.Token != msg.Token {
+				t.Errorf("%d: stale answer is not marked: %v", i, answer.Content[1])
+			}
+		})
+	}
+}
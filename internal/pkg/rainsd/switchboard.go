@@ -1,45 +1,92 @@
 //The switchboard handles incoming connections from servers and clients,
 //opens connections to servers to which messages need to be sent but for which no active connection is available
-//and provides the SendTo function which sends the message to the specified server.
+//and provides the SendTo function which queues the message to be sent to the specified server.
 
 package rainsd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
-	"github.com/netsec-ethz/rains/internal/pkg/connection/scion"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
-	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
-//sendTo sends message to the specified receiver with retries
+//sendTo queues msg to be sent to receiver. The message is sent by the writer of receiver's send
+//queue which tries it retries times and waits backoffMilliSeconds before the first retry, doubling
+//the backoff with each retry. An error is returned if msg cannot be queued. In this case and if
+//sending fails, the sections and queries waiting for an answer to msg are dropped.
 func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	backoffMilliSeconds int) (err error) {
+	o := outgoing{
+		msg:     msg,
+		retries: retries,
+		backoff: time.Duration(backoffMilliSeconds) * time.Millisecond,
+	}
+	if err := s.sendQueues.push(o, receiver, s.writeQueue); err != nil {
+		log.Warn("Was not able to queue message", "receiver", receiver, "error", err)
+		if err == errSendQueueFull || err == errTooManySendQueues {
+			s.sendFailed(o)
+		}
+		return err
+	}
+	return nil
+}
 
-	// In any case we add the capabilities of this server to the message.
-	msg.Capabilities = []message.Capability{message.Capability(s.capabilityHash)}
+//write sends o to receiver with retries. A coalesced message which does not fit into a datagram
+//is split up into its parts again.
+func (s *Server) write(o outgoing, receiver net.Addr) error {
+	msg := o.msg
+	// If the message does not contain a capability list, we add the hash of this server's
+	// capabilities to it.
+	if len(msg.Capabilities) == 0 {
+		msg.Capabilities = []message.Capability{message.Capability(s.capabilityHash)}
+	}
+	if err := s.signMessage(&msg); err != nil {
+		return fmt.Errorf("failed to sign message: %v", err)
+	}
 	encodedMsg := new(bytes.Buffer)
 	if err := cbor.NewWriter(encodedMsg).Marshal(&msg); err != nil {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
+	if s.sendsDatagram(receiver) && encodedMsg.Len() > connection.MaxUDPPacketBytes {
+		if o.parts != nil {
+			for _, part := range o.parts {
+				if err := s.write(part, receiver); err != nil {
+					log.Warn("Was not able to send message", "receiver", receiver, "error", err)
+					s.sendFailed(part)
+				}
+			}
+			return nil
+		}
+		//The receiver can retrieve the message over tcp instead.
+		log.Info("Message does not fit into a datagram", "receiver", receiver,
+			"size", encodedMsg.Len())
+		sendNotificationMsg(answeredToken(msg), receiver, section.NTMsgTooLarge,
+			strconv.Itoa(connection.MaxUDPPacketBytes), s)
+		return nil
+	}
 
 	// Try to send the message, with given number of retries
-	backoff := time.Duration(backoffMilliSeconds) * time.Millisecond
+	backoff := o.backoff
 	if err := s.sendToTry(encodedMsg.Bytes(), receiver); err == nil {
 		return nil
 	}
-	for i := 1; i < retries; i++ {
+	for i := 1; i < o.retries; i++ {
 		time.Sleep(backoff)
 		backoff *= 2
 		if err := s.sendToTry(encodedMsg.Bytes(), receiver); err == nil {
@@ -50,27 +97,67 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	return errors.New("Was not able to send the mesage. No retries left")
 }
 
+//answeredToken returns the token the receiver of msg is waiting for. A notification carries the
+//token of the message it answers, all other answers have the same token as the query.
+func answeredToken(msg message.Message) token.Token {
+	if len(msg.Content) > 0 {
+		if n, ok := msg.Content[0].(*section.Notification); ok {
+			return n.Token
+		}
+	}
+	return msg.Token
+}
+
+//sendsDatagram returns true if messages to receiver are sent over one of the server's datagram
+//sockets.
+func (s *Server) sendsDatagram(receiver net.Addr) bool {
+	return s.packetConnTo(receiver) != nil
+}
+
+//packetConnTo returns the datagram socket over which messages to receiver are sent or nil if
+//messages to receiver are sent over a connection.
+func (s *Server) packetConnTo(receiver net.Addr) net.PacketConn {
+	return s.packetConns[connection.TypeOf(receiver)]
+}
+
+//transport returns the transport over which receiver is reached. Tls connections are established
+//with the server's certificate and verification settings.
+func (s *Server) transport(receiver net.Addr) (connection.Transport, error) {
+	if _, ok := receiver.(*net.TCPAddr); ok {
+		return connection.TLSTransport{
+			ClientConfig: s.tlsClientConfig(receiver),
+			ServerConfig: s.tlsServerConfig(),
+			KeepAlive:    s.config().KeepAlivePeriod,
+		}, nil
+	}
+	return connection.TransportOf(receiver)
+}
+
+//dial returns a new connection to receiver. Messages to datagram addresses are only sent over the
+//server's own sockets such that answers arrive at the server's address.
+func (s *Server) dial(receiver net.Addr) (net.Conn, error) {
+	switch typ := connection.TypeOf(receiver); typ {
+	case connection.UDP, connection.SCION:
+		return nil, fmt.Errorf("server does not listen on a %v address", typ)
+	}
+	t, err := s.transport(receiver)
+	if err != nil {
+		return nil, err
+	}
+	return t.Dial(receiver)
+}
+
 //sendToTry sends message to the specified receiver.
 func (s *Server) sendToTry(encodedMsg []byte, receiver net.Addr) (err error) {
-	if s.packetConn != nil {
-		if _, err := s.packetConn.WriteTo(encodedMsg, receiver); err != nil {
+	if packetConn := s.packetConnTo(receiver); packetConn != nil {
+		if _, err := packetConn.WriteTo(encodedMsg, receiver); err != nil {
 			return fmt.Errorf("unable to send message: %v", err)
 		}
 		return nil
 	} else {
 		conns, ok := s.caches.ConnCache.GetConnection(receiver)
-		if !ok {
-			conn, err := createConnection(receiver, s.config.KeepAlivePeriod, s.certPool)
-			//add connection to cache
-			conns = append(conns, conn)
-			if err != nil {
-				log.Warn("Could not establish connection", "error", err, "receiver", receiver)
-				return err
-			}
-			s.caches.ConnCache.AddConnection(conn)
-			go s.handleConnection(conn, receiver)
-			conns = []net.Conn{conn}
-		}
+		//The capabilities must be obtained before the connections are removed from the cache.
+		caps, _ := s.caches.ConnCache.GetCapabilityList(receiver)
 		for _, conn := range conns {
 			if _, err := conn.Write(encodedMsg); err != nil {
 				s.caches.ConnCache.CloseAndRemoveConnection(conn)
@@ -80,141 +167,241 @@ func (s *Server) sendToTry(encodedMsg []byte, receiver net.Addr) (err error) {
 				return nil
 			}
 		}
-		return errors.New("unable to send message on any connection")
+		if ok && !acceptsTLSConnections(caps) {
+			return errors.New("unable to send message on any connection and receiver does not " +
+				"accept new connections")
+		}
+		conn, err := s.dial(receiver)
+		if err != nil {
+			log.Warn("Could not establish connection", "error", err, "receiver", receiver)
+			return err
+		}
+		s.caches.ConnCache.AddConnection(conn)
+		go s.handleConnection(conn, receiver)
+		if _, err := conn.Write(encodedMsg); err != nil {
+			s.caches.ConnCache.CloseAndRemoveConnection(conn)
+			return fmt.Errorf("unable to send message on new connection: %v", err)
+		}
+		log.Debug("Send successful", "receiver", receiver)
+		return nil
+	}
+}
+
+//acceptsTLSConnections returns true if a peer with capabilities listens for tls over tcp
+//connections. A peer whose capabilities are unknown is assumed to do so.
+func acceptsTLSConnections(capabilities []message.Capability) bool {
+	if len(capabilities) == 0 {
+		return true
+	}
+	for _, c := range capabilities {
+		if c == message.TLSOverTCP {
+			return true
+		}
 	}
+	return false
 }
 
 func (s *Server) sendToRecursiveResolver(msg message.Message) {
 	for _, sec := range msg.Content {
 		if q, ok := sec.(*query.Name); ok {
-			go s.resolver.ServerLookup(q, s.Addr(), msg.Token)
+			go func() {
+				if err := s.resolver.ServerLookup(q, s.Addr(), msg.Token); err != nil {
+					s.serveStale(q, msg.Token)
+				}
+			}()
 		}
 	}
 }
 
-//createConnection establishes a connection with receiver
-func createConnection(receiver net.Addr, keepAlive time.Duration, pool *x509.CertPool) (net.Conn, error) {
-	switch receiver.(type) {
-	case *net.TCPAddr:
-		dialer := &net.Dialer{
-			KeepAlive: keepAlive,
+//listenAddresses returns all addresses on which a server with config listens.
+func listenAddresses(config *Config) []connection.Info {
+	return append([]connection.Info{config.ServerAddress}, config.ListenAddresses...)
+}
+
+//openListeners opens a listener on each of the server's listen addresses. There can be at most one
+//UDP and one SCION address as replies are sent over the datagram socket matching the type of the
+//receiver's address. If a listener cannot be opened, the already opened ones are closed again.
+func (s *Server) openListeners(srvLogger log.Logger) error {
+	s.packetConns = make(map[connection.Type]net.PacketConn)
+	for _, info := range listenAddresses(s.config()) {
+		if err := s.openListener(info, srvLogger.New("addr", info.Addr)); err != nil {
+			s.closeListeners()
+			return err
 		}
-		return tls.DialWithDialer(dialer, receiver.Network(), receiver.String(), &tls.Config{RootCAs: pool, InsecureSkipVerify: true})
-	default:
-		return nil, errors.New("No matching type found for Connection info")
+	}
+	return nil
+}
+
+//openListener opens a listener on info. A plain UDP address additionally accepts tls over tcp
+//connections on the same port such that answers which do not fit into a datagram can be retrieved.
+func (s *Server) openListener(info connection.Info, srvLogger log.Logger) error {
+	typ := connection.TypeOf(info.Addr)
+	if _, ok := s.packetConns[typ]; ok {
+		return fmt.Errorf("server can only listen on one %v address", typ)
+	}
+	t, err := s.transport(info.Addr)
+	if err != nil {
+		return err
+	}
+	listener, err := t.Listen(info.Addr)
+	if err != nil {
+		srvLogger.Error("Listener error on startup", "error", err)
+		return err
+	}
+	if listener.Datagram != nil {
+		s.packetConns[typ] = listener.Datagram
+	} else {
+		s.listeners = append(s.listeners, listener.Stream)
+	}
+	srvLogger.Info("Started listener", "type", typ)
+	if addr, ok := info.Addr.(*net.UDPAddr); ok {
+		tcpAddr := &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+		return s.openListener(connection.Info{Type: connection.TCP, Addr: tcpAddr},
+			srvLogger.New("purpose", "large answers"))
+	}
+	return nil
+}
+
+//tlsServerConfig returns the tls configuration with which this server accepts connections.
+func (s *Server) tlsServerConfig() *tls.Config {
+	//The certificate is looked up for each connection such that a reloaded one is used.
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.credentials().cert, nil
+		},
+		InsecureSkipVerify: true,
+	}
+}
+
+//closeListeners closes all of the server's listeners. Blocked calls to serve return.
+func (s *Server) closeListeners() {
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for _, conn := range s.packetConns {
+		conn.Close()
 	}
 }
 
-//Listen listens for incoming connections and creates a go routine for each connection.
-func (s *Server) listen(id string) {
-	srvLogger := log.New("id", id, "addr", s.Addr().String())
-	switch s.config.ServerAddress.Type {
-	case connection.TCP:
-		srvLogger.Info("Start TCP listener")
-		tlsConfig := &tls.Config{Certificates: []tls.Certificate{s.tlsCert}, InsecureSkipVerify: true}
-		listener, err := tls.Listen(s.Addr().Network(),
-			s.config.ServerAddress.Addr.String(), tlsConfig)
+//serve delivers incoming messages on all listeners until they are closed. Go routines handling
+//accepted connections are added to routines.
+func (s *Server) serve(ctx context.Context, srvLogger log.Logger, routines *sync.WaitGroup) {
+	listening := &sync.WaitGroup{}
+	for _, listener := range s.listeners {
+		listening.Add(1)
+		go func(listener net.Listener) {
+			defer listening.Done()
+			s.acceptConnections(ctx, listener, srvLogger.New("addr", listener.Addr()), routines)
+		}(listener)
+	}
+	for typ, conn := range s.packetConns {
+		listening.Add(1)
+		go func(typ connection.Type, conn net.PacketConn) {
+			defer listening.Done()
+			s.readDatagrams(ctx, conn, srvLogger.New("addr", conn.LocalAddr(), "type", typ))
+		}(typ, conn)
+	}
+	listening.Wait()
+}
+
+//acceptConnections accepts connections on listener until it is closed. Go routines handling
+//accepted connections are added to routines.
+func (s *Server) acceptConnections(ctx context.Context, listener net.Listener, srvLogger log.Logger,
+	routines *sync.WaitGroup) {
+	defer srvLogger.Info("TCP Shutdown listener")
+	for {
+		conn, err := listener.Accept()
 		if err != nil {
-			srvLogger.Error("Listener error on startup", "error", err)
-			return
-		}
-		defer listener.Close()
-		defer srvLogger.Info("TCP Shutdown listener")
-		for {
-			select {
-			case <-s.shutdown:
-				// break out of the loop when receiving shutdown
-				srvLogger.Info("Received shutdown signal from TCP")
+			if ctx.Err() != nil {
 				return
-			default:
-			}
-			conn, err := listener.Accept()
-			if err != nil {
-				srvLogger.Error("listener could not accept connection", "error", err)
-				continue
-			}
-			if isIPBlacklisted(conn.RemoteAddr()) {
-				continue
-			}
-			s.caches.ConnCache.AddConnection(conn)
-			if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-				go s.handleConnection(conn, tcpAddr)
-			} else {
-				log.Warn("Type assertion failed. Expected *net.TCPAddr", "addr", conn.RemoteAddr())
 			}
+			srvLogger.Error("listener could not accept connection", "error", err)
+			continue
 		}
-	case connection.SCION:
-		addr, ok := s.config.ServerAddress.Addr.(*snet.UDPAddr)
-		if !ok {
-			log.Warn(fmt.Sprintf("Type assertion failed. Expected *connection.SCIONAddr, got %T", addr))
-			return
+		if s.blacklist.isIPBlacklisted(conn.RemoteAddr()) {
+			conn.Close()
+			continue
 		}
-		conn, err := scion.Listen(addr.Host)
-		srvLogger.Info(fmt.Sprintf("Started SCION listener on %v", addr), "id", id)
+		s.caches.ConnCache.AddConnection(conn)
+		routines.Add(1)
+		go func() {
+			defer routines.Done()
+			s.handleConnection(conn, conn.RemoteAddr())
+		}()
+	}
+}
+
+//readDatagrams delivers the messages received on conn until it is closed.
+func (s *Server) readDatagrams(ctx context.Context, conn net.PacketConn, srvLogger log.Logger) {
+	defer srvLogger.Info("Datagram Shutdown listener")
+	for {
+		buf := make([]byte, connection.MaxUDPPacketBytes)
+		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
-			log.Warn("failed to ListenSCION", "err", err)
-			return
-		}
-		defer conn.Close()
-		defer srvLogger.Info("SCION Shutdown listener", "id", id)
-		s.packetConn = conn
-		for {
-			select {
-			case <-s.shutdown:
-				// break out of the loop when receiving shutdown
-				srvLogger.Info("Received shutdown signal from SCION")
+			if ctx.Err() != nil {
 				return
-			default:
 			}
-			buf := make([]byte, connection.MaxUDPPacketBytes)
-			n, addr, err := s.packetConn.ReadFrom(buf)
-			if err != nil {
-				log.Warn("Failed to ReadFrom", "err", err)
-				continue
-			}
-			data := buf[:n]
-			// Note: We cannot use handleConnection because UDP is connectionless and we have to
-			// manually stick the remote endpoint address in the handler.
-			var msg message.Message
-			if err := cbor.NewReader(bytes.NewReader(data)).Unmarshal(&msg); err != nil {
-				log.Warn("failed to unmarshal CBOR", "err", err)
-				continue
+			log.Warn("Failed to ReadFrom", "err", err)
+			continue
+		}
+		if s.blacklist.isIPBlacklisted(addr) {
+			continue
+		}
+		data := buf[:n]
+		if s.config().MaxMsgSize > 0 && n > s.config().MaxMsgSize {
+			if tok, err := message.ReadToken(bytes.NewReader(data)); err == nil {
+				handleMsgTooLarge(tok, addr, s)
 			}
-			deliver(&msg, addr,
-				s.queues.Prio, s.queues.Normal, s.queues.Notify, s.caches.PendingKeys)
+			continue
+		}
+		// Note: We cannot use handleConnection because UDP is connectionless and we have to
+		// manually stick the remote endpoint address in the handler.
+		//The declared lengths must fit into the datagram.
+		encoded, err := newMsgSizeLimiter(bytes.NewReader(data), n).readMsg()
+		if err != nil {
+			log.Warn("failed to read message from datagram", "sender", addr, "err", err)
+			continue
+		}
+		var msg message.Message
+		if err := cbor.NewReader(bytes.NewReader(encoded)).Unmarshal(&msg); err != nil {
+			log.Warn("failed to unmarshal CBOR", "err", err)
+			continue
 		}
-	default:
-		log.Warn("Unsupported Network address type.")
+		s.deliver(&msg, addr)
 	}
 }
 
 //handleConnection deframes all incoming messages on conn and passes them to the inbox along with the dstAddr
 func (s *Server) handleConnection(conn net.Conn, dstAddr net.Addr) {
 	log.Info("New connection", "serverAddr", s.Addr(), "conn", dstAddr)
-	reader := cbor.NewReader(conn)
+	limiter := newMsgSizeLimiter(conn, s.config().MaxMsgSize)
 	for {
-		var msg message.Message
-		select {
-		case <-s.shutdown:
-			return
-		default:
-		}
-		//FIXME CFE how to check efficiently that message is not too large?
-		if err := reader.Unmarshal(&msg); err != nil {
-			if err.Error() == "failed to read tag: EOF" {
+		//The message is read into memory before it is decoded such that its size is known.
+		encoded, err := limiter.readMsg()
+		if err != nil {
+			if limiter.exceeded() {
+				//The rest of the message is skipped such that the next message can be read.
+				tok, err := limiter.skipMsg()
+				if err != nil {
+					log.Warn("failed to skip too large message", "conn", dstAddr, "error", err)
+					break
+				}
+				handleMsgTooLarge(tok, conn.RemoteAddr(), s)
+				continue
+			}
+			if err == io.EOF {
 				log.Info("Connection has been closed", "conn", dstAddr)
 			} else {
 				log.Warn(fmt.Sprintf("failed to read from client: %v", err))
 			}
 			break
 		}
-		deliver(&msg, conn.RemoteAddr(),
-			s.queues.Prio, s.queues.Normal, s.queues.Notify, s.caches.PendingKeys)
+		var msg message.Message
+		if err := cbor.NewReader(bytes.NewReader(encoded)).Unmarshal(&msg); err != nil {
+			log.Warn(fmt.Sprintf("failed to read from client: %v", err))
+			break
+		}
+		s.deliver(&msg, conn.RemoteAddr())
 	}
 	s.caches.ConnCache.CloseAndRemoveConnection(conn)
 }
-
-//isIPBlacklisted returns true if addr is blacklisted
-func isIPBlacklisted(addr net.Addr) bool {
-	return false
-}
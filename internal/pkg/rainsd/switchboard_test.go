@@ -0,0 +1,122 @@
+package rainsd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+func TestPacketConnTo(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer udpConn.Close()
+	s := &Server{packetConns: map[connection.Type]net.PacketConn{connection.UDP: udpConn}}
+	var tests = []struct {
+		receiver net.Addr
+		want     net.PacketConn
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}, udpConn},
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}, nil},
+		{&snet.UDPAddr{}, nil},
+	}
+	for i, test := range tests {
+		if conn := s.packetConnTo(test.receiver); conn != test.want {
+			t.Errorf("%d: wrong socket for %T. expected=%v actual=%v", i, test.receiver,
+				test.want, conn)
+		}
+	}
+}
+
+//newMemoryServer returns a started server listening on the in-memory address addr.
+func newMemoryServer(t *testing.T, addr connection.MemoryAddr, caps []message.Capability) *Server {
+	t.Helper()
+	s, err := runMemoryServer(t, memoryConfig(t, addr, caps))
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	return s
+}
+
+//memoryConfig returns a config of a server listening on addr. The root key pair is stored as root
+//in the config's checkpoint path.
+func memoryConfig(t *testing.T, addr connection.MemoryAddr, caps []message.Capability) Config {
+	t.Helper()
+	config := DefaultConfig()
+	config.ServerAddress = connection.Info{Type: connection.Memory, Addr: addr}
+	config.Capabilities = caps
+	dir := t.TempDir()
+	config.CheckPointPath = dir
+	config.TLSCertificateFile = "../../../cmd/rainsd/data/cert/server.crt"
+	config.TLSPrivateKeyFile = "../../../cmd/rainsd/data/cert/server.key"
+	config.RootZonePublicKeyPath = filepath.Join(dir, "rootDelegationAssertion.gob")
+	if err := keyManager.GenerateKey(dir, "root", "", algorithmTypes.Ed25519.String(), "",
+		1); err != nil {
+		t.Fatalf("Was not able to generate root key pair: %v", err)
+	}
+	if err := keyManager.SelfSignedDelegation(filepath.Join(dir, "root"),
+		config.RootZonePublicKeyPath, "", ".", ".", time.Hour); err != nil {
+		t.Fatalf("Was not able to self sign root key pair: %v", err)
+	}
+	return config
+}
+
+//runMemoryServer runs a server with config until the test has finished. It returns as soon as the
+//server is ready or with the error of Run if it fails to start.
+func runMemoryServer(t *testing.T, config Config) (*Server, error) {
+	t.Helper()
+	s, err := New(config, config.ServerAddress.Addr.String())
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run(context.Background()) }()
+	select {
+	case <-s.Ready():
+		t.Cleanup(s.Shutdown)
+		return s, nil
+	case err := <-errs:
+		return nil, err
+	}
+}
+
+func TestMemoryTopology(t *testing.T) {
+	caps := []message.Capability{message.TLSOverTCP, "urn:x-rains:memory"}
+	a := newMemoryServer(t, "TestMemoryTopologyA", caps)
+	b := newMemoryServer(t, "TestMemoryTopologyB", []message.Capability{message.TLSOverTCP})
+
+	//b does not know a's capability hash. It answers with its capability list over a's connection
+	//and a sends its capability list in return.
+	heartbeat := &section.Notification{Type: section.NTHeartbeat}
+	if err := sendSection(heartbeat, token.Token{}, b.Addr(), a); err != nil {
+		t.Fatalf("Was not able to send heartbeat: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		listA, _ := a.caches.ConnCache.GetCapabilityList(b.Addr())
+		var listB []message.Capability
+		for _, conn := range b.caches.ConnCache.GetAllConnections() {
+			listB, _ = b.caches.ConnCache.GetCapabilityList(conn.RemoteAddr())
+		}
+		if reflect.DeepEqual(listA, []message.Capability{message.TLSOverTCP}) &&
+			reflect.DeepEqual(listB, message.SortCapabilities(caps)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Capability lists were not exchanged. a=%v b=%v", listA, listB)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,273 @@
+package rainsd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+)
+
+//errCertInfoLookup is returned in mode certInfo if the certificate information or the addresses of
+//a name in the receiver's certificate could not be looked up.
+var errCertInfoLookup = errors.New("tls verification failed: was not able to look up certificate " +
+	"information")
+
+//Modes in which the tls certificate of a server this server connects to is verified.
+const (
+	//TLSVerifyNone accepts any certificate. It is also used if no mode is configured.
+	TLSVerifyNone = "none"
+	//TLSVerifyCA verifies the certificate chain against the configured root CAs.
+	TLSVerifyCA = "ca"
+	//TLSVerifyCertInfo requires the certificate to match a certificate information published for
+	//a name in the certificate which is bound to the receiver's address.
+	TLSVerifyCertInfo = "certInfo"
+)
+
+//loadTLSRootCAs returns the CA pool against which server certificates are verified in mode ca. It
+//returns pool if caFile is empty.
+func loadTLSRootCAs(mode, caFile string, pool *x509.CertPool) (*x509.CertPool, error) {
+	switch mode {
+	case "", TLSVerifyNone, TLSVerifyCertInfo:
+		return pool, nil
+	case TLSVerifyCA:
+		if caFile == "" {
+			return pool, nil
+		}
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("was not able to read tls root CA file: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls root CA file %s does not contain a certificate", caFile)
+		}
+		return pool, nil
+	default:
+		return nil, fmt.Errorf("unknown tls verification mode: %s", mode)
+	}
+}
+
+//tlsCredentials contains the tls certificate of a server and the root CAs against which the
+//certificates of other servers are verified.
+type tlsCredentials struct {
+	cert     tls.Certificate
+	certPool *x509.CertPool
+}
+
+//loadTLSCredentials loads the tls certificate and root CAs according to config.
+func loadTLSCredentials(config Config) (*tlsCredentials, error) {
+	pool, cert, err := loadTLSCertificate(config.TLSCertificateFile, config.TLSPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if pool, err = loadTLSRootCAs(config.TLSVerification, config.TLSRootCAFile, pool); err != nil {
+		return nil, err
+	}
+	return &tlsCredentials{cert: cert, certPool: pool}, nil
+}
+
+//tlsClientConfig returns the tls configuration with which this server connects to receiver. In
+//mode ca, the certificate must contain receiver's IP address as subject alternative name.
+func (s *Server) tlsClientConfig(receiver net.Addr) *tls.Config {
+	switch s.config().TLSVerification {
+	case TLSVerifyCA:
+		return connection.TLSVerification{RootCAs: s.credentials().certPool,
+			ServerName: peerHost(receiver)}.TLSConfig()
+	case TLSVerifyCertInfo:
+		config := connection.TLSVerification{Insecure: true}.TLSConfig()
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyCertInfo(receiver, cs.PeerCertificates, s.caches.AssertionsCache,
+				s.lookupCertInfo)
+		}
+		return config
+	default:
+		return connection.TLSVerification{Insecure: true}.TLSConfig()
+	}
+}
+
+//verifyCertInfo returns nil if chain matches a tls certificate information cached for a name in
+//chain's leaf certificate and this name has a cached address assertion for receiver's IP. If the
+//address or the certificate information of a name is not cached, lookup is called to obtain them.
+//The error of a failed lookup is returned if no name could be verified.
+func verifyCertInfo(receiver net.Addr, chain []*x509.Certificate, assertionsCache cache.Assertion,
+	lookup func(name string) error) error {
+	if len(chain) == 0 {
+		return errors.New("tls verification failed: server did not present a certificate")
+	}
+	tcpAddr, ok := receiver.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("tls verification failed: unsupported receiver address type %T", receiver)
+	}
+	names := certNames(chain[0])
+	if len(names) == 0 {
+		return fmt.Errorf("tls verification failed: certificate of %s does not contain a name",
+			receiver)
+	}
+	var lookupErr error
+	for _, name := range names {
+		if !nameHasIP(name, tcpAddr.IP, assertionsCache) ||
+			len(cachedCertInfos(name, assertionsCache)) == 0 {
+			if err := lookup(name); err != nil {
+				lookupErr = err
+				continue
+			}
+		}
+		if !nameHasIP(name, tcpAddr.IP, assertionsCache) {
+			continue
+		}
+		infos := cachedCertInfos(name, assertionsCache)
+		if len(infos) == 0 {
+			return fmt.Errorf("tls verification failed: no certificate information cached for %s",
+				name)
+		}
+		return connection.VerifyCertInfos(chain, infos)
+	}
+	if lookupErr != nil {
+		return lookupErr
+	}
+	return fmt.Errorf("tls verification failed: no name in the certificate of %s is bound to its "+
+		"address. names=%v", receiver, names)
+}
+
+//cachedCertInfos returns the certificate information of all cached assertions for name.
+func cachedCertInfos(name string, assertionsCache cache.Assertion) []object.Certificate {
+	infos := []object.Certificate{}
+	if assertions, ok := assertionsCache.Get(name, ".", object.OTCertInfo, true); ok {
+		for _, a := range assertions {
+			for _, o := range a.Content {
+				if info, ok := o.Value.(object.Certificate); ok && o.Type == object.OTCertInfo {
+					infos = append(infos, info)
+				}
+			}
+		}
+	}
+	return infos
+}
+
+//lookupCertInfo queries the recursive resolver for the certificate information and the addresses
+//of name. The sections of the answer are cached if their signatures are valid. Nothing is looked
+//up if the server has no recursive resolver.
+func (s *Server) lookupCertInfo(name string) error {
+	if s.resolver == nil {
+		return nil
+	}
+	log.Info("Look up certificate information", "name", name)
+	answer, err := s.resolver.ClientLookup(&query.Name{
+		Name:       name,
+		Context:    ".",
+		Expiration: time.Now().Add(s.config().DelegationQueryValidity).Unix(),
+		Types:      []object.Type{object.OTCertInfo, object.OTIP4Addr, object.OTIP6Addr},
+	})
+	if err != nil {
+		return fmt.Errorf("%w of %s: %v", errCertInfoLookup, name, err)
+	}
+	s.cacheVerifiedSections(answer.Content, make(map[string]bool))
+	return nil
+}
+
+//cacheVerifiedSections adds all sections of secs with valid signatures to the caches. Missing
+//public keys are obtained by delegation queries to the recursive resolver. queried contains the
+//zones for which a delegation has already been queried.
+func (s *Server) cacheVerifiedSections(secs []section.Section, queried map[string]bool) {
+	verified := []section.WithSigForward{}
+	for _, sec := range secs {
+		sec, ok := sec.(section.WithSigForward)
+		if !ok {
+			continue
+		}
+		pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+		missingKeys := make(map[missingKeyMetaData]bool)
+		publicKeysPresent(sec, s.caches.ZoneKeyCache, pkeys, missingKeys, s.metrics)
+		if len(missingKeys) != 0 {
+			s.lookupDelegations(missingKeys, queried)
+			pkeys = make(map[keys.PublicKeyID][]keys.PublicKey)
+			missingKeys = make(map[missingKeyMetaData]bool)
+			publicKeysPresent(sec, s.caches.ZoneKeyCache, pkeys, missingKeys, s.metrics)
+			if len(missingKeys) != 0 {
+				log.Warn("Public keys to verify section are missing", "section", sec)
+				continue
+			}
+		}
+		valid := siglib.CheckSectionSignatures(sec, pkeys, s.config().MaxCacheValidity)
+		s.metrics.sigVerification("section", valid)
+		if !valid {
+			log.Warn("Invalid signature", "section", sec)
+			continue
+		}
+		verified = append(verified, sec)
+	}
+	addSectionsToCache(verified, s.config().Authorities, s.caches.AssertionsCache,
+		s.caches.NegAssertionCache, s.caches.ZoneKeyCache)
+}
+
+//lookupDelegations queries the recursive resolver for the delegations of the zones in missingKeys
+//which have not been queried yet and caches the verified answers.
+func (s *Server) lookupDelegations(missingKeys map[missingKeyMetaData]bool,
+	queried map[string]bool) {
+	for k := range missingKeys {
+		if s.resolver == nil || queried[k.Zone] {
+			continue
+		}
+		queried[k.Zone] = true
+		answer, err := s.resolver.ClientLookup(&query.Name{
+			Name:       k.Zone,
+			Context:    k.Context,
+			Expiration: time.Now().Add(s.config().DelegationQueryValidity).Unix(),
+			Types:      []object.Type{object.OTDelegation},
+			KeyPhase:   k.KeyPhase,
+		})
+		if err != nil {
+			log.Warn("Was not able to look up delegation", "zone", k.Zone, "err", err)
+			continue
+		}
+		s.cacheVerifiedSections(answer.Content, queried)
+	}
+}
+
+//certNames returns the fully qualified names contained in cert. The common name is only used if
+//there are no subject alternative names.
+func certNames(cert *x509.Certificate) []string {
+	names := cert.DNSNames
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = []string{cert.Subject.CommonName}
+	}
+	fqdns := make([]string, len(names))
+	for i, name := range names {
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+		fqdns[i] = name
+	}
+	return fqdns
+}
+
+//nameHasIP returns true if there is a cached address assertion for name containing ip.
+func nameHasIP(name string, ip net.IP, assertionsCache cache.Assertion) bool {
+	for _, t := range []object.Type{object.OTIP4Addr, object.OTIP6Addr} {
+		assertions, ok := assertionsCache.Get(name, ".", t, true)
+		if !ok {
+			continue
+		}
+		for _, a := range assertions {
+			for _, o := range a.Content {
+				if addr, ok := o.Value.(net.IP); ok && o.Type == t && addr.Equal(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package rainsd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//writeTLSCertificate stores a self signed certificate for ip and its private key in dir and
+//returns the certificate.
+func writeTLSCertificate(t *testing.T, dir string, ip net.IP) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Was not able to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{ip},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Was not able to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Was not able to encode key: %v", err)
+	}
+	files := map[string]*pem.Block{
+		"server.crt": {Type: "CERTIFICATE", Bytes: der},
+		"server.key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for name, block := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block),
+			0600); err != nil {
+			t.Fatalf("Was not able to write %s: %v", name, err)
+		}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Was not able to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestTLSVerifyCA(t *testing.T) {
+	config := memoryConfig(t, "TestTLSVerifyCA", DefaultConfig().Capabilities)
+	cert := writeTLSCertificate(t, config.CheckPointPath, net.ParseIP("192.0.2.1"))
+	config.TLSCertificateFile = filepath.Join(config.CheckPointPath, "server.crt")
+	config.TLSPrivateKeyFile = filepath.Join(config.CheckPointPath, "server.key")
+	config.TLSVerification = TLSVerifyCA
+	s, err := New(config, "TestTLSVerifyCA")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	var tests = []struct {
+		receiver net.Addr
+		valid    bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5022}, false},
+	}
+	for i, test := range tests {
+		tlsConfig := s.tlsClientConfig(test.receiver)
+		err := tlsConfig.VerifyConnection(tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert}})
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong verification result for %v. expected valid=%v err=%v", i,
+				test.receiver, test.valid, err)
+		}
+	}
+}
+
+//certInfoServer returns a server in tls verification mode certInfo, its certificate for ip and an
+//assertion for the certificate's name containing ip and a certificate information for it. The
+//assertion is signed with the root key.
+func certInfoServer(t *testing.T, addr connection.MemoryAddr, ip net.IP) (*Server,
+	*x509.Certificate, *section.Assertion) {
+	t.Helper()
+	config := memoryConfig(t, addr, DefaultConfig().Capabilities)
+	cert := writeTLSCertificate(t, config.CheckPointPath, ip)
+	config.TLSCertificateFile = filepath.Join(config.CheckPointPath, "server.crt")
+	config.TLSPrivateKeyFile = filepath.Join(config.CheckPointPath, "server.key")
+	config.TLSVerification = TLSVerifyCertInfo
+	s, err := New(config, addr.String())
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	data, err := connection.CertInfoData(cert, algorithmTypes.Sha256)
+	if err != nil {
+		t.Fatalf("Was not able to compute certificate information: %v", err)
+	}
+	a := &section.Assertion{
+		SubjectName: "server",
+		SubjectZone: ".",
+		Context:     ".",
+		Content: []object.Object{
+			{Type: object.OTIP4Addr, Value: ip},
+			{Type: object.OTCertInfo, Value: object.Certificate{Type: object.PTTLS,
+				Usage: object.CUEndEntity, HashAlgo: algorithmTypes.Sha256, Data: data}},
+		},
+	}
+	signAssertion(t, config.CheckPointPath, "root", a)
+	return s, cert, a
+}
+
+func TestTLSVerifyCertInfo(t *testing.T) {
+	receiver := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022}
+	var tests = []struct {
+		name   string
+		tamper bool
+		errMsg string
+	}{
+		{"looked up", false, ""},
+		{"invalid signature", true, "no name in the certificate"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, cert, a := certInfoServer(t, "TestTLSVerifyCertInfo", receiver.IP)
+			if test.tamper {
+				a.Content[1].Value = object.Certificate{Type: object.PTTLS,
+					Usage: object.CUEndEntity, HashAlgo: algorithmTypes.NoHashAlgo}
+			}
+			lookups := 0
+			lookup := func(name string) error {
+				lookups++
+				if name != "server." {
+					t.Errorf("wrong name looked up. expected=server. actual=%s", name)
+				}
+				s.cacheVerifiedSections([]section.Section{a}, make(map[string]bool))
+				return nil
+			}
+			chain := []*x509.Certificate{cert}
+			for i := 0; i < 2; i++ {
+				err := verifyCertInfo(receiver, chain, s.caches.AssertionsCache, lookup)
+				if test.errMsg == "" && err != nil {
+					t.Fatalf("certificate was not verified: %v", err)
+				}
+				if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+					t.Fatalf("wrong error. expected=%q actual=%v", test.errMsg, err)
+				}
+			}
+			//Verified sections are cached and not looked up again.
+			if want := map[bool]int{false: 1, true: 2}[test.tamper]; lookups != want {
+				t.Errorf("wrong number of lookups. expected=%d actual=%d", want, lookups)
+			}
+		})
+	}
+}
+
+func TestTLSVerifyCertInfoLookupFailed(t *testing.T) {
+	s, cert, _ := certInfoServer(t, "TestTLSVerifyCertInfoLookupFailed", net.ParseIP("192.0.2.1"))
+	//The forwarder does not accept connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	forwarder := l.Addr()
+	l.Close()
+	resolver, err := libresolve.New(nil, []net.Addr{forwarder}, s.config().RootZonePublicKeyPath,
+		libresolve.Forward, forwarder, 10, util.MaxCacheValidity{}, 10)
+	if err != nil {
+		t.Fatalf("Was not able to create resolver: %v", err)
+	}
+	s.SetResolver(resolver)
+	err = verifyCertInfo(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022},
+		[]*x509.Certificate{cert}, s.caches.AssertionsCache, s.lookupCertInfo)
+	if !errors.Is(err, errCertInfoLookup) {
+		t.Errorf("wrong error. expected=%v actual=%v", errCertInfoLookup, err)
+	}
+}
@@ -31,10 +31,11 @@ func (s *Server) verify(msgSender util.MsgSectionSender) {
 	switch msgSender.Sections[0].(type) {
 	case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
 		isAuthoritative := hasAuthority(msgSender, s)
-		if len(s.config.Authorities) != 0 {
+		if len(s.config().Authorities) != 0 {
 			//An authoritative server drops all messages containing sections over which it has no
 			//authority and are not a response to a query issued by this server
-			if !isAuthoritative && !s.caches.PendingKeys.ContainsToken(msgSender.Token) {
+			if !isAuthoritative && !s.caches.PendingKeys.ContainsToken(msgSender.Token) &&
+				!s.caches.PendingMessages.ContainsToken(msgSender.Token) {
 				log.Info("Drop message not part of authority", "msgSender", msgSender)
 				return
 			}
@@ -49,7 +50,7 @@ func (s *Server) verify(msgSender util.MsgSectionSender) {
 
 func hasAuthority(msgSender util.MsgSectionSender, s *Server) bool {
 	for _, sec := range msgSender.Sections {
-		if !isAuthoritative(sec.(section.WithSigForward), s.config.Authorities) {
+		if !isAuthoritative(sec.(section.WithSigForward), s.config().Authorities) {
 			return false
 		}
 	}
@@ -76,7 +77,7 @@ func verifySections(ss util.MsgSectionSender, s *Server, isAuthoritative bool) {
 				"invalid context", s)
 			return //already logged, that context is invalid
 		}
-		publicKeysPresent(sec, s.caches.ZoneKeyCache, keys, missingKeys)
+		publicKeysPresent(sec, s.caches.ZoneKeyCache, keys, missingKeys, s.metrics)
 	}
 	if len(missingKeys) != 0 {
 		handleMissingKeys(ss, missingKeys, s, isAuthoritative)
@@ -132,13 +133,16 @@ func isQueryExpired(expires int64) bool {
 }
 
 //publicKeysPresent adds all public keys that are cached to keys and for all that are not, the
-//corresponding signature meta data is added to missingKeys
+//corresponding signature meta data is added to missingKeys. The lookups are counted in m.
 func publicKeysPresent(s section.WithSigForward, zoneKeyCache cache.ZonePublicKey,
-	keys map[keys.PublicKeyID][]keys.PublicKey, missingKeys map[missingKeyMetaData]bool) {
+	keys map[keys.PublicKeyID][]keys.PublicKey, missingKeys map[missingKeyMetaData]bool,
+	m *metrics) {
 	keysNeeded := make(map[signature.MetaData]bool)
 	s.NeededKeys(keysNeeded)
 	for sigData := range keysNeeded {
-		if key, _, ok := zoneKeyCache.Get(s.GetSubjectZone(), s.GetContext(), sigData); ok {
+		key, _, ok := zoneKeyCache.Get(s.GetSubjectZone(), s.GetContext(), sigData)
+		m.cacheLookup("zoneKey", ok)
+		if ok {
 			//returned public key is guaranteed to be valid
 			log.Debug("Corresponding Public key in cache.", "cacheKey=sigMetaData", sigData, "publicKey", key)
 			keys[sigData.PublicKeyID] = append(keys[sigData.PublicKeyID], key)
@@ -159,7 +163,9 @@ func verifySignatures(ss util.MsgSectionSender, keys map[keys.PublicKeyID][]keys
 	for _, sec := range ss.Sections {
 		sec := sec.(section.WithSigForward)
 		sections = append(sections, sec)
-		if !siglib.CheckSectionSignatures(sec, keys, s.config.MaxCacheValidity) {
+		valid := siglib.CheckSectionSignatures(sec, keys, s.config().MaxCacheValidity)
+		s.metrics.sigVerification("section", valid)
+		if !valid {
 			return nil, false
 		}
 	}
@@ -173,10 +179,13 @@ func handleMissingKeys(ss util.MsgSectionSender, missingKeys map[missingKeyMetaD
 	sec := ss.Sections
 	log.Info("Some public keys are missing. Add section to pending key cache",
 		"#missingKeys", len(missingKeys), "sections", ss.Sections)
+	//The sections wait until the delegation queries sent to the recursive resolver after all
+	//retries have expired.
 	exp := getQueryValidity(sec[0].(section.WithSigForward).Sigs(keys.RainsKeySpace),
-		s.config.DelegationQueryValidity)
+		s.config().DelegationQueryValidity+keyRequestDuration(s.config()))
 	t := token.New()
 	s.caches.PendingKeys.Add(ss, t, exp)
+	s.pendingKeyTimer.schedule(exp)
 	queries := []section.Section{}
 	for k := range missingKeys {
 		log.Info("MissingKeys", "key", k)
@@ -193,7 +202,7 @@ func handleMissingKeys(ss util.MsgSectionSender, missingKeys map[missingKeyMetaD
 		log.Info("Send missing delegation keys to recursive resolver", "msg", msg)
 		s.sendToRecursiveResolver(msg)
 	} else {
-		s.sendTo(msg, ss.Sender, 0, 0)
+		s.requestKeys(msg, ss.Sender)
 	}
 }
 
@@ -0,0 +1,143 @@
+package rainsd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//cborZonefileExt is the file extension of a zonefile containing a cbor encoded message instead of
+//sections in zonefile format.
+const cborZonefileExt = ".cbor"
+
+//zonefileContent contains the sections of the configured zonefiles.
+type zonefileContent struct {
+	//verified contains the sections of all zonefiles whose signatures have been verified.
+	verified []section.WithSigForward
+	//unverified contains the sections of each zonefile whose public keys are not yet cached.
+	unverified []util.MsgSectionSender
+	//missingKeys contains the public keys missing to verify unverified by zonefile.
+	missingKeys []map[missingKeyMetaData]bool
+	//zones contains the subject zones of all sections.
+	zones map[string]bool
+}
+
+//loadZonefiles loads the configured zonefiles into the caches.
+func (s *Server) loadZonefiles() error {
+	content, err := s.readZonefiles(s.config())
+	if err != nil {
+		return err
+	}
+	s.addZonefileContent(content)
+	return nil
+}
+
+//readZonefiles returns the sections of the zonefiles listed in config. The signatures of all
+//sections are verified against the zone key cache. An error is returned if a zonefile cannot be
+//read, contains a section of a zone over which the server has no authority or an inconsistent
+//section, or if a signature is invalid. It is also returned if public keys are missing and the
+//server has no resolver to obtain them.
+func (s *Server) readZonefiles(config *Config) (zonefileContent, error) {
+	content := zonefileContent{zones: make(map[string]bool)}
+	for _, path := range config.ZoneFiles {
+		sections, err := readZonefile(path)
+		if err != nil {
+			return zonefileContent{}, fmt.Errorf("was not able to read zonefile %s: %v", path, err)
+		}
+		keys := make(map[keys.PublicKeyID][]keys.PublicKey)
+		missingKeys := make(map[missingKeyMetaData]bool)
+		for _, sec := range sections {
+			if !isAuthoritative(sec, config.Authorities) {
+				return zonefileContent{}, fmt.Errorf("zonefile %s contains zone %s in context %s "+
+					"over which the server has no authority", path, sec.GetSubjectZone(),
+					sec.GetContext())
+			}
+			if !sec.IsConsistent() || contextInvalid(sec.GetContext()) {
+				return zonefileContent{}, fmt.Errorf("zonefile %s contains an inconsistent section: %v",
+					path, sec)
+			}
+			content.zones[sec.GetSubjectZone()] = true
+			publicKeysPresent(sec, s.caches.ZoneKeyCache, keys, missingKeys, s.metrics)
+		}
+		if len(missingKeys) != 0 {
+			if s.resolver == nil {
+				return zonefileContent{}, fmt.Errorf("public keys to verify zonefile %s are "+
+					"missing and there is no resolver to obtain them", path)
+			}
+			ss := util.MsgSectionSender{Sections: make([]section.Section, len(sections))}
+			for i, sec := range sections {
+				ss.Sections[i] = sec
+			}
+			content.unverified = append(content.unverified, ss)
+			content.missingKeys = append(content.missingKeys, missingKeys)
+			continue
+		}
+		for _, sec := range sections {
+			valid := siglib.CheckSectionSignatures(sec, keys, config.MaxCacheValidity)
+			s.metrics.sigVerification("section", valid)
+			if !valid {
+				return zonefileContent{}, fmt.Errorf("zonefile %s contains a section without "+
+					"valid signature: %v", path, sec)
+			}
+		}
+		content.verified = append(content.verified, sections...)
+	}
+	return content, nil
+}
+
+//addZonefileContent replaces all cached sections of the zones in content with the verified
+//sections of content. As the server is authoritative over them, they are not evicted from the
+//caches. The missing public keys of the unverified sections are queried and the sections are
+//verified and cached as soon as the keys arrive.
+func (s *Server) addZonefileContent(content zonefileContent) {
+	for zone := range content.zones {
+		s.caches.AssertionsCache.RemoveZone(zone)
+		s.caches.NegAssertionCache.RemoveZone(zone)
+	}
+	if len(content.verified) > 0 {
+		s.assert(util.SectionWithSigSender{Sections: content.verified})
+	}
+	for i, ss := range content.unverified {
+		handleMissingKeys(ss, content.missingKeys[i], s, true)
+	}
+	log.Info("Loaded zonefiles", "zones", len(content.zones), "sections", len(content.verified),
+		"waitingForKeys", len(content.unverified))
+}
+
+//readZonefile returns the sections stored at path. A file with the extension cborZonefileExt
+//contains a cbor encoded message whose content are the sections. All other files are in zonefile
+//format.
+func readZonefile(path string) ([]section.WithSigForward, error) {
+	if filepath.Ext(path) != cborZonefileExt {
+		return zonefile.IO{}.LoadZonefile(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	msg := message.Message{}
+	if err := cbor.NewReader(file).Unmarshal(&msg); err != nil {
+		return nil, err
+	}
+	sections := []section.WithSigForward{}
+	for _, sec := range msg.Content {
+		sec, ok := sec.(section.WithSigForward)
+		if !ok {
+			return nil, errors.New("message contains a section which is neither an assertion, " +
+				"shard, pshard nor zone")
+		}
+		sections = append(sections, sec)
+	}
+	return sections, nil
+}
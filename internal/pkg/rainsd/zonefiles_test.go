@@ -0,0 +1,154 @@
+package rainsd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/zonefile"
+)
+
+//signedAssertion returns an assertion of name in zone with an ip4 address signed by the root key
+//stored in config's checkpoint path.
+func signedAssertion(t *testing.T, config Config, zone, name, ip string) *section.Assertion {
+	t.Helper()
+	a := &section.Assertion{
+		SubjectName: name,
+		SubjectZone: zone,
+		Context:     ".",
+		Content:     []object.Object{{Type: object.OTIP4Addr, Value: net.ParseIP(ip)}},
+	}
+	signAssertion(t, config.CheckPointPath, "root", a)
+	return a
+}
+
+//signAssertion signs a with the private key keyName stored at keyPath. The signature is valid for
+//an hour.
+func signAssertion(t *testing.T, keyPath, keyName string, a *section.Assertion) {
+	t.Helper()
+	block, err := keyManager.DecryptKey(keyPath, keyName+keyManager.SecSuffix, "")
+	if err != nil {
+		t.Fatalf("Was not able to decrypt key %s: %v", keyName, err)
+	}
+	keyID, privateKey, err := keyManager.PemToKeyID(block)
+	if err != nil {
+		t.Fatalf("Was not able to decode key %s: %v", keyName, err)
+	}
+	a.AddSig(signature.Sig{
+		PublicKeyID: keyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+	})
+	if err := siglib.SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{keyID: privateKey}); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+}
+
+//storeZonefile stores sections at path in zonefile format or as cbor encoded message if path ends
+//in cborZonefileExt.
+func storeZonefile(t *testing.T, path string, sections ...section.Section) {
+	t.Helper()
+	if filepath.Ext(path) != cborZonefileExt {
+		if err := (zonefile.IO{}).EncodeAndStore(path, sections); err != nil {
+			t.Fatalf("Was not able to store zonefile: %v", err)
+		}
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Was not able to create file: %v", err)
+	}
+	defer file.Close()
+	msg := message.Message{Token: token.New(), Content: sections}
+	if err := cbor.NewWriter(file).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to store message: %v", err)
+	}
+}
+
+//cachedIP4 returns the ip4 address of the cached assertion of fqdn or the empty string.
+func cachedIP4(s *Server, fqdn string) string {
+	assertions, ok := s.caches.AssertionsCache.Get(fqdn, ".", object.OTIP4Addr, false)
+	if !ok || len(assertions) == 0 {
+		return ""
+	}
+	return assertions[0].Content[0].Value.(net.IP).String()
+}
+
+func TestLoadZonefiles(t *testing.T) {
+	var tests = []struct {
+		name        string
+		file        string
+		zone        string
+		authorities []ZoneContext
+		tamper      bool
+		wantErr     bool
+	}{
+		{"zonefile", "root.txt", ".", []ZoneContext{{".", "."}}, false, false},
+		{"cbor", "root.cbor", ".", []ZoneContext{{".", "."}}, false, false},
+		{"no authority", "root.txt", ".", []ZoneContext{{"ch.", "."}}, false, true},
+		{"invalid signature", "root.txt", ".", []ZoneContext{{".", "."}}, true, true},
+		{"missing key", "ethz.txt", "ethz.ch.", []ZoneContext{{"ethz.ch.", "."}}, false, true},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := memoryConfig(t, connection.MemoryAddr(t.Name()), DefaultConfig().Capabilities)
+			config.Authorities = test.authorities
+			a := signedAssertion(t, config, test.zone, "www", "192.0.2.1")
+			if test.tamper {
+				a.Content[0].Value = net.ParseIP("192.0.2.2")
+			}
+			config.ZoneFiles = []string{filepath.Join(t.TempDir(), test.file)}
+			storeZonefile(t, config.ZoneFiles[0], a)
+			s, err := runMemoryServer(t, config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("%d: unexpected error. expected=%v actual=%v", i, test.wantErr, err)
+			}
+			if err == nil && cachedIP4(s, a.FQDN()) != "192.0.2.1" {
+				t.Errorf("%d: assertion of zonefile is not cached", i)
+			}
+		})
+	}
+}
+
+func TestReloadZonefiles(t *testing.T) {
+	config := memoryConfig(t, "TestReloadZonefiles", DefaultConfig().Capabilities)
+	config.Authorities = []ZoneContext{{".", "."}}
+	config.ZoneFiles = []string{filepath.Join(t.TempDir(), "root.txt")}
+	storeZonefile(t, config.ZoneFiles[0], signedAssertion(t, config, ".", "www", "192.0.2.1"))
+	s, err := runMemoryServer(t, config)
+	if err != nil {
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+
+	storeZonefile(t, config.ZoneFiles[0], signedAssertion(t, config, ".", "mail", "192.0.2.2"))
+	if _, err := s.Reload(config); err != nil {
+		t.Fatalf("Was not able to reload: %v", err)
+	}
+	if ip := cachedIP4(s, "www."); ip != "" {
+		t.Errorf("assertion of the old zonefile is still cached: %s", ip)
+	}
+	if ip := cachedIP4(s, "mail."); ip != "192.0.2.2" {
+		t.Errorf("assertion of the new zonefile is not cached: %s", ip)
+	}
+
+	invalid := config
+	invalid.ZoneFiles = []string{filepath.Join(t.TempDir(), "missing.txt")}
+	if _, err := s.Reload(invalid); err == nil {
+		t.Fatal("missing zonefile was accepted")
+	}
+	if s.config().ZoneFiles[0] != config.ZoneFiles[0] || cachedIP4(s, "mail.") != "192.0.2.2" {
+		t.Error("failed reload changed the server's zonefiles")
+	}
+}
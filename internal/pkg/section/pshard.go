@@ -230,10 +230,14 @@ func (s *Pshard) IsNonexistent(q *query.Name) (bool, error) {
 	if q.Context != s.Context {
 		return false, errors.New("query has different context")
 	}
-	if strings.HasSuffix(q.Name, s.SubjectZone) {
+	suffix := "." + s.SubjectZone
+	if s.SubjectZone == "." {
+		suffix = "."
+	}
+	if !strings.HasSuffix(q.Name, suffix) || q.Name == suffix {
 		return false, errors.New("query has different suffix")
 	}
-	name := strings.TrimSuffix(q.Name, s.SubjectZone)
+	name := strings.TrimSuffix(q.Name, suffix)
 	if !s.InRange(name) {
 		return false, errors.New("query is not in pshard's range")
 	}
@@ -4,6 +4,9 @@ import (
 	"math/rand"
 	"sort"
 	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 )
 
 func TestPshardCopy(t *testing.T) {
@@ -81,6 +84,39 @@ func TestPshardInRange(t *testing.T) {
 	}
 }
 
+func TestPshardIsNonexistent(t *testing.T) {
+	pshard := GetPshard()
+	a := &Assertion{
+		SubjectName: "bbb",
+		SubjectZone: pshard.SubjectZone,
+		Context:     pshard.Context,
+		Content:     []object.Object{{Type: object.OTIP4Addr, Value: "127.0.0.1"}},
+	}
+	if err := pshard.AddAssertion(a); err != nil {
+		t.Fatalf("Was not able to add assertion to pshard: %v", err)
+	}
+	var tests = []struct {
+		input   *query.Name
+		want    bool
+		wantErr bool
+	}{
+		{&query.Name{Name: "bbb.example.com.", Context: ".", Types: []object.Type{object.OTIP4Addr}}, false, false},
+		{&query.Name{Name: "bbb.example.com.", Context: ".", Types: []object.Type{object.OTIP6Addr, object.OTIP4Addr}}, false, false},
+		{&query.Name{Name: "bbb.example.com.", Context: ".", Types: []object.Type{object.OTIP6Addr}}, true, false},
+		{&query.Name{Name: "ccc.example.com.", Context: ".", Types: []object.Type{object.OTIP4Addr}}, true, false},
+		{&query.Name{Name: "bbb.example.com.", Context: "cx-test", Types: []object.Type{object.OTIP4Addr}}, false, true},
+		{&query.Name{Name: "bbb.example.org.", Context: ".", Types: []object.Type{object.OTIP4Addr}}, false, true},
+		{&query.Name{Name: "bbbexample.com.", Context: ".", Types: []object.Type{object.OTIP4Addr}}, false, true},
+		{&query.Name{Name: "zzzz.example.com.", Context: ".", Types: []object.Type{object.OTIP4Addr}}, false, true},
+	}
+	for i, test := range tests {
+		nonexistent, err := pshard.IsNonexistent(test.input)
+		if nonexistent != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%d: wrong result. expected=%t actual=%t err=%v", i, test.want, nonexistent, err)
+		}
+	}
+}
+
 func checkPshard(s1, s2 *Pshard, t *testing.T) {
 	if s1.Context != s2.Context {
 		t.Error("Pshard context mismatch")
@@ -160,6 +160,74 @@ func signSectionUnsafe(s section.WithSig, ks map[keys.PublicKeyID]interface{}) e
 	return nil
 }
 
+//SignMessageUnsafe signs msg with the given private keys and adds the resulting bytestrings to
+//msg's signatures. The signatures' meta data must be added to msg before calling this function. It
+//does not check the validity of msg or its signatures.
+func SignMessageUnsafe(msg *message.Message, ks map[keys.PublicKeyID]interface{}) error {
+	encoding, err := messageEncoding(msg)
+	if err != nil {
+		return err
+	}
+	sigs := msg.Signatures
+	msg.Signatures = nil
+	for _, sig := range sigs {
+		if err := (&sig).SignData(ks[sig.PublicKeyID], encoding); err != nil {
+			return err
+		}
+		msg.Signatures = append(msg.Signatures, sig)
+	}
+	return nil
+}
+
+//CheckMessageSignatures verifies all signatures on msg but not the signatures of the contained
+//sections. Expired signatures are removed. Returns true if at least one signature is left and all
+//non expired signatures are correct.
+func CheckMessageSignatures(msg *message.Message, pkeys map[keys.PublicKeyID][]keys.PublicKey) bool {
+	if msg == nil {
+		log.Warn("msg is nil")
+		return false
+	}
+	if !checkMessageStringFields(msg) {
+		return false //error already logged
+	}
+	encoding, err := messageEncoding(msg)
+	if err != nil {
+		log.Warn("Was not able to marshal message.", "error", err)
+		return false
+	}
+	sigs := msg.Signatures
+	msg.Signatures = nil
+	for _, sig := range sigs {
+		if sig.ValidUntil < time.Now().Unix() {
+			log.Info("signature is expired", "signature", sig)
+			continue
+		}
+		key, ok := getPublicKey(pkeys[sig.PublicKeyID], sig.MetaData())
+		if !ok {
+			log.Warn("No time overlapping publicKey in keys for signature", "keys", pkeys,
+				"signature", sig)
+			return false
+		}
+		if !sig.VerifySignature(key.Key, encoding) {
+			log.Warn("Sig does not match", "token", msg.Token, "signature", sig)
+			return false
+		}
+		msg.Signatures = append(msg.Signatures, sig)
+	}
+	return len(msg.Signatures) > 0
+}
+
+//messageEncoding returns the cbor encoding of msg without its signatures.
+func messageEncoding(msg *message.Message) ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signatures = nil
+	encoding := new(bytes.Buffer)
+	if err := unsigned.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		return nil, fmt.Errorf("Was not able to marshal message: %v", err)
+	}
+	return encoding.Bytes(), nil
+}
+
 //ValidSectionAndSignature returns true if the section is not nil, all the signatures ValidUntil are
 //in the future, the string fields do not contain  <whitespace>:<non whitespace>:<whitespace>, and
 //the section's content is sorted (by sorting it).
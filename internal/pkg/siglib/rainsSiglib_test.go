@@ -1,6 +1,7 @@
 package siglib
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -8,12 +9,14 @@ import (
 	"golang.org/x/crypto/ed25519"
 
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
@@ -64,6 +67,73 @@ func TestSignErrors(t *testing.T) {
 	}
 }
 
+func TestSignMessageUnsafe(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	msg := message.GetMessage()
+	msg.Signatures = []signature.Sig{sig}
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+	if err := SignMessageUnsafe(&msg, ks); err != nil {
+		t.Fatalf("Was not able to sign message: %v", err)
+	}
+	//The signature must still be valid after the message has been sent over the wire.
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	received := message.Message{}
+	if err := cbor.NewReader(encoding).Unmarshal(&received); err != nil {
+		t.Fatalf("Was not able to unmarshal message: %v", err)
+	}
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	if !CheckMessageSignatures(&received, ksPub) {
+		t.Error("Valid message signature was rejected")
+	}
+	received.Token = token.New()
+	if CheckMessageSignatures(&received, ksPub) {
+		t.Error("Signature of modified message was accepted")
+	}
+	received.Token = msg.Token
+	received.Signer = "evil.ch."
+	if CheckMessageSignatures(&received, ksPub) {
+		t.Error("Signature of message with modified signer was accepted")
+	}
+}
+
+func TestCheckMessageSignaturesErrors(t *testing.T) {
+	keys0 := make(map[keys.PublicKeyID][]keys.PublicKey)
+	keys1 := make(map[keys.PublicKeyID][]keys.PublicKey)
+	keys1[keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}] = []keys.PublicKey{
+		keys.PublicKey{ValidSince: time.Now().Unix(), ValidUntil: time.Now().Add(time.Minute).Unix()}}
+	ed25519Sig := signature.Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+		ValidUntil: time.Now().Add(time.Minute).Unix(), Data: []byte("invalid")}
+	var tests = []struct {
+		input           *message.Message
+		inputPublicKeys map[keys.PublicKeyID][]keys.PublicKey
+		want            bool
+	}{
+		{nil, keys0, false},                //msg nil
+		{&message.Message{}, keys0, false}, //no signatures
+		{&message.Message{Signatures: []signature.Sig{ed25519Sig},
+			Capabilities: []message.Capability{":ip:"}}, keys1, false}, //checkStringField false
+		{&message.Message{Signatures: []signature.Sig{ed25519Sig}}, keys0, false}, //no matching key
+		{&message.Message{Signatures: []signature.Sig{signature.Sig{
+			PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}}}}, keys1, false}, //sig expired
+		{&message.Message{Signatures: []signature.Sig{ed25519Sig}}, keys1, false}, //VerifySignature invalid
+	}
+	for i, test := range tests {
+		if res := CheckMessageSignatures(test.input, test.inputPublicKeys); res != test.want {
+			t.Errorf("%d: expected=%v, actual=%v, value=%v", i, test.want, res, test.input)
+		}
+	}
+}
+
 func TestCheckSectionSignaturesErrors(t *testing.T) {
 	keys0 := make(map[keys.PublicKeyID][]keys.PublicKey)
 	keys1 := make(map[keys.PublicKeyID][]keys.PublicKey)
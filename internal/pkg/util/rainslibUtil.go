@@ -127,11 +127,41 @@ func NewNotificationMessage(tok token.Token, t section.NotificationType, data st
 
 //SendQuery creates a connection with connInfo, frames msg and writes it to the connection.
 //It then waits for the response. When it receives the response or times out, it returns the answer
-//or an error.
+//or an error. The server's tls certificate is not verified.
 func SendQuery(msg message.Message, addr net.Addr, timeout time.Duration) (
 	message.Message, error) {
+	return SendVerifiedQuery(msg, addr, timeout, connection.TLSVerification{Insecure: true})
+}
+
+//SendVerifiedQuery is the same as SendQuery except that the server's tls certificate is verified
+//according to v. If addr is a plain UDP address and the answer does not fit into a datagram, msg is
+//sent again over tcp to the same IP address and port.
+func SendVerifiedQuery(msg message.Message, addr net.Addr, timeout time.Duration,
+	v connection.TLSVerification) (message.Message, error) {
+	answer, err := sendQuery(msg, addr, timeout, v)
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && err == nil && isMsgTooLarge(answer, msg.Token) {
+		log.Debug("Answer is too large for UDP, retrying over TCP", "addr", addr)
+		tcpAddr := &net.TCPAddr{IP: udpAddr.IP, Port: udpAddr.Port, Zone: udpAddr.Zone}
+		return sendQuery(msg, tcpAddr, timeout, v)
+	}
+	return answer, err
+}
+
+//isMsgTooLarge returns true if msg is a message too large notification for tok.
+func isMsgTooLarge(msg message.Message, tok token.Token) bool {
+	for _, sec := range msg.Content {
+		if n, ok := sec.(*section.Notification); ok && n.Type == section.NTMsgTooLarge &&
+			n.Token == tok {
+			return true
+		}
+	}
+	return false
+}
 
-	conn, err := connection.CreateConnection(addr)
+//sendQuery sends msg to addr and returns the first answer with msg's token.
+func sendQuery(msg message.Message, addr net.Addr, timeout time.Duration,
+	v connection.TLSVerification) (message.Message, error) {
+	conn, err := connection.CreateVerifiedConnection(addr, v)
 	if err != nil {
 		return message.Message{}, err
 	}
@@ -81,3 +81,58 @@ func decode(t *testing.T, input []byte) []section.WithSigForward {
 	}
 	return sections
 }
+
+func TestDecodeOpenShardRange(t *testing.T) {
+	shards := []section.Section{
+		&section.Shard{SubjectZone: "ch.", Context: "."},
+		&section.Shard{SubjectZone: "ch.", Context: ".", RangeFrom: "a"},
+		&section.Shard{SubjectZone: "ch.", Context: ".", RangeTo: "z"},
+	}
+	sections, err := IO{}.Decode([]byte(IO{}.Encode(shards)))
+	if err != nil || len(sections) != len(shards) {
+		t.Fatalf("Was not able to decode shards: %v", err)
+	}
+	for i, sec := range sections {
+		want := shards[i].(*section.Shard)
+		if s := sec.(*section.Shard); s.RangeFrom != want.RangeFrom || s.RangeTo != want.RangeTo {
+			t.Errorf("%d: wrong range. expected=[%s:%s] actual=[%s:%s]", i, want.RangeFrom,
+				want.RangeTo, s.RangeFrom, s.RangeTo)
+		}
+	}
+}
+
+func TestParseShardRange(t *testing.T) {
+	bloomFilter := ":bloomKM12: :shake256: e28b1bd3a73882b198dfe4f0fa95403c5916ac7b97387bd20f49511de628b702"
+	var tests = []struct {
+		input     string
+		rangeFrom string
+		rangeTo   string
+	}{
+		{":S: ch. . < > [ ]", "", ""},
+		{":S: ch. . a > [ ]", "a", ""},
+		{":S: ch. . < z [ ]", "", "z"},
+		{":S: ch. . a z [ ]", "a", "z"},
+		{":P: ch. . < > " + bloomFilter, "", ""},
+		{":P: ch. . a > " + bloomFilter, "a", ""},
+		{":P: ch. . < z " + bloomFilter, "", "z"},
+	}
+	for i, test := range tests {
+		sections := decode(t, []byte(test.input))
+		if len(sections) != 1 {
+			t.Fatalf("%d: wrong number of sections. expected=1 actual=%d", i, len(sections))
+		}
+		var rangeFrom, rangeTo string
+		switch sec := sections[0].(type) {
+		case *section.Shard:
+			rangeFrom, rangeTo = sec.RangeFrom, sec.RangeTo
+		case *section.Pshard:
+			rangeFrom, rangeTo = sec.RangeFrom, sec.RangeTo
+		default:
+			t.Fatalf("%d: wrong section type %T", i, sec)
+		}
+		if rangeFrom != test.rangeFrom || rangeTo != test.rangeTo {
+			t.Errorf("%d: wrong range. expected=[%s:%s] actual=[%s:%s]", i, test.rangeFrom,
+				test.rangeTo, rangeFrom, rangeTo)
+		}
+	}
+}
@@ -975,19 +975,19 @@ ZFPdefault:
 		ZFPDollar = ZFPS[ZFPpt-2 : ZFPpt+1]
 //line internal/pkg/zonefile/zoneFileParser.y:260
 		{
-			ZFPVAL.shardRange = []string{"<", ZFPDollar[2].str}
+			ZFPVAL.shardRange = []string{"", ZFPDollar[2].str}
 		}
 	case 17:
 		ZFPDollar = ZFPS[ZFPpt-2 : ZFPpt+1]
 //line internal/pkg/zonefile/zoneFileParser.y:264
 		{
-			ZFPVAL.shardRange = []string{ZFPDollar[1].str, ">"}
+			ZFPVAL.shardRange = []string{ZFPDollar[1].str, ""}
 		}
 	case 18:
 		ZFPDollar = ZFPS[ZFPpt-2 : ZFPpt+1]
 //line internal/pkg/zonefile/zoneFileParser.y:268
 		{
-			ZFPVAL.shardRange = []string{"<", ">"}
+			ZFPVAL.shardRange = []string{"", ""}
 		}
 	case 19:
 		ZFPDollar = ZFPS[ZFPpt-0 : ZFPpt+1]
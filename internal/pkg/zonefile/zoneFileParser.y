@@ -258,15 +258,15 @@ shardRange      : ID ID
                 }
                 | rangeBegin ID
                 {
-                    $$ = []string{"<", $2}
+                    $$ = []string{"", $2}
                 }
                 | ID rangeEnd
                 {
-                    $$ = []string{$1, ">"}
+                    $$ = []string{$1, ""}
                 }
                 | rangeBegin rangeEnd
                 {
-                    $$ = []string{"<", ">"}
+                    $$ = []string{"", ""}
                 }
 
 shardContent :  /* empty */
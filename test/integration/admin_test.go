@@ -0,0 +1,125 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+)
+
+func TestAdminAPI(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("Was not able to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5040},
+	}
+	conf.CheckPointPath = filepath.Join(dir, "checkpoint")
+	conf.AdminAddress = rainsd.AdminUnixPrefix + socket
+	server, err := rainsd.New(conf, "adminServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}}}
+	request := func(method, path string) (int, string) {
+		req, _ := http.NewRequest(method, "http://rainsd"+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Admin request %s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("admin socket is not restricted to its owner. info=%v err=%v", info, err)
+	}
+	//The root zone key is loaded at startup.
+	if code, body := request(http.MethodGet, rainsd.AdminZoneKeysPath+"?zone=."); code !=
+		http.StatusOK || !strings.Contains(body, ":deleg:") {
+		t.Errorf("root zone key is not listed. code=%d body=%s", code, body)
+	}
+	if code, body := request(http.MethodGet, rainsd.AdminZoneKeysPath+"?zone=ch."); code !=
+		http.StatusOK || body != "" {
+		t.Errorf("zone keys of another zone are listed. code=%d body=%s", code, body)
+	}
+	for _, path := range []string{rainsd.AdminAssertionsPath, rainsd.AdminNegAssertionsPath,
+		rainsd.AdminPendingKeysPath, rainsd.AdminPendingQueriesPath} {
+		if code, _ := request(http.MethodGet, path); code != http.StatusOK {
+			t.Errorf("GET %s failed. code=%d", path, code)
+		}
+	}
+
+	//An open connection is listed.
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Was not able to connect to server: %v", err)
+	}
+	defer conn.Close()
+	listed := false
+	for i := 0; i < 20 && !listed; i++ {
+		_, body := request(http.MethodGet, rainsd.AdminConnectionsPath)
+		listed = strings.Contains(body, conn.LocalAddr().String())
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !listed {
+		t.Error("open connection is not listed")
+	}
+
+	if code, _ := request(http.MethodPost, rainsd.AdminFlushPath); code != http.StatusBadRequest {
+		t.Errorf("flush without zone was accepted. code=%d", code)
+	}
+	if code, _ := request(http.MethodGet, rainsd.AdminFlushPath+"?zone=ch."); code !=
+		http.StatusMethodNotAllowed {
+		t.Errorf("flush with GET was accepted. code=%d", code)
+	}
+	if code, _ := request(http.MethodPost, rainsd.AdminFlushPath+"?zone=ch."); code !=
+		http.StatusOK {
+		t.Errorf("flush failed. code=%d", code)
+	}
+	if code, _ := request(http.MethodPost, rainsd.AdminUnblockPath+"?zone=ch."); code !=
+		http.StatusNotFound {
+		t.Errorf("zone which is not blocked was unblocked. code=%d", code)
+	}
+	if code, body := request(http.MethodPost, rainsd.AdminCheckpointPath); code != http.StatusOK {
+		t.Errorf("checkpoint failed. code=%d body=%s", code, body)
+	}
+	for _, file := range []string{"assertionCheckPoint.cbor", "negAssertionCheckPoint.cbor",
+		"zoneKeyCheckPoint.cbor"} {
+		if _, err := os.Stat(filepath.Join(conf.CheckPointPath, file)); err != nil {
+			t.Errorf("checkpoint file is missing: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestAuthoritativeAnswer(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	rootServer := startAuthServer(t, "Root", nil)
+	defer rootServer.Shutdown()
+	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
+	defer chServer.Shutdown()
+
+	var tests = []struct {
+		name string
+		want section.NotificationType
+	}{
+		//The ch zone proves that there is no delegation for nonexistent.ch.
+		{"www.nonexistent.ch.", section.NTNoAssertionsExist},
+		//The ch server is not authoritative for example.org. and has no glue records to refer to.
+		{"www.example.org.", section.NTNoAssertionAvail},
+	}
+	for i, test := range tests {
+		q := &query.Name{
+			Name:       test.name,
+			Context:    ".",
+			Types:      []object.Type{object.OTIP4Addr},
+			Expiration: time.Now().Add(time.Minute).Unix(),
+		}
+		answer, err := util.SendQuery(message.Message{Token: token.New(),
+			Content: []section.Section{q}}, chServer.Addr(), time.Second)
+		if err != nil {
+			t.Fatalf("%d: no answer from authoritative server: %v", i, err)
+		}
+		if n, ok := answer.Content[0].(*section.Notification); !ok || n.Type != test.want {
+			t.Errorf("%d: wrong answer. expected=%v actual=%v", i, test.want, answer.Content[0])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestCapabilityHashNotKnown(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5029},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/capability/"
+	server, err := rainsd.New(conf, "capabilityServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//An unknown capability hash is answered with the server's capability list.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	msg := message.Message{
+		Token:        token.New(),
+		Capabilities: []message.Capability{message.Capability(unknownHash)},
+		Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	answer, err := util.SendQuery(msg, server.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("No answer to unknown capability hash: %v", err)
+	}
+	n, ok := answer.Content[0].(*section.Notification)
+	if !ok || n.Type != section.NTCapHashNotKnown || n.Data != string(message.TLSOverTCP) {
+		t.Fatalf("Expected capability hash not known notification. actual=%v", answer.Content[0])
+	}
+	ownHash, _ := message.CapabilityHash(conf.Capabilities)
+	if len(answer.Capabilities) != 1 || string(answer.Capabilities[0]) != ownHash {
+		t.Errorf("Answer does not contain the server's capability hash. actual=%v",
+			answer.Capabilities)
+	}
+
+	//A known capability hash is not answered.
+	msg.Token = token.New()
+	msg.Capabilities = []message.Capability{message.Capability(ownHash)}
+	if answer, err := util.SendQuery(msg, server.Addr(), 500*time.Millisecond); err == nil {
+		t.Errorf("Unexpected answer to known capability hash. answer=%v", answer)
+	}
+}
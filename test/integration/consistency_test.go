@@ -0,0 +1,108 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestInconsistentSections(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	rootServer := startAuthServer(t, "Root", nil)
+	defer rootServer.Shutdown()
+	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
+	defer chServer.Shutdown()
+	ethzChServer := startAuthServer(t, "ethz.ch", []net.Addr{rootServer.Addr()})
+	defer ethzChServer.Shutdown()
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5028},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/consistency/"
+	cachingResolver, err := rainsd.New(conf, "consistencyResolver")
+	if err != nil {
+		t.Fatalf("Was not able to create client resolver: %v", err)
+	}
+	resolver, err := libresolve.New([]net.Addr{rootServer.Addr()}, nil,
+		rootServer.Config().RootZonePublicKeyPath, libresolve.Recursive, cachingResolver.Addr(),
+		1000, rootServer.Config().MaxCacheValidity, 50)
+	if err != nil {
+		t.Fatalf("Was not able to create recursive resolver: %v", err)
+	}
+	cachingResolver.SetResolver(resolver)
+	runServer(t, cachingResolver)
+	defer cachingResolver.Shutdown()
+
+	qs, as := loadQueriesAndAnswers(t)
+	ip4Query := decodeQueries([]byte(qs))[0]
+	ip4Answer := decodeAnswers([]byte(as), t)[0]
+	addr := cachingResolver.Addr()
+	sendQueryVerifyResponse(t, *ip4Query, addr, ip4Answer)
+
+	//A correctly signed shard stating that www.ethz.ch does not exist contradicts the cached
+	//assertion. As its validity starts before the one of the assertion, it is not a newer version
+	//of the zone. The resolver must reject it and evict the zone.
+	pubConfig, err := publisher.LoadConfig("testdata/conf/publisherethz.ch.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load ethz.ch publisher config: %v", err)
+	}
+	shard := signedEmptyShard(t, "ethz.ch.", "testdata/keys/ethz.ch",
+		pubConfig.MetaDataConf.SigValidSince-60)
+	msg := message.Message{Token: token.New(), Content: []section.Section{shard}}
+	answerMsg, err := util.SendQuery(msg, addr, time.Second)
+	if err != nil {
+		t.Fatalf("could not send shard or receive answer. err=%v", err)
+	}
+	if n, ok := answerMsg.Content[0].(*section.Notification); !ok ||
+		n.Type != section.NTRcvInconsistentMsg {
+		t.Fatalf("Expected inconsistency notification. actual=%v", answerMsg.Content[0])
+	}
+	sendQueryVerifyNotification(t, withOptions(ip4Query, query.QOCachedAnswersOnly), addr,
+		section.NTNoAssertionAvail)
+}
+
+//signedEmptyShard returns a shard over the whole namespace of zone without any content. It is
+//signed with the private keys stored at keyPath. The signatures are valid from validSince until an
+//hour from now.
+func signedEmptyShard(t *testing.T, zone, keyPath string, validSince int64) *section.Shard {
+	t.Helper()
+	privateKeys, err := publisher.LoadPrivateKeys(keyPath)
+	if err != nil {
+		t.Fatalf("Was not able to load private keys: %v", err)
+	}
+	shard := &section.Shard{SubjectZone: zone, Context: "."}
+	for keyID := range privateKeys {
+		shard.AddSig(signature.Sig{
+			PublicKeyID: keyID,
+			ValidSince:  validSince,
+			ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		})
+	}
+	if err := siglib.SignSectionUnsafe(shard, privateKeys); err != nil {
+		t.Fatalf("Was not able to sign shard: %v", err)
+	}
+	return shard
+}
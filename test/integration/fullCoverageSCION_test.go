@@ -115,8 +115,7 @@ func TestFullCoverageSCION(t *testing.T) {
 		panic(err.Error())
 	}
 	cachingResolver.SetResolver(resolver)
-	go cachingResolver.Start(false, "resolver")
-	time.Sleep(1000 * time.Millisecond)
+	runServer(t, cachingResolver)
 	log.Info("caching server successfully started")
 
 	//Send queries to client resolver and observe the recursive lookup results.
@@ -153,8 +152,7 @@ func TestFullCoverageSCION(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Was not able to create client resolver: %v", err)
 	}
-	go cachingResolver2.Start(false, "resolver2")
-	time.Sleep(500 * time.Millisecond)
+	runServer(t, cachingResolver2)
 	log.Info("caching server successfully started")
 	log.Info("begin sending queries which should be cached by pre load")
 	for i, query := range queries {
@@ -327,14 +325,13 @@ func startSCIONAuthServer(t *testing.T, name string, rootServers []net.Addr) *ra
 		panic(err.Error())
 	}
 	server.SetResolver(resolver)
-	go server.Start(false, "nameServer"+name)
+	runServer(t, server)
 
 	if len(resolver.RootNameServers) > 0 && resolver.RootNameServers[0] == nil {
 		log.Error(fmt.Sprintf("Started name server %s with nil root name server: %v",
 			name, resolver.RootNameServers[0]))
 	}
 
-	time.Sleep(250 * time.Millisecond)
 	config, err := publisher.LoadConfig("testdata/conf/SCIONpublisher" + name + ".conf")
 	if err != nil {
 		t.Fatal(fmt.Sprintf("Was not able to load %s publisher config: ", name), err)
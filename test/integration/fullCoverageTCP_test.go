@@ -5,6 +5,7 @@ package integration
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -57,8 +58,7 @@ func TestFullCoverage(t *testing.T) {
 		panic(err.Error())
 	}
 	cachingResolver.SetResolver(resolver)
-	go cachingResolver.Start(false, "resolver")
-	time.Sleep(1000 * time.Millisecond)
+	runServer(t, cachingResolver)
 	log.Info("caching server successfully started")
 
 	//Send queries to client resolver and observe the recursive lookup results.
@@ -77,7 +77,6 @@ func TestFullCoverage(t *testing.T) {
 	rootServer.Shutdown()
 	chServer.Shutdown()
 	ethzChServer.Shutdown()
-	time.Sleep(500 * time.Millisecond)
 	log.Info("begin sending queries which should be cached by recursive lookup")
 	for i, query := range queries {
 		sendQueryVerifyResponse(t, *query, cachingResolver.Addr(), answers[i])
@@ -96,8 +95,7 @@ func TestFullCoverage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Was not able to create client resolver: %v", err)
 	}
-	go cachingResolver2.Start(false, "resolver")
-	time.Sleep(500 * time.Millisecond)
+	runServer(t, cachingResolver2)
 	log.Info("caching server successfully started")
 	log.Info("begin sending queries which should be cached by pre load")
 	for i, query := range queries {
@@ -261,8 +259,15 @@ func keySetup(t *testing.T, keyPath string) {
 	}
 }
 
+//checkCheckpoint returns as soon as all checkpoint files of the resolver have been rewritten.
 func checkCheckpoint(t *testing.T) {
-	checkpointPath := "testdata/checkpoint/resolver/zoneKeyCheckPoint.gob"
+	for _, name := range []string{"assertionCheckPoint.cbor", "negAssertionCheckPoint.cbor",
+		"zoneKeyCheckPoint.cbor"} {
+		checkCheckpointFile(t, "testdata/checkpoint/resolver/"+name)
+	}
+}
+
+func checkCheckpointFile(t *testing.T, checkpointPath string) {
 	var modTime time.Time
 	if info, err := os.Stat(checkpointPath); err == nil {
 		modTime = info.ModTime()
@@ -300,8 +305,7 @@ func startAuthServer(t *testing.T, name string, rootServers []net.Addr) *rainsd.
 		panic(err.Error())
 	}
 	server.SetResolver(resolver)
-	go server.Start(false, "nameServer"+name)
-	time.Sleep(250 * time.Millisecond)
+	runServer(t, server)
 	config, err := publisher.LoadConfig("testdata/conf/publisher" + name + ".conf")
 	if err != nil {
 		t.Fatal(fmt.Sprintf("Was not able to load %s publisher config: ", name), err)
@@ -314,6 +318,17 @@ func startAuthServer(t *testing.T, name string, rootServers []net.Addr) *rainsd.
 	return server
 }
 
+//runServer runs server in a go routine and returns as soon as it is ready.
+func runServer(t *testing.T, server *rainsd.Server) {
+	errs := make(chan error, 1)
+	go func() { errs <- server.Run(context.Background()) }()
+	select {
+	case <-server.Ready():
+	case err := <-errs:
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+}
+
 func loadQueriesAndAnswers(t *testing.T) (string, string) {
 	var answers, queries []string
 	input, err := ioutil.ReadFile("testdata/messages/messages.txt")
@@ -0,0 +1,87 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestServerLifecycle(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5036},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/lifecycle/"
+	conf.DrainTimeout = time.Second
+	newServer := func() *rainsd.Server {
+		server, err := rainsd.New(conf, "lifecycleServer")
+		if err != nil {
+			t.Fatalf("Was not able to create server: %v", err)
+		}
+		return server
+	}
+
+	//The server answers as soon as it is ready and stops when its context is canceled.
+	server := newServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- server.Run(ctx) }()
+	select {
+	case <-server.Ready():
+	case err := <-errs:
+		t.Fatalf("Was not able to run server: %v", err)
+	}
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	msg := message.Message{
+		Token:        token.New(),
+		Capabilities: []message.Capability{message.Capability(unknownHash)},
+		Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	if _, err := util.SendQuery(msg, server.Addr(), time.Second); err != nil {
+		t.Fatalf("No answer from ready server: %v", err)
+	}
+	cancel()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Server did not drain: %v", err)
+		}
+	case <-time.After(2 * conf.DrainTimeout):
+		t.Fatal("Run did not return after its context was canceled")
+	}
+	if err := server.Run(context.Background()); err == nil {
+		t.Error("Stopped server was started again")
+	}
+
+	//The address is free again and a server which has been shut down cannot be started.
+	server = newServer()
+	runServer(t, server)
+	server.Shutdown()
+	if _, err := util.SendQuery(msg, server.Addr(), 500*time.Millisecond); err == nil {
+		t.Error("Server answered after it has been shut down")
+	}
+	server = newServer()
+	server.Shutdown()
+	if err := server.Run(context.Background()); err == nil {
+		t.Error("Server which has been shut down was started")
+	}
+}
@@ -0,0 +1,74 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestMultipleListeners(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5042}
+	udpAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5043}
+	conf.ServerAddress = connection.Info{Type: connection.TCP, Addr: tcpAddr}
+	conf.ListenAddresses = []connection.Info{{Type: connection.UDP, Addr: udpAddr}}
+	conf.CheckPointPath = "testdata/checkpoint/listeners/"
+
+	//A server cannot listen on two addresses of the same datagram type.
+	invalidConf := conf
+	invalidConf.ListenAddresses = append(conf.ListenAddresses, connection.Info{
+		Type: connection.UDP,
+		Addr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5044},
+	})
+	invalidServer, err := rainsd.New(invalidConf, "invalidListenersServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	if err := invalidServer.Run(context.Background()); err == nil {
+		t.Fatal("Server with two UDP addresses was started")
+	}
+
+	server, err := rainsd.New(conf, "listenersServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//The server answers on each of its addresses over the transport of the sender.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	for _, addr := range []net.Addr{tcpAddr, udpAddr} {
+		msg := message.Message{
+			Token:        token.New(),
+			Capabilities: []message.Capability{message.Capability(unknownHash)},
+			Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+		}
+		answer, err := util.SendQuery(msg, addr, time.Second)
+		if err != nil {
+			t.Fatalf("No answer on %s address: %v", addr.Network(), err)
+		}
+		n, ok := answer.Content[0].(*section.Notification)
+		if !ok || n.Type != section.NTCapHashNotKnown {
+			t.Errorf("Expected capability hash not known notification on %s address. actual=%v",
+				addr.Network(), answer.Content[0])
+		}
+	}
+}
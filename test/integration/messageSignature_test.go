@@ -0,0 +1,183 @@
+//go:build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/keyManager"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"golang.org/x/crypto/ed25519"
+)
+
+const infraKeyName = "ns.ethz.ch."
+
+func TestMessageSignatures(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	rootServer := startAuthServer(t, "Root", nil)
+	defer rootServer.Shutdown()
+	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
+	defer chServer.Shutdown()
+	ethzChServer := startAuthServer(t, "ethz.ch", []net.Addr{rootServer.Addr()})
+	defer ethzChServer.Shutdown()
+
+	//Publish the infrastructure key of ns.ethz.ch as part of an update of the ethz.ch zone. The
+	//authoritative server must replace the version of the zone published above.
+	keyDir, err := ioutil.TempDir("", "infraKey")
+	if err != nil {
+		t.Fatalf("Was not able to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(keyDir)
+	infraKey := publishInfraKey(t, keyDir)
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5030},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/messageSignature/"
+	conf.InfraKeyPath = filepath.Join(keyDir, "infra"+keyManager.SecSuffix)
+	conf.InfraKeyName = infraKeyName
+	conf.MessageSignatureValidity = time.Minute
+	cachingResolver, err := rainsd.New(conf, "messageSignatureResolver")
+	if err != nil {
+		t.Fatalf("Was not able to create client resolver: %v", err)
+	}
+	resolver, err := libresolve.New([]net.Addr{rootServer.Addr()}, nil,
+		rootServer.Config().RootZonePublicKeyPath, libresolve.Recursive, cachingResolver.Addr(),
+		1000, rootServer.Config().MaxCacheValidity, 50)
+	if err != nil {
+		t.Fatalf("Was not able to create recursive resolver: %v", err)
+	}
+	cachingResolver.SetResolver(resolver)
+	runServer(t, cachingResolver)
+	defer cachingResolver.Shutdown()
+
+	privateKeys, err := publisher.LoadPrivateKeys(keyDir)
+	if err != nil {
+		t.Fatalf("Was not able to load private infrastructure key: %v", err)
+	}
+	qs, as := loadQueriesAndAnswers(t)
+	ip4Query := decodeQueries([]byte(qs))[0]
+	ip4Answer := decodeAnswers([]byte(as), t)[0]
+
+	//The resolver looks up the signer's infrastructure key before it answers a signed query. The
+	//answer is signed with the resolver's infrastructure key.
+	msg := signedMessage(t, []section.Section{ip4Query}, privateKeys)
+	answer, err := util.SendQuery(msg, cachingResolver.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not send signed query or receive answer. err=%v", err)
+	}
+	if a, ok := answer.Content[0].(*section.Assertion); !ok ||
+		a.CompareTo(ip4Answer.(*section.Assertion)) != 0 {
+		t.Fatalf("Answer does not match expected result. actual=%v expected=%v",
+			answer.Content[0], ip4Answer)
+	}
+	if answer.Signer != infraKeyName ||
+		!siglib.CheckMessageSignatures(&answer, map[keys.PublicKeyID][]keys.PublicKey{
+			infraKey.PublicKeyID: []keys.PublicKey{infraKey}}) {
+		t.Errorf("Answer is not signed with the resolver's infrastructure key. answer=%v", answer)
+	}
+
+	//A message whose content was modified after signing is rejected.
+	msg = signedMessage(t, []section.Section{withOptions(ip4Query)}, privateKeys)
+	msg.Token = token.New()
+	answer, err = util.SendQuery(msg, cachingResolver.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("could not send modified message or receive answer. err=%v", err)
+	}
+	if n, ok := answer.Content[0].(*section.Notification); !ok || n.Type != section.NTBadMessage {
+		t.Fatalf("Expected bad message notification. actual=%v", answer.Content[0])
+	}
+}
+
+//publishInfraKey generates an infrastructure key pair in keyDir and publishes the ethz.ch zone
+//with an additional assertion containing the public key for infraKeyName. It returns the public
+//key together with its validity.
+func publishInfraKey(t *testing.T, keyDir string) keys.PublicKey {
+	t.Helper()
+	if err := keyManager.GenerateKey(keyDir, "infra", "", algorithmTypes.Ed25519.String(), "",
+		1); err != nil {
+		t.Fatalf("Was not able to generate infrastructure key pair: %v", err)
+	}
+	pems, err := keyManager.LoadPublicKeys(keyDir)
+	if err != nil || len(pems) != 1 {
+		t.Fatalf("Was not able to load infrastructure public key: %v", err)
+	}
+	pkey := keys.PublicKey{
+		PublicKeyID: keys.PublicKeyID{
+			Algorithm: algorithmTypes.Ed25519,
+			KeySpace:  keys.RainsKeySpace,
+			KeyPhase:  1,
+		},
+		ValidSince: time.Now().Unix(),
+		ValidUntil: time.Now().Add(time.Hour).Unix(),
+		Key:        ed25519.PublicKey(pems[0].Bytes),
+	}
+
+	zonefile, err := ioutil.ReadFile("testdata/zonefiles/ethz.ch.txt")
+	if err != nil {
+		t.Fatalf("Was not able to read ethz.ch zonefile: %v", err)
+	}
+	zone := strings.TrimSpace(string(zonefile))
+	zone = strings.TrimSuffix(zone, "]") + "    :A: " +
+		strings.TrimSuffix(infraKeyName, ".ethz.ch.") + "  [ :infra: :ed25519: 1 " +
+		pems[0].Headers[keyManager.HexEncoding] + " ]\n]\n"
+	zonefilePath := filepath.Join(keyDir, "ethz.ch.txt")
+	if err := ioutil.WriteFile(zonefilePath, []byte(zone), 0600); err != nil {
+		t.Fatalf("Was not able to write ethz.ch zonefile: %v", err)
+	}
+	config, err := publisher.LoadConfig("testdata/conf/publisherethz.ch.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load ethz.ch publisher config: %v", err)
+	}
+	config.ZonefilePath = zonefilePath
+	if err := publisher.New(config).Publish(); err != nil {
+		t.Fatalf("ethz.ch publisher error: %v", err)
+	}
+	time.Sleep(1000 * time.Millisecond)
+	return pkey
+}
+
+//signedMessage returns a message containing content which is signed with privateKeys on behalf of
+//infraKeyName.
+func signedMessage(t *testing.T, content []section.Section,
+	privateKeys map[keys.PublicKeyID]interface{}) message.Message {
+	t.Helper()
+	msg := message.Message{Token: token.New(), Content: content, Signer: infraKeyName}
+	for keyID := range privateKeys {
+		msg.Signatures = append(msg.Signatures, signature.Sig{
+			PublicKeyID: keyID,
+			ValidSince:  time.Now().Unix(),
+			ValidUntil:  time.Now().Add(time.Minute).Unix(),
+		})
+	}
+	if err := siglib.SignMessageUnsafe(&msg, privateKeys); err != nil {
+		t.Fatalf("Was not able to sign message: %v", err)
+	}
+	return msg
+}
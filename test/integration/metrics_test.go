@@ -0,0 +1,78 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestMetrics(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5033},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/metrics/"
+	conf.MetricsAddress = "127.0.0.1:5034"
+	server, err := rainsd.New(conf, "metricsServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//A heartbeat with an unknown capability hash is answered with a notification.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	msg := message.Message{
+		Token:        token.New(),
+		Capabilities: []message.Capability{message.Capability(unknownHash)},
+		Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	if _, err := util.SendQuery(msg, server.Addr(), time.Second); err != nil {
+		t.Fatalf("No answer to heartbeat: %v", err)
+	}
+
+	resp, err := http.Get("http://" + conf.MetricsAddress + "/metrics")
+	if err != nil {
+		t.Fatalf("Was not able to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Was not able to read metrics: %v", err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`rainsd_queue_capacity{queue="prio"} %d`, conf.PrioBufferSize),
+		fmt.Sprintf(`rainsd_workers{queue="normal"} %d`, conf.NormalWorkerCount),
+		`rainsd_queue_length{queue="notification"} 0`,
+		`rainsd_cache_entries{cache="capability"}`,
+		`rainsd_cache_entries{cache="pendingQuery"} 0`,
+		`rainsd_notifications_received_total{type="NTHeartbeat"} 1`,
+		`rainsd_notifications_sent_total{type="NTCapHashNotKnown"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics do not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+//maxMsgSize is smaller than the ethz.ch zone but large enough for shards of it.
+const maxMsgSize = 800
+
+func TestMsgTooLarge(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	//The root and ch servers provide the delegation of ethz.ch.
+	rootServer := startAuthServer(t, "Root", nil)
+	defer rootServer.Shutdown()
+	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
+	defer chServer.Shutdown()
+
+	conf, err := rainsd.LoadConfig("testdata/conf/namingServerethz.ch.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load ethz.ch config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5032},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/msgTooLarge/"
+	conf.MaxMsgSize = maxMsgSize
+	server, err := rainsd.New(conf, "msgTooLargeServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	resolver, err := libresolve.New([]net.Addr{rootServer.Addr()}, nil,
+		conf.RootZonePublicKeyPath, libresolve.Recursive, server.Addr(), 1000,
+		conf.MaxCacheValidity, 50)
+	if err != nil {
+		t.Fatalf("Was not able to create recursive resolver: %v", err)
+	}
+	server.SetResolver(resolver)
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//A too large message is answered with the server's maximum message size. The connection can
+	//still be used afterwards.
+	msg := message.Message{
+		Token: token.New(),
+		Content: []section.Section{&section.Notification{
+			Type: section.NTHeartbeat,
+			Data: strings.Repeat("a", maxMsgSize),
+		}},
+	}
+	answer, err := util.SendQuery(msg, server.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("No answer to too large message: %v", err)
+	}
+	if n, ok := answer.Content[0].(*section.Notification); !ok || n.Type != section.NTMsgTooLarge ||
+		n.Data != strconv.Itoa(maxMsgSize) {
+		t.Fatalf("Expected message too large notification. actual=%v", answer.Content[0])
+	}
+
+	//The publisher resends the zone split into shards which fit into a message.
+	pubConf, err := publisher.LoadConfig("testdata/conf/publisherethz.ch.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load ethz.ch publisher config: %v", err)
+	}
+	pubConf.AuthServers = []connection.Info{conf.ServerAddress}
+	if err := publisher.New(pubConf).Publish(); err != nil {
+		t.Fatalf("ethz.ch publisher error: %v", err)
+	}
+	time.Sleep(1000 * time.Millisecond)
+
+	qs, as := loadQueriesAndAnswers(t)
+	ip4Query := decodeQueries([]byte(qs))[0]
+	ip4Answer := decodeAnswers([]byte(as), t)[0]
+	answer, err = util.SendQuery(message.Message{Token: token.New(),
+		Content: []section.Section{ip4Query}}, server.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("could not send query or receive answer. err=%v", err)
+	}
+	if a, ok := answer.Content[0].(*section.Assertion); !ok ||
+		a.CompareTo(ip4Answer.(*section.Assertion)) != 0 {
+		t.Fatalf("Answer does not match expected result. actual=%v expected=%v",
+			answer.Content[0], ip4Answer)
+	}
+}
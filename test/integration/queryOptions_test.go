@@ -0,0 +1,127 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestQueryOptions(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	rootServer := startAuthServer(t, "Root", nil)
+	chServer := startAuthServer(t, "ch", []net.Addr{rootServer.Addr()})
+	ethzChServer := startAuthServer(t, "ethz.ch", []net.Addr{rootServer.Addr()})
+
+	//Start a caching resolver whose cached assertions expire quickly.
+	assertionValidity := 5 * time.Second
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5027},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/queryOptions/"
+	conf.MaxCacheValidity.AssertionValidity = assertionValidity
+	cachingResolver, err := rainsd.New(conf, "queryOptionsResolver")
+	if err != nil {
+		t.Fatalf("Was not able to create client resolver: %v", err)
+	}
+	resolver, err := libresolve.New([]net.Addr{rootServer.Addr()}, nil,
+		rootServer.Config().RootZonePublicKeyPath, libresolve.Recursive, cachingResolver.Addr(),
+		1000, rootServer.Config().MaxCacheValidity, 50)
+	if err != nil {
+		t.Fatalf("Was not able to create recursive resolver: %v", err)
+	}
+	cachingResolver.SetResolver(resolver)
+	runServer(t, cachingResolver)
+	defer cachingResolver.Shutdown()
+
+	//The first two messages ask for the ip4 and ip6 address of www.ethz.ch.
+	qs, as := loadQueriesAndAnswers(t)
+	queries := decodeQueries([]byte(qs))
+	answers := decodeAnswers([]byte(as), t)
+	ip4Query, ip4Answer := queries[0], answers[0]
+	ip6Query, ip6Answer := queries[1], answers[1]
+	addr := cachingResolver.Addr()
+
+	//cached answers only must not trigger a recursive lookup
+	sendQueryVerifyNotification(t, withOptions(ip4Query, query.QOCachedAnswersOnly), addr,
+		section.NTNoAssertionAvail)
+	sendQueryVerifyResponse(t, *ip4Query, addr, ip4Answer)
+	sendQueryVerifyResponse(t, *withOptions(ip4Query, query.QOCachedAnswersOnly), addr, ip4Answer)
+
+	//the answer to a query with the no proactive caching option must not be cached
+	sendQueryVerifyResponse(t, *withOptions(ip6Query, query.QONoProactiveCaching), addr, ip6Answer)
+	sendQueryVerifyNotification(t, withOptions(ip6Query, query.QOCachedAnswersOnly), addr,
+		section.NTNoAssertionAvail)
+
+	//max freshness bypasses the cache, thus there is no answer when upstream is unreachable
+	rootServer.Shutdown()
+	chServer.Shutdown()
+	ethzChServer.Shutdown()
+	sendQueryVerifyNoAnswer(t, withOptions(ip4Query, query.QOMaxFreshness), addr)
+
+	//expired assertions are only returned when the query allows it
+	time.Sleep(assertionValidity)
+	sendQueryVerifyNotification(t, withOptions(ip4Query, query.QOCachedAnswersOnly), addr,
+		section.NTNoAssertionAvail)
+	sendQueryVerifyResponse(t, *withOptions(ip4Query, query.QOCachedAnswersOnly,
+		query.QOExpiredAssertionsOk), addr, ip4Answer)
+}
+
+//withOptions returns a copy of q with the given query options and a fresh expiration time.
+func withOptions(q *query.Name, opts ...query.Option) *query.Name {
+	copy := *q
+	copy.Options = opts
+	copy.Expiration = time.Now().Add(time.Hour).Unix()
+	return &copy
+}
+
+func sendQueryVerifyNotification(t *testing.T, q *query.Name, addr net.Addr,
+	nType section.NotificationType) {
+
+	t.Helper()
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	answerMsg, err := util.SendQuery(msg, addr, time.Second)
+	if err != nil {
+		t.Fatalf("could not send query or receive answer. query=%v err=%v", q, err)
+	}
+	if len(answerMsg.Content) != 1 {
+		t.Fatalf("Got not exactly one answer for the query. msg=%v", answerMsg)
+	}
+	n, ok := answerMsg.Content[0].(*section.Notification)
+	if !ok || n.Type != nType {
+		t.Fatalf("Expected notification of type %v. actual=%v", nType, answerMsg.Content[0])
+	}
+}
+
+func sendQueryVerifyNoAnswer(t *testing.T, q *query.Name, addr net.Addr) {
+	t.Helper()
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	answerMsg, err := util.SendQuery(msg, addr, time.Second)
+	if err != nil {
+		return
+	}
+	for _, sec := range answerMsg.Content {
+		if _, ok := sec.(*section.Notification); !ok {
+			t.Fatalf("Expected no answer for the query. query=%v answer=%v", q, answerMsg)
+		}
+	}
+}
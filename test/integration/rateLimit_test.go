@@ -0,0 +1,68 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5035},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/rateLimit/"
+	conf.RateLimit = 0.1
+	conf.RateLimitBurst = 2
+	conf.RateLimitNotify = true
+	server, err := rainsd.New(conf, "rateLimitServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//A heartbeat with an unknown capability hash is answered with a notification unless the sender
+	//exceeded its rate limit.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	heartbeat := func() message.Message {
+		return message.Message{
+			Token:        token.New(),
+			Capabilities: []message.Capability{message.Capability(unknownHash)},
+			Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+		}
+	}
+	want := []section.NotificationType{section.NTCapHashNotKnown, section.NTCapHashNotKnown,
+		section.NTUnspecServerErr}
+	for i, nt := range want {
+		answer, err := util.SendQuery(heartbeat(), server.Addr(), time.Second)
+		if err != nil {
+			t.Fatalf("%d: no answer to heartbeat: %v", i, err)
+		}
+		if n, ok := answer.Content[0].(*section.Notification); !ok || n.Type != nt {
+			t.Errorf("%d: wrong answer. expected=%v actual=%v", i, nt, answer.Content[0])
+		}
+	}
+	//Further messages are dropped silently.
+	if _, err := util.SendQuery(heartbeat(), server.Addr(), 500*time.Millisecond); err == nil {
+		t.Error("Message of a rate limited sender was answered")
+	}
+}
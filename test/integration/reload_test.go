@@ -0,0 +1,104 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestConfigReload(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5037},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/reload/"
+	conf.MetricsAddress = "127.0.0.1:5038"
+	server, err := rainsd.New(conf, "reloadServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	//Reloadable settings are applied, all others are reported and keep their value.
+	newConf := conf
+	newConf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5039},
+	}
+	newConf.NormalWorkerCount = conf.NormalWorkerCount + 2
+	newConf.AssertionCacheSize = 5
+	newConf.Authorities = []rainsd.ZoneContext{{Zone: "ch.", Context: "."}}
+	restart, err := server.Reload(newConf)
+	if err != nil {
+		t.Fatalf("Was not able to reload config: %v", err)
+	}
+	if len(restart) != 1 || restart[0] != "ServerAddress" {
+		t.Errorf("wrong fields requiring a restart. expected=[ServerAddress] actual=%v", restart)
+	}
+	actual := server.Config()
+	if actual.NormalWorkerCount != newConf.NormalWorkerCount || actual.AssertionCacheSize != 5 ||
+		len(actual.Authorities) != 1 {
+		t.Errorf("reloadable settings were not applied. actual=%+v", actual)
+	}
+	if actual.ServerAddress.Addr.String() != conf.ServerAddress.Addr.String() {
+		t.Errorf("server address changed without a restart. actual=%v", actual.ServerAddress)
+	}
+	resp, err := http.Get("http://" + conf.MetricsAddress + "/metrics")
+	if err != nil {
+		t.Fatalf("Was not able to scrape metrics: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Was not able to read metrics: %v", err)
+	}
+	want := fmt.Sprintf(`rainsd_workers{queue="normal"} %d`, newConf.NormalWorkerCount)
+	if !strings.Contains(string(body), want) {
+		t.Errorf("Metrics do not contain %s", want)
+	}
+
+	//A certificate which cannot be loaded aborts the reload.
+	brokenConf := newConf
+	brokenConf.TLSCertificateFile = "testdata/cert/missing.crt"
+	brokenConf.NormalWorkerCount = 1
+	if _, err := server.Reload(brokenConf); err == nil {
+		t.Error("Reload with a missing certificate succeeded")
+	}
+	if server.Config().NormalWorkerCount != newConf.NormalWorkerCount {
+		t.Error("Failed reload changed the configuration")
+	}
+
+	//The server still answers over tls with its reloaded certificate.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	msg := message.Message{
+		Token:        token.New(),
+		Capabilities: []message.Capability{message.Capability(unknownHash)},
+		Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+	if _, err := util.SendQuery(msg, server.Addr(), time.Second); err != nil {
+		t.Fatalf("No answer after reload: %v", err)
+	}
+}
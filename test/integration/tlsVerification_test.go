@@ -0,0 +1,96 @@
+//go:build integration
+
+package integration
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestTLSVerification(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5031},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/tlsVerification/"
+	conf.TLSVerification = rainsd.TLSVerifyCA
+	server, err := rainsd.New(conf, "tlsVerificationServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	certPEM, err := ioutil.ReadFile(conf.TLSCertificateFile)
+	if err != nil {
+		t.Fatalf("Was not able to read server certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Was not able to parse server certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	pin, err := connection.CertInfoData(cert, algorithmTypes.Sha256)
+	if err != nil {
+		t.Fatalf("Was not able to compute certificate information: %v", err)
+	}
+	other := make([]byte, len(pin))
+
+	var tests = []struct {
+		name   string
+		v      connection.TLSVerification
+		errMsg string
+	}{
+		{"insecure", connection.TLSVerification{Insecure: true}, ""},
+		{"root CA", connection.TLSVerification{RootCAs: pool}, ""},
+		{"host root CAs", connection.TLSVerification{}, "tls verification failed"},
+		{"server name", connection.TLSVerification{RootCAs: pool, ServerName: "ns.ethz.ch"},
+			"tls verification failed"},
+		{"cert info", connection.TLSVerification{CertInfos: []object.Certificate{{
+			Type: object.PTTLS, Usage: object.CUEndEntity, HashAlgo: algorithmTypes.Sha256,
+			Data: pin}}}, ""},
+		{"wrong cert info", connection.TLSVerification{CertInfos: []object.Certificate{{
+			Type: object.PTTLS, Usage: object.CUEndEntity, HashAlgo: algorithmTypes.Sha256,
+			Data: other}}}, "does not match"},
+	}
+	//An unknown capability hash is always answered.
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	for _, test := range tests {
+		msg := message.Message{
+			Token:        token.New(),
+			Capabilities: []message.Capability{message.Capability(unknownHash)},
+			Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+		}
+		_, err := util.SendVerifiedQuery(msg, server.Addr(), time.Second, test.v)
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.errMsg, err)
+		}
+	}
+}
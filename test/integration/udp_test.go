@@ -0,0 +1,83 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestUDPTransport(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	conf, err := rainsd.LoadConfig("testdata/conf/resolver.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load resolver config: %v", err)
+	}
+	udpAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5041}
+	conf.ServerAddress = connection.Info{Type: connection.UDP, Addr: udpAddr}
+	conf.CheckPointPath = "testdata/checkpoint/udp/"
+	//The capability list sent in answer to an unknown capability hash does not fit into a
+	//datagram.
+	for i := 0; len(conf.Capabilities) < 200; i++ {
+		conf.Capabilities = append(conf.Capabilities,
+			message.Capability(fmt.Sprintf("urn:x-rains:%060d", i)))
+	}
+	server, err := rainsd.New(conf, "udpServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	unknownHash, _ := message.CapabilityHash([]message.Capability{"urn:x-rains:unknown"})
+	msg := message.Message{
+		Token:        token.New(),
+		Capabilities: []message.Capability{message.Capability(unknownHash)},
+		Content:      []section.Section{&section.Notification{Type: section.NTHeartbeat}},
+	}
+
+	//A too large answer is replaced by a message too large notification over UDP.
+	conn, err := connection.CreateConnection(udpAddr)
+	if err != nil {
+		t.Fatalf("Was not able to create UDP connection: %v", err)
+	}
+	defer conn.Close()
+	if err := connection.WriteMessage(conn, &msg); err != nil {
+		t.Fatalf("Was not able to send message over UDP: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	answer, err := connection.ReceiveMessage(conn)
+	if err != nil {
+		t.Fatalf("No answer over UDP: %v", err)
+	}
+	n, ok := answer.Content[0].(*section.Notification)
+	if !ok || n.Type != section.NTMsgTooLarge || n.Token != msg.Token {
+		t.Fatalf("Expected message too large notification. actual=%v", answer.Content[0])
+	}
+
+	//The client falls back to TCP on the same port and receives the whole answer.
+	msg.Token = token.New()
+	fullAnswer, err := util.SendQuery(msg, udpAddr, time.Second)
+	if err != nil {
+		t.Fatalf("No answer after falling back to TCP: %v", err)
+	}
+	n, ok = fullAnswer.Content[0].(*section.Notification)
+	if !ok || n.Type != section.NTCapHashNotKnown ||
+		len(strings.Fields(n.Data)) != len(conf.Capabilities) {
+		t.Errorf("Expected full capability list. actual=%v", fullAnswer.Content[0])
+	}
+}
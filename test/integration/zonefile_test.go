@@ -0,0 +1,70 @@
+//go:build integration
+
+package integration
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/publisher"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/rainsd"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestZonefileLoading(t *testing.T) {
+	h := log.CallerFileHandler(log.StdoutHandler)
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, h))
+	keySetup(t, "testdata/keys/root")
+
+	//Sign the root zone without publishing it.
+	pubConf, err := publisher.LoadConfig("testdata/conf/publisherRoot.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load root publisher config: %v", err)
+	}
+	pubConf.DoPublish = false
+	pubConf.OutputPath = filepath.Join(t.TempDir(), "root.txt")
+	if err := publisher.New(pubConf).Publish(); err != nil {
+		t.Fatalf("Was not able to sign root zone: %v", err)
+	}
+
+	conf, err := rainsd.LoadConfig("testdata/conf/namingServerRoot.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load namingServerRoot config: %v", err)
+	}
+	conf.ServerAddress = connection.Info{
+		Type: connection.TCP,
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5045},
+	}
+	conf.CheckPointPath = "testdata/checkpoint/zonefile/"
+	conf.ZoneFiles = []string{pubConf.OutputPath}
+	server, err := rainsd.New(conf, "zonefileServer")
+	if err != nil {
+		t.Fatalf("Was not able to create server: %v", err)
+	}
+	runServer(t, server)
+	defer server.Shutdown()
+
+	q := &query.Name{
+		Name:       "ns1.ch.",
+		Context:    ".",
+		Types:      []object.Type{object.OTIP4Addr},
+		Expiration: time.Now().Add(time.Minute).Unix(),
+	}
+	answer, err := util.SendQuery(message.Message{Token: token.New(),
+		Content: []section.Section{q}}, server.Addr(), time.Second)
+	if err != nil {
+		t.Fatalf("No answer from server: %v", err)
+	}
+	if a, ok := answer.Content[0].(*section.Assertion); !ok || a.FQDN() != "ns1.ch." {
+		t.Errorf("wrong answer. expected assertion of ns1.ch. actual=%v", answer.Content[0])
+	}
+}